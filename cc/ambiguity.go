@@ -0,0 +1,106 @@
+package grammar
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+// Ambiguity is a sentence that a grammar derives by more than one parse tree.
+type Ambiguity struct {
+	Sentence string
+	// Count is the number of its parse trees, nil if there are infinitely
+	// many because a symbol derives some of its tokens by deriving itself.
+	Count *big.Int
+	// Trees are two of its parse trees, or only one if the others are the
+	// infinitely many of a symbol deriving itself.
+	Trees []*node
+	// Alternatives are those of each node of its parse forest that has more
+	// than one: the productions responsible.
+	Alternatives [][]Alternative
+	// Conflicts are those of the LALR(1) table of the grammar, in which a
+	// production of the alternatives is reduced.
+	Conflicts []string
+}
+
+// Ambiguous searches the sentences of G of at most n tokens, the shorter
+// first, for one with more than one parse tree in its Earley forest, which
+// it returns, or nil if there is none. A grammar may be ambiguous only in
+// longer sentences; there is no algorithm that decides.
+func (G Grammar) Ambiguous(n int) (*Ambiguity, error) {
+	e, err := G.enumerator()
+	if err != nil {
+		return nil, err
+	}
+	for k := 0; k <= n; k++ {
+		sentences, err := e.layer(k)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range sentences {
+			F, err := G.Earley(source.NewFile("", s))
+			if err != nil {
+				return nil, fmt.Errorf("%q: %v", s, err)
+			}
+			count, err := F.Count()
+			if err == nil && count.Cmp(big.NewInt(1)) <= 0 {
+				continue
+			}
+			a := &Ambiguity{Sentence: s, Count: count, Trees: F.Trees(2), Alternatives: F.Ambiguities()}
+			a.Conflicts = G.conflicts(a.Alternatives)
+			return a, nil
+		}
+	}
+	return nil, nil
+}
+
+// conflicts are those of the LALR(1) table of G in which a production of alts
+// is reduced.
+func (G Grammar) conflicts(alts [][]Alternative) []string {
+	t, _ := G.lalr(nil, nil)
+	responsible := map[int]bool{}
+	for _, as := range alts {
+		for _, a := range as {
+			responsible[a.Production+1] = true
+		}
+	}
+	conflicts := []string{}
+	for _, c := range t.conflicts {
+		for _, act := range c.actions {
+			if act < 0 && responsible[-act-1] {
+				conflicts = append(conflicts, t.conflictstring(c))
+				break
+			}
+		}
+	}
+	return conflicts
+}
+
+// String writes the sentence with its trees, the alternatives of the forest
+// and the conflicts.
+func (a *Ambiguity) String() string {
+	var b strings.Builder
+	if a.Count == nil {
+		fmt.Fprintf(&b, "%q has infinitely many parse trees, including\n", a.Sentence)
+	} else {
+		fmt.Fprintf(&b, "%q has %s parse trees, including\n", a.Sentence, a.Count)
+	}
+	for _, tree := range a.Trees {
+		fmt.Fprintln(&b, tree)
+	}
+	b.WriteString("ambiguous at\n")
+	for _, alts := range a.Alternatives {
+		for _, alt := range alts {
+			fmt.Fprintf(&b, "\t%s\n", alt)
+		}
+	}
+	if len(a.Conflicts) > 0 {
+		b.WriteString("with the LALR(1) conflicts\n")
+		for _, c := range a.Conflicts {
+			fmt.Fprintf(&b, "\t%s\n", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,80 @@
+package grammar
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAmbiguous(t *testing.T) {
+	tests := []struct {
+		src      string
+		n        int
+		sentence string
+		count    string // of the parse trees, "" for infinitely many
+		conflict string // one of the conflicts
+	}{
+		{
+			"stmt → if expr then stmt | if expr then stmt else stmt | other", 9,
+			"if expr then if expr then other else other", "2",
+			"on else: shift, reduce stmt → if expr then stmt",
+		},
+		{"E → E + E | E * E | a", 5, "a * a * a", "2", "on *: shift, reduce E → E * E"},
+		// the rule for symbols of bnf/bnf.grm
+		{"symbol → symbol||symbol | alpha | ε\nalpha → a | b", 3, "", "", "reduce symbol → symbol symbol, reduce symbol → ε"},
+		{"symbol → symbol||symbol | alpha\nalpha → a | b", 3, "aaa", "2", "on a: shift, reduce symbol → symbol symbol"},
+		{"S → S | a", 3, "a", "", "on $: reduce S' → S, reduce S → S"},
+	}
+	for _, tc := range tests {
+		a, err := mustgrammar(t, tc.src).Ambiguous(tc.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a == nil {
+			t.Errorf("%q: no ambiguity up to %d tokens", tc.src, tc.n)
+			continue
+		}
+		count := ""
+		if a.Count != nil {
+			count = a.Count.String()
+		}
+		if a.Sentence != tc.sentence || count != tc.count {
+			t.Errorf("%q: %q has %q parse trees, want %q with %q", tc.src, a.Sentence, count, tc.sentence, tc.count)
+		}
+		if len(a.Trees) == 0 || count != "" && (len(a.Trees) != 2 || a.Trees[0].String() == a.Trees[1].String()) {
+			t.Errorf("%q: trees\n%v", tc.src, a.Trees)
+		}
+		if len(a.Alternatives) == 0 || len(a.Alternatives[0]) < 2 {
+			t.Errorf("%q: alternatives %v", tc.src, a.Alternatives)
+		}
+		if !strings.Contains(strings.Join(a.Conflicts, "\n"), tc.conflict) {
+			t.Errorf("%q: conflicts %q, want %q", tc.src, a.Conflicts, tc.conflict)
+		}
+	}
+}
+
+func TestUnambiguous(t *testing.T) {
+	src, err := os.ReadFile("bnf/dragon-216.grm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		G Grammar
+		n int
+	}{
+		{mustgrammar(t, string(src)), 10},
+		{mustgrammar(t, "S → + S S | - S S | a"), 7},
+		{mustgrammar(t, "E → E + T | T\nT → T * F | F\nF → ( E ) | a"), 7},
+		{mustgrammar(t, "stmt → if expr then stmt | if expr then matched else stmt | other\nmatched → if expr then matched else matched | other"), 10},
+		{mustgrammar(t, nested), 6},
+	}
+	for _, tc := range tests {
+		a, err := tc.G.Ambiguous(tc.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a != nil {
+			t.Errorf("%s: %v", plain(tc.G), a)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Command cc generates a parser in Go from a grammar file.
+//
+//	cc [-ll] [-p package] [-o file.go] [-witness n] file.grm
+//
+// The grammar file declares the terminals, their precedences and the
+// attributes of the Nonterminals, and its productions may end with actions,
+// as described in the documentation of grammar.Spec. The parser, written to
+// standard output or to the file given by -o, uses an LALR(1) table, or an
+// LL(1) table with -ll, and depends only on the standard library. If the
+// LALR(1) table has conflicts, the sentences of at most -witness tokens are
+// searched for one that the grammar derives by two parse trees.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/akiarie/dragon-tests/grammar"
+)
+
+func run(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("cc", flag.ContinueOnError)
+	fs.SetOutput(stdout)
+	ll := fs.Bool("ll", false, "generate a predictive parser from an LL(1) table")
+	pkg := fs.String("p", "", "`package` of the parser, instead of that of the grammar file")
+	out := fs.String("o", "", "write the parser to `file` instead of standard output")
+	witness := fs.Int("witness", 6, "on LALR(1) conflicts, search sentences of up to `n` tokens for an ambiguous one")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: cc [flags] file.grm")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one grammar file")
+	}
+	src, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	spec, err := grammar.ReadSpec(fs.Arg(0), string(src))
+	if err != nil {
+		return err
+	}
+	if *pkg != "" {
+		spec.Package = *pkg
+	}
+	m := grammar.LALR
+	if *ll {
+		m = grammar.LL
+	}
+	code, err := spec.Generate(m)
+	if err != nil && m == grammar.LALR && *witness > 0 {
+		if a, _ := spec.Grammar.Ambiguous(*witness); a != nil {
+			return fmt.Errorf("%v\nthe grammar is ambiguous: %s", err, a)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		_, err = stdout.Write(code)
+		return err
+	}
+	return os.WriteFile(*out, code, 0o644)
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "cc: %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
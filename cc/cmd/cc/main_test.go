@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "calc.go")
+	if err := run([]string{"-p", "arith", "-o", out, "../../bnf/calc.grm"}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	code, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(code, []byte("\npackage arith\n")) || !bytes.Contains(code, []byte("func Parse(src string, actions Actions) (*Expr, error)")) {
+		t.Errorf("generated\n%s", code)
+	}
+	ambiguous := filepath.Join(t.TempDir(), "ambiguous.grm")
+	if err := os.WriteFile(ambiguous, []byte("e → e + e | x\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{ambiguous}, "not LALR(1)"},
+		{[]string{ambiguous}, `"x + x + x" has 2 parse trees`},
+		{[]string{"-witness", "0", ambiguous}, "state 4 on +: shift, reduce e → e + e"},
+		{[]string{"-ll", "../../bnf/calc.grm"}, "not LL(1)"},
+		{[]string{"../../bnf/dragon-216.grm"}, ""},
+		{[]string{"missing.grm"}, "no such file"},
+		{nil, "expected one grammar file"},
+	} {
+		err := run(tc.args, io.Discard)
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%v: got error %v, want %q", tc.args, err, tc.err)
+		}
+	}
+}
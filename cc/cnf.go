@@ -0,0 +1,302 @@
+package grammar
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// cfg is a grammar whose bodies are lists of symbols, without actions, for
+// transformations that construct new productions.
+type cfg struct {
+	heads  []string // the first is the start symbol
+	bodies map[string][][]string
+}
+
+func (G Grammar) cfg() *cfg {
+	g := &cfg{bodies: map[string][][]string{}}
+	for _, nt := range G {
+		for _, prod := range nt.Productions {
+			g.add(nt.Head, prod.body())
+		}
+	}
+	return g
+}
+
+// add adds the production head → body, unless g has it.
+func (g *cfg) add(head string, body []string) {
+	if _, ok := g.bodies[head]; !ok {
+		g.heads = append(g.heads, head)
+		g.bodies[head] = nil
+	}
+	for _, b := range g.bodies[head] {
+		if strings.Join(b, " ") == strings.Join(body, " ") {
+			return
+		}
+	}
+	g.bodies[head] = append(g.bodies[head], body)
+}
+
+func (g *cfg) isnonterminal(sym string) bool {
+	_, ok := g.bodies[sym]
+	return ok
+}
+
+// fresh is a name for a new Nonterminal that is not a symbol of g: base, or
+// else base followed by a number.
+func (g *cfg) fresh(base string) string {
+	used := map[string]bool{}
+	for _, head := range g.heads {
+		used[head] = true
+		for _, body := range g.bodies[head] {
+			for _, sym := range body {
+				used[sym] = true
+			}
+		}
+	}
+	if !used[base] {
+		return base
+	}
+	for i := 1; ; i++ {
+		if name := fmt.Sprintf("%s%d", base, i); !used[name] {
+			return name
+		}
+	}
+}
+
+func (g *cfg) grammar() Grammar {
+	G := Grammar{}
+	for _, head := range g.heads {
+		nt := Nonterminal{Head: head}
+		for _, body := range g.bodies[head] {
+			if len(body) == 0 {
+				nt.Productions = append(nt.Productions, "ε")
+			} else {
+				nt.Productions = append(nt.Productions, production(strings.Join(body, " ")))
+			}
+		}
+		G = append(G, nt)
+	}
+	return G
+}
+
+// nullable are the Nonterminals that derive ε.
+func (g *cfg) nullable() map[string]bool {
+	nullable := map[string]bool{}
+	for changed := true; changed; {
+		changed = false
+		for _, head := range g.heads {
+			for _, body := range g.bodies[head] {
+				all := true
+				for _, sym := range body {
+					all = all && nullable[sym]
+				}
+				if all && !nullable[head] {
+					nullable[head], changed = true, true
+				}
+			}
+		}
+	}
+	return nullable
+}
+
+// eliminateε replaces each production by those omitting any of the
+// Nonterminals of its body that derive ε, without the ε-productions, except
+// for the start symbol, which must not occur in a body.
+func (g *cfg) eliminateε() {
+	nullable := g.nullable()
+	old := g.bodies
+	g.bodies = map[string][][]string{}
+	for _, head := range g.heads {
+		g.bodies[head] = nil
+		for _, body := range old[head] {
+			var omit func(i int, kept []string)
+			omit = func(i int, kept []string) {
+				if i == len(body) {
+					if len(kept) > 0 {
+						g.add(head, kept)
+					}
+					return
+				}
+				omit(i+1, append(append([]string{}, kept...), body[i]))
+				if nullable[body[i]] {
+					omit(i+1, kept)
+				}
+			}
+			omit(0, nil)
+		}
+	}
+	if start := g.heads[0]; nullable[start] {
+		g.add(start, []string{})
+	}
+}
+
+// eliminateunits replaces the unit productions A → B by the productions of
+// the Nonterminals that A derives by them.
+func (g *cfg) eliminateunits() {
+	old := g.bodies
+	g.bodies = map[string][][]string{}
+	for _, head := range g.heads {
+		g.bodies[head] = nil
+	}
+	for _, head := range g.heads {
+		reached := map[string]bool{head: true}
+		for queue := []string{head}; len(queue) > 0; queue = queue[1:] {
+			for _, body := range old[queue[0]] {
+				if len(body) == 1 && g.isnonterminal(body[0]) {
+					if !reached[body[0]] {
+						reached[body[0]] = true
+						queue = append(queue, body[0])
+					}
+				} else {
+					g.add(head, body)
+				}
+			}
+		}
+	}
+}
+
+// eliminateuseless removes the Nonterminals that derive no string of
+// terminals, and then those that the start symbol does not derive, reporting
+// false if the start symbol is among the former.
+func (g *cfg) eliminateuseless() bool {
+	generating := map[string]bool{}
+	for changed := true; changed; {
+		changed = false
+		for _, head := range g.heads {
+			for _, body := range g.bodies[head] {
+				all := true
+				for _, sym := range body {
+					all = all && (generating[sym] || !g.isnonterminal(sym))
+				}
+				if all && !generating[head] {
+					generating[head], changed = true, true
+				}
+			}
+		}
+	}
+	if !generating[g.heads[0]] {
+		return false
+	}
+	reachable := map[string]bool{g.heads[0]: true}
+	for queue := []string{g.heads[0]}; len(queue) > 0; queue = queue[1:] {
+	bodies:
+		for _, body := range g.bodies[queue[0]] {
+			for _, sym := range body {
+				if g.isnonterminal(sym) && !generating[sym] {
+					continue bodies
+				}
+			}
+			for _, sym := range body {
+				if g.isnonterminal(sym) && !reachable[sym] {
+					reachable[sym] = true
+					queue = append(queue, sym)
+				}
+			}
+		}
+	}
+	old := g.bodies
+	heads := g.heads
+	g.heads, g.bodies = nil, map[string][][]string{}
+	for _, head := range heads {
+		if !reachable[head] {
+			continue
+		}
+		g.heads = append(g.heads, head)
+		g.bodies[head] = nil
+	prods:
+		for _, body := range old[head] {
+			for _, sym := range body {
+				if _, ok := old[sym]; ok && !generating[sym] {
+					continue prods
+				}
+			}
+			g.add(head, body)
+		}
+	}
+	return true
+}
+
+var identre = regexp.MustCompile(`^[A-Za-z]\w*$`)
+
+// ToCNF converts G, without its actions, to a grammar in Chomsky normal form
+// that generates the same language: each body is two Nonterminals or one
+// terminal, except for a production S0 → ε of a new start symbol S0 if G
+// derives ε. It eliminates ε-productions, unit productions and useless
+// symbols, and then puts a new Nonterminal in place of each terminal in a body
+// of more than one symbol, and of the tail of each body of more than two.
+func (G Grammar) ToCNF() (Grammar, error) {
+	if err := G.Validate(); err != nil {
+		return nil, err
+	}
+	g := G.cfg()
+	start := g.fresh(g.heads[0] + "0")
+	g.heads = append([]string{start}, g.heads...)
+	g.bodies[start] = [][]string{{G[0].Head}}
+	g.eliminateε()
+	g.eliminateunits()
+	if !g.eliminateuseless() {
+		return nil, fmt.Errorf("%s derives no string of terminals", G[0].Head)
+	}
+
+	// the Nonterminals for terminals
+	terms := map[string]string{}
+	for _, head := range append([]string{}, g.heads...) {
+		for _, body := range g.bodies[head] {
+			if len(body) < 2 {
+				continue
+			}
+			for i, sym := range body {
+				if g.isnonterminal(sym) {
+					continue
+				}
+				if _, ok := terms[sym]; !ok {
+					base := "T"
+					if identre.MatchString(sym) {
+						base = strings.ToUpper(sym)
+					}
+					terms[sym] = g.fresh(base)
+					g.add(terms[sym], []string{sym})
+				}
+				body[i] = terms[sym]
+			}
+		}
+	}
+
+	// the Nonterminals for the tails of long bodies
+	tails := map[string]string{}
+	for k := 0; k < len(g.heads); k++ {
+		head := g.heads[k]
+		for i, body := range g.bodies[head] {
+			if len(body) <= 2 {
+				continue
+			}
+			tail := strings.Join(body[1:], " ")
+			if _, ok := tails[tail]; !ok {
+				tails[tail] = g.fresh("C")
+				g.add(tails[tail], append([]string{}, body[1:]...))
+			}
+			g.bodies[head][i] = []string{body[0], tails[tail]}
+		}
+	}
+	return g.grammar(), nil
+}
+
+// cnf reports the first production of G that is not in Chomsky normal form.
+func (G Grammar) cnf() error {
+	heads := G.heads()
+	for _, nt := range G {
+		for _, prod := range nt.Productions {
+			body := prod.body()
+			switch {
+			case len(body) == 0 && nt.Head == G[0].Head:
+			case len(body) == 1 && heads[body[0]].Head == "":
+			case len(body) == 2 && heads[body[0]].Head != "" && heads[body[1]].Head != "" &&
+				body[0] != G[0].Head && body[1] != G[0].Head:
+			default:
+				return fmt.Errorf("not in Chomsky normal form: %s → %s", nt.Head, prod)
+			}
+		}
+	}
+	return nil
+}
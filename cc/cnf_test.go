@@ -0,0 +1,127 @@
+package grammar
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+// plain writes G a Nonterminal to a line, without colours.
+func plain(G Grammar) string {
+	lines := []string{}
+	for _, nt := range G {
+		lines = append(lines, nt.Head+" → "+strings.Join(productionstostrings(nt.Productions), " | "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestToCNF(t *testing.T) {
+	tests := []struct {
+		src, cnf string
+		inputs   []string
+	}{
+		{
+			"S → + S S | - S S | a",
+			"S0 → T C | T1 C | a\nS → T C | T1 C | a\nT → +\nT1 → -\nC → S S",
+			[]string{"a", "+ a a", "+ - + a a + a a a", "+ a", "a a", "- a"},
+		},
+		{
+			"S → 0 S 1 | 0 1",
+			"S0 → T C | T T1\nS → T C | T T1\nT → 0\nT1 → 1\nC → S T1",
+			[]string{"0 1", "0 0 0 1 1 1", "0 0 1", "1 0", "0 1 0 1"},
+		},
+		{
+			"S → ( S ) S S | ε",
+			"S0 → ε | T C | T C1 | T C2 | T C3 | T C4 | T T1\nS → T C | T C1 | T C2 | T C3 | T C4 | T T1\n" +
+				"T → (\nT1 → )\nC → S C3\nC1 → S C4\nC2 → S T1\nC3 → T1 C5\nC4 → T1 S\nC5 → S S",
+			[]string{"", "( )", "( ( ) )", "( ) ( ) ( )", "( ( ) ( ) ( ) )", "(", ") ("},
+		},
+		{
+			"E → E + T | T\nT → T * F | F\nF → ( E ) | a\nU → U a",
+			"E0 → E C | T C1 | T3 C2 | a\nE → E C | T C1 | T3 C2 | a\nT → T C1 | T3 C2 | a\nF → T3 C2 | a\n" +
+				"T1 → +\nT2 → *\nT3 → (\nT4 → )\nC → T1 T\nC1 → T2 F\nC2 → E T4",
+			[]string{"a", "a + a * a", "( a + a ) * a", "a +", "( a"},
+		},
+	}
+	for _, tc := range tests {
+		G, err := ReadGrammar(tc.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		C, err := G.ToCNF()
+		if err != nil {
+			t.Errorf("%q: %v", tc.src, err)
+			continue
+		}
+		if got := plain(C); got != tc.cnf {
+			t.Errorf("%q: ToCNF =\n%s\nwant\n%s", tc.src, got, tc.cnf)
+		}
+		for _, input := range tc.inputs {
+			f := source.NewFile("", input)
+			table, err := C.CYK(f)
+			if err != nil {
+				t.Errorf("%q: %v", input, err)
+				continue
+			}
+			if want := G.Recognize(f) == nil; table.Accepts() != want {
+				t.Errorf("%q: CYK accepts %q: %v, want %v", tc.src, input, !want, want)
+			}
+			if tree, err := table.Tree(); table.Accepts() && (err != nil || tree.head != C[0].Head) {
+				t.Errorf("%q: %q: Tree = %v, %v", tc.src, input, tree, err)
+			}
+		}
+	}
+}
+
+func TestCYK(t *testing.T) {
+	C, err := mustgrammar(t, "S → 0 S 1 | 0 1").ToCNF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := C.CYK(source.NewFile("", "0011"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `4  {S0, S}
+3  ∅    {C}
+2  ∅    {S0, S}  ∅
+1  {T}  {T}      {T1}  {T1}
+   0    0        1     1
+`
+	if got := table.String(); got != want {
+		t.Errorf("table:\n%s\nwant\n%s", got, want)
+	}
+	tree, err := table.Tree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := flat(tree); got != "(0((01)1))" {
+		t.Errorf("Tree = %s", got)
+	}
+	table, err = C.CYK(source.NewFile("", "001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := table.Tree(); err == nil || err.Error() != "1:1: S0 does not derive the input" {
+		t.Errorf("Tree of 001: %v", err)
+	}
+}
+
+func TestCNFErrors(t *testing.T) {
+	if _, err := mustgrammar(t, "S → S a").ToCNF(); err == nil || err.Error() != "S derives no string of terminals" {
+		t.Errorf("ToCNF: %v", err)
+	}
+	if _, err := mustgrammar(t, "S → 0 S 1 | 0 1").CYK(source.NewFile("", "01")); err == nil ||
+		err.Error() != "not in Chomsky normal form: S → 0 S 1" {
+		t.Errorf("CYK: %v", err)
+	}
+}
+
+func mustgrammar(t testing.TB, src string) Grammar {
+	G, err := ReadGrammar(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return G
+}
@@ -0,0 +1,158 @@
+package grammar
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+// CYKTable is the triangular table of the Cocke-Younger-Kasami algorithm for
+// a grammar in Chomsky normal form and a sequence of tokens: the Nonterminals
+// that derive each subsequence.
+type CYKTable struct {
+	G      Grammar
+	tokens []Token
+	file   *source.File
+	cells  [][]map[string]split // [i][l-1] for the l tokens from token i
+}
+
+// split is how a Nonterminal derives the tokens of a cell, the first way
+// found: by production prod, the first symbol of whose body derives k tokens.
+type split struct {
+	prod production
+	k    int
+}
+
+// CYK fills the table for the tokens of f, bottom-up from the shortest
+// subsequences, as G derives them. G must be in Chomsky normal form.
+func (G Grammar) CYK(f *source.File) (*CYKTable, error) {
+	if err := G.cnf(); err != nil {
+		return nil, err
+	}
+	tokens, err := G.lexemes(f)
+	if err != nil {
+		return nil, err
+	}
+	n := len(tokens)
+	t := &CYKTable{G: G, tokens: tokens, file: f, cells: make([][]map[string]split, n)}
+	for i := range t.cells {
+		t.cells[i] = make([]map[string]split, n-i)
+		for l := range t.cells[i] {
+			t.cells[i][l] = map[string]split{}
+		}
+	}
+	add := func(cell map[string]split, head string, s split) {
+		if _, ok := cell[head]; !ok {
+			cell[head] = s
+		}
+	}
+	for i, tk := range tokens {
+		for _, nt := range G {
+			for _, prod := range nt.Productions {
+				if body := prod.body(); len(body) == 1 && matches(body[0], tk) {
+					add(t.cells[i][0], nt.Head, split{prod, 1})
+				}
+			}
+		}
+	}
+	// the productions whose bodies are two Nonterminals, read once
+	type binary struct {
+		head        string
+		prod        production
+		left, right string
+	}
+	binaries := []binary{}
+	for _, nt := range G {
+		for _, prod := range nt.Productions {
+			if body := prod.body(); len(body) == 2 {
+				binaries = append(binaries, binary{nt.Head, prod, body[0], body[1]})
+			}
+		}
+	}
+	for l := 2; l <= n; l++ {
+		for i := 0; i+l <= n; i++ {
+			for k := 1; k < l; k++ {
+				left, right := t.cells[i][k-1], t.cells[i+k][l-k-1]
+				for _, b := range binaries {
+					_, lok := left[b.left]
+					_, rok := right[b.right]
+					if lok && rok {
+						add(t.cells[i][l-1], b.head, split{b.prod, k})
+					}
+				}
+			}
+		}
+	}
+	return t, nil
+}
+
+// Accepts reports whether the start symbol derives all the tokens.
+func (t *CYKTable) Accepts() bool {
+	if len(t.tokens) == 0 {
+		for _, prod := range t.G[0].Productions {
+			if len(prod.body()) == 0 {
+				return true
+			}
+		}
+		return false
+	}
+	_, ok := t.cells[0][len(t.tokens)-1][t.G[0].Head]
+	return ok
+}
+
+// Tree reconstructs a parse tree of the tokens from the table.
+func (t *CYKTable) Tree() (*node, error) {
+	start := t.G[0].Head
+	if !t.Accepts() {
+		return nil, fmt.Errorf("%s: %s does not derive the input", position(t.file, t.tokens, 0), start)
+	}
+	if len(t.tokens) == 0 {
+		return derived(start, "ε", nil), nil
+	}
+	var tree func(A string, i, l int) *node
+	tree = func(A string, i, l int) *node {
+		s := t.cells[i][l-1][A]
+		if l == 1 {
+			tk := t.tokens[i]
+			return derived(A, s.prod, []node{{symbol: tk.string, span: tk.span}})
+		}
+		body := s.prod.body()
+		return derived(A, s.prod, []node{*tree(body[0], i, s.k), *tree(body[1], i+s.k, l-s.k)})
+	}
+	return tree(start, 0, len(t.tokens)), nil
+}
+
+// String draws the table as a triangle: the row for each length of
+// subsequence, longest first, with the cell for each token at which one
+// begins, and the tokens beneath.
+func (t *CYKTable) String() string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	n := len(t.tokens)
+	for l := n; l >= 1; l-- {
+		row := []string{fmt.Sprint(l)}
+		for i := 0; i+l <= n; i++ {
+			heads := []string{}
+			for _, nt := range t.G {
+				if _, ok := t.cells[i][l-1][nt.Head]; ok {
+					heads = append(heads, nt.Head)
+				}
+			}
+			if len(heads) == 0 {
+				row = append(row, "∅")
+			} else {
+				row = append(row, "{"+strings.Join(heads, ", ")+"}")
+			}
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	row := []string{""}
+	for _, tk := range t.tokens {
+		row = append(row, tk.string)
+	}
+	fmt.Fprintln(w, strings.Join(row, "\t"))
+	w.Flush()
+	return b.String()
+}
@@ -0,0 +1,242 @@
+package grammar
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Symbol is a symbol of a sentential form: a Nonterminal, or a terminal, as
+// the lexeme of its token.
+type Symbol struct {
+	Name        string
+	Nonterminal bool
+}
+
+// Form is a sentential form.
+type Form []Symbol
+
+func (form Form) String() string {
+	if len(form) == 0 {
+		return "ε"
+	}
+	names := []string{}
+	for _, sym := range form {
+		names = append(names, sym.Name)
+	}
+	return strings.Join(names, " ")
+}
+
+// Step is a sentential form of a derivation, and the production applied to
+// it to derive the next: to the Nonterminal Form[At], Head → Body, the body
+// empty for an ε-production. At is -1 in the last step, whose form is the
+// sentence.
+type Step struct {
+	Form Form
+	At   int
+	Head string
+	Body []string
+}
+
+// Derivation is the sequence of sentential forms by which a parse tree derives
+// its sentence, replacing the leftmost or the rightmost Nonterminal of each.
+type Derivation struct {
+	Rightmost bool
+	Steps     []Step
+}
+
+// pending is a symbol of a sentential form, with its subtree if it is a
+// Nonterminal not yet replaced.
+type pending struct {
+	sym  Symbol
+	tree *node
+}
+
+// symbolof is the symbol of the root of n.
+func (n *node) symbolof() Symbol {
+	if n.head == "" {
+		return Symbol{Name: n.symbol}
+	}
+	return Symbol{Name: n.head, Nonterminal: true}
+}
+
+func (n *node) derive(rightmost bool) *Derivation {
+	d := &Derivation{Rightmost: rightmost}
+	form := []pending{{n.symbolof(), n}}
+	for {
+		at := -1
+		for i, p := range form {
+			if p.sym.Nonterminal && (at < 0 || rightmost) {
+				at = i
+			}
+		}
+		syms := Form{}
+		for _, p := range form {
+			syms = append(syms, p.sym)
+		}
+		if at < 0 {
+			d.Steps = append(d.Steps, Step{Form: syms, At: -1})
+			return d
+		}
+		t := form[at].tree
+		d.Steps = append(d.Steps, Step{Form: syms, At: at, Head: t.head, Body: t.prod.body()})
+		children := []pending{}
+		for i := range t.children {
+			children = append(children, pending{t.children[i].symbolof(), &t.children[i]})
+		}
+		form = append(append(append([]pending{}, form[:at]...), children...), form[at+1:]...)
+	}
+}
+
+// Leftmost is the leftmost derivation of the sentence of the tree n.
+func (n *node) Leftmost() *Derivation { return n.derive(false) }
+
+// Rightmost is the rightmost derivation of the sentence of the tree n.
+func (n *node) Rightmost() *Derivation { return n.derive(true) }
+
+// String writes the derivation a form to a line, as
+//
+//	S ⇒ + S S
+//	  ⇒ + a S
+//
+// and so on.
+func (d *Derivation) String() string {
+	var b strings.Builder
+	first := d.Steps[0].Form.String()
+	b.WriteString(first)
+	for i, step := range d.Steps[1:] {
+		if i > 0 {
+			b.WriteString("\n" + strings.Repeat(" ", utf8.RuneCountInString(first)))
+		}
+		fmt.Fprintf(&b, " ⇒ %s", step.Form)
+	}
+	return b.String()
+}
+
+// LaTeX writes the derivation as an align* environment of amsmath, with ⇒
+// marked lm or rm.
+func (d *Derivation) LaTeX() string {
+	arrow := `\underset{lm}{\Rightarrow}`
+	if d.Rightmost {
+		arrow = `\underset{rm}{\Rightarrow}`
+	}
+	var b strings.Builder
+	b.WriteString("\\begin{align*}\n")
+	b.WriteString(d.Steps[0].Form.latex())
+	for i, step := range d.Steps[1:] {
+		if i > 0 {
+			b.WriteString(` \\` + "\n")
+		}
+		fmt.Fprintf(&b, " &%s %s", arrow, step.Form.latex())
+	}
+	b.WriteString("\n\\end{align*}\n")
+	return b.String()
+}
+
+// latex writes sym in math mode as the book does: a Nonterminal in italics, a
+// terminal that is a word in bold.
+func (sym Symbol) latex() string {
+	word := true
+	for _, c := range sym.Name {
+		word = word && (unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_')
+	}
+	name := latexescape(sym.Name)
+	switch {
+	case sym.Nonterminal && utf8.RuneCountInString(sym.Name) == 1:
+		return name
+	case sym.Nonterminal:
+		return `\mathit{` + name + "}"
+	case word:
+		return `\mathbf{` + name + "}"
+	}
+	return name
+}
+
+// latexescape escapes the characters of s that are special to LaTeX.
+func latexescape(s string) string {
+	return strings.NewReplacer(
+		`\`, `\backslash `, "{", `\{`, "}", `\}`, "_", `\_`, "#", `\#`, "$", `\$`,
+		"%", `\%`, "&", `\&`, "^", `\hat{}`, "~", `\sim `, "ε", `\epsilon `,
+	).Replace(s)
+}
+
+// latex writes the form in math mode, its symbols spaced apart.
+func (form Form) latex() string {
+	if len(form) == 0 {
+		return `\epsilon`
+	}
+	syms := []string{}
+	for _, sym := range form {
+		syms = append(syms, sym.latex())
+	}
+	return strings.Join(syms, `\;`)
+}
+
+// Handle is a step of a bottom-up parse: a right-sentential form, and the
+// handle Form[At:At+len(Body)] that is reduced to Head.
+type Handle struct {
+	Form Form
+	At   int
+	Head string
+	Body []string
+}
+
+// Reductions are the handles of a bottom-up parse, in the order in which they
+// are reduced.
+type Reductions []Handle
+
+// Reductions are the handles reduced by a bottom-up parse of the sentence of
+// the tree n, which reverses its rightmost derivation.
+func (n *node) Reductions() Reductions {
+	steps := n.Rightmost().Steps
+	r := Reductions{}
+	for i := len(steps) - 2; i >= 0; i-- {
+		r = append(r, Handle{Form: steps[i+1].Form, At: steps[i].At, Head: steps[i].Head, Body: steps[i].Body})
+	}
+	return r
+}
+
+// handle is the text of the handle of h.
+func (h Handle) handle() Form {
+	return h.Form[h.At : h.At+len(h.Body)]
+}
+
+// reducing writes the production by which the handle of h is reduced.
+func (h Handle) reducing() string {
+	if len(h.Body) == 0 {
+		return h.Head + " → ε"
+	}
+	return h.Head + " → " + strings.Join(h.Body, " ")
+}
+
+// String writes a table of the right-sentential forms, their handles and the
+// productions by which they are reduced, as Fig. 4.26 does.
+func (r Reductions) String() string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "right sentential form\thandle\treducing production")
+	for _, h := range r {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", h.Form, h.handle(), h.reducing())
+	}
+	w.Flush()
+	return b.String()
+}
+
+// LaTeX writes the table of String as a tabular environment.
+func (r Reductions) LaTeX() string {
+	var b strings.Builder
+	b.WriteString("\\begin{tabular}{lll}\n")
+	b.WriteString(`Right Sentential Form & Handle & Reducing Production \\ \hline` + "\n")
+	for _, h := range r {
+		body := Form{}
+		for i, sym := range h.Body {
+			body = append(body, Symbol{Name: sym, Nonterminal: h.handle()[i].Nonterminal})
+		}
+		fmt.Fprintf(&b, `$%s$ & $%s$ & $%s \rightarrow %s$ \\`+"\n",
+			h.Form.latex(), h.handle().latex(), Symbol{h.Head, true}.latex(), body.latex())
+	}
+	b.WriteString("\\end{tabular}\n")
+	return b.String()
+}
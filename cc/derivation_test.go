@@ -0,0 +1,113 @@
+package grammar
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDerivation(t *testing.T) {
+	tests := []struct {
+		G               Grammar
+		input           string
+		leftmost        string
+		rightmost       string
+		steps, expanded []int // the positions replaced in each
+	}{
+		{
+			mustgrammar(t, "S → + S S | - S S | a"), "+ - + a a + a a a",
+			"S ⇒ + S S ⇒ + - S S S ⇒ + - + S S S S ⇒ + - + a S S S ⇒ + - + a a S S ⇒ + - + a a + S S S ⇒ " +
+				"+ - + a a + a S S ⇒ + - + a a + a a S ⇒ + - + a a + a a a",
+			"S ⇒ + S S ⇒ + S a ⇒ + - S S a ⇒ + - S + S S a ⇒ + - S + S a a ⇒ + - S + a a a ⇒ " +
+				"+ - + S S + a a a ⇒ + - + S a + a a a ⇒ + - + a a + a a a",
+			[]int{0, 1, 2, 3, 4, 5, 6, 7, 8, -1}, []int{0, 2, 1, 3, 5, 4, 2, 4, 3, -1},
+		},
+		{
+			mustgrammar(t, "S → ( S ) S | ε"), "( ) ( )",
+			"S ⇒ ( S ) S ⇒ ( ) S ⇒ ( ) ( S ) S ⇒ ( ) ( ) S ⇒ ( ) ( )",
+			"S ⇒ ( S ) S ⇒ ( S ) ( S ) S ⇒ ( S ) ( S ) ⇒ ( S ) ( ) ⇒ ( ) ( )",
+			nil, nil,
+		},
+		{
+			fig54.Grammar, "3 * 5",
+			"T ⇒ F R ⇒ 3 R ⇒ 3 * F R ⇒ 3 * 5 R ⇒ 3 * 5",
+			"T ⇒ F R ⇒ F * F R ⇒ F * F ⇒ F * 5 ⇒ 3 * 5",
+			nil, nil,
+		},
+	}
+	oneline := func(d *Derivation) string {
+		return strings.Join(strings.Fields(d.String()), " ")
+	}
+	for _, tc := range tests {
+		tree, err := tc.G.ParseAST([]byte(tc.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lm, rm := tree.Leftmost(), tree.Rightmost()
+		if got := oneline(lm); got != tc.leftmost {
+			t.Errorf("%q: leftmost derivation\n%s\nwant\n%s", tc.input, got, tc.leftmost)
+		}
+		if got := oneline(rm); got != tc.rightmost {
+			t.Errorf("%q: rightmost derivation\n%s\nwant\n%s", tc.input, got, tc.rightmost)
+		}
+		for i := range tc.steps {
+			if lm.Steps[i].At != tc.steps[i] || rm.Steps[i].At != tc.expanded[i] {
+				t.Errorf("%q: step %d replaces %d and %d, want %d and %d",
+					tc.input, i, lm.Steps[i].At, rm.Steps[i].At, tc.steps[i], tc.expanded[i])
+			}
+		}
+	}
+}
+
+func TestReductions(t *testing.T) {
+	tests := []struct {
+		G     Grammar
+		input string
+		table string
+	}{
+		{mustgrammar(t, "S → + S S | - S S | a"), "+ - a a a", `right sentential form  handle  reducing production
++ - a a a              a       S → a
++ - S a a              a       S → a
++ - S S a              - S S   S → - S S
++ S a                  a       S → a
++ S S                  + S S   S → + S S
+`},
+		{mustgrammar(t, "S → ( S ) S | ε"), "( )", `right sentential form  handle   reducing production
+( )                    ε        S → ε
+( S )                  ε        S → ε
+( S ) S                ( S ) S  S → ( S ) S
+`},
+	}
+	for _, tc := range tests {
+		tree, err := tc.G.ParseAST([]byte(tc.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tree.Reductions().String(); got != tc.table {
+			t.Errorf("%q: reductions\n%s\nwant\n%s", tc.input, got, tc.table)
+		}
+	}
+}
+
+func TestDerivationLaTeX(t *testing.T) {
+	tree, err := mustgrammar(t, "block → '{' stmts '}'\nstmts → id_1 ; | ε").ParseAST([]byte("{ id_1 ; }"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `\begin{align*}
+\mathit{block} &\underset{lm}{\Rightarrow} \{\;\mathit{stmts}\;\} \\
+ &\underset{lm}{\Rightarrow} \{\;\mathbf{id\_1}\;;\;\}
+\end{align*}
+`
+	if got := tree.Leftmost().LaTeX(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	want = `\begin{tabular}{lll}
+Right Sentential Form & Handle & Reducing Production \\ \hline
+$\{\;\mathbf{id\_1}\;;\;\}$ & $\mathbf{id\_1}\;;$ & $\mathit{stmts} \rightarrow \mathbf{id\_1}\;;$ \\
+$\{\;\mathit{stmts}\;\}$ & $\{\;\mathit{stmts}\;\}$ & $\mathit{block} \rightarrow \{\;\mathit{stmts}\;\}$ \\
+\end{tabular}
+`
+	if got := tree.Reductions().LaTeX(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
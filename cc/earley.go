@@ -0,0 +1,203 @@
+package grammar
+
+import (
+	"fmt"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+// eitem is an Earley item [A → α·β, i]: a production, the position of the
+// dot, and the set in which the item was predicted.
+type eitem struct{ prod, dot, origin int }
+
+// chart is the Earley sets of a sequence of tokens, set j holding the items
+// after the first j tokens.
+type chart struct {
+	*symbols
+	tokens    []Token
+	nullable  map[int]bool
+	sets      [][]eitem
+	index     []map[eitem]bool
+	completed []map[int]map[int]bool // [j][A][i] if A derives tokens i to j
+
+	// the forest, constructed from the chart
+	nodes  map[[3]int]*fnode
+	splits map[[4]int][][]*fnode
+}
+
+func (c *chart) add(j int, it eitem) {
+	if !c.index[j][it] {
+		c.index[j][it] = true
+		c.sets[j] = append(c.sets[j], it)
+	}
+}
+
+// earley constructs the chart of tokens by Earley's algorithm, predicting as
+// Aycock and Horspool do: an item whose dot is before a Nonterminal that
+// derives ε is also advanced past it, so the completions of ε-productions need
+// no special case.
+func (G Grammar) earley(tokens []Token) *chart {
+	c := &chart{symbols: G.number(), tokens: tokens, nullable: map[int]bool{}}
+	for head, f := range G.sets().first {
+		if f["ε"] {
+			c.nullable[c.code[head]] = true
+		}
+	}
+	n := len(tokens)
+	c.sets = make([][]eitem, n+1)
+	c.index = make([]map[eitem]bool, n+1)
+	c.completed = make([]map[int]map[int]bool, n+1)
+	for j := range c.sets {
+		c.index[j], c.completed[j] = map[eitem]bool{}, map[int]map[int]bool{}
+	}
+	c.add(0, eitem{0, 0, 0})
+	for j := 0; j <= n; j++ {
+		for k := 0; k < len(c.sets[j]); k++ {
+			it := c.sets[j][k]
+			body := c.prods[it.prod].body
+			if it.dot == len(body) {
+				A := c.prods[it.prod].head
+				if c.completed[j][A] == nil {
+					c.completed[j][A] = map[int]bool{}
+				}
+				if c.completed[j][A][it.origin] {
+					continue
+				}
+				c.completed[j][A][it.origin] = true
+				for _, p := range c.sets[it.origin] {
+					if pb := c.prods[p.prod].body; p.dot < len(pb) && pb[p.dot] == A {
+						c.add(j, eitem{p.prod, p.dot + 1, p.origin})
+					}
+				}
+				continue
+			}
+			X := body[it.dot]
+			if c.isterminal(X) {
+				if j < n && matches(c.name(X), tokens[j]) {
+					c.add(j+1, eitem{it.prod, it.dot + 1, it.origin})
+				}
+				continue
+			}
+			for p, prod := range c.prods {
+				if prod.head == X {
+					c.add(j, eitem{p, 0, j})
+				}
+			}
+			if c.nullable[X] {
+				c.add(j, eitem{it.prod, it.dot + 1, it.origin})
+			}
+		}
+	}
+	return c
+}
+
+// accepts reports a syntax error at the first token after which no item
+// survives, if the start symbol does not derive all the tokens; the end of
+// the input is expected there if it derives those before.
+func (c *chart) accepts(f *source.File) error {
+	n := len(c.tokens)
+	if c.index[n][eitem{0, 1, 0}] {
+		return nil
+	}
+	j := n
+	for len(c.sets[j]) == 0 {
+		j--
+	}
+	expected := symbolset{}
+	for _, it := range c.sets[j] {
+		if body := c.prods[it.prod].body; it.dot < len(body) && c.isterminal(body[it.dot]) {
+			expected[c.name(body[it.dot])] = true
+		}
+	}
+	// the start symbol derives the tokens before j
+	if c.index[j][eitem{0, 1, 0}] {
+		expected["end of input"] = true
+	}
+	found := "end of input"
+	if j < n {
+		found = fmt.Sprintf("%q", c.tokens[j].string)
+	}
+	return fmt.Errorf("%s: expected one of %s, found %s", position(f, c.tokens, j), expected, found)
+}
+
+// Recognize reports whether G derives the tokens of f, by Earley's algorithm,
+// which accepts every context-free grammar.
+func (G Grammar) Recognize(f *source.File) error {
+	tokens, err := G.lexemes(f)
+	if err != nil {
+		return err
+	}
+	return G.earley(tokens).accepts(f)
+}
+
+// node is the node of the forest for X deriving tokens i to j, which it must.
+func (c *chart) node(X, i, j int) *fnode {
+	key := [3]int{X, i, j}
+	if n, ok := c.nodes[key]; ok {
+		return n
+	}
+	n := &fnode{sym: X, start: i, end: j}
+	c.nodes[key] = n // before its children, which may include it
+	if c.isterminal(X) {
+		return n
+	}
+	for p, prod := range c.prods {
+		if prod.head == X && c.index[j][eitem{p, len(prod.body), i}] {
+			for _, children := range c.decompose(p, len(prod.body), i, j) {
+				n.alts = append(n.alts, packed{p, children})
+			}
+		}
+	}
+	return n
+}
+
+// decompose returns the ways in which the first k symbols of the body of
+// production p derive tokens i to j, as the nodes of the symbols.
+func (c *chart) decompose(p, k, i, j int) [][]*fnode {
+	key := [4]int{p, k, i, j}
+	if seqs, ok := c.splits[key]; ok {
+		return seqs
+	}
+	var seqs [][]*fnode
+	if k == 0 {
+		if i == j {
+			seqs = [][]*fnode{{}}
+		}
+		c.splits[key] = seqs
+		return seqs
+	}
+	X := c.prods[p].body[k-1]
+	for m := i; m <= j; m++ { // where X begins
+		if !c.index[m][eitem{p, k - 1, i}] {
+			continue
+		}
+		if c.isterminal(X) {
+			if m != j-1 || !matches(c.name(X), c.tokens[m]) {
+				continue
+			}
+		} else if !c.completed[j][X][m] {
+			continue
+		}
+		child := c.node(X, m, j)
+		for _, seq := range c.decompose(p, k-1, i, m) {
+			seqs = append(seqs, append(append([]*fnode{}, seq...), child))
+		}
+	}
+	c.splits[key] = seqs
+	return seqs
+}
+
+// Earley parses f by Earley's algorithm, returning the forest of its parse
+// trees. Unlike ParseFile, it accepts left-recursive and ambiguous grammars.
+func (G Grammar) Earley(f *source.File) (*Forest, error) {
+	tokens, err := G.lexemes(f)
+	if err != nil {
+		return nil, err
+	}
+	c := G.earley(tokens)
+	if err := c.accepts(f); err != nil {
+		return nil, err
+	}
+	c.nodes, c.splits = map[[3]int]*fnode{}, map[[4]int][][]*fnode{}
+	return &Forest{symbols: c.symbols, tokens: tokens, file: f, root: c.node(c.code[G[0].Head], 0, len(tokens))}, nil
+}
@@ -0,0 +1,127 @@
+package grammar
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+// ambiguous is the grammar of expressions that needs precedences, (4.3).
+var ambiguous = Grammar{
+	Nonterminal{"E", []production{"E + E", "E * E", "( E )", "/[0-9]/"}},
+}
+
+// flat writes a tree with parentheses around each Nonterminal of more than
+// one child, and none around those deriving ε.
+func flat(n *node) string {
+	if n.head == "" {
+		return n.symbol
+	}
+	parts := []string{}
+	for i := range n.children {
+		if part := flat(&n.children[i]); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	switch len(parts) {
+	case 0:
+		return ""
+	case 1:
+		return parts[0]
+	}
+	return "(" + strings.Join(parts, "") + ")"
+}
+
+func TestEarley(t *testing.T) {
+	nullable := Grammar{
+		Nonterminal{"S", []production{"A A A x"}},
+		Nonterminal{"A", []production{"a", "B"}},
+		Nonterminal{"B", []production{"ε"}},
+	}
+	cyclic := Grammar{Nonterminal{"S", []production{"S", "a"}}}
+	leftrec := Grammar{Nonterminal{"E", []production{"E - /[0-9]/", "/[0-9]/"}}}
+	tests := []struct {
+		G      Grammar
+		input  string
+		count  string
+		policy Policy
+		tree   string
+	}{
+		{ambiguous, "1", "1", Ordered, "1"},
+		{ambiguous, "1+2+3", "2", LongestFirst, "((1+2)+3)"},
+		{ambiguous, "1+2+3", "2", ShortestFirst, "(1+(2+3))"},
+		{ambiguous, "1+2*3", "2", Ordered, "(1+(2*3))"},
+		{ambiguous, "1*2+3", "2", Ordered, "((1*2)+3)"},
+		{ambiguous, "(1+2)*3", "1", Ordered, "((((1+2)))*3)"},
+		{ambiguous, "1+2+3+4+5", "14", LongestFirst, "((((1+2)+3)+4)+5)"},
+		{leftrec, "9-5-2", "1", Ordered, "((9-5)-2)"},
+		{nullable, "x", "1", Ordered, "x"},
+		{nullable, "a x", "3", LongestFirst, "(ax)"},
+		{nullable, "a a a x", "1", Ordered, "(aaax)"},
+		{cyclic, "a", "", Ordered, "a"},
+	}
+	for _, tc := range tests {
+		f := source.NewFile("", tc.input)
+		if err := tc.G.Recognize(f); err != nil {
+			t.Errorf("%q: Recognize: %v", tc.input, err)
+			continue
+		}
+		F, err := tc.G.Earley(f)
+		if err != nil {
+			t.Errorf("%q: %v", tc.input, err)
+			continue
+		}
+		count, err := F.Count()
+		if tc.count == "" {
+			if err == nil || !strings.Contains(err.Error(), "infinitely many") {
+				t.Errorf("%q: Count = %v, %v, want infinitely many", tc.input, count, err)
+			}
+		} else if err != nil || count.String() != tc.count {
+			t.Errorf("%q: Count = %v, %v, want %s", tc.input, count, err, tc.count)
+		} else if trees := F.Trees(0); len(trees) != int(count.Int64()) {
+			t.Errorf("%q: %d trees, want %s", tc.input, len(trees), tc.count)
+		}
+		tree, err := F.Tree(tc.policy)
+		if err != nil {
+			t.Errorf("%q: Tree: %v", tc.input, err)
+		} else if got := flat(tree); got != tc.tree {
+			t.Errorf("%q: Tree = %s, want %s", tc.input, got, tc.tree)
+		}
+	}
+}
+
+func TestEarleyForest(t *testing.T) {
+	F, err := ambiguous.Earley(source.NewFile("", "1+2+3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `E[0:5] → E[0:1] +[1:2] E[2:5] | E[0:3] +[3:4] E[4:5]
+E[0:1] → /[0-9]/[0:1]
+E[2:5] → E[2:3] +[3:4] E[4:5]
+E[2:3] → /[0-9]/[2:3]
+E[4:5] → /[0-9]/[4:5]
+E[0:3] → E[0:1] +[1:2] E[2:3]
+`
+	if got := F.String(); got != want {
+		t.Errorf("forest:\n%s\nwant:\n%s", got, want)
+	}
+	if trees := F.Trees(1); len(trees) != 1 {
+		t.Errorf("Trees(1) gave %d trees", len(trees))
+	}
+}
+
+func TestEarleyErrors(t *testing.T) {
+	tests := []struct{ input, err string }{
+		{"1+", "1:3: expected one of {(, /[0-9]/}, found end of input"},
+		{"1+)", `1:3: expected one of {(, /[0-9]/}, found ")"`},
+		{"(1 2", `1:4: expected one of {), *, +}, found "2"`},
+		{"1 2", `1:3: expected one of {*, +, end of input}, found "2"`},
+	}
+	for _, tc := range tests {
+		_, err := ambiguous.Earley(source.NewFile("", tc.input))
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("%q: got error %v, want %s", tc.input, err, tc.err)
+		}
+	}
+}
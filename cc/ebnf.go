@@ -0,0 +1,373 @@
+package grammar
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ebnf is an item of a body in extended BNF: a symbol or an action, or a
+// construct of alternatives of items between brackets, perhaps with an
+// operator *, + or ? after it, which a symbol may also have.
+type ebnf struct {
+	sym  string // if kind is 0
+	kind byte   // (, [ or { for a construct
+	alts [][]ebnf
+	op   byte
+}
+
+// closers are the closing brackets of the constructs.
+var closers = map[string]string{"(": ")", "[": "]", "{": "}"}
+
+// isop reports whether c is an operator of extended BNF.
+func isop(c byte) bool { return c == '*' || c == '+' || c == '?' }
+
+// construct reports the field closing the construct that the bracket at
+// fields[i] opens, or -1 if the bracket is a terminal. A bracket opens a
+// construct if it is closed by its pair, perhaps with an operator attached,
+// and no alternative between them is empty; besides, as a group without an
+// operator or alternatives means only its contents, parentheses must have
+// one or the other, so that ( E ) remains three terminals.
+func construct(fields []string, i int) int {
+	opener := fields[i]
+	closer, ok := closers[opener]
+	if !ok || (opener == "{" && isaction(strings.Join(fields[i:], " "))) {
+		return -1
+	}
+	depth := 0
+	j := i + 1
+	for ; j < len(fields); j++ {
+		f := fields[j]
+		if f == opener {
+			depth++
+		} else if f == closer || (len(f) == 2 && f[:1] == closer && isop(f[1])) {
+			if depth == 0 {
+				break
+			}
+			depth--
+		}
+	}
+	if j == len(fields) {
+		return -1
+	}
+	alts := alternatives(fields[i+1 : j])
+	for _, alt := range alts {
+		if len(alt) == 0 {
+			return -1
+		}
+	}
+	if opener == "(" && len(fields[j]) == 1 && len(alts) == 1 {
+		return -1
+	}
+	return j
+}
+
+// alternatives splits fields at the bars outside constructs.
+func alternatives(fields []string) [][]string {
+	alts := [][]string{}
+	start := 0
+	for i := 0; i < len(fields); i++ {
+		if j := construct(fields, i); j >= 0 {
+			i = j
+		} else if fields[i] == "|" {
+			alts = append(alts, fields[start:i])
+			start = i + 1
+		}
+	}
+	return append(alts, fields[start:])
+}
+
+// parseebnf reads the items of fields.
+func parseebnf(fields []string) []ebnf {
+	items := []ebnf{}
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		if j := construct(fields, i); j >= 0 {
+			it := ebnf{kind: f[0]}
+			for _, alt := range alternatives(fields[i+1 : j]) {
+				it.alts = append(it.alts, parseebnf(alt))
+			}
+			if len(fields[j]) == 2 {
+				it.op = fields[j][1]
+			}
+			items = append(items, it)
+			i = j
+		} else if len(f) > 1 && isop(f[len(f)-1]) {
+			items = append(items, ebnf{sym: f[:len(f)-1], op: f[len(f)-1]})
+		} else {
+			items = append(items, ebnf{sym: f})
+		}
+	}
+	return items
+}
+
+// ebnf reads the body of prod as items, reporting whether it uses any
+// construct or operator of extended BNF. Each action is a single item.
+func (prod production) ebnf() ([]ebnf, bool) {
+	fields := []string{}
+	for _, p := range prod.pieces() {
+		if p.action {
+			fields = append(fields, p.text)
+		} else {
+			fields = append(fields, strings.Fields(p.text)...)
+		}
+	}
+	items := parseebnf(fields)
+	for _, it := range items {
+		if it.kind != 0 || it.op != 0 {
+			return items, true
+		}
+	}
+	return items, false
+}
+
+// writeebnf writes the items as they are read, each symbol as sym writes it.
+func writeebnf(items []ebnf, sym func(string) string) string {
+	fields := []string{}
+	for _, it := range items {
+		if it.kind == 0 {
+			f := sym(it.sym)
+			if it.op != 0 {
+				f += string(it.op)
+			}
+			fields = append(fields, f)
+			continue
+		}
+		alts := []string{}
+		for _, alt := range it.alts {
+			alts = append(alts, writeebnf(alt, sym))
+		}
+		closer := closers[string(it.kind)]
+		if it.op != 0 {
+			closer += string(it.op)
+		}
+		fields = append(fields, string(it.kind), strings.Join(alts, " | "), closer)
+	}
+	return strings.Join(fields, " ")
+}
+
+func (it ebnf) String() string {
+	return writeebnf([]ebnf{it}, func(s string) string { return s })
+}
+
+// Rule is the Nonterminal head with the given bodies, which may be written in
+// extended BNF, as Seq, Alt, Star, Plus and Opt construct them.
+func Rule(head string, bodies ...string) Nonterminal {
+	nt := Nonterminal{Head: head}
+	for _, body := range bodies {
+		nt.Productions = append(nt.Productions, production(body))
+	}
+	return nt
+}
+
+// Seq is the sequence of items.
+func Seq(items ...string) string { return strings.Join(items, " ") }
+
+// Alt is a choice of one of alts.
+func Alt(alts ...string) string {
+	if len(alts) == 1 {
+		return alts[0]
+	}
+	return "( " + strings.Join(alts, " | ") + " )"
+}
+
+// attachable reports whether an operator may be attached to s: whether it
+// is a single symbol without one.
+func attachable(s string) bool {
+	return s != "" && !strings.ContainsAny(s, " \t") && !isop(s[len(s)-1]) && closers[s] == ""
+}
+
+// Star is the sequence of items repeated any number of times.
+func Star(items ...string) string {
+	if s := Seq(items...); attachable(s) {
+		return s + "*"
+	}
+	return "{ " + Seq(items...) + " }"
+}
+
+// Plus is the sequence of items repeated at least once.
+func Plus(items ...string) string {
+	if s := Seq(items...); attachable(s) {
+		return s + "+"
+	}
+	return "( " + Seq(items...) + " )+"
+}
+
+// Opt is the sequence of items, or nothing.
+func Opt(items ...string) string {
+	if s := Seq(items...); attachable(s) {
+		return s + "?"
+	}
+	return "[ " + Seq(items...) + " ]"
+}
+
+var wordre = regexp.MustCompile(`^\w+$`)
+
+// desugarer replaces the constructs of a grammar by helper Nonterminals.
+type desugarer struct {
+	used    map[string]bool
+	helpers map[string]string // by the construct, written canonically
+	count   map[string]int    // of the helpers numbered after each head
+	added   []Nonterminal     // for the head being desugared
+}
+
+// repetition is the meaning of the operator or brackets of a construct.
+func repetition(it ebnf) byte {
+	switch {
+	case it.op != 0:
+		return it.op
+	case it.kind == '{':
+		return '*'
+	case it.kind == '[':
+		return '?'
+	}
+	return 0
+}
+
+var suffixes = map[byte]string{'*': "star", '+': "plus", '?': "opt"}
+
+// helper is the Nonterminal for the construct or symbol with an operator it,
+// met in a production of head, adding it if it is new.
+func (d *desugarer) helper(head string, it ebnf) (string, error) {
+	rep, alts := repetition(it), it.alts
+	if it.kind == 0 {
+		alts = [][]ebnf{{{sym: it.sym}}}
+	}
+	key := string(rep) + ebnf{kind: '(', alts: alts}.String()
+	if name, ok := d.helpers[key]; ok {
+		return name, nil
+	}
+	var name string
+	if len(alts) == 1 && len(alts[0]) == 1 && alts[0][0].kind == 0 && alts[0][0].op == 0 &&
+		wordre.MatchString(alts[0][0].sym) && rep != 0 {
+		name = alts[0][0].sym + "_" + suffixes[rep]
+		for i := 1; d.used[name]; i++ {
+			name = fmt.Sprintf("%s_%s%d", alts[0][0].sym, suffixes[rep], i)
+		}
+	} else {
+		for name = head; d.used[name]; {
+			d.count[head]++
+			name = fmt.Sprintf("%s_%d", head, d.count[head])
+		}
+	}
+	d.used[name], d.helpers[key] = true, name
+	i := len(d.added)
+	d.added = append(d.added, Nonterminal{Head: name})
+
+	var star string
+	if rep == '+' {
+		s, err := d.helper(head, ebnf{kind: '{', alts: alts})
+		if err != nil {
+			return "", err
+		}
+		star = s
+	}
+	prods := []production{}
+	for _, alt := range alts {
+		for _, inner := range alt {
+			if inner.kind == 0 && len(inner.sym) > 1 && inner.sym[0] == '{' {
+				return "", fmt.Errorf("action inside %s", it)
+			}
+		}
+		body, err := d.body(head, alt)
+		if err != nil {
+			return "", err
+		}
+		switch rep {
+		case '*':
+			if body != "" {
+				prods = append(prods, production(Seq(body, name)))
+			}
+		case '+':
+			prods = append(prods, production(strings.TrimSpace(Seq(body, star))))
+		default:
+			if body == "" {
+				body = "ε"
+			}
+			prods = append(prods, production(body))
+		}
+	}
+	if rep == '*' || rep == '?' {
+		prods = append(prods, "ε")
+	}
+	d.added[i].Productions = prods
+	return name, nil
+}
+
+// body writes items in plain BNF, without ε, adding the helpers for their
+// constructs.
+func (d *desugarer) body(head string, items []ebnf) (string, error) {
+	fields := []string{}
+	for _, it := range items {
+		if it.kind == 0 && it.op == 0 {
+			if it.sym != "ε" {
+				fields = append(fields, it.sym)
+			}
+			continue
+		}
+		name, err := d.helper(head, it)
+		if err != nil {
+			return "", err
+		}
+		fields = append(fields, name)
+	}
+	return Seq(fields...), nil
+}
+
+// Desugar rewrites G in plain BNF, replacing each construct of extended BNF
+// in its bodies by a new Nonterminal:
+//
+//	{ α } and α*   by   R → α R | ε
+//	( α )+ and α+  by   P → α R, with R for { α }
+//	[ α ] and α?   by   O → α | ε
+//	( α | β )      by   A → α | β
+//
+// where a construct may have alternatives separated by bars. The helper for
+// a single symbol X is named X_star, X_plus or X_opt; the others are named
+// after the head of the production in which they occur, A_1, A_2, ..., in
+// the order in which they are met, outer before inner, each following that
+// head in the result. A name that G uses already is numbered further, and a
+// construct that recurs has one helper. The repetitions are right-recursive,
+// so that every parser here accepts them. A terminal that is a bracket
+// opening a construct must be quoted, as in '{'. The productions without
+// constructs are unchanged, actions and all, so a grammar in BNF is its own
+// desugaring.
+func (G Grammar) Desugar() (Grammar, error) {
+	d := &desugarer{used: map[string]bool{}, helpers: map[string]string{}, count: map[string]int{}}
+	for _, nt := range G {
+		d.used[nt.Head] = true
+		for _, prod := range nt.Productions {
+			for _, sym := range prod.symbols() {
+				d.used[sym] = true
+			}
+		}
+	}
+	desugared := Grammar{}
+	for _, nt := range G {
+		d.added = nil
+		result := Nonterminal{Head: nt.Head}
+		for _, prod := range nt.Productions {
+			items, ok := prod.ebnf()
+			for _, it := range items {
+				if it.kind == 0 && it.sym == "|" {
+					return nil, fmt.Errorf("%s → %s: bar outside brackets", nt.Head, prod)
+				}
+			}
+			if !ok {
+				result.Productions = append(result.Productions, prod)
+				continue
+			}
+			body, err := d.body(nt.Head, items)
+			if err != nil {
+				return nil, fmt.Errorf("%s → %s: %v", nt.Head, prod, err)
+			}
+			if body == "" {
+				body = "ε"
+			}
+			result.Productions = append(result.Productions, production(body))
+		}
+		desugared = append(desugared, result)
+		desugared = append(desugared, d.added...)
+	}
+	return desugared, nil
+}
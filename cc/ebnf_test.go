@@ -0,0 +1,168 @@
+package grammar
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+func TestDesugar(t *testing.T) {
+	tests := []struct {
+		src, bnf       string
+		accept, reject []string
+	}{
+		{
+			"L → I R*\nR → , I\nI → /[a-z]/",
+			"L → I R_star\nR_star → R R_star | ε\nR → , I\nI → /[a-z]/",
+			[]string{"a", "a, b, c"}, []string{"a,", ", a"},
+		},
+		{
+			"L → /[0-9]/+ ;?",
+			"L → L_1 L_3\nL_1 → /[0-9]/ L_2\nL_2 → /[0-9]/ L_2 | ε\nL_3 → ; | ε",
+			[]string{"1", "1 2 3", "1 2 ;"}, []string{";", "1 ; ;"},
+		},
+		{
+			"E → T { ( + | - ) T }\nT → /[0-9]/",
+			"E → T E_1\nE_1 → E_2 T E_1 | ε\nE_2 → + | -\nT → /[0-9]/",
+			[]string{"9-5+2", "9"}, []string{"9-", "-5"},
+		},
+		{
+			"stmt → if ( expr ) stmt [ else stmt ] | other\nexpr → x",
+			"stmt → if ( expr ) stmt stmt_1 | other\nstmt_1 → else stmt | ε\nexpr → x",
+			[]string{"if ( x ) other", "if ( x ) other else other"}, []string{"if ( x ) else other"},
+		},
+		{
+			"S → ( a | b c )+ d? | ( x )*",
+			"S → S_1 d_opt | x_star\nS_1 → a S_2 | b c S_2\nS_2 → a S_2 | b c S_2 | ε\nd_opt → d | ε\nx_star → x x_star | ε",
+			[]string{"a", "b c a d", "", "x x"}, []string{"d", "b"},
+		},
+		{
+			"A → x* | y x*\nx_star → z",
+			"A → x_star1 | y x_star1\nx_star1 → x x_star1 | ε\nx_star → z",
+			[]string{"x x", "y"}, []string{"z"},
+		},
+		{
+			"block → '{' stmt* '}'\nstmt → s",
+			"block → '{' stmt_star '}'\nstmt_star → stmt stmt_star | ε\nstmt → s",
+			[]string{"{}", "{ s s }"}, []string{"{ s"},
+		},
+	}
+	for _, tc := range tests {
+		G, err := ReadGrammar(tc.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		D, err := G.Desugar()
+		if err != nil {
+			t.Errorf("%q: %v", tc.src, err)
+			continue
+		}
+		if got := plain(D); got != tc.bnf {
+			t.Errorf("%q: desugared to\n%s\nwant\n%s", tc.src, got, tc.bnf)
+		}
+		for _, input := range tc.accept {
+			if err := D.Recognize(source.NewFile("", input)); err != nil {
+				t.Errorf("%q on %q: %v", tc.src, input, err)
+			}
+		}
+		for _, input := range tc.reject {
+			if err := D.Recognize(source.NewFile("", input)); err == nil {
+				t.Errorf("%q accepts %q", tc.src, input)
+			}
+		}
+	}
+}
+
+func TestDesugarBNF(t *testing.T) {
+	// a grammar in BNF is its own desugaring
+	for _, G := range []Grammar{fig54.Grammar, mustgrammar(t, nested), mustgrammar(t, "S → { print('x') } a S | ε\n")} {
+		D, err := G.Desugar()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(D, G) {
+			t.Errorf("%s desugared to %s", plain(G), plain(D))
+		}
+	}
+}
+
+func TestEBNFConstructors(t *testing.T) {
+	G := Grammar{
+		Rule("list", Seq("'['", Opt("item", Star("',' item")), "']'")),
+		Rule("item", Alt("num", "list"), Plus("'-'")),
+		Rule("num", "/[0-9]/+"),
+	}
+	want := "list → '[' [ item { ',' item } ] ']'\nitem → ( num | list ) | '-'+\nnum → /[0-9]/+"
+	if got := plain(G); got != want {
+		t.Errorf("constructed\n%s\nwant\n%s", got, want)
+	}
+	read := mustgrammar(t, "list → '[' [ item { ',' item } ] ']'\nitem → ( num | list ) | '-'+\nnum → /[0-9]/+")
+	if !reflect.DeepEqual(read, G) {
+		t.Errorf("read %s", plain(read))
+	}
+	D, err := G.Desugar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "list → '[' list_1 ']'\nlist_1 → item list_2 | ε\nlist_2 → ',' item list_2 | ε\n" +
+		"item → item_1 | item_2\nitem_1 → num | list\nitem_2 → '-' item_3\nitem_3 → '-' item_3 | ε\n" +
+		"num → num_1\nnum_1 → /[0-9]/ num_2\nnum_2 → /[0-9]/ num_2 | ε"
+	if got := plain(D); got != want {
+		t.Errorf("desugared to\n%s\nwant\n%s", got, want)
+	}
+	for input, ok := range map[string]bool{"[]": true, "[1, [2 3], -]": true, "[1,]": false, "[[]": false} {
+		if err := D.Recognize(source.NewFile("", input)); (err == nil) != ok {
+			t.Errorf("%q: %v", input, err)
+		}
+	}
+}
+
+func TestDesugarErrors(t *testing.T) {
+	tests := []struct {
+		G   Grammar
+		err string
+	}{
+		{Grammar{Rule("S", "a | b")}, "S → a | b: bar outside brackets"},
+		{mustgrammar(t, "S → { a { print('a') } } b"), "S → { a { print('a') } } b: action inside { a { print('a') } }"},
+	}
+	for _, tc := range tests {
+		_, err := tc.G.Desugar()
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("%s: got error %v, want %s", plain(tc.G), err, tc.err)
+		}
+	}
+}
+
+func TestEBNFString(t *testing.T) {
+	ansi := regexp.MustCompile("\x1b\\[[0-9;]*m")
+	G := mustgrammar(t, "block → '{' stmt* '}'\nstmt → if ( expr ) stmt [ else stmt ] | other\nexpr → x")
+	want := "block → '{' stmt* '}'\n\n" +
+		"stmt  → if ( expr ) stmt [ else stmt ]\n      | other\n\n" +
+		"expr  → x"
+	if got := ansi.ReplaceAllString(G.String(), ""); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	D, err := G.Desugar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "block     → '{' stmt_star '}'\n\n" +
+		"stmt_star → stmt stmt_star\n          | ε\n\n" +
+		"stmt      → if ( expr ) stmt stmt_1\n          | other\n\n" +
+		"stmt_1    → else stmt\n          | ε\n\n" +
+		"expr      → x"
+	if got := ansi.ReplaceAllString(D.String(), ""); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	// either form reads back as it was
+	for _, G := range []Grammar{G, D} {
+		read, err := ReadGrammar(ansi.ReplaceAllString(G.String(), ""))
+		if err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(read, G) {
+			t.Errorf("%s read back as %s", plain(G), plain(read))
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package grammar
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+// Counterexample is a sentence of one grammar that another does not derive.
+type Counterexample struct {
+	Sentence string
+	// Derivation is its leftmost derivation by the grammar that derives it.
+	Derivation *Derivation
+	// Err is the error of the other grammar in recognizing it.
+	Err error
+}
+
+func (c *Counterexample) String() string {
+	return fmt.Sprintf("%q is not derived: %v\n%s", c.Sentence, c.Err, c.Derivation)
+}
+
+// Includes searches for a sentence of H that G does not derive: among all
+// those of at most n tokens, the shorter first, and then among samples
+// generated at random, which may be longer. It returns the first found, or nil
+// if there is none; the languages may differ nonetheless, in sentences not
+// searched.
+func (G Grammar) Includes(H Grammar, n, samples int) (*Counterexample, error) {
+	e, err := H.enumerator()
+	if err != nil {
+		return nil, err
+	}
+	for k := 0; k <= n; k++ {
+		sentences, err := e.layer(k)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range sentences {
+			f := source.NewFile("", s)
+			rejected := G.Recognize(f)
+			if rejected == nil {
+				continue
+			}
+			F, err := H.Earley(f)
+			if err != nil {
+				return nil, fmt.Errorf("%q: %v", s, err)
+			}
+			tree, err := F.Tree(Ordered)
+			if err != nil {
+				return nil, err
+			}
+			return &Counterexample{Sentence: s, Derivation: tree.Leftmost(), Err: rejected}, nil
+		}
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < samples; i++ {
+		s, err := H.Generate(GenerateOptions{Rand: r})
+		if err != nil {
+			return nil, err
+		}
+		if err := G.Recognize(source.NewFile("", s.Text)); err != nil {
+			return &Counterexample{Sentence: s.Text, Derivation: s.Tree().Leftmost(), Err: err}, nil
+		}
+	}
+	return nil, nil
+}
+
+// Equivalent searches for a sentence that only one of G and H derives, as
+// Includes does, those of H first.
+func (G Grammar) Equivalent(H Grammar, n, samples int) (*Counterexample, error) {
+	if c, err := G.Includes(H, n, samples); c != nil || err != nil {
+		return c, err
+	}
+	return H.Includes(G, n, samples)
+}
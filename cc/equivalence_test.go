@@ -0,0 +1,88 @@
+package grammar
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEquivalent(t *testing.T) {
+	tests := []struct {
+		G, H       Grammar
+		n, samples int
+		sentence   string // only one derives, if any
+		derivation string
+	}{
+		{
+			mustgrammar(t, "E → E + T | T\nT → T * F | F\nF → ( E ) | a"),
+			mustgrammar(t, "E → T R\nR → + T R | ε\nT → F U\nU → * F U | ε\nF → ( E ) | a"),
+			7, 20, "", "",
+		},
+		{mustgrammar(t, "S → a S b | ε"), mustgrammar(t, "S → a S | S b | ε"), 4, 0, "a", "S ⇒ a S ⇒ a"},
+		{mustgrammar(t, "S → a S | S b | ε"), mustgrammar(t, "S → a S b | ε"), 4, 0, "a", "S ⇒ a S ⇒ a"},
+		// only sampling reaches the sentences of two tokens
+		{mustgrammar(t, "S → a S | b"), mustgrammar(t, "S → a S | b | c c"), 1, 20, "c c", ""},
+	}
+	for _, tc := range tests {
+		c, err := tc.G.Equivalent(tc.H, tc.n, tc.samples)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case c == nil && tc.sentence != "":
+			t.Errorf("%s and %s: equivalent, want %q", plain(tc.G), plain(tc.H), tc.sentence)
+		case c == nil:
+		case !strings.HasSuffix(c.Sentence, tc.sentence) || c.Err == nil:
+			t.Errorf("%s and %s: %v, want %q", plain(tc.G), plain(tc.H), c, tc.sentence)
+		case tc.derivation != "" && strings.Join(strings.Fields(c.Derivation.String()), " ") != tc.derivation:
+			t.Errorf("%s and %s: derivation\n%s\nwant\n%s", plain(tc.G), plain(tc.H), c.Derivation, tc.derivation)
+		}
+	}
+	if c, _ := mustgrammar(t, "S → a S | S b | ε").Includes(mustgrammar(t, "S → a S b | ε"), 6, 20); c != nil {
+		t.Errorf("a*b* does not include %v", c)
+	}
+	c, _ := mustgrammar(t, "S → a").Includes(mustgrammar(t, "S → a a"), 2, 0)
+	if want := `1:3: expected one of {end of input}, found "a"`; c == nil || c.Err.Error() != want {
+		t.Errorf("a does not include a a: %v, want %s", c, want)
+	}
+}
+
+// TestTransformations checks that the transformations of grammars preserve
+// their languages.
+func TestTransformations(t *testing.T) {
+	grammars := []Grammar{mustgrammar(t, "S → A B\nA → A a | b\nB → B c | d")}
+	for _, tc := range fuzzed(t) {
+		G, err := tc.G.Desugar()
+		if err != nil {
+			t.Fatal(err)
+		}
+		grammars = append(grammars, G)
+	}
+	for _, G := range grammars {
+		cnf, err := G.ToCNF()
+		if err != nil {
+			t.Fatal(err)
+		}
+		transformed := map[string]Grammar{"ToCNF": cnf}
+		if A, err := G.AntiLeftRecurse(); err == nil {
+			transformed["AntiLeftRecurse"] = A
+		}
+		for name, H := range transformed {
+			if c, err := G.Equivalent(H, 4, 20); c != nil || err != nil {
+				t.Errorf("%s of %s: %v%v", name, plain(G), c, err)
+			}
+		}
+	}
+	for _, tc := range []struct{ ebnf, bnf string }{
+		{"block → '{' S* '}'\nS → s | block", "block → '{' L '}'\nL → S L | ε\nS → s | block"},
+		{"L → x ( , x )*", "L → x | L , x"},
+		{"S → a+ [ b | c ]", "S → A | A b | A c\nA → a | A a"},
+	} {
+		G, err := mustgrammar(t, tc.ebnf).Desugar()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c, err := G.Equivalent(mustgrammar(t, tc.bnf), 4, 20); c != nil || err != nil {
+			t.Errorf("Desugar of %s: %v%v", tc.ebnf, c, err)
+		}
+	}
+}
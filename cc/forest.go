@@ -0,0 +1,356 @@
+package grammar
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+// fnode is a node of a parse forest: symbol sym deriving tokens start to end,
+// in each of the ways given by alts.
+type fnode struct {
+	sym        int
+	start, end int
+	alts       []packed
+}
+
+// packed is one way in which a Nonterminal derives its tokens: by production
+// prod, the symbols of whose body derive children.
+type packed struct {
+	prod     int
+	children []*fnode
+}
+
+// Forest is a shared packed parse forest: all the parse trees of an input,
+// sharing a node for each symbol and the tokens it derives, in which every
+// way that a Nonterminal derives its tokens is packed.
+type Forest struct {
+	*symbols
+	tokens []Token
+	file   *source.File
+	root   *fnode
+}
+
+// Count is the number of parse trees in the forest. It is an error if there
+// are infinitely many, because a symbol derives some tokens by deriving
+// itself.
+func (F *Forest) Count() (*big.Int, error) {
+	counts := map[*fnode]*big.Int{}
+	visiting := map[*fnode]bool{}
+	var count func(n *fnode) (*big.Int, error)
+	count = func(n *fnode) (*big.Int, error) {
+		if total, ok := counts[n]; ok {
+			return total, nil
+		}
+		if visiting[n] {
+			return nil, fmt.Errorf("%s: infinitely many parse trees, %s deriving %s by deriving itself",
+				position(F.file, F.tokens, n.start), F.name(n.sym), F.text(n))
+		}
+		total := big.NewInt(0)
+		if F.isterminal(n.sym) {
+			total.SetInt64(1)
+		}
+		visiting[n] = true
+		for _, alt := range n.alts {
+			product := big.NewInt(1)
+			for _, child := range alt.children {
+				k, err := count(child)
+				if err != nil {
+					return nil, err
+				}
+				product.Mul(product, k)
+			}
+			total.Add(total, product)
+		}
+		visiting[n] = false
+		counts[n] = total
+		return total, nil
+	}
+	return count(F.root)
+}
+
+// text is the tokens that n derives.
+func (F *Forest) text(n *fnode) string {
+	if n.start == n.end {
+		return "ε"
+	}
+	words := []string{}
+	for _, tk := range F.tokens[n.start:n.end] {
+		words = append(words, tk.string)
+	}
+	return fmt.Sprintf("%q", strings.Join(words, " "))
+}
+
+// leaf is the tree of the token of terminal node n.
+func (F *Forest) leaf(n *fnode) *node {
+	tk := F.tokens[n.start]
+	return &node{symbol: tk.string, span: tk.span}
+}
+
+func (F *Forest) derived(n *fnode, alt packed, children []node) *node {
+	return derived(F.name(n.sym), F.prods[alt.prod].prod, children)
+}
+
+// Trees enumerates the parse trees in the forest, at most limit of them if
+// limit is positive. Of the infinitely many trees of a cyclic grammar, only
+// those in which no symbol derives the same tokens twice along a path are
+// given.
+func (F *Forest) Trees(limit int) []*node {
+	onpath := map[*fnode]bool{}
+	var all func(n *fnode) []*node
+	all = func(n *fnode) []*node {
+		if F.isterminal(n.sym) {
+			return []*node{F.leaf(n)}
+		}
+		onpath[n] = true
+		defer delete(onpath, n)
+		var trees []*node
+		for _, alt := range n.alts {
+			if F.revisits(alt, onpath) {
+				continue
+			}
+			combos := [][]node{{}}
+			for _, child := range alt.children {
+				subtrees := all(child)
+				var next [][]node
+			product:
+				for _, combo := range combos {
+					for _, t := range subtrees {
+						next = append(next, append(append([]node{}, combo...), *t))
+						if limit > 0 && len(next) == limit {
+							break product
+						}
+					}
+				}
+				combos = next
+			}
+			for _, combo := range combos {
+				trees = append(trees, F.derived(n, alt, combo))
+				if limit > 0 && len(trees) == limit {
+					return trees
+				}
+			}
+		}
+		return trees
+	}
+	return all(F.root)
+}
+
+func (F *Forest) revisits(alt packed, onpath map[*fnode]bool) bool {
+	for _, child := range alt.children {
+		if onpath[child] {
+			return true
+		}
+	}
+	return false
+}
+
+// Alternative is one of the ways in which a Nonterminal derives tokens Start
+// to End of the input: by its Production, numbered from 0 in the order of the
+// grammar, the ith symbol of whose Body derives the tokens from Splits[i].
+type Alternative struct {
+	Head       string
+	Production int
+	Body       []string
+	Start, End int
+	Splits     []int
+}
+
+// String writes a as the production, with the indices of the tokens that each
+// symbol derives.
+func (a Alternative) String() string {
+	body := []string{}
+	for i, sym := range a.Body {
+		end := a.End
+		if i+1 < len(a.Splits) {
+			end = a.Splits[i+1]
+		}
+		body = append(body, fmt.Sprintf("%s[%d:%d]", sym, a.Splits[i], end))
+	}
+	if len(body) == 0 {
+		body = append(body, "ε")
+	}
+	return fmt.Sprintf("%s[%d:%d] → %s", a.Head, a.Start, a.End, strings.Join(body, " "))
+}
+
+func (F *Forest) alternative(n *fnode, alt packed) Alternative {
+	a := Alternative{
+		Head:       F.name(n.sym),
+		Production: alt.prod - 1,
+		Body:       F.names(F.prods[alt.prod].body),
+		Start:      n.start,
+		End:        n.end,
+		Splits:     []int{},
+	}
+	for _, child := range alt.children {
+		a.Splits = append(a.Splits, child.start)
+	}
+	return a
+}
+
+// Policy disambiguates a forest, returning the index of the alternative to
+// choose of those by which a Nonterminal derives some tokens.
+type Policy func(alts []Alternative) int
+
+// compare orders alternatives of the same tokens by their splits, the first
+// that differs deciding.
+func compare(a, b Alternative) int {
+	for i := 0; i < len(a.Splits) && i < len(b.Splits); i++ {
+		if a.Splits[i] != b.Splits[i] {
+			return a.Splits[i] - b.Splits[i]
+		}
+	}
+	return len(a.Splits) - len(b.Splits)
+}
+
+// LongestFirst prefers the alternative whose first symbol derives the most
+// tokens, then its second, and so on, which makes the operator of E → E + E
+// associate to the left.
+func LongestFirst(alts []Alternative) int {
+	best := 0
+	for i := range alts {
+		if compare(alts[i], alts[best]) > 0 {
+			best = i
+		}
+	}
+	return best
+}
+
+// ShortestFirst prefers the alternative whose first symbol derives the fewest
+// tokens, then its second, and so on, making operators associate to the
+// right.
+func ShortestFirst(alts []Alternative) int {
+	best := 0
+	for i := range alts {
+		if compare(alts[i], alts[best]) < 0 {
+			best = i
+		}
+	}
+	return best
+}
+
+// Ordered prefers the production that comes first in the grammar, choosing
+// among alternatives by the same production as LongestFirst does. Of the
+// operators of E → E + E | E * E, the latter binds tighter.
+func Ordered(alts []Alternative) int {
+	best := 0
+	for i := range alts {
+		if alts[i].Production < alts[best].Production ||
+			alts[i].Production == alts[best].Production && compare(alts[i], alts[best]) > 0 {
+			best = i
+		}
+	}
+	return best
+}
+
+// Tree is the parse tree chosen from the forest by policy, among the
+// alternatives of each node that lead to a tree in which no symbol derives
+// the same tokens twice along a path.
+func (F *Forest) Tree(policy Policy) (*node, error) {
+	onpath := map[*fnode]bool{}
+	var build func(n *fnode) (*node, error)
+	build = func(n *fnode) (*node, error) {
+		if F.isterminal(n.sym) {
+			return F.leaf(n), nil
+		}
+		onpath[n] = true
+		defer delete(onpath, n)
+		candidates := []packed{}
+		for _, alt := range n.alts {
+			if !F.revisits(alt, onpath) {
+				candidates = append(candidates, alt)
+			}
+		}
+	choice:
+		for len(candidates) > 0 {
+			alts := make([]Alternative, len(candidates))
+			for i, alt := range candidates {
+				alts[i] = F.alternative(n, alt)
+			}
+			k := policy(alts)
+			if k < 0 || k >= len(alts) {
+				return nil, fmt.Errorf("policy chose alternative %d of %d", k, len(alts))
+			}
+			alt := candidates[k]
+			children := make([]node, len(alt.children))
+			for i, child := range alt.children {
+				t, err := build(child)
+				if err != nil {
+					return nil, err
+				}
+				if t == nil { // every choice for the child revisits a node
+					candidates = append(candidates[:k:k], candidates[k+1:]...)
+					continue choice
+				}
+				children[i] = *t
+			}
+			return F.derived(n, alt, children), nil
+		}
+		return nil, nil
+	}
+	return build(F.root)
+}
+
+// nonterminals are the Nonterminal nodes reachable from the root, each
+// before its descendants.
+func (F *Forest) nonterminals() []*fnode {
+	var nodes []*fnode
+	seen := map[*fnode]bool{}
+	var list func(n *fnode)
+	list = func(n *fnode) {
+		if seen[n] || F.isterminal(n.sym) {
+			return
+		}
+		seen[n] = true
+		nodes = append(nodes, n)
+		for _, alt := range n.alts {
+			for _, child := range alt.children {
+				list(child)
+			}
+		}
+	}
+	list(F.root)
+	return nodes
+}
+
+// Ambiguities are the alternatives of each node of the forest that has more
+// than one, in the order of String.
+func (F *Forest) Ambiguities() [][]Alternative {
+	var ambiguities [][]Alternative
+	for _, n := range F.nonterminals() {
+		if len(n.alts) > 1 {
+			alts := []Alternative{}
+			for _, alt := range n.alts {
+				alts = append(alts, F.alternative(n, alt))
+			}
+			ambiguities = append(ambiguities, alts)
+		}
+	}
+	return ambiguities
+}
+
+// String lists the Nonterminal nodes of the forest reachable from its root,
+// each with its alternatives, a node written as its symbol and the indices of
+// the tokens it derives.
+func (F *Forest) String() string {
+	var b strings.Builder
+	for _, n := range F.nonterminals() {
+		alts := []string{}
+		for _, alt := range n.alts {
+			a := F.alternative(n, alt).String()
+			alts = append(alts, a[strings.Index(a, "→ ")+len("→ "):])
+		}
+		fmt.Fprintf(&b, "%s[%d:%d] → %s\n", F.name(n.sym), n.start, n.end, strings.Join(alts, " | "))
+	}
+	return b.String()
+}
+
+// position is that of token i of f, or the end of f.
+func position(f *source.File, tokens []Token, i int) source.Position {
+	if i < len(tokens) {
+		return f.Position(tokens[i].span.Start)
+	}
+	return f.Position(f.Pos(f.Size()))
+}
@@ -0,0 +1,514 @@
+package grammar
+
+import (
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Method is the kind of parsing table that Generate constructs.
+type Method int
+
+const (
+	LALR Method = iota // LALR(1), resolving conflicts by precedence as yacc does
+	LL                 // LL(1), for a predictive parser
+)
+
+// goname is the exported Go identifier for s.
+func goname(s string) string {
+	r := []rune(s)
+	if len(r) == 0 || !unicode.IsLetter(r[0]) {
+		return "N" + s
+	}
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+// generator holds what Generate has found of the spec.
+type generator struct {
+	spec    *Spec
+	syms    *symbols
+	types   map[string]string            // of the node of each Nonterminal
+	fields  map[string]map[string]string // the types of the attributes of each Nonterminal
+	methods map[string]string            // the signatures of the Actions
+	callers map[string]string            // a production calling each Action
+}
+
+// Generate writes the Go source of a package that parses by the grammar of
+// spec with a table constructed by method m. The package has a node type for
+// each Nonterminal, whose fields are its attributes and Lexeme, the text that
+// it derives, and the functions
+//
+//	func Tokens(src string) ([]Token, error)
+//	func Parse(src string, actions Actions) (*Start, error)
+//
+// for the type Start of the start symbol. The actions of the productions
+// must be those of an S-attributed definition, at the ends of the bodies, and
+// may not nest calls; they are applied as each production is reduced, and
+// their calls are to the methods of the interface Actions, whose types follow
+// from those of their arguments and of the attributes they define. The
+// package imports only the standard library and those of the spec.
+func (spec *Spec) Generate(m Method) ([]byte, error) {
+	g := &generator{
+		spec:    spec,
+		syms:    spec.Grammar.number(),
+		types:   map[string]string{},
+		fields:  map[string]map[string]string{},
+		methods: map[string]string{},
+		callers: map[string]string{},
+	}
+	reserved := map[string]bool{"Token": true, "Error": true, "Actions": true, "Parse": true, "Tokens": true}
+	for _, nt := range spec.Grammar {
+		typ := goname(nt.Head)
+		if reserved[typ] {
+			return nil, fmt.Errorf("%s: the type %s of Nonterminal %s is already declared", spec.Name, typ, nt.Head)
+		}
+		reserved[typ] = true
+		g.types[nt.Head] = typ
+		g.fields[nt.Head] = map[string]string{"lexeme": "string"}
+		for _, a := range spec.attrs[nt.Head] {
+			if _, ok := g.fields[nt.Head][a.name]; ok {
+				return nil, fmt.Errorf("%s: %s.%s declared twice", spec.Name, nt.Head, a.name)
+			}
+			g.fields[nt.Head][a.name] = a.typ
+		}
+	}
+	for _, nt := range spec.Grammar {
+		for _, prod := range nt.Productions {
+			seen := false
+			for _, p := range prod.pieces() {
+				if p.action {
+					seen = true
+				} else if seen && strings.TrimSpace(p.text) != "" {
+					return nil, fmt.Errorf("%s: %s → %s: actions must end the body", spec.Name, nt.Head, prod)
+				}
+			}
+		}
+	}
+	d := spec.sdd()
+	if err := d.SAttributed(); err != nil {
+		return nil, fmt.Errorf("%s: %v", spec.Name, err)
+	}
+	rules, _ := d.rules()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Code generated by cc from %s. DO NOT EDIT.\n\n", spec.Name)
+	fmt.Fprintf(&b, "// Package %s parses by the grammar\n//\n", spec.Package)
+	for p := 1; p < len(g.syms.prods); p++ {
+		fmt.Fprintf(&b, "//\t%s\n", g.syms.prodstring(p))
+	}
+	fmt.Fprintf(&b, "package %s\n\nimport (\n\t\"fmt\"\n\t\"regexp\"\n\t\"strings\"\n\t\"unicode\"\n\t\"unicode/utf8\"\n", spec.Package)
+	for _, path := range spec.imports {
+		fmt.Fprintf(&b, "\t%q\n", path)
+	}
+	b.WriteString(")\n")
+	b.WriteString(genruntime)
+
+	// the nodes
+	for _, nt := range spec.Grammar {
+		fmt.Fprintf(&b, "\n// %s is a node for %s.\ntype %s struct {\n\tLexeme string\n", g.types[nt.Head], nt.Head, g.types[nt.Head])
+		for _, a := range spec.attrs[nt.Head] {
+			fmt.Fprintf(&b, "\t%s %s\n", goname(a.name), a.typ)
+		}
+		b.WriteString("}\n")
+	}
+
+	// the actions
+	var reduce strings.Builder
+	for p := 1; p < len(g.syms.prods); p++ {
+		if err := g.reduction(&reduce, p, rules); err != nil {
+			return nil, fmt.Errorf("%s: %s → %s: %v", spec.Name, g.syms.name(g.syms.prods[p].head), g.syms.prods[p].prod, err)
+		}
+	}
+	names := []string{}
+	for name := range g.methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	b.WriteString("\n// Actions are the functions that the rules of the productions call.\ntype Actions interface {\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "\t%s%s // for %s\n", name, g.methods[name], g.callers[name])
+	}
+	b.WriteString("}\n")
+	fmt.Fprintf(&b, `
+// reduce computes the node for the head of production p from the values of
+// its body, which derives src[start:end].
+func reduce(src string, actions Actions, p int, kids []value, start, end int) (interface{}, error) {
+	switch p {
+%s	}
+	panic(fmt.Sprintf("no production %%d", p))
+}
+`, reduce.String())
+
+	// the lexer
+	T := len(g.syms.terminals)
+	fmt.Fprintf(&b, "\n// terminals are the kinds of Tokens, with the endmarker first.\nvar terminals = %#v\n", g.syms.terminals)
+	b.WriteString("\nvar literals = []struct {\n\tkind int\n\ttext string\n}{\n")
+	var patterns strings.Builder
+	for a := 1; a < T; a++ {
+		name := g.syms.terminals[a]
+		re, ok := spec.tokens[name]
+		if m := regexptk.FindStringSubmatch(name); !ok && len(m) > 1 {
+			re, ok = m[1], true
+		}
+		if ok {
+			fmt.Fprintf(&patterns, "\t{%d, regexp.MustCompile(%q)},\n", a, "^(?:"+re+")")
+		} else {
+			fmt.Fprintf(&b, "\t{%d, %q},\n", a, name)
+		}
+	}
+	fmt.Fprintf(&b, "}\n\nvar patterns = []struct {\n\tkind int\n\tre   *regexp.Regexp\n}{\n%s}\n", patterns.String())
+	b.WriteString("\nvar ignore = []*regexp.Regexp{\n")
+	for _, re := range spec.ignore {
+		fmt.Fprintf(&b, "\tregexp.MustCompile(%q),\n", "^(?:"+re+")")
+	}
+	b.WriteString("}\n")
+
+	// the parser
+	b.WriteString("\n// prods are the productions, by the Nonterminals of their heads and the\n// symbols of their bodies, terminals first.\nvar prods = []struct {\n\thead int\n\tbody []int\n}{\n")
+	for p, prod := range g.syms.prods {
+		fmt.Fprintf(&b, "\t{%d, %#v}, // %s\n", prod.head-T, prod.body, g.syms.prodstring(p))
+	}
+	b.WriteString("}\n")
+	start := g.types[spec.Grammar[0].Head]
+	switch m {
+	case LALR:
+		prodprec := map[int]precedence{}
+		for p, prod := range g.syms.prods {
+			if t, ok := spec.prodprec[prod.prod]; ok && p > 0 {
+				prodprec[p] = spec.prec[t]
+			}
+		}
+		t, err := spec.Grammar.lalr(spec.prec, prodprec)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", spec.Name, err)
+		}
+		fmt.Fprintf(&b, "\n// action is the LALR(1) parsing table: a shift to state s is s+1, a\n// reduction by production p is -(p+1), and 0 is an error.\nvar action = %s\n", table(t.action))
+		fmt.Fprintf(&b, "\n// gotos are the states entered on the Nonterminals, plus 1.\nvar gotos = %s\n", table(t.gotos))
+		fmt.Fprintf(&b, genlr, start, start)
+	case LL:
+		ll, err := spec.Grammar.ll1()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", spec.Name, err)
+		}
+		rows := make([][]int, len(g.syms.nonterminals))
+		for n, head := range g.syms.nonterminals {
+			rows[n] = make([]int, T)
+			for term, prod := range ll[head] {
+				for p, np := range g.syms.prods {
+					if p > 0 && np.head == n+T && np.prod == prod {
+						rows[n][g.syms.code[term]] = p + 1
+					}
+				}
+			}
+		}
+		fmt.Fprintf(&b, "\n// table is the LL(1) parsing table: the production to expand each\n// Nonterminal by on each terminal, plus 1.\nvar table = %s\n", table(rows))
+		fmt.Fprintf(&b, genll, start, T, g.syms.code[spec.Grammar[0].Head], start)
+	}
+
+	src, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return nil, fmt.Errorf("%s: generated invalid Go: %v", spec.Name, err)
+	}
+	return src, nil
+}
+
+func table(rows [][]int) string {
+	var b strings.Builder
+	b.WriteString("[][]int{\n")
+	for _, row := range rows {
+		b.WriteString("\t{")
+		for i, v := range row {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			fmt.Fprint(&b, v)
+		}
+		b.WriteString("},\n")
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// reduction writes the case of reduce for production p, which applies its
+// rules.
+func (g *generator) reduction(b *strings.Builder, p int, rules compiled) error {
+	np := g.syms.prods[p]
+	head := g.syms.name(np.head)
+	fmt.Fprintf(b, "\tcase %d: // %s\n\t\tx := &%s{Lexeme: src[start:end]}\n", p, g.syms.prodstring(p), g.types[head])
+	defined := map[string]bool{"lexeme": true}
+	expr := func(o operand, r *rule) (string, string, error) {
+		switch o := o.(type) {
+		case string:
+			return strconv.Quote(o), "string", nil
+		case *attrref:
+			i := r.refs[o]
+			if i == -1 {
+				if !defined[o.attr] {
+					return "", "", fmt.Errorf("%s.%s is used before it is defined", o.symbol, o.attr)
+				}
+				return "x." + goname(o.attr), g.fields[head][o.attr], nil
+			}
+			if o.attr == "lexeme" {
+				return fmt.Sprintf("src[kids[%d].start:kids[%d].end]", i, i), "string", nil
+			}
+			sym := g.syms.name(np.body[i])
+			return fmt.Sprintf("kids[%d].v.(*%s).%s", i, g.types[sym], goname(o.attr)), g.fields[sym][o.attr], nil
+		}
+		return "", "", fmt.Errorf("%s: calls may not be nested in a generated parser", optext(o))
+	}
+	for j, r := range rules[head][np.prod] {
+		result := ""
+		if r.attr != "" {
+			result = g.fields[head][r.attr]
+		}
+		c, ok := r.src.(*call)
+		if !ok {
+			e, typ, err := expr(r.src, r)
+			if err != nil {
+				return err
+			}
+			if typ != result {
+				return fmt.Errorf("%s: %s is not %s", r.text, typ, result)
+			}
+			fmt.Fprintf(b, "\t\tx.%s = %s\n", goname(r.attr), e)
+			defined[r.attr] = true
+			continue
+		}
+		args, types := []string{}, []string{}
+		for k, arg := range c.args {
+			e, typ, err := expr(arg, r)
+			if err != nil {
+				return err
+			}
+			args = append(args, e)
+			types = append(types, fmt.Sprintf("a%d %s", k, typ))
+		}
+		sig := fmt.Sprintf("(%s) error", strings.Join(types, ", "))
+		if result != "" {
+			sig = fmt.Sprintf("(%s) (%s, error)", strings.Join(types, ", "), result)
+		}
+		name := goname(c.name)
+		if other, ok := g.methods[name]; ok && other != sig {
+			return fmt.Errorf("%s: %s%s, but %s%s for %s", r.text, name, sig, name, other, g.callers[name])
+		}
+		if _, ok := g.methods[name]; !ok {
+			g.methods[name], g.callers[name] = sig, g.syms.prodstring(p)
+		}
+		if result == "" {
+			fmt.Fprintf(b, "\t\tif err := actions.%s(%s); err != nil {\n\t\t\treturn nil, err\n\t\t}\n", name, strings.Join(args, ", "))
+			continue
+		}
+		fmt.Fprintf(b, "\t\tv%d, err := actions.%s(%s)\n\t\tif err != nil {\n\t\t\treturn nil, err\n\t\t}\n\t\tx.%s = v%d\n", j, name, strings.Join(args, ", "), goname(r.attr), j)
+		defined[r.attr] = true
+	}
+	b.WriteString("\t\treturn x, nil\n")
+	return nil
+}
+
+// genruntime is the lexer and the types common to every generated parser.
+const genruntime = `
+// Token is a token of the input.
+type Token struct {
+	Kind   string // the terminal: its text, its /regexp/ or the name of its %token
+	Text   string
+	Offset int
+}
+
+// Error is an error at a position of the input, counted from 1 in lines and
+// bytes.
+type Error struct {
+	Line, Column int
+	Msg          string
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg) }
+
+func errorat(src string, offset int, format string, args ...interface{}) *Error {
+	line := 1 + strings.Count(src[:offset], "\n")
+	column := offset - strings.LastIndex(src[:offset], "\n")
+	return &Error{line, column, fmt.Sprintf(format, args...)}
+}
+
+// lex reads the tokens of src, ending with the endmarker, and their kinds. A
+// token is the longest match of a terminal, a literal before a pattern.
+func lex(src string) ([]Token, []int, error) {
+	var tokens []Token
+	var kinds []int
+	for off := 0; ; {
+		for skipped := true; skipped; {
+			skipped = false
+			if rest := strings.TrimLeftFunc(src[off:], unicode.IsSpace); len(rest) < len(src)-off {
+				off, skipped = len(src)-len(rest), true
+			}
+			for _, re := range ignore {
+				if loc := re.FindStringIndex(src[off:]); loc != nil && loc[1] > 0 {
+					off, skipped = off+loc[1], true
+				}
+			}
+		}
+		if off == len(src) {
+			return append(tokens, Token{Kind: terminals[0], Offset: off}), append(kinds, 0), nil
+		}
+		n, kind := 0, -1
+		for _, l := range literals {
+			if len(l.text) > n && strings.HasPrefix(src[off:], l.text) {
+				n, kind = len(l.text), l.kind
+			}
+		}
+		for _, p := range patterns {
+			if loc := p.re.FindStringIndex(src[off:]); loc != nil && loc[1] > n {
+				n, kind = loc[1], p.kind
+			}
+		}
+		if kind == -1 {
+			r, _ := utf8.DecodeRuneInString(src[off:])
+			return nil, nil, errorat(src, off, "unexpected %q", r)
+		}
+		tokens = append(tokens, Token{terminals[kind], src[off : off+n], off})
+		kinds = append(kinds, kind)
+		off += n
+	}
+}
+
+// Tokens returns the tokens of src.
+func Tokens(src string) ([]Token, error) {
+	tokens, _, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	return tokens[:len(tokens)-1], nil
+}
+
+// value is a symbol on the stack of the parser, a Token or a node, with the
+// extent of the input that it derives.
+type value struct {
+	v          interface{}
+	start, end int
+}
+
+func describe(kind int) string {
+	if kind == 0 {
+		return "end of input"
+	}
+	return fmt.Sprintf("%q", terminals[kind])
+}
+
+// unexpected is the error for the token tk of the given kind, where row gives
+// the terminals that were expected.
+func unexpected(src string, tk Token, kind int, row []int) error {
+	var names []string
+	for a, act := range row {
+		if act != 0 {
+			names = append(names, describe(a))
+		}
+	}
+	found := describe(kind)
+	if kind != 0 {
+		found = fmt.Sprintf("%q", tk.Text)
+	}
+	return errorat(src, tk.Offset, "unexpected %s, expected %s", found, strings.Join(names, " or "))
+}
+`
+
+// genlr is the driver of an LALR(1) table.
+const genlr = `
+// Parse parses src, calling actions as each production is reduced, and
+// returns the node of the start symbol.
+func Parse(src string, actions Actions) (*%s, error) {
+	tokens, kinds, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	states := []int{0}
+	values := []value{{}}
+	for i := 0; ; {
+		state := states[len(states)-1]
+		switch act := action[state][kinds[i]]; {
+		case act > 0:
+			states = append(states, act-1)
+			values = append(values, value{tokens[i], tokens[i].Offset, tokens[i].Offset + len(tokens[i].Text)})
+			i++
+		case act == -1:
+			return values[1].v.(*%s), nil
+		case act < 0:
+			p := -act - 1
+			n := len(prods[p].body)
+			kids := values[len(values)-n:]
+			start, end := tokens[i].Offset, tokens[i].Offset
+			if n > 0 {
+				start, end = kids[0].start, kids[n-1].end
+			}
+			v, err := reduce(src, actions, p, kids, start, end)
+			if err != nil {
+				return nil, errorat(src, start, "%%v", err)
+			}
+			states, values = states[:len(states)-n], values[:len(values)-n]
+			states = append(states, gotos[states[len(states)-1]][prods[p].head]-1)
+			values = append(values, value{v, start, end})
+		default:
+			return nil, unexpected(src, tokens[i], kinds[i], action[state])
+		}
+	}
+}
+`
+
+// genll is the driver of an LL(1) table.
+const genll = `
+// Parse parses src, calling actions once the body of each production has
+// been, and returns the node of the start symbol.
+func Parse(src string, actions Actions) (*%s, error) {
+	tokens, kinds, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	const nterminals = %d
+	type frame struct{ sym, prod int } // reducing by prod if it is not -1
+	stack := []frame{{%d, -1}}
+	var values []value
+	i := 0
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		switch {
+		case f.prod >= 0:
+			n := len(prods[f.prod].body)
+			kids := values[len(values)-n:]
+			start, end := tokens[i].Offset, tokens[i].Offset
+			if n > 0 {
+				start, end = kids[0].start, kids[n-1].end
+			}
+			v, err := reduce(src, actions, f.prod, kids, start, end)
+			if err != nil {
+				return nil, errorat(src, start, "%%v", err)
+			}
+			values = append(values[:len(values)-n], value{v, start, end})
+		case f.sym < nterminals:
+			if kinds[i] != f.sym {
+				row := make([]int, nterminals)
+				row[f.sym] = 1
+				return nil, unexpected(src, tokens[i], kinds[i], row)
+			}
+			values = append(values, value{tokens[i], tokens[i].Offset, tokens[i].Offset + len(tokens[i].Text)})
+			i++
+		default:
+			row := table[f.sym-nterminals]
+			p := row[kinds[i]] - 1
+			if p < 0 {
+				return nil, unexpected(src, tokens[i], kinds[i], row)
+			}
+			stack = append(stack, frame{-1, p})
+			for j := len(prods[p].body) - 1; j >= 0; j-- {
+				stack = append(stack, frame{prods[p].body[j], -1})
+			}
+		}
+	}
+	if kinds[i] != 0 {
+		return nil, unexpected(src, tokens[i], kinds[i], []int{1})
+	}
+	return values[0].v.(*%s), nil
+}
+`
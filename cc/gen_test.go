@@ -0,0 +1,170 @@
+package grammar
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sexpr counts the atoms of nested lists, by an LL(1) grammar.
+const sexpr = `
+%package sexpr
+%token atom /[a-z0-9]+/
+%attr list n int
+%attr items n int
+
+list  → ( items ) { list.n = items.n }
+items → list items { items.n = add(list.n, items1.n) }
+      | atom items { items.n = inc(items1.n) }
+      | ε { items.n = zero() }
+`
+
+const calcmain = `package calc
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+)
+
+type actions struct{}
+
+func (actions) Add(a, b float64) (float64, error) { return a + b, nil }
+func (actions) Sub(a, b float64) (float64, error) { return a - b, nil }
+func (actions) Mul(a, b float64) (float64, error) { return a * b, nil }
+func (actions) Pow(a, b float64) (float64, error) { return math.Pow(a, b), nil }
+func (actions) Neg(a float64) (float64, error)    { return -a, nil }
+func (actions) Number(s string) (float64, error)  { return strconv.ParseFloat(s, 64) }
+func (actions) Div(a, b float64) (float64, error) {
+	if b == 0 {
+		return 0, fmt.Errorf("division by zero")
+	}
+	return a / b, nil
+}
+
+func TestParse(t *testing.T) {
+	for src, want := range map[string]string{
+		"1 + 2 * 3":          "7",
+		"2 ^ 3 ^ 2 # right":  "512",
+		"-(10 - 4) - 2 / 4":  "-6.5",
+		"(1 + 2":             "1:7: unexpected end of input, expected \"+\" or \"-\" or \"*\" or \"/\" or \"^\" or \")\"",
+		"1 / (2 - 2)":        "1:1: division by zero",
+		"1 $ 2":              "1:3: unexpected '$'",
+	} {
+		var got string
+		if x, err := Parse(src, actions{}); err != nil {
+			got = err.Error()
+		} else {
+			got = fmt.Sprint(x.Val)
+		}
+		if got != want {
+			t.Errorf("%s: got %s, want %s", src, got, want)
+		}
+	}
+}
+`
+
+const sexprmain = `package sexpr
+
+import "testing"
+
+type actions struct{}
+
+func (actions) Add(a, b int) (int, error) { return a + b, nil }
+func (actions) Inc(a int) (int, error)    { return a + 1, nil }
+func (actions) Zero() (int, error)        { return 0, nil }
+
+func TestParse(t *testing.T) {
+	x, err := Parse("(a (b c) () d)", actions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.N != 4 || x.Lexeme != "(a (b c) () d)" {
+		t.Errorf("got %+v", x)
+	}
+	if _, err := Parse("(a b", actions{}); err == nil || err.Error() != "1:5: unexpected end of input, expected \"(\" or \")\" or \"atom\"" {
+		t.Errorf("got error %v", err)
+	}
+}
+`
+
+// TestGenerate generates parsers in a module of their own, which go vet
+// and go test check.
+func TestGenerate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs go")
+	}
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip(err)
+	}
+	calcsrc, err := os.ReadFile("bnf/calc.grm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	write := func(name, src string) {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.mod", "module gen\n\ngo 1.16\n")
+	for _, tc := range []struct {
+		name, src, test string
+		m               Method
+	}{
+		{"calc.grm", string(calcsrc), calcmain, LALR},
+		{"sexpr.grm", sexpr, sexprmain, LL},
+	} {
+		spec, err := ReadSpec(tc.name, tc.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := spec.Generate(tc.m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		write(filepath.Join(spec.Package, spec.Package+".go"), string(out))
+		write(filepath.Join(spec.Package, "parse_test.go"), tc.test)
+	}
+	for _, args := range [][]string{{"vet", "./..."}, {"test", "./..."}} {
+		cmd := exec.Command(gobin, args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("go %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src string
+		m   Method
+		err string
+	}{
+		{"e → e + e | x", LALR, "not LALR(1):\n\tstate 4 on +: shift, reduce e → e + e"},
+		{"e → e + x | x", LL, "not LL(1)"},
+		{"%attr e v int\ne → x { e.v = f(g()) }", LALR, "g(): calls may not be nested"},
+		{"e → x { print(x.lexeme) } y", LALR, "actions must end the body"},
+		{"%attr e v int\n%attr f v int\ne → f { f.v = one() }\nf → x { f.v = one() }", LALR, "f.v is not an inherited attribute of f"},
+		{"%attr e v int\ne → x { e.v = x.lexeme }", LALR, "e.v = x.lexeme: string is not int"},
+		{"%attr e v int\ne → x { e.v = f(x.lexeme) } | y { e.v = f() }", LALR, "F() (int, error), but F(a0 string) (int, error)"},
+		{"token → x", LALR, "the type Token of Nonterminal token is already declared"},
+		{"%left +\ne → x %prec -", LALR, "- has no precedence"},
+		{"%frob\ne → x", LALR, "x.grm:1: unknown directive %frob"},
+	} {
+		spec, err := ReadSpec("x.grm", tc.src)
+		if err == nil {
+			_, err = spec.Generate(tc.m)
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: got error %v, want %q", tc.src, err, tc.err)
+		}
+	}
+}
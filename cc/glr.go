@@ -0,0 +1,227 @@
+package grammar
+
+import (
+	"fmt"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+// gnode is a node of a graph-structured stack: a state of the LR automaton
+// after the first level tokens, with an edge to each node below it.
+type gnode struct {
+	state, level int
+	edges        []gedge
+}
+
+// gedge is an edge of the stack, labelled with the forest node of the symbol
+// between its ends.
+type gedge struct {
+	to  *gnode
+	sym *fnode
+}
+
+// gpath is a path down the stack from a node: the node it ends at and the
+// labels of its edges from the bottom, with a key that identifies it among
+// the paths from the same node.
+type gpath struct {
+	to   *gnode
+	syms []*fnode
+	key  string
+}
+
+func (v *gnode) paths(m int) []gpath {
+	if m == 0 {
+		return []gpath{{to: v, syms: []*fnode{}}}
+	}
+	var paths []gpath
+	for i, e := range v.edges {
+		for _, p := range e.to.paths(m - 1) {
+			paths = append(paths, gpath{p.to, append(append([]*fnode{}, p.syms...), e.sym), fmt.Sprintf("%d %s", i, p.key)})
+		}
+	}
+	return paths
+}
+
+func (v *gnode) edge(u *gnode) bool {
+	for _, e := range v.edges {
+		if e.to == u {
+			return true
+		}
+	}
+	return false
+}
+
+// pack adds the alternative of n by production prod, unless n has it.
+func (n *fnode) pack(prod int, children []*fnode) {
+alts:
+	for _, alt := range n.alts {
+		if alt.prod != prod || len(alt.children) != len(children) {
+			continue
+		}
+		for i := range children {
+			if alt.children[i] != children[i] {
+				continue alts
+			}
+		}
+		return
+	}
+	n.alts = append(n.alts, packed{prod, children})
+}
+
+// glr is a generalized LR parser, which follows every action of the cells of
+// an LALR(1) table that has conflicts.
+type glr struct {
+	*lrtable
+	tokens []Token
+	nodes  map[[3]int]*fnode
+}
+
+func (g *glr) node(X, i, j int) *fnode {
+	key := [3]int{X, i, j}
+	n, ok := g.nodes[key]
+	if !ok {
+		n = &fnode{sym: X, start: i, end: j}
+		g.nodes[key] = n
+	}
+	return n
+}
+
+// lookaheads are the terminals that token j is an instance of.
+func (g *glr) lookaheads(j int) []int {
+	if j == len(g.tokens) {
+		return []int{0}
+	}
+	var terms []int
+	for a := 1; a < len(g.terminals); a++ {
+		if matches(g.terminals[a], g.tokens[j]) {
+			terms = append(terms, a)
+		}
+	}
+	return terms
+}
+
+// reduce performs the reductions of the nodes of frontier, the top of the
+// stack after j tokens, on the lookaheads, returning the nodes that they
+// add. Whenever an edge is added to a node of the frontier, the reductions
+// are repeated along the paths through it, as Farshi corrected Tomita's
+// algorithm for ε-productions.
+func (g *glr) reduce(frontier []*gnode, j int, lookaheads []int) []*gnode {
+	index := map[int]*gnode{}
+	for _, v := range frontier {
+		index[v.state] = v
+	}
+	done := map[string]bool{}
+	for changed := true; changed; {
+		changed = false
+		for k := 0; k < len(frontier); k++ {
+			w := frontier[k]
+			for _, a := range lookaheads {
+				for _, act := range g.cells[w.state][a] {
+					if act >= -1 { // a shift, or accepting
+						continue
+					}
+					p := -act - 1
+					prod := g.prods[p]
+					for _, path := range w.paths(len(prod.body)) {
+						key := fmt.Sprintf("%p %d %s", w, p, path.key)
+						if done[key] {
+							continue
+						}
+						done[key] = true
+						u := path.to
+						n := g.node(prod.head, u.level, j)
+						n.pack(p, path.syms)
+						s := g.gotos[u.state][prod.head-len(g.terminals)] - 1
+						v, ok := index[s]
+						if !ok {
+							v = &gnode{state: s, level: j}
+							index[s] = v
+							frontier = append(frontier, v)
+						}
+						if !v.edge(u) {
+							v.edges = append(v.edges, gedge{u, n})
+							changed = true
+						}
+					}
+				}
+			}
+		}
+	}
+	return frontier
+}
+
+// errorf reports that no node of the frontier can shift token j, expecting
+// the terminals that some node could shift after the reductions on any
+// lookahead.
+func (g *glr) errorf(f *source.File, frontier []*gnode, j int) error {
+	all := make([]int, len(g.terminals))
+	for a := range all {
+		all[a] = a
+	}
+	expected := symbolset{}
+	for _, v := range g.reduce(frontier, j, all) {
+		for a, cell := range g.cells[v.state] {
+			for _, act := range cell {
+				if act > 0 || act == -1 {
+					expected[g.terminals[a]] = true
+				}
+			}
+		}
+	}
+	found := "end of input"
+	if j < len(g.tokens) {
+		found = fmt.Sprintf("%q", g.tokens[j].string)
+	}
+	return fmt.Errorf("%s: expected one of %s, found %s", position(f, g.tokens, j), expected, found)
+}
+
+// GLR parses f by a generalized LR parser (Tomita's algorithm), which forks
+// on the conflicts of the LALR(1) table of G and merges the stacks that reach
+// the same state on a graph-structured stack, returning the forest of the
+// parse trees. Like Earley, it accepts left-recursive and ambiguous grammars.
+func (G Grammar) GLR(f *source.File) (*Forest, error) {
+	tokens, err := G.lexemes(f)
+	if err != nil {
+		return nil, err
+	}
+	t, _ := G.lalr(nil, nil) // the conflicts are where the parser forks
+	g := &glr{lrtable: t, tokens: tokens, nodes: map[[3]int]*fnode{}}
+	frontier := []*gnode{{state: 0}}
+	for j := 0; ; j++ {
+		lookaheads := g.lookaheads(j)
+		frontier = g.reduce(frontier, j, lookaheads)
+		if j == len(tokens) {
+			for _, v := range frontier {
+				for _, act := range g.cells[v.state][0] {
+					if act == -1 {
+						root := g.nodes[[3]int{g.code[G[0].Head], 0, j}]
+						return &Forest{symbols: t.symbols, tokens: tokens, file: f, root: root}, nil
+					}
+				}
+			}
+			return nil, g.errorf(f, frontier, j)
+		}
+		var next []*gnode
+		index := map[int]*gnode{}
+		for _, v := range frontier {
+			for _, a := range lookaheads {
+				for _, act := range g.cells[v.state][a] {
+					if act <= 0 {
+						continue
+					}
+					w, ok := index[act-1]
+					if !ok {
+						w = &gnode{state: act - 1, level: j + 1}
+						index[act-1] = w
+						next = append(next, w)
+					}
+					w.edges = append(w.edges, gedge{v, g.node(a, j, j+1)})
+				}
+			}
+		}
+		if len(next) == 0 {
+			return nil, g.errorf(f, frontier, j)
+		}
+		frontier = next
+	}
+}
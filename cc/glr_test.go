@@ -0,0 +1,107 @@
+package grammar
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+// canonical lists the alternatives of the forest in order, so that forests
+// constructed in different orders compare equal.
+func canonical(F *Forest) string {
+	alts := []string{}
+	for _, n := range F.nonterminals() {
+		for _, alt := range n.alts {
+			alts = append(alts, F.alternative(n, alt).String())
+		}
+	}
+	sort.Strings(alts)
+	return strings.Join(alts, "\n")
+}
+
+func TestGLR(t *testing.T) {
+	hidden := Grammar{
+		Nonterminal{"S", []production{"A S b", "x"}},
+		Nonterminal{"A", []production{"ε"}},
+	}
+	nullable := Grammar{
+		Nonterminal{"S", []production{"A A A x"}},
+		Nonterminal{"A", []production{"a", "B"}},
+		Nonterminal{"B", []production{"ε"}},
+	}
+	tests := []struct {
+		G     Grammar
+		input string
+		count string
+	}{
+		{ambiguous, "1", "1"},
+		{ambiguous, "1+2*3", "2"},
+		{ambiguous, "(1+2)*3", "1"},
+		{ambiguous, "1+2+3+4+5", "14"},
+		{ambiguous, "1*2+3*4+5*6", "42"},
+		{hidden, "x b b", "1"},
+		{nullable, "x", "1"},
+		{nullable, "a x", "3"},
+		{nullable, "a a x", "3"},
+		{Grammar{Nonterminal{"S", []production{"S", "a"}}}, "a", ""},
+		{Grammar{Nonterminal{"S", []production{"S S", "a", "ε"}}}, "a a", ""},
+	}
+	for _, tc := range tests {
+		f := source.NewFile("", tc.input)
+		F, err := tc.G.GLR(f)
+		if err != nil {
+			t.Errorf("%q: %v", tc.input, err)
+			continue
+		}
+		count := func(F *Forest) string {
+			count, err := F.Count()
+			if err != nil {
+				return err.Error()
+			}
+			return count.String()
+		}
+		if got := count(F); got != tc.count && !(tc.count == "" && strings.Contains(got, "infinitely many")) {
+			t.Errorf("%q: Count = %s, want %s", tc.input, got, tc.count)
+		}
+		E, err := tc.G.Earley(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if canonical(F) != canonical(E) {
+			t.Errorf("%q: GLR forest\n%s\nEarley forest\n%s", tc.input, F, E)
+		}
+	}
+}
+
+func TestGLRAmbiguities(t *testing.T) {
+	F, err := ambiguous.GLR(source.NewFile("", "1+2*3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := fmt.Sprint(F.Ambiguities())
+	want := "[[E[0:5] → E[0:3] *[3:4] E[4:5] E[0:5] → E[0:1] +[1:2] E[2:5]]]"
+	if got != want {
+		t.Errorf("Ambiguities = %s, want %s", got, want)
+	}
+	tree, err := F.Tree(Ordered)
+	if err != nil || flat(tree) != "(1+(2*3))" {
+		t.Errorf("Tree = %v, %v", tree, err)
+	}
+}
+
+func TestGLRErrors(t *testing.T) {
+	tests := []struct{ input, err string }{
+		{"1+", "1:3: expected one of {(, /[0-9]/}, found end of input"},
+		{"1+)", `1:3: expected one of {(, /[0-9]/}, found ")"`},
+		{"(1 2", `1:4: expected one of {), *, +}, found "2"`},
+	}
+	for _, tc := range tests {
+		_, err := ambiguous.GLR(source.NewFile("", tc.input))
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("%q: got error %v, want %s", tc.input, err, tc.err)
+		}
+	}
+}
@@ -6,3 +6,7 @@ require (
 	github.com/ttacon/chalk v0.0.0-20160626202418-22c06c80ed31 // indirect
 	github.com/xlab/treeprint v1.1.0
 )
+
+require github.com/akiarie/dragon-tests/source v0.0.0-00010101000000-000000000000
+
+replace github.com/akiarie/dragon-tests/source => ../source
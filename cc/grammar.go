@@ -7,13 +7,19 @@ import (
 	"unicode"
 	"unicode/utf8"
 
+	"github.com/akiarie/dragon-tests/source"
+
 	"github.com/ttacon/chalk"
 	"github.com/xlab/treeprint"
 )
 
+// Trace makes parsing print each attempt to match a symbol.
+var Trace bool
+
 type Token struct {
 	string
-	preimage string
+	preimage string // with any space before it
+	span     source.Span
 }
 
 func (tk Token) String() string {
@@ -34,10 +40,11 @@ func preimage(tokens []Token) string {
 	return strings.Join(images, "")
 }
 
-var tkEmpty Token = Token{"ε", ""}
+var tkEmpty Token = Token{string: "ε"}
 
 type lexer struct {
 	G      Grammar
+	file   *source.File
 	pos    int
 	input  string
 	tokens []Token
@@ -53,25 +60,60 @@ func tokenize(lex *lexer) stateFn {
 	for i, c := range lex.input[lex.pos:] {
 		stream += fmt.Sprintf("%c", c)
 		if tk, ok := lex.G.parsetoken(stream); ok {
-			lex.tokens = append(lex.tokens, tk)
 			lex.pos += i + utf8.RuneLen(c)
+			if lex.file != nil {
+				tk.span = lex.file.Span(lex.pos-len(strings.TrimLeftFunc(stream, unicode.IsSpace)), lex.pos)
+			}
+			lex.tokens = append(lex.tokens, tk)
 			return tokenize
 		}
 	}
 	if strings.TrimSpace(stream) == "" {
 		return nil
 	}
+	if lex.file != nil {
+		panic(fmt.Sprintf("%s: unknown sequence '%s', tokens: %v", lex.file.Position(lex.file.Pos(lex.pos)), stream, lex.tokens))
+	}
 	panic(fmt.Sprintf("Unknown sequence '%s', tokens: %v", stream, lex.tokens))
 }
 
+// run tokenizes the whole input, returning an unknown sequence as an error.
+func (lex *lexer) run() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			msg, ok := r.(string)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("%s", msg)
+		}
+	}()
+	for state := stateFn(tokenize); state != nil; state = state(lex) {
+	}
+	return nil
+}
+
+// Tokens returns the tokens of f in the terminals of the Grammar.
+func (G Grammar) Tokens(f *source.File) ([]Token, error) {
+	lex := &lexer{G: G, file: f, input: f.Source()}
+	if err := lex.run(); err != nil {
+		return nil, err
+	}
+	return lex.tokens, nil
+}
+
+// Span is the extent of the token in its file.
+func (tk Token) Span() source.Span { return tk.span }
+
 type production string
 
-var prodsymsre = regexp.MustCompile(`(\w+)|(\/[^/]+\/)|([^ |])|(?:'([^']+)')`)
-var prodsymsrebar = regexp.MustCompile(`((?:\w|\|{2})+)|(\/[^/]+\/)|([^ |])|(?:'([^']+)')`)
+var prodsymsre = regexp.MustCompile(`(?:'([^']+)')|(\w+)|(\/[^/]+\/)|([^ |])`)
+var prodsymsrebar = regexp.MustCompile(`(?:'([^']+)')|((?:\w|\|{2})+)|(\/[^/]+\/)|([^ |])`)
 
+// symbols are the grammar symbols of the body of prod, without its actions.
 func (prod production) symbols() []string {
 	fields := []string{}
-	for _, m := range prodsymsre.FindAllStringSubmatch(string(prod), -1) {
+	for _, m := range prodsymsre.FindAllStringSubmatch(string(prod.stripped()), -1) {
 		for _, sym := range m[1:] {
 			if len(sym) > 0 {
 				fields = append(fields, strings.TrimSpace(sym))
@@ -88,7 +130,7 @@ type prodsymbol struct {
 
 func (prod production) symbolsconcat(split bool) []prodsymbol {
 	symbols := []string{}
-	for _, m := range prodsymsrebar.FindAllStringSubmatch(string(prod), -1) {
+	for _, m := range prodsymsrebar.FindAllStringSubmatch(string(prod.stripped()), -1) {
 		for _, sym := range m[1:] {
 			if len(sym) > 0 {
 				symbols = append(symbols, strings.TrimSpace(sym))
@@ -117,59 +159,6 @@ func productionstostrings(prods []production) []string {
 	return fields
 }
 
-func (prod production) parse() {
-	G := Grammar{
-		Nonterminal{"production",
-			[]production{
-				"symbol production", "symbol",
-				"command production", "command",
-			},
-		},
-		Nonterminal{"command",
-			[]production{
-				"{ print(string) }",
-			},
-		},
-		Nonterminal{"string", []production{"quote||literal||quote"}},
-		Nonterminal{"literal", []production{"symbol", "symbol literal"}},
-		Nonterminal{"name", []production{"alpha||name", "alpha||digit", "alpha"}},
-		Nonterminal{"symbol", []production{"char||symbol", "char"}},
-		Nonterminal{"char",
-			[]production{
-				"alpha",
-				"digit",
-				"specialchar",
-				"quote||bnfchar||quote",
-				"quote",
-				"'ε'",
-			},
-		},
-		Nonterminal{"alpha", []production{`/[a-zA-Z]/`}},
-		Nonterminal{"digit", []production{`/[0-9]/`}},
-		Nonterminal{"specialchar", []production{`/[-!@#%_—=;:,<>"]/`, `/[\?\$\^&\*\(\)\+\[\]\(\)\.\\]/`, "`", "escquote"}},
-		Nonterminal{"bnfchar", []production{"'|'", "'||'", "'→'"}},
-		Nonterminal{"quote", []production{"'"}},
-	}
-	fmt.Println(G)
-	//lex := &lexer{G: G, input: string(prod)}
-	//for state := stateFn(tokenize); state != nil; state = state(lex) {
-	//}
-	//fmt.Println(G.terminals())
-	//fmt.Println(prod)
-	//fmt.Println(lex.tokens)
-
-	/*
-		tree, n, err := G[0].parse(lex.tokens, G)
-		if err != nil {
-			panic(err)
-		}
-		if n < len(lex.tokens) {
-			panic(fmt.Errorf("Unable to parse '%s' at %d", preimage(lex.tokens[n:]), n))
-		}
-		fmt.Println(tree)
-	*/
-}
-
 // Nonterminal represents a nonterminal in a context-free grammar.
 type Nonterminal struct {
 	Head        string
@@ -183,7 +172,11 @@ type Nonterminal struct {
 //     A → γ R | δ R
 //     R → α R | β R | ε
 func (nt Nonterminal) AntiLeftRecurse() ([]Nonterminal, error) {
-	var Rsym string = "R"
+	return nt.antileftrecurse("R")
+}
+
+// antileftrecurse is AntiLeftRecurse, naming R Rsym.
+func (nt Nonterminal) antileftrecurse(Rsym string) ([]Nonterminal, error) {
 	static := []production{}
 	tails := []production{}
 	for _, prod := range nt.Productions {
@@ -195,7 +188,12 @@ func (nt Nonterminal) AntiLeftRecurse() ([]Nonterminal, error) {
 			if len(symbols) < 2 {
 				return nil, fmt.Errorf("Cannot anti recurse %s → %s, too few symbols", nt.Head, prod)
 			}
-			α := strings.Join(symbols[1:], " ")
+			// the rest of the body, keeping its actions
+			α := strings.TrimSpace(string(prod))
+			if !strings.HasPrefix(α, nt.Head) {
+				return nil, fmt.Errorf("Cannot anti recurse %s → %s, action before %s", nt.Head, prod, nt.Head)
+			}
+			α = strings.TrimSpace(α[len(nt.Head):])
 			tails = append(tails, production(fmt.Sprintf("%s %s", α, Rsym)))
 		} else {
 			γ := strings.TrimSpace(string(prod))
@@ -221,14 +219,24 @@ func (nt Nonterminal) String() string {
 	return fmt.Sprintf("{%s → %v}", nt.Head, strings.Join(productionstostrings(nt.Productions), " | "))
 }
 
-func (nt Nonterminal) parse(tokens []Token, G Grammar) (*node, int, error) {
+// parse matches a prefix of tokens by nt, trying its productions in order. An
+// application of a Nonterminal within itself on the same tokens, by left
+// recursion, fails, as it would otherwise never end; active holds those under
+// way, by the number of tokens that remain.
+func (nt Nonterminal) parse(tokens []Token, G Grammar, active map[pegkey]bool) (*node, int, error) {
+	key := pegkey{nt.Head, len(tokens)}
+	if active[key] {
+		return nil, -1, fmt.Errorf("%s is left-recursive", nt.Head)
+	}
+	active[key] = true
+	defer delete(active, key)
 	for _, prod := range nt.Productions {
 		children := []node{}
 		pos := 0
 		var parser func(int) (*node, int, error)
 		for _, sym := range prod.symbolsconcat(true) {
 			if sym.canspace {
-				for len(tokens[pos].string) == 0 {
+				for pos < len(tokens) && len(tokens[pos].string) == 0 {
 					pos += 1
 				}
 			}
@@ -237,13 +245,8 @@ func (nt Nonterminal) parse(tokens []Token, G Grammar) (*node, int, error) {
 					if i >= len(tokens) {
 						return nil, -1, fmt.Errorf("Empty token list %v", tokens)
 					}
-					if tokens[i].string == tk.string {
-						return &node{symbol: tk.string}, 1, nil
-					} else if m := regexptk.FindStringSubmatch(tk.string); len(m) > 1 {
-						re := regexp.MustCompile(m[1])
-						if len(tokens[i].string) > 0 && re.FindString(tokens[i].string) == tokens[i].string {
-							return &node{symbol: tokens[i].string}, 1, nil
-						}
+					if matches(tk.string, tokens[i]) {
+						return &node{symbol: tokens[i].string, span: tokens[i].span}, 1, nil
 					}
 					return nil, -1, fmt.Errorf("Unknown Token %v", tokens[0])
 				}
@@ -251,7 +254,7 @@ func (nt Nonterminal) parse(tokens []Token, G Grammar) (*node, int, error) {
 				for _, subnt := range G {
 					if sym.string == subnt.Head {
 						parser = func(i int) (*node, int, error) {
-							return subnt.parse(tokens[i:], G)
+							return subnt.parse(tokens[i:], G, active)
 						}
 						break // crucial to prevent subnt in the function above being re-written
 					}
@@ -260,23 +263,29 @@ func (nt Nonterminal) parse(tokens []Token, G Grammar) (*node, int, error) {
 			if parser == nil { // should be impossible, but in case
 				panic(fmt.Sprintf("Unknown symbol: %s", sym.string))
 			}
-			fmt.Printf("Parse %q with [%s, %t] in [%s] from %s\n", tokens[pos], sym.string, sym.canspace, prod, nt)
+			if Trace {
+				fmt.Printf("Parse %q with [%s, %t] in [%s] from %s\n", tokens[pos], sym.string, sym.canspace, prod, nt)
+			}
 			if child, shift, err := parser(pos); err == nil {
 				children = append(children, *child)
-				fmt.Printf("%v\nParsed '%s' with [%s, %t] in [%s] from %s\n", children, tokens[pos:pos+shift], sym.string, sym.canspace, prod, nt)
+				if Trace {
+					fmt.Printf("%v\nParsed '%s' with [%s, %t] in [%s] from %s\n", children, tokens[pos:pos+shift], sym.string, sym.canspace, prod, nt)
+				}
 				pos += shift
 			} else {
-				fmt.Println(err)
-				fmt.Printf("%v\nCannot parse %q with [%s, %t] in [%s] from %s\n", children, tokens[pos], sym.string, sym.canspace, prod, nt)
+				if Trace {
+					fmt.Println(err)
+					fmt.Printf("%v\nCannot parse %q with [%s, %t] in [%s] from %s\n", children, tokens[pos], sym.string, sym.canspace, prod, nt)
+				}
 				goto nextprod
 			}
 		}
-		return &node{symbol: fmt.Sprintf("%s → %s", nt.Head, prod), children: children}, pos, nil
+		return derived(nt.Head, prod, children), pos, nil
 	nextprod:
 	}
 	for _, prod := range nt.Productions {
-		if prod == "ε" {
-			return &node{symbol: fmt.Sprintf("%s → %s", nt.Head, tkEmpty)}, 0, nil
+		if prod.stripped() == "ε" {
+			return derived(nt.Head, prod, nil), 0, nil
 		}
 	}
 	return nil, -1, fmt.Errorf("Syntax error in '%s' using %s", preimage(tokens), nt)
@@ -301,7 +310,7 @@ func (G Grammar) terminals() []Token {
 			for _, sym := range prod.symbols() {
 				if _, ok := ntmap[sym]; !ok {
 					if _, ok := tokenmap[sym]; !ok {
-						tokens = append(tokens, Token{sym, ""})
+						tokens = append(tokens, Token{string: sym})
 						tokenmap[sym] = true
 					}
 				}
@@ -318,14 +327,14 @@ func (G Grammar) parsetoken(s string) (Token, bool) {
 	for _, tk := range G.terminals() {
 		if m := regexptk.FindStringSubmatch(tk.string); len(m) > 1 {
 			if re := regexp.MustCompile(m[1]); re.FindString(trim) == trim {
-				return Token{trim, s}, true
+				return Token{string: trim, preimage: s}, true
 			}
 		}
 		if trim == tk.string {
-			return Token{tk.string, s}, true
+			return Token{string: tk.string, preimage: s}, true
 		}
 	}
-	return Token{"Unknown", ""}, false
+	return Token{string: "Unknown"}, false
 }
 
 func (G Grammar) String() string {
@@ -333,7 +342,7 @@ func (G Grammar) String() string {
 	for _, nt := range G {
 		ntmap[nt.Head] = true
 	}
-	prettyprod := func(prod production) string {
+	prettysyms := func(prod production) []string {
 		pieces := []string{}
 		for _, sym := range prod.symbolsconcat(false) {
 			if sym.string == "||" {
@@ -352,7 +361,20 @@ func (G Grammar) String() string {
 				pieces = append(pieces, strings.Join(parts, "||"))
 			}
 		}
-		return strings.Join(pieces, " ")
+		return pieces
+	}
+	// the productions are written as they are read, so that the quotes of
+	// terminals and the constructs of extended BNF remain
+	prettyprod := func(prod production) string {
+		items, _ := prod.ebnf()
+		return writeebnf(items, func(sym string) string {
+			if len(sym) > 1 && sym[0] == '{' {
+				return chalk.Green.NewStyle().Style(sym)
+			} else if len(sym) > 1 && sym[0] == '\'' {
+				return sym
+			}
+			return strings.Join(prettysyms(production(sym)), " ")
+		})
 	}
 	padlen := 0
 	for _, nt := range G {
@@ -374,8 +396,52 @@ func (G Grammar) String() string {
 	return strings.TrimRightFunc(s, unicode.IsSpace)
 }
 
-// Validate ensures that every Nonterminal has at least one production.
+// ReadGrammar reads a grammar in the notation of the bnf directory, with each
+// Nonterminal written
+//     head → α
+//          | β
+// where alternatives may also share a line, separated by a bar between
+// spaces. Blank lines are ignored. The bodies may be written in extended BNF,
+// whose bars inside brackets do not separate alternatives (see Desugar).
+func ReadGrammar(src string) (Grammar, error) {
+	G := Grammar{}
+	for i, line := range strings.Split(src, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "|" {
+			if len(G) == 0 {
+				return nil, fmt.Errorf("line %d: alternative before any Nonterminal", i+1)
+			}
+			fields = fields[1:]
+		} else {
+			if len(fields) < 2 || fields[1] != "→" {
+				return nil, fmt.Errorf("line %d: expected 'head →' or '|'", i+1)
+			}
+			G = append(G, Nonterminal{Head: fields[0]})
+			fields = fields[2:]
+		}
+		nt := &G[len(G)-1]
+		for _, alt := range alternatives(fields) {
+			if len(alt) == 0 {
+				return nil, fmt.Errorf("line %d: empty production for %s", i+1, nt.Head)
+			}
+			nt.Productions = append(nt.Productions, production(strings.Join(alt, " ")))
+		}
+	}
+	if len(G) == 0 {
+		return nil, fmt.Errorf("no productions")
+	}
+	return G, nil
+}
+
+// Validate ensures that G has a Nonterminal, and every Nonterminal at least
+// one production.
 func (G Grammar) Validate() error {
+	if len(G) == 0 {
+		return fmt.Errorf("no productions")
+	}
 	for _, nt := range G {
 		if len(nt.Productions) == 0 {
 			return fmt.Errorf("Nonterminal %s with no productions", nt.Head)
@@ -387,26 +453,54 @@ func (G Grammar) Validate() error {
 // ParseAST parses the input string according to the Grammar, returning an
 // error if this is not possible.
 func (G Grammar) ParseAST(input []byte) (*node, error) {
-	lex := &lexer{G: G, input: string(input)}
-	for state := stateFn(tokenize); state != nil; state = state(lex) {
+	return G.ParseFile(source.NewFile("", string(input)))
+}
+
+// ParseFile is ParseAST on the source of f, whose positions the nodes of the
+// tree record.
+func (G Grammar) ParseFile(f *source.File) (tree *node, err error) {
+	if err := G.Validate(); err != nil {
+		return nil, err
+	}
+	lex := &lexer{G: G, file: f, input: f.Source()}
+	if err := lex.run(); err != nil {
+		return nil, err
 	}
-	tree, n, err := G[0].parse(lex.tokens, G)
+	tree, n, err := G[0].parse(lex.tokens, G, map[pegkey]bool{})
 	if err != nil {
 		return nil, err
 	}
 	if n < len(lex.tokens) {
-		return nil, fmt.Errorf("Unable to parse '%s' at %d", preimage(lex.tokens[n:]), n)
+		return nil, fmt.Errorf("%s: Unable to parse '%s'", f.Position(lex.tokens[n].span.Start), preimage(lex.tokens[n:]))
 	}
 	return tree, nil
 }
 
 // AntiLeftRecurse eliminates left-recursion in the Grammar by calling
 // (Nonterminal).AntiLeftRecurse() on every Nonterminal and replacing as
-// appropriate.
+// appropriate. Each R is named R, R1, R2 and so on, by the first name that
+// is not yet a symbol of the grammar.
 func (G Grammar) AntiLeftRecurse() (Grammar, error) {
+	used := map[string]bool{}
+	for _, nt := range G {
+		used[nt.Head] = true
+		for _, prod := range nt.Productions {
+			for _, sym := range prod.body() {
+				used[sym] = true
+			}
+		}
+	}
+	count := 0
 	newG := Grammar{}
 	for _, nt := range G {
-		repnts, err := nt.AntiLeftRecurse()
+		Rsym := "R"
+		for ; used[Rsym]; Rsym = fmt.Sprintf("R%d", count) {
+			count++
+		}
+		repnts, err := nt.antileftrecurse(Rsym)
+		if len(repnts) > 1 {
+			used[Rsym] = true
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -418,6 +512,22 @@ func (G Grammar) AntiLeftRecurse() (Grammar, error) {
 type node struct {
 	symbol   string
 	children []node
+	span     source.Span // of the tokens derived, if any
+
+	// for a Nonterminal, the production by which it derives its children
+	head  string
+	prod  production
+	attrs Attributes // set by the actions of a Scheme
+}
+
+// derived is the node for head deriving children by prod, spanning all of
+// them.
+func derived(head string, prod production, children []node) *node {
+	n := &node{symbol: fmt.Sprintf("%s → %s", head, prod), children: children, head: head, prod: prod}
+	for _, c := range children {
+		n.span = source.Join(n.span, c.span)
+	}
+	return n
 }
 
 func (n node) String() string {
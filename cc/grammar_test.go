@@ -1,7 +1,11 @@
 package grammar
 
 import (
+	"os"
+	"reflect"
 	"testing"
+
+	"github.com/akiarie/dragon-tests/source"
 )
 
 /*
@@ -90,5 +94,60 @@ func TestBNF(t *testing.T) {
 
 func TestProdExpr(t *testing.T) {
 	prod := production("+ term { print('+') } rest")
-	prod.parse()
+	if got := prod.stripped(); got != "+ term rest" {
+		t.Errorf("stripped %q", got)
+	}
+	if got := prod.symbols(); !reflect.DeepEqual(got, []string{"+", "term", "rest"}) {
+		t.Errorf("symbols %q", got)
+	}
+	if got := production("{ a } {print('}')}").pieces(); !reflect.DeepEqual(got, []piece{{"{ a } ", false}, {"{print('}')}", true}}) {
+		t.Errorf("pieces %v", got)
+	}
+}
+
+func TestParseFileSpans(t *testing.T) {
+	G := Grammar{
+		Nonterminal{"sum", []production{"digit + digit"}},
+		Nonterminal{"digit", []production{`/[0-9]/`}},
+	}
+	f := source.NewFile("sum", "1 +  2")
+	tree, err := G.ParseFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Text(tree.span); got != "1 +  2" {
+		t.Errorf("tree spans %q", got)
+	}
+	last := tree.children[len(tree.children)-1]
+	if got := f.Position(last.span.Start).String(); got != "sum:1:6" {
+		t.Errorf("last child at %s", got)
+	}
+}
+
+func TestReadGrammar(t *testing.T) {
+	src, err := os.ReadFile("bnf/dragon-216.grm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	G, err := ReadGrammar(string(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Grammar{
+		Nonterminal{"stmt", []production{
+			"expr ;",
+			"if ( expr ) stmt",
+			"for ( optexpr ; optexpr ; optexpr ) stmt",
+			"other",
+		}},
+		Nonterminal{"optexpr", []production{"ε", "expr"}},
+	}
+	if !reflect.DeepEqual(G, want) {
+		t.Errorf("got\n%v\nwant\n%v", G, want)
+	}
+	for _, bad := range []string{"| a", "a b", "a → b |", "\n"} {
+		if _, err := ReadGrammar(bad); err == nil {
+			t.Errorf("%q read without error", bad)
+		}
+	}
 }
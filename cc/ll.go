@@ -0,0 +1,294 @@
+package grammar
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+// end is the endmarker $ of FOLLOW sets and parsing tables.
+const end = "$"
+
+// body is the symbols of the body of prod, without ε.
+func (prod production) body() []string {
+	syms := []string{}
+	for _, sym := range prod.stripped().symbolsconcat(true) {
+		if sym.string != "ε" {
+			syms = append(syms, sym.string)
+		}
+	}
+	return syms
+}
+
+// heads are the Nonterminals of G by head.
+func (G Grammar) heads() map[string]Nonterminal {
+	heads := map[string]Nonterminal{}
+	for _, nt := range G {
+		heads[nt.Head] = nt
+	}
+	return heads
+}
+
+// matches reports whether tk is an instance of the terminal term, which is
+// either a string or a /regular expression/.
+func matches(term string, tk Token) bool {
+	if tk.string == term {
+		return true
+	}
+	if m := regexptk.FindStringSubmatch(term); len(m) > 1 {
+		re := regexp.MustCompile(m[1])
+		return len(tk.string) > 0 && re.FindString(tk.string) == tk.string
+	}
+	return false
+}
+
+type symbolset map[string]bool
+
+func (s symbolset) add(t symbolset) bool {
+	changed := false
+	for sym := range t {
+		if sym != "ε" && !s[sym] {
+			s[sym], changed = true, true
+		}
+	}
+	return changed
+}
+
+func (s symbolset) String() string {
+	syms := []string{}
+	for sym := range s {
+		syms = append(syms, sym)
+	}
+	sort.Strings(syms)
+	return "{" + strings.Join(syms, ", ") + "}"
+}
+
+// sets are the FIRST and FOLLOW sets of the Nonterminals of a grammar
+// (Section 4.4.2), with ε in FIRST(A) if A derives ε.
+type sets struct {
+	heads         map[string]Nonterminal
+	first, follow map[string]symbolset
+}
+
+// firstof is FIRST(α), with ε if α derives ε.
+func (s *sets) firstof(α []string) symbolset {
+	f := symbolset{}
+	for _, sym := range α {
+		if _, ok := s.heads[sym]; !ok {
+			f[sym] = true
+			return f
+		}
+		f.add(s.first[sym])
+		if !s.first[sym]["ε"] {
+			return f
+		}
+	}
+	f["ε"] = true
+	return f
+}
+
+func (G Grammar) sets() *sets {
+	s := &sets{heads: G.heads(), first: map[string]symbolset{}, follow: map[string]symbolset{}}
+	for _, nt := range G {
+		s.first[nt.Head], s.follow[nt.Head] = symbolset{}, symbolset{}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, nt := range G {
+			for _, prod := range nt.Productions {
+				f := s.firstof(prod.body())
+				if f["ε"] && !s.first[nt.Head]["ε"] {
+					s.first[nt.Head]["ε"], changed = true, true
+				}
+				changed = s.first[nt.Head].add(f) || changed
+			}
+		}
+	}
+	s.follow[G[0].Head][end] = true
+	for changed := true; changed; {
+		changed = false
+		for _, nt := range G {
+			for _, prod := range nt.Productions {
+				body := prod.body()
+				for i, sym := range body {
+					if _, ok := s.heads[sym]; !ok {
+						continue
+					}
+					rest := s.firstof(body[i+1:])
+					changed = s.follow[sym].add(rest) || changed
+					if rest["ε"] {
+						changed = s.follow[sym].add(s.follow[nt.Head]) || changed
+					}
+				}
+			}
+		}
+	}
+	return s
+}
+
+// lltable is a predictive parsing table (Section 4.4.3): the production of
+// each Nonterminal to use on each lookahead terminal.
+type lltable map[string]map[string]production
+
+// ll1 constructs the parsing table of G, failing if G is not LL(1).
+func (G Grammar) ll1() (lltable, error) {
+	s := G.sets()
+	table := lltable{}
+	for _, nt := range G {
+		table[nt.Head] = map[string]production{}
+		for _, prod := range nt.Productions {
+			f := s.firstof(prod.body())
+			if f["ε"] {
+				f.add(s.follow[nt.Head])
+			}
+			for term := range f {
+				if term == "ε" {
+					continue
+				}
+				if other, ok := table[nt.Head][term]; ok {
+					return nil, fmt.Errorf("not LL(1): %s → %s and %s → %s both on %s", nt.Head, other, nt.Head, prod, term)
+				}
+				table[nt.Head][term] = prod
+			}
+		}
+	}
+	return table, nil
+}
+
+// llparser is a recursive predictive parser, driven by an lltable.
+type llparser struct {
+	G      Grammar
+	heads  map[string]Nonterminal
+	table  lltable
+	file   *source.File
+	tokens []Token
+	pos    int
+}
+
+func (p *llparser) errorf(format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	if p.pos < len(p.tokens) {
+		return fmt.Errorf("%s: %s", p.file.Position(p.tokens[p.pos].span.Start), msg)
+	}
+	return fmt.Errorf("%s: %s", p.file.Position(p.file.Pos(p.file.Size())), msg)
+}
+
+func (p *llparser) lookahead() string {
+	if p.pos < len(p.tokens) {
+		return fmt.Sprintf("%q", p.tokens[p.pos].string)
+	}
+	return "end of input"
+}
+
+// predict chooses the production of head for the next token, preferring a
+// terminal it equals to a regular expression it matches.
+func (p *llparser) predict(head string) (production, error) {
+	row := p.table[head]
+	if p.pos == len(p.tokens) {
+		if prod, ok := row[end]; ok {
+			return prod, nil
+		}
+	} else {
+		tk := p.tokens[p.pos]
+		if prod, ok := row[tk.string]; ok {
+			return prod, nil
+		}
+		terms := []string{}
+		for term := range row {
+			terms = append(terms, term)
+		}
+		sort.Strings(terms)
+		for _, term := range terms {
+			if term != end && matches(term, tk) {
+				return row[term], nil
+			}
+		}
+	}
+	expected := symbolset{}
+	for term := range row {
+		expected[term] = true
+	}
+	return "", p.errorf("%s cannot begin with %s, expected one of %s", head, p.lookahead(), expected)
+}
+
+// match consumes the terminal term.
+func (p *llparser) match(term string) (*node, error) {
+	if p.pos == len(p.tokens) || !matches(term, p.tokens[p.pos]) {
+		return nil, p.errorf("expected %s, found %s", term, p.lookahead())
+	}
+	tk := p.tokens[p.pos]
+	p.pos++
+	return &node{symbol: tk.string, span: tk.span}, nil
+}
+
+// lexemes are the tokens of f, without the space between them.
+func (G Grammar) lexemes(f *source.File) ([]Token, error) {
+	all, err := G.Tokens(f)
+	if err != nil {
+		return nil, err
+	}
+	tokens := []Token{}
+	for _, tk := range all {
+		if tk.string != "" { // space
+			tokens = append(tokens, tk)
+		}
+	}
+	return tokens, nil
+}
+
+// llparse parses f by the table of G, calling visit before each symbol of the
+// body of each production is parsed, with the index of the symbol and the
+// children so far, and once more at the end with the index -1.
+func (G Grammar) llparse(f *source.File, visit func(n *node, i int) error) (*node, error) {
+	table, err := G.ll1()
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := G.lexemes(f)
+	if err != nil {
+		return nil, err
+	}
+	p := &llparser{G: G, heads: G.heads(), table: table, file: f, tokens: tokens}
+	var parse func(n *node) error
+	parse = func(n *node) error {
+		prod, err := p.predict(n.head)
+		if err != nil {
+			return err
+		}
+		n.prod = prod
+		n.symbol = fmt.Sprintf("%s → %s", n.head, prod)
+		body := prod.body()
+		n.children = make([]node, len(body))
+		for i, sym := range body {
+			if err := visit(n, i); err != nil {
+				return err
+			}
+			if _, ok := p.heads[sym]; ok {
+				n.children[i].head = sym
+				if err := parse(&n.children[i]); err != nil {
+					return err
+				}
+			} else {
+				child, err := p.match(sym)
+				if err != nil {
+					return err
+				}
+				child.attrs = n.children[i].attrs
+				n.children[i] = *child
+			}
+			n.span = source.Join(n.span, n.children[i].span)
+		}
+		return visit(n, -1)
+	}
+	tree := &node{head: G[0].Head}
+	if err := parse(tree); err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, p.errorf("unexpected %s after %s", p.lookahead(), G[0].Head)
+	}
+	return tree, nil
+}
@@ -0,0 +1,372 @@
+package grammar
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// symbols numbers the symbols and productions of a grammar for its parsing
+// tables: the terminals from 0, which is the endmarker, and then the
+// Nonterminals. Production 0 is S' → S, for the start symbol S.
+type symbols struct {
+	terminals    []string
+	nonterminals []string
+	code         map[string]int
+	prods        []numbered
+}
+
+// numbered is a production whose symbols are numbered.
+type numbered struct {
+	head int // a Nonterminal
+	body []int
+	prod production
+}
+
+func (s *symbols) name(code int) string {
+	if code < len(s.terminals) {
+		return s.terminals[code]
+	}
+	return s.nonterminals[code-len(s.terminals)]
+}
+
+func (s *symbols) isterminal(code int) bool { return code < len(s.terminals) }
+
+func (s *symbols) names(codes []int) []string {
+	names := make([]string, len(codes))
+	for i, code := range codes {
+		names[i] = s.name(code)
+	}
+	return names
+}
+
+// prodstring is the text of production p, without its actions.
+func (s *symbols) prodstring(p int) string {
+	if len(s.prods[p].body) == 0 {
+		return fmt.Sprintf("%s → ε", s.name(s.prods[p].head))
+	}
+	return fmt.Sprintf("%s → %s", s.name(s.prods[p].head), strings.Join(s.names(s.prods[p].body), " "))
+}
+
+func (G Grammar) number() *symbols {
+	heads := G.heads()
+	s := &symbols{terminals: []string{end}, code: map[string]int{end: 0}}
+	for _, nt := range G {
+		for _, prod := range nt.Productions {
+			for _, sym := range prod.body() {
+				if _, ok := heads[sym]; !ok && s.code[sym] == 0 && sym != end {
+					s.code[sym] = len(s.terminals)
+					s.terminals = append(s.terminals, sym)
+				}
+			}
+		}
+	}
+	start := G[0].Head + "'"
+	s.nonterminals = []string{start}
+	s.code[start] = len(s.terminals)
+	for _, nt := range G {
+		s.code[nt.Head] = len(s.terminals) + len(s.nonterminals)
+		s.nonterminals = append(s.nonterminals, nt.Head)
+	}
+	s.prods = []numbered{{s.code[start], []int{s.code[G[0].Head]}, production(G[0].Head)}}
+	for _, nt := range G {
+		for _, prod := range nt.Productions {
+			p := numbered{head: s.code[nt.Head], body: []int{}, prod: prod}
+			for _, sym := range prod.body() {
+				p.body = append(p.body, s.code[sym])
+			}
+			s.prods = append(s.prods, p)
+		}
+	}
+	return s
+}
+
+// item is an LR(0) item A → α·β: the production and the position of the dot.
+type item struct{ prod, dot int }
+
+// lrstate is a state of the LR(0) automaton, given by its kernel items.
+type lrstate struct {
+	kernel []item
+	gotos  map[int]int // on each symbol
+}
+
+// assoc is the associativity of a terminal with a precedence.
+type assoc int
+
+const (
+	leftassoc assoc = iota
+	rightassoc
+	nonassoc
+)
+
+// precedence is that of a terminal or production, a greater level binding
+// tighter; level 0 is none.
+type precedence struct {
+	level int
+	assoc assoc
+}
+
+// lrtable is an LALR(1) parsing table (Section 4.7). An action is a shift to
+// state s, written s+1, a reduction by production p, written -(p+1), or 0 for
+// an error; the reduction by production 0 accepts. gotos are on Nonterminals,
+// written s+1.
+type lrtable struct {
+	*symbols
+	states []lrstate
+	action [][]int
+	gotos  [][]int
+	// the actions of each state on each terminal before conflicts are
+	// resolved
+	cells     [][][]int
+	conflicts []conflict // left unresolved
+}
+
+// conflict is a cell of an LR table with more than one action.
+type conflict struct {
+	state, terminal int
+	actions         []int
+}
+
+func (t *lrtable) conflictstring(c conflict) string {
+	actions := []string{}
+	for _, act := range c.actions {
+		if act > 0 {
+			actions = append(actions, "shift")
+		} else {
+			actions = append(actions, "reduce "+t.prodstring(-act-1))
+		}
+	}
+	return fmt.Sprintf("state %d on %s: %s", c.state, t.terminals[c.terminal], strings.Join(actions, ", "))
+}
+
+func (s *symbols) closure(kernel []item) []item {
+	items := append([]item{}, kernel...)
+	added := map[int]bool{}
+	for i := 0; i < len(items); i++ {
+		body := s.prods[items[i].prod].body
+		if items[i].dot == len(body) || s.isterminal(body[items[i].dot]) || added[body[items[i].dot]] {
+			continue
+		}
+		B := body[items[i].dot]
+		added[B] = true
+		for p, prod := range s.prods {
+			if prod.head == B {
+				items = append(items, item{p, 0})
+			}
+		}
+	}
+	return items
+}
+
+// lr0 constructs the canonical collection of sets of LR(0) items.
+func (s *symbols) lr0() []lrstate {
+	states := []lrstate{{kernel: []item{{0, 0}}, gotos: map[int]int{}}}
+	index := map[string]int{fmt.Sprint(states[0].kernel): 0}
+	for i := 0; i < len(states); i++ {
+		next := map[int][]item{}
+		var order []int
+		for _, it := range s.closure(states[i].kernel) {
+			body := s.prods[it.prod].body
+			if it.dot == len(body) {
+				continue
+			}
+			X := body[it.dot]
+			if _, ok := next[X]; !ok {
+				order = append(order, X)
+			}
+			next[X] = append(next[X], item{it.prod, it.dot + 1})
+		}
+		for _, X := range order {
+			kernel := next[X]
+			sort.Slice(kernel, func(a, b int) bool {
+				return kernel[a].prod < kernel[b].prod || kernel[a].prod == kernel[b].prod && kernel[a].dot < kernel[b].dot
+			})
+			key := fmt.Sprint(kernel)
+			j, ok := index[key]
+			if !ok {
+				j = len(states)
+				index[key] = j
+				states = append(states, lrstate{kernel: kernel, gotos: map[int]int{}})
+			}
+			states[i].gotos[X] = j
+		}
+	}
+	return states
+}
+
+// propagated stands for the lookahead # of Algorithm 4.62, which is not a
+// symbol of any grammar.
+const propagated = "\x00#"
+
+// closure1 is the closure of the LR(1) items given by kernel, each with the
+// lookaheads of la.
+func (s *symbols) closure1(first *sets, kernel []item, la []symbolset) ([]item, []symbolset) {
+	items := append([]item{}, kernel...)
+	las := []symbolset{}
+	index := map[item]int{}
+	for i, it := range kernel {
+		las = append(las, symbolset{})
+		las[i].add(la[i])
+		index[it] = i
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := 0; i < len(items); i++ {
+			body := s.prods[items[i].prod].body
+			if items[i].dot == len(body) || s.isterminal(body[items[i].dot]) {
+				continue
+			}
+			β := s.names(body[items[i].dot+1:])
+			f := first.firstof(β)
+			if f["ε"] {
+				f.add(las[i])
+			}
+			for p, prod := range s.prods {
+				if prod.head != body[items[i].dot] {
+					continue
+				}
+				j, ok := index[item{p, 0}]
+				if !ok {
+					j = len(items)
+					index[item{p, 0}] = j
+					items = append(items, item{p, 0})
+					las = append(las, symbolset{})
+				}
+				changed = las[j].add(f) || changed
+			}
+		}
+	}
+	return items, las
+}
+
+// lalr constructs the LALR(1) parsing table of G from its LR(0) automaton by
+// propagating lookaheads (Algorithm 4.63), resolving conflicts between shifts
+// and reductions by the precedences of the terminal and the production as
+// yacc does. prec gives the precedence of terminals and, by index from 1 as
+// in the numbering of the symbols, of productions; a production otherwise has
+// that of the last terminal of its body. Unresolved conflicts are errors.
+func (G Grammar) lalr(prec map[string]precedence, prodprec map[int]precedence) (*lrtable, error) {
+	t := &lrtable{symbols: G.number()}
+	t.states = t.lr0()
+	first := G.sets()
+
+	// the lookaheads of each kernel item
+	la := make([][]symbolset, len(t.states))
+	for i, st := range t.states {
+		la[i] = make([]symbolset, len(st.kernel))
+		for k := range st.kernel {
+			la[i][k] = symbolset{}
+		}
+	}
+	la[0][0][end] = true
+	type at struct{ state, k int }
+	propagates := map[at][]at{}
+	kernelindex := func(state int, it item) int {
+		for k, kit := range t.states[state].kernel {
+			if kit == it {
+				return k
+			}
+		}
+		panic(fmt.Sprintf("no kernel item %v in state %d", it, state))
+	}
+	for i, st := range t.states {
+		for k, K := range st.kernel {
+			items, las := t.closure1(first, []item{K}, []symbolset{{propagated: true}})
+			for j, it := range items {
+				body := t.prods[it.prod].body
+				if it.dot == len(body) {
+					continue
+				}
+				target := st.gotos[body[it.dot]]
+				kt := kernelindex(target, item{it.prod, it.dot + 1})
+				for a := range las[j] {
+					if a == propagated {
+						propagates[at{i, k}] = append(propagates[at{i, k}], at{target, kt})
+					} else {
+						la[target][kt][a] = true
+					}
+				}
+			}
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for from, tos := range propagates {
+			for _, to := range tos {
+				changed = la[to.state][to.k].add(la[from.state][from.k]) || changed
+			}
+		}
+	}
+
+	T, N := len(t.terminals), len(t.nonterminals)
+	t.cells = make([][][]int, len(t.states))
+	t.gotos = make([][]int, len(t.states))
+	for i, st := range t.states {
+		t.cells[i] = make([][]int, T)
+		t.gotos[i] = make([]int, N)
+		for X, j := range st.gotos {
+			if t.isterminal(X) {
+				t.cells[i][X] = append(t.cells[i][X], j+1)
+			} else {
+				t.gotos[i][X-T] = j + 1
+			}
+		}
+		items, las := t.closure1(first, st.kernel, la[i])
+		for j, it := range items {
+			if it.dot < len(t.prods[it.prod].body) {
+				continue
+			}
+			for a := range las[j] {
+				t.cells[i][t.code[a]] = append(t.cells[i][t.code[a]], -(it.prod + 1))
+			}
+		}
+		for a := range t.cells[i] {
+			sort.Sort(sort.Reverse(sort.IntSlice(t.cells[i][a]))) // the shift first
+		}
+	}
+
+	precof := func(p int) precedence {
+		if pr, ok := prodprec[p]; ok {
+			return pr
+		}
+		body := t.prods[p].body
+		for j := len(body) - 1; j >= 0; j-- {
+			if t.isterminal(body[j]) {
+				return prec[t.name(body[j])]
+			}
+		}
+		return precedence{}
+	}
+	var conflicts []string
+	t.action = make([][]int, len(t.states))
+	for i := range t.states {
+		t.action[i] = make([]int, T)
+		for a, cell := range t.cells[i] {
+			switch {
+			case len(cell) == 0:
+				continue
+			case len(cell) == 1:
+				t.action[i][a] = cell[0]
+				continue
+			case len(cell) == 2 && cell[0] > 0:
+				// shift/reduce
+				pa, pp := prec[t.terminals[a]], precof(-cell[1]-1)
+				if pa.level > 0 && pp.level > 0 {
+					switch {
+					case pp.level > pa.level || pp.level == pa.level && pa.assoc == leftassoc:
+						t.action[i][a] = cell[1]
+					case pp.level < pa.level || pa.assoc == rightassoc:
+						t.action[i][a] = cell[0]
+					}
+					continue
+				}
+			}
+			t.conflicts = append(t.conflicts, conflict{i, a, cell})
+			conflicts = append(conflicts, t.conflictstring(t.conflicts[len(t.conflicts)-1]))
+		}
+	}
+	if len(conflicts) > 0 {
+		return t, fmt.Errorf("not LALR(1):\n\t%s", strings.Join(conflicts, "\n\t"))
+	}
+	return t, nil
+}
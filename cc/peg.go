@@ -0,0 +1,278 @@
+package grammar
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+// pitem is an item of a body in the notation of parsing expression grammars:
+// a symbol with perhaps a predicate & or ! before it, or an operator *, + or ?
+// after it, written without space between them.
+type pitem struct {
+	pred byte
+	sym  string // as written, quoted if it is a quoted terminal
+	op   byte
+}
+
+var pitemre = regexp.MustCompile(`([&!]?)(\w+|\/[^/]+\/|'[^']+'|[^ |])([*+?]?)`)
+
+// name is the symbol of the item, without quotes.
+func (it pitem) name() string {
+	if len(it.sym) > 2 && it.sym[0] == '\'' && it.sym[len(it.sym)-1] == '\'' {
+		return it.sym[1 : len(it.sym)-1]
+	}
+	return it.sym
+}
+
+// pitems are the items of the body of prod, without its actions, none for ε.
+func (prod production) pitems() []pitem {
+	if prod.stripped() == "ε" {
+		return nil
+	}
+	items := []pitem{}
+	for _, m := range pitemre.FindAllStringSubmatch(string(prod.stripped()), -1) {
+		it := pitem{sym: m[2]}
+		if m[1] != "" {
+			it.pred = m[1][0]
+		}
+		if m[3] != "" {
+			it.op = m[3][0]
+		}
+		items = append(items, it)
+	}
+	return items
+}
+
+// pegkey is the application of a Nonterminal at a token.
+type pegkey struct {
+	head string
+	pos  int
+}
+
+// pegresult is the tree of an application and the token after it, or nil
+// for a failure.
+type pegresult struct {
+	n   *node
+	end int
+}
+
+// pegparser is a packrat parser, which memoizes the result of applying each
+// Nonterminal at each token, so that its backtracking takes linear time.
+type pegparser struct {
+	G       Grammar
+	heads   map[string]Nonterminal
+	items   map[string][][]pitem
+	leftrec map[string]bool // the directly left-recursive Nonterminals
+	file    *source.File
+	tokens  []Token
+	memo    map[pegkey]*pegresult
+	active  map[pegkey]bool
+
+	// the farthest token at which a terminal failed to match, outside any
+	// predicate, and the terminals expected there
+	farthest   int
+	expected   symbolset
+	predicates int
+}
+
+// apply applies head at token pos, growing the seed of a directly
+// left-recursive Nonterminal as Warth, Douglass and Millstein do: the
+// application first fails, and is then repeated, each time using the last
+// result for the recursive application, while it consumes more tokens.
+func (p *pegparser) apply(head string, pos int) (*pegresult, error) {
+	key := pegkey{head, pos}
+	if r, ok := p.memo[key]; ok {
+		return r, nil
+	}
+	if p.active[key] {
+		return nil, fmt.Errorf("%s: %s is left-recursive, but not directly", position(p.file, p.tokens, pos), head)
+	}
+	p.active[key] = true
+	defer delete(p.active, key)
+	if !p.leftrec[head] {
+		r, err := p.choice(head, pos)
+		if err != nil {
+			return nil, err
+		}
+		p.memo[key] = r
+		return r, nil
+	}
+	p.memo[key] = &pegresult{end: -1}
+	for {
+		r, err := p.choice(head, pos)
+		if err != nil {
+			return nil, err
+		}
+		if r.n == nil || r.end <= p.memo[key].end {
+			return p.memo[key], nil
+		}
+		p.memo[key] = r
+	}
+}
+
+// choice applies the productions of head in order, until one matches.
+func (p *pegparser) choice(head string, pos int) (*pegresult, error) {
+	for i, items := range p.items[head] {
+		children, end, err := p.sequence(items, pos)
+		if err != nil {
+			return nil, err
+		}
+		if end < 0 {
+			continue
+		}
+		prod := p.heads[head].Productions[i]
+		if len(items) == 0 {
+			return &pegresult{derived(head, prod, nil), pos}, nil
+		}
+		return &pegresult{derived(head, prod, children), end}, nil
+	}
+	return &pegresult{end: -1}, nil
+}
+
+// sequence matches the items from token pos, returning the trees of the
+// symbols they match and the token after them, or -1.
+func (p *pegparser) sequence(items []pitem, pos int) ([]node, int, error) {
+	children := []node{}
+	for _, it := range items {
+		if it.pred != 0 {
+			p.predicates++
+			_, end, err := p.item(it, pos)
+			p.predicates--
+			if err != nil {
+				return nil, -1, err
+			}
+			if (end >= 0) != (it.pred == '&') {
+				return nil, -1, nil
+			}
+			continue
+		}
+		matched, end, err := p.item(it, pos)
+		if err != nil || end < 0 {
+			return nil, -1, err
+		}
+		children = append(children, matched...)
+		pos = end
+	}
+	return children, pos, nil
+}
+
+// item matches the symbol of it, as many times as its operator allows.
+func (p *pegparser) item(it pitem, pos int) ([]node, int, error) {
+	matched := []node{}
+	for {
+		r, err := p.symbol(it.name(), pos)
+		if err != nil {
+			return nil, -1, err
+		}
+		if r.n == nil {
+			break
+		}
+		matched = append(matched, *r.n)
+		if it.op == 0 || it.op == '?' || r.end == pos { // once, or forever
+			pos = r.end
+			break
+		}
+		pos = r.end
+	}
+	if len(matched) == 0 && it.op != '*' && it.op != '?' {
+		return nil, -1, nil
+	}
+	return matched, pos, nil
+}
+
+func (p *pegparser) symbol(sym string, pos int) (*pegresult, error) {
+	if _, ok := p.heads[sym]; ok {
+		return p.apply(sym, pos)
+	}
+	if pos < len(p.tokens) && matches(sym, p.tokens[pos]) {
+		tk := p.tokens[pos]
+		return &pegresult{&node{symbol: tk.string, span: tk.span}, pos + 1}, nil
+	}
+	if p.predicates == 0 && pos >= p.farthest {
+		if pos > p.farthest {
+			p.farthest, p.expected = pos, symbolset{}
+		}
+		p.expected[sym] = true
+	}
+	return &pegresult{end: -1}, nil
+}
+
+// ParsePEG parses input as ParseAST does, reading G as a parsing expression
+// grammar.
+func (G Grammar) ParsePEG(input []byte) (*node, error) {
+	return G.ParsePEGFile(source.NewFile("", string(input)))
+}
+
+// ParsePEGFile parses f reading G as a parsing expression grammar, by a
+// packrat parser. The productions of a Nonterminal are an ordered choice, the
+// first that matches being taken, an ε-production matching wherever it
+// comes; a symbol of a body may be followed by *, + or ? to match it any
+// number of times, at least once or at most once, and be preceded by & or !,
+// to match only if the symbol would match, or would not, without consuming
+// anything. A directly left-recursive Nonterminal matches as much as it can.
+// The space between tokens is skipped.
+func (G Grammar) ParsePEGFile(f *source.File) (*node, error) {
+	if err := G.Validate(); err != nil {
+		return nil, err
+	}
+	p := &pegparser{
+		G:        G,
+		heads:    G.heads(),
+		items:    map[string][][]pitem{},
+		leftrec:  map[string]bool{},
+		file:     f,
+		memo:     map[pegkey]*pegresult{},
+		active:   map[pegkey]bool{},
+		expected: symbolset{},
+	}
+	// the tokens are those of the symbols, without the operators
+	lexical := Grammar{}
+	for _, nt := range G {
+		syms := []string{}
+		for _, prod := range nt.Productions {
+			items := prod.pitems()
+			p.items[nt.Head] = append(p.items[nt.Head], items)
+			if len(items) > 0 && items[0].pred == 0 && items[0].sym == nt.Head {
+				p.leftrec[nt.Head] = true
+			}
+			for _, it := range items {
+				syms = append(syms, it.sym)
+			}
+		}
+		if len(syms) == 0 {
+			syms = append(syms, "ε")
+		}
+		lexical = append(lexical, Nonterminal{nt.Head, []production{production(strings.Join(syms, " "))}})
+	}
+	tokens, err := lexical.lexemes(f)
+	if err != nil {
+		return nil, err
+	}
+	p.tokens = tokens
+	r, err := p.apply(G[0].Head, 0)
+	if err != nil {
+		return nil, err
+	}
+	if r.n != nil {
+		if r.end == len(tokens) {
+			return r.n, nil
+		}
+		if r.end > p.farthest {
+			p.farthest, p.expected = r.end, symbolset{}
+		}
+		if r.end == p.farthest {
+			p.expected[end] = true
+		}
+	}
+	found := "end of input"
+	if p.farthest < len(tokens) {
+		found = fmt.Sprintf("%q", tokens[p.farthest].string)
+	}
+	if len(p.expected) == 0 { // a predicate failed
+		return nil, fmt.Errorf("%s: unexpected %s", position(f, tokens, p.farthest), found)
+	}
+	return nil, fmt.Errorf("%s: expected one of %s, found %s", position(f, tokens, p.farthest), p.expected, found)
+}
@@ -0,0 +1,101 @@
+package grammar
+
+import (
+	"strings"
+	"testing"
+)
+
+// nested is the source of a grammar on which the backtracking of ParseAST
+// takes time exponential in the depth of the parentheses.
+const nested = `
+	E → T + E | T - E | T
+	T → F * T | F
+	F → ( E ) | /[a-z]/
+`
+
+func TestPEG(t *testing.T) {
+	// the trees of the grammars that ParseAST can parse are the same
+	for _, tc := range []struct {
+		G     Grammar
+		input string
+	}{
+		{mustgrammar(t, "S → + S S | - S S | a"), "+ - + a a + a a a"},
+		{mustgrammar(t, "S → 0 S 1 | 0 1"), "00 001 111"},
+		{mustgrammar(t, nested), "a * (b + c) - d"},
+		{mustgrammar(t, nested), "((((a))))"},
+		{fig54.Grammar, "2 * 3 * 4"},
+	} {
+		want, err := tc.G.ParseAST([]byte(tc.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := tc.G.ParsePEG([]byte(tc.input))
+		if err != nil {
+			t.Errorf("%q: %v", tc.input, err)
+		} else if got.String() != want.String() {
+			t.Errorf("%q: tree\n%s\nwant\n%s", tc.input, got, want)
+		}
+	}
+}
+
+func TestPEGOperators(t *testing.T) {
+	tests := []struct {
+		src, input, tree string
+	}{
+		{"E → E - T | E + T | T\nT → /[0-9]/", "9-5+2", "((9-5)+2)"},
+		{"E → E - T | T\nT → T * F | F\nF → /[0-9]/", "1-2*3-4", "((1-(2*3))-4)"},
+		{"L → I R*\nR → , I\nI → /[a-z]/", "a, b, c", "(a(,b)(,c))"},
+		{"L → I R*\nR → , I\nI → /[a-z]/", "a", "a"},
+		{"L → /[0-9]/+ ;?", "1 2 3", "(123)"},
+		{"L → /[0-9]/+ ;?", "1 2 ;", "(12;)"},
+		{"S → !x /[a-z]/ | x /[a-z]/", "x y", "(xy)"},
+		{"S → !x /[a-z]/ | x /[a-z]/", "y", "y"},
+		{"S → A &; ;\nA → /[a-z]/", "x;", "(x;)"},
+		{"S → a S | ε", "a a", "(aa)"},
+	}
+	for _, tc := range tests {
+		tree, err := mustgrammar(t, tc.src).ParsePEG([]byte(tc.input))
+		if err != nil {
+			t.Errorf("%q on %q: %v", tc.src, tc.input, err)
+		} else if got := flat(tree); got != tc.tree {
+			t.Errorf("%q on %q: %s, want %s", tc.src, tc.input, got, tc.tree)
+		}
+	}
+}
+
+func TestPEGErrors(t *testing.T) {
+	tests := []struct {
+		src, input, err string
+	}{
+		{"E → E - T | T\nT → /[0-9]/", "9-", "1:3: expected one of {/[0-9]/}, found end of input"},
+		{"E → E - T | T\nT → /[0-9]/", "9 5", `1:3: expected one of {$, -}, found "5"`},
+		{"S → !x /[a-z]/", "x", `1:1: unexpected "x"`},
+		{"A → B x | x\nB → A y", "x y x", "1:1: A is left-recursive, but not directly"},
+		{"S → /[a-z]/ ;", "a", "1:2: expected one of {;}, found end of input"},
+	}
+	for _, tc := range tests {
+		_, err := mustgrammar(t, tc.src).ParsePEG([]byte(tc.input))
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("%q on %q: got error %v, want %s", tc.src, tc.input, err, tc.err)
+		}
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	input := []byte(strings.Repeat("(", 3) + "a" + strings.Repeat(")", 3))
+	G := mustgrammar(b, nested)
+	b.Run("backtracking", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := G.ParseAST(input); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("packrat", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := G.ParsePEG(input); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,557 @@
+package grammar
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+// SDD is a syntax-directed definition (Section 5.1): a Grammar whose
+// productions carry semantic rules, and the attributes of each Nonterminal,
+// which are either synthesized or inherited. The rules are written as the
+// assignments of actions, whose places in the bodies do not matter; a call
+// that is not assigned is a rule with side effects only. Every symbol also
+// has the synthesized attribute lexeme, the text that it derives, which no
+// rule defines.
+type SDD struct {
+	Grammar     Grammar
+	Synthesized map[string][]string
+	Inherited   map[string][]string
+	Funcs       map[string]Func
+}
+
+// rule is a semantic rule of a production, compiled.
+type rule struct {
+	target int    // -1 for the head, or the index of a symbol in the body
+	attr   string // empty for side effects
+	src    operand
+	refs   map[*attrref]int // the targets of the attributes that src uses
+	text   string
+}
+
+// optext is the text of o.
+func optext(o operand) string {
+	switch o := o.(type) {
+	case string:
+		return fmt.Sprintf("'%s'", o)
+	case *attrref:
+		return o.symbol + "." + o.attr
+	case *call:
+		args := make([]string, len(o.args))
+		for i, arg := range o.args {
+			args[i] = optext(arg)
+		}
+		return fmt.Sprintf("%s(%s)", o.name, strings.Join(args, ", "))
+	}
+	panic(fmt.Sprintf("unknown operand %T", o))
+}
+
+// refs are the attributes that o uses.
+func refs(o operand) []*attrref {
+	switch o := o.(type) {
+	case *attrref:
+		return []*attrref{o}
+	case *call:
+		var all []*attrref
+		for _, arg := range o.args {
+			all = append(all, refs(arg)...)
+		}
+		return all
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, t := range list {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+// occurrence is the name by which rules refer to the ith symbol of body:
+// itself, or with k appended for the kth occurrence of a symbol that recurs or
+// is the head.
+func occurrence(head string, body []string, i int) string {
+	k, n := 0, 0
+	for j, sym := range body {
+		if sym == body[i] {
+			n++
+			if j <= i {
+				k++
+			}
+		}
+	}
+	if n == 1 && body[i] != head {
+		return body[i]
+	}
+	return fmt.Sprintf("%s%d", body[i], k)
+}
+
+// compiled are the rules of each production.
+type compiled map[string]map[production][]*rule
+
+// rules compiles the rules of d, ensuring that each defines a synthesized
+// attribute of the head or an inherited attribute of a Nonterminal of the
+// body, that each attribute used is declared, and that every production
+// defines every attribute that it must, once.
+func (d SDD) rules() (compiled, error) {
+	heads := d.Grammar.heads()
+	for _, decl := range []map[string][]string{d.Synthesized, d.Inherited} {
+		for head := range decl {
+			if _, ok := heads[head]; !ok {
+				return nil, fmt.Errorf("attributes declared for unknown Nonterminal %s", head)
+			}
+		}
+	}
+	for head, attrs := range d.Inherited {
+		for _, attr := range attrs {
+			if contains(d.Synthesized[head], attr) || attr == "lexeme" {
+				return nil, fmt.Errorf("%s.%s is declared both synthesized and inherited", head, attr)
+			}
+		}
+	}
+	if start := d.Grammar[0].Head; len(d.Inherited[start]) > 0 {
+		return nil, fmt.Errorf("start symbol %s cannot have inherited attributes", start)
+	}
+	declared := func(sym, attr string) bool {
+		return attr == "lexeme" || contains(d.Synthesized[sym], attr) || contains(d.Inherited[sym], attr)
+	}
+	actions, err := Scheme{d.Grammar, d.Funcs}.actions()
+	if err != nil {
+		return nil, err
+	}
+	all := compiled{}
+	for _, nt := range d.Grammar {
+		all[nt.Head] = map[production][]*rule{}
+		for _, prod := range nt.Productions {
+			errorf := func(format string, args ...interface{}) error {
+				return fmt.Errorf("%s → %s: %s", nt.Head, prod, fmt.Sprintf(format, args...))
+			}
+			body := prod.body()
+			locate := func(symbol string) (int, string, error) {
+				i, err := prod.locate(nt.Head, symbol)
+				if err != nil {
+					return 0, "", errorf("%v", err)
+				}
+				if i == -1 {
+					return i, nt.Head, nil
+				}
+				if i >= len(body) {
+					return 0, "", errorf("no symbol %s in the production", symbol)
+				}
+				return i, body[i], nil
+			}
+			defined := map[string]bool{}
+			for _, a := range actions[nt.Head][prod] {
+				for _, stmt := range a.stmts {
+					r := &rule{target: -1, src: stmt.src, refs: map[*attrref]int{}, text: optext(stmt.src)}
+					if dst := stmt.dst; dst != nil {
+						i, sym, err := locate(dst.symbol)
+						if err != nil {
+							return nil, err
+						}
+						if i == -1 && !contains(d.Synthesized[sym], dst.attr) {
+							return nil, errorf("%s.%s is not a synthesized attribute of %s", dst.symbol, dst.attr, sym)
+						}
+						if i >= 0 && !contains(d.Inherited[sym], dst.attr) {
+							return nil, errorf("%s.%s is not an inherited attribute of %s", dst.symbol, dst.attr, sym)
+						}
+						name := fmt.Sprintf("%d.%s", i, dst.attr)
+						if defined[name] {
+							return nil, errorf("%s.%s is defined twice", dst.symbol, dst.attr)
+						}
+						defined[name] = true
+						r.target, r.attr = i, dst.attr
+						r.text = optext(dst) + " = " + r.text
+					}
+					for _, ref := range refs(stmt.src) {
+						i, sym, err := locate(ref.symbol)
+						if err != nil {
+							return nil, err
+						}
+						if !declared(sym, ref.attr) {
+							return nil, errorf("%s has no attribute %s", sym, ref.attr)
+						}
+						r.refs[ref] = i
+					}
+					all[nt.Head][prod] = append(all[nt.Head][prod], r)
+				}
+			}
+			for _, attr := range d.Synthesized[nt.Head] {
+				if !defined[fmt.Sprintf("%d.%s", -1, attr)] {
+					return nil, errorf("no rule defines %s.%s", nt.Head, attr)
+				}
+			}
+			for i, sym := range body {
+				for _, attr := range d.Inherited[sym] {
+					if !defined[fmt.Sprintf("%d.%s", i, attr)] {
+						return nil, errorf("no rule defines %s.%s", occurrence(nt.Head, body, i), attr)
+					}
+				}
+			}
+		}
+	}
+	return all, nil
+}
+
+// SAttributed returns nil if every attribute of d is synthesized (Section
+// 5.2.3), or else an error naming one that is not.
+func (d SDD) SAttributed() error {
+	if _, err := d.rules(); err != nil {
+		return err
+	}
+	for _, nt := range d.Grammar {
+		if attrs := d.Inherited[nt.Head]; len(attrs) > 0 {
+			return fmt.Errorf("%s.%s is inherited", nt.Head, attrs[0])
+		}
+	}
+	return nil
+}
+
+// LAttributed returns nil if d is L-attributed (Section 5.2.4): if the rule
+// for each inherited attribute of a symbol X of a body uses only inherited
+// attributes of the head, attributes of the symbols to the left of X and
+// other inherited attributes of X. Otherwise it returns an error naming a
+// rule that is not.
+func (d SDD) LAttributed() error {
+	all, err := d.rules()
+	if err != nil {
+		return err
+	}
+	for _, nt := range d.Grammar {
+		for _, prod := range nt.Productions {
+			body := prod.body()
+			for _, r := range all[nt.Head][prod] {
+				if r.target == -1 {
+					continue
+				}
+				for _, ref := range refs(r.src) {
+					switch i := r.refs[ref]; {
+					case i == -1 && contains(d.Inherited[nt.Head], ref.attr):
+					case i >= 0 && i < r.target:
+					case i == r.target && contains(d.Inherited[body[i]], ref.attr):
+					default:
+						return fmt.Errorf("%s → %s: %s uses %s.%s, which is neither inherited from the head nor to the left of %s",
+							nt.Head, prod, r.text, ref.symbol, ref.attr, occurrence(nt.Head, body, r.target))
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// lexeme is the text that n derives.
+func lexeme(f *source.File, n *node) string {
+	if !n.span.IsValid() {
+		return ""
+	}
+	return f.Text(n.span)
+}
+
+// apply evaluates the rule r of the production at n, with the attribute
+// values found so far.
+func (d SDD) apply(w io.Writer, f *source.File, n *node, r *rule) error {
+	v, err := evaluate(r.src, d.Funcs, w, func(ref *attrref) (interface{}, error) {
+		m := n
+		if i := r.refs[ref]; i >= 0 {
+			m = &n.children[i]
+		}
+		if ref.attr == "lexeme" {
+			return lexeme(f, m), nil
+		}
+		v, ok := m.attrs[ref.attr]
+		if !ok {
+			return nil, fmt.Errorf("%s.%s is not yet defined", ref.symbol, ref.attr)
+		}
+		return v, nil
+	})
+	if err != nil {
+		if n.span.IsValid() {
+			return fmt.Errorf("%s: %s: %v", f.Position(n.span.Start), r.text, err)
+		}
+		return fmt.Errorf("%s: %v", r.text, err)
+	}
+	if r.attr != "" {
+		m := n
+		if r.target >= 0 {
+			m = &n.children[r.target]
+		}
+		if m.attrs == nil {
+			m.attrs = Attributes{}
+		}
+		m.attrs[r.attr] = v
+	}
+	return nil
+}
+
+// Graph is the dependency graph of the attribute instances of a parse tree
+// (Section 5.2.1), with an edge to each instance from those its rule uses.
+type Graph struct {
+	sdd      SDD
+	file     *source.File
+	tree     *node
+	vertices []*vertex
+}
+
+type vertex struct {
+	id    int
+	n     *node // whose attribute it is
+	at    *node // of the production whose rule defines it
+	name  string
+	rule  *rule
+	deps  []*vertex
+	succs []*vertex
+}
+
+func (g *Graph) describe(v *vertex) string {
+	if v.n.span.IsValid() {
+		return fmt.Sprintf("%s at %s", v.name, g.file.Position(v.n.span.Start))
+	}
+	return v.name
+}
+
+// Graph parses f and constructs the dependency graph of its tree.
+func (d SDD) Graph(f *source.File) (*Graph, error) {
+	all, err := d.rules()
+	if err != nil {
+		return nil, err
+	}
+	tree, err := d.Grammar.ParseFile(f)
+	if err != nil {
+		return nil, err
+	}
+	g := &Graph{sdd: d, file: f, tree: tree}
+	type instance struct {
+		n    *node
+		attr string
+	}
+	defines := map[instance]*vertex{}
+	var nodes []*node
+	var walk func(n *node)
+	walk = func(n *node) {
+		n.attrs = Attributes{"lexeme": lexeme(f, n)}
+		if n.head == "" {
+			return
+		}
+		nodes = append(nodes, n)
+		body := n.prod.body()
+		for _, r := range all[n.head][n.prod] {
+			v := &vertex{id: len(g.vertices) + 1, n: n, at: n, rule: r}
+			if r.target >= 0 {
+				v.n = &n.children[r.target]
+				v.name = fmt.Sprintf("%s.%s", body[r.target], r.attr)
+			} else if r.attr != "" {
+				v.name = fmt.Sprintf("%s.%s", n.head, r.attr)
+			} else {
+				v.name = r.text
+			}
+			if r.attr != "" {
+				defines[instance{v.n, r.attr}] = v
+			}
+			g.vertices = append(g.vertices, v)
+		}
+		for i := range n.children {
+			walk(&n.children[i])
+		}
+	}
+	walk(tree)
+	for _, v := range g.vertices {
+		for _, ref := range refs(v.rule.src) {
+			if ref.attr == "lexeme" {
+				continue
+			}
+			m, i := v.at, v.rule.refs[ref]
+			if i >= 0 {
+				m = &v.at.children[i]
+			}
+			u := defines[instance{m, ref.attr}]
+			if u == nil {
+				return nil, fmt.Errorf("no rule defines %s.%s", ref.symbol, ref.attr)
+			}
+			v.deps = append(v.deps, u)
+			u.succs = append(u.succs, v)
+		}
+	}
+	return g, nil
+}
+
+// Order lists the attribute instances in an order in which their rules may
+// be applied, a topological sort of the graph, or returns an error describing
+// a cycle if there is none.
+func (g *Graph) Order() ([]string, error) {
+	order, err := g.order()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(order))
+	for i, v := range order {
+		names[i] = g.describe(v)
+	}
+	return names, nil
+}
+
+func (g *Graph) order() ([]*vertex, error) {
+	indegree := map[*vertex]int{}
+	var ready []*vertex
+	for _, v := range g.vertices {
+		indegree[v] = len(v.deps)
+		if len(v.deps) == 0 {
+			ready = append(ready, v)
+		}
+	}
+	order := []*vertex{}
+	for len(ready) > 0 {
+		v := ready[0]
+		ready = ready[1:]
+		order = append(order, v)
+		for _, u := range v.succs {
+			if indegree[u]--; indegree[u] == 0 {
+				ready = append(ready, u)
+			}
+		}
+	}
+	if len(order) == len(g.vertices) {
+		return order, nil
+	}
+	// every vertex left has a dependency left, so following them must cycle
+	var v *vertex
+	for _, u := range g.vertices {
+		if indegree[u] > 0 {
+			v = u
+			break
+		}
+	}
+	seen := map[*vertex]int{}
+	path := []*vertex{}
+	for seen[v] == 0 {
+		path = append(path, v)
+		seen[v] = len(path)
+		for _, u := range v.deps {
+			if indegree[u] > 0 {
+				v = u
+				break
+			}
+		}
+	}
+	cycle := []string{}
+	for _, u := range path[seen[v]-1:] {
+		cycle = append(cycle, g.describe(u))
+	}
+	cycle = append(cycle, g.describe(v))
+	return nil, fmt.Errorf("circular dependency: %s", strings.Join(cycle, " ← "))
+}
+
+// Evaluate applies the rules of the tree in a topological order, writing any
+// output of print, and returns the attributes of the root.
+func (g *Graph) Evaluate(w io.Writer) (Attributes, error) {
+	order, err := g.order()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range order {
+		if err := g.sdd.apply(w, g.file, v.at, v.rule); err != nil {
+			return nil, err
+		}
+	}
+	return g.tree.attrs, nil
+}
+
+// String lists the vertices of the graph, each with those it depends on.
+func (g *Graph) String() string {
+	var b strings.Builder
+	for _, v := range g.vertices {
+		fmt.Fprintf(&b, "%d\t%s", v.id, g.describe(v))
+		if len(v.deps) > 0 {
+			ids := make([]string, len(v.deps))
+			for i, u := range v.deps {
+				ids[i] = fmt.Sprint(u.id)
+			}
+			fmt.Fprintf(&b, " ← %s", strings.Join(ids, ", "))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// Evaluate parses f and evaluates its attributes by the dependency graph of
+// the tree, returning the attributes of the root.
+func (d SDD) Evaluate(w io.Writer, f *source.File) (Attributes, error) {
+	g, err := d.Graph(f)
+	if err != nil {
+		return nil, err
+	}
+	return g.Evaluate(w)
+}
+
+// EvaluateLL evaluates an L-attributed SDD while parsing f by a predictive
+// parser (Section 5.5): the inherited attributes of each symbol are computed
+// before the symbol is parsed, and the synthesized attributes of the head
+// once its body has been. The grammar must be LL(1).
+func (d SDD) EvaluateLL(w io.Writer, f *source.File) (Attributes, error) {
+	if err := d.LAttributed(); err != nil {
+		return nil, err
+	}
+	all, _ := d.rules()
+	tree, err := d.Grammar.llparse(f, func(n *node, i int) error {
+		if n.attrs == nil {
+			n.attrs = Attributes{}
+		}
+		if i == -1 {
+			n.attrs["lexeme"] = lexeme(f, n)
+		}
+		var pending []*rule
+		for _, r := range all[n.head][n.prod] {
+			if r.target == i {
+				pending = append(pending, r)
+			}
+		}
+		// the inherited attributes of a symbol may use one another
+		for len(pending) > 0 {
+			var left []*rule
+			for _, r := range pending {
+				if d.ready(n, r) {
+					if err := d.apply(w, f, n, r); err != nil {
+						return err
+					}
+				} else {
+					left = append(left, r)
+				}
+			}
+			if len(left) == len(pending) {
+				texts := make([]string, len(left))
+				for j, r := range left {
+					texts[j] = r.text
+				}
+				return fmt.Errorf("%s → %s: circular dependency among %s", n.head, n.prod, strings.Join(texts, "; "))
+			}
+			pending = left
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tree.attrs, nil
+}
+
+// ready reports whether the attributes that r uses at n are all defined.
+func (d SDD) ready(n *node, r *rule) bool {
+	for ref, i := range r.refs {
+		if ref.attr == "lexeme" {
+			continue
+		}
+		m := n
+		if i >= 0 {
+			m = &n.children[i]
+		}
+		if _, ok := m.attrs[ref.attr]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,168 @@
+package grammar
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+var arith = map[string]Func{
+	"int": func(args ...interface{}) (interface{}, error) {
+		return strconv.Atoi(args[0].(string))
+	},
+	"mul": func(args ...interface{}) (interface{}, error) {
+		return args[0].(int) * args[1].(int), nil
+	},
+	"add": func(args ...interface{}) (interface{}, error) {
+		return args[0].(int) + args[1].(int), nil
+	},
+}
+
+// fig54 is the L-attributed SDD of Fig. 5.4, for products of digits.
+var fig54 = SDD{
+	Grammar: Grammar{
+		Nonterminal{"T", []production{"F R { R.inh = F.val; T.val = R.syn }"}},
+		Nonterminal{"R", []production{
+			"* F R { R1.inh = mul(R.inh, F.val); R.syn = R1.syn }",
+			"ε { R.syn = R.inh }",
+		}},
+		Nonterminal{"F", []production{"/[0-9]/ { F.val = int(F.lexeme) }"}},
+	},
+	Synthesized: map[string][]string{"T": {"val"}, "R": {"syn"}, "F": {"val"}},
+	Inherited:   map[string][]string{"R": {"inh"}},
+	Funcs:       arith,
+}
+
+// tac generates three-address code for sums, as in Fig. 6.19 but with the
+// left recursion eliminated.
+func tac() SDD {
+	temps := 0
+	cat := func(args ...interface{}) (interface{}, error) {
+		return fmt.Sprint(args...), nil
+	}
+	return SDD{
+		Grammar: Grammar{
+			Nonterminal{"S", []production{"id = E { S.code = cat(E.code, gen(id.lexeme, ' = ', E.addr)) }"}},
+			Nonterminal{"E", []production{"T R { R.inh = T.addr; R.icode = T.code; E.addr = R.addr; E.code = R.code }"}},
+			Nonterminal{"R", []production{
+				"+ T R { R1.inh = newtemp(); R1.icode = cat(R.icode, T.code, gen(R1.inh, ' = ', R.inh, ' + ', T.addr)); R.addr = R1.addr; R.code = R1.code }",
+				"ε { R.addr = R.inh; R.code = R.icode }",
+			}},
+			Nonterminal{"T", []production{"id { T.addr = id.lexeme; T.code = '' }"}},
+			Nonterminal{"id", []production{"/[a-z]/"}},
+		},
+		Synthesized: map[string][]string{"S": {"code"}, "E": {"addr", "code"}, "R": {"addr", "code"}, "T": {"addr", "code"}},
+		Inherited:   map[string][]string{"R": {"inh", "icode"}},
+		Funcs: map[string]Func{
+			"newtemp": func(args ...interface{}) (interface{}, error) {
+				temps++
+				return fmt.Sprintf("t%d", temps), nil
+			},
+			"gen": func(args ...interface{}) (interface{}, error) {
+				return fmt.Sprint(args...) + "\n", nil
+			},
+			"cat": cat,
+		},
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	evaluators := map[string]func(SDD, io.Writer, *source.File) (Attributes, error){
+		"graph": SDD.Evaluate,
+		"LL":    SDD.EvaluateLL,
+	}
+	for name, evaluate := range evaluators {
+		attrs, err := evaluate(fig54, io.Discard, source.NewFile("", "3 * 5 * 2"))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if attrs["val"] != 30 || attrs["lexeme"] != "3 * 5 * 2" {
+			t.Errorf("%s: got %v", name, attrs)
+		}
+		attrs, err = evaluate(tac(), io.Discard, source.NewFile("", "a = b + c + d"))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if want := "t1 = b + c\nt2 = t1 + d\na = t2\n"; attrs["code"] != want {
+			t.Errorf("%s: got code\n%vwant\n%s", name, attrs["code"], want)
+		}
+	}
+	if err := fig54.LAttributed(); err != nil {
+		t.Error(err)
+	}
+	if err := fig54.SAttributed(); err == nil || err.Error() != "R.inh is inherited" {
+		t.Errorf("fig 5.4 S-attributed: %v", err)
+	}
+	if _, err := fig54.EvaluateLL(io.Discard, source.NewFile("", "3 *")); err == nil || !strings.Contains(err.Error(), "F cannot begin with end of input") {
+		t.Errorf("syntax error reported as %v", err)
+	}
+}
+
+func TestGraph(t *testing.T) {
+	g, err := fig54.Graph(source.NewFile("", "3*5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `1	R.inh at 1:2 ← 3
+2	T.val at 1:1 ← 5
+3	F.val at 1:1
+4	R.inh ← 1, 6
+5	R.syn at 1:2 ← 7
+6	F.val at 1:3
+7	R.syn ← 4
+`
+	if got := g.String(); got != want {
+		t.Errorf("got\n%swant\n%s", got, want)
+	}
+	order, err := g.Order()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(order, "; "); got != "F.val at 1:1; F.val at 1:3; R.inh at 1:2; R.inh; R.syn; R.syn at 1:2; T.val at 1:1" {
+		t.Errorf("order %s", got)
+	}
+
+	cyclic := SDD{
+		Grammar: Grammar{
+			Nonterminal{"S", []production{"A { S.s = A.s; A.i = A.s }"}},
+			Nonterminal{"A", []production{"/x/ { A.s = A.i }"}},
+		},
+		Synthesized: map[string][]string{"S": {"s"}, "A": {"s"}},
+		Inherited:   map[string][]string{"A": {"i"}},
+	}
+	if err := cyclic.LAttributed(); err == nil || !strings.Contains(err.Error(), "A.i = A.s uses A.s") {
+		t.Errorf("cyclic L-attributed: %v", err)
+	}
+	_, err = cyclic.Evaluate(io.Discard, source.NewFile("", "x"))
+	if want := "circular dependency: A.s at 1:1 ← A.i at 1:1 ← A.s at 1:1"; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %s", err, want)
+	}
+}
+
+func TestRules(t *testing.T) {
+	for _, tc := range []struct {
+		rules       string
+		synthesized []string
+		err         string
+	}{
+		{"F.val = int(F.lexeme)", []string{"val"}, ""},
+		{"F.val = int(F.lexeme)", nil, "F.val is not a synthesized attribute of F"},
+		{"F.val = int(F.size)", []string{"val"}, "F has no attribute size"},
+		{"F.val = '1'; F.val = '2'", []string{"val"}, "F.val is defined twice"},
+		{"print(F.lexeme)", []string{"val"}, "no rule defines F.val"},
+	} {
+		d := SDD{
+			Grammar:     Grammar{Nonterminal{"F", []production{production("/[0-9]/ { " + tc.rules + " }")}}},
+			Synthesized: map[string][]string{"F": tc.synthesized},
+			Funcs:       arith,
+		}
+		err := d.SAttributed()
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || !strings.HasSuffix(err.Error(), tc.err)) {
+			t.Errorf("%s: got error %v, want %q", tc.rules, err, tc.err)
+		}
+	}
+}
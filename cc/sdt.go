@@ -0,0 +1,518 @@
+package grammar
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+// An action is a fragment of program embedded in the body of a production, as
+// in the translation schemes of Section 2.3.5:
+//
+//	rest → + term { print('+') } rest
+//
+// It is a sequence of statements separated by semicolons, each a call or an
+// assignment to an attribute:
+//
+//	action  → { stmt ; ... }
+//	stmt    → call | ref = operand
+//	operand → call | ref | 'literal' | "literal"
+//	call    → name ( operand , ... )
+//	ref     → symbol . attribute
+//
+// A ref names the head of the production by its symbol, and a symbol of the
+// body by its own, or by the symbol followed by k for its kth occurrence where
+// the symbol recurs, as in E → E1 + T.
+type action struct {
+	text  string
+	stmts []actionstmt
+}
+
+type actionstmt struct {
+	dst *attrref // nil for a call
+	src operand
+}
+
+// operand is a literal string, an *attrref or a *call.
+type operand interface{}
+
+type attrref struct {
+	symbol, attr string
+}
+
+type call struct {
+	name string
+	args []operand
+}
+
+func (a *action) String() string { return a.text }
+
+// isaction reports whether the { at the start of s begins an action rather than
+// being a terminal: whether it is followed by a name and then ( or ..
+func isaction(s string) bool {
+	s = strings.TrimLeftFunc(s[1:], unicode.IsSpace)
+	i := 0
+	for i < len(s) && (s[i] == '_' || unicode.IsLetter(rune(s[i])) || unicode.IsDigit(rune(s[i]))) {
+		i++
+	}
+	if i == 0 {
+		return false
+	}
+	s = strings.TrimLeftFunc(s[i:], unicode.IsSpace)
+	return len(s) > 0 && (s[0] == '(' || s[0] == '.')
+}
+
+// piece is a run of the body of a production that is either symbols or the
+// text of an action.
+type piece struct {
+	text   string
+	action bool
+}
+
+// pieces separates the body of prod into its symbols and its actions.
+func (prod production) pieces() []piece {
+	s := string(prod)
+	var pieces []piece
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] != '{' || (i > 0 && !unicode.IsSpace(rune(s[i-1]))) || !isaction(s[i:]) {
+			continue
+		}
+		// the action ends at the first } outside quotes
+		j, quote := i+1, byte(0)
+		for ; j < len(s); j++ {
+			if quote != 0 {
+				if s[j] == quote {
+					quote = 0
+				}
+			} else if s[j] == '\'' || s[j] == '"' {
+				quote = s[j]
+			} else if s[j] == '}' {
+				break
+			}
+		}
+		if j == len(s) {
+			j-- // unterminated, reported by parseaction
+		}
+		if start < i {
+			pieces = append(pieces, piece{s[start:i], false})
+		}
+		pieces = append(pieces, piece{s[i : j+1], true})
+		start, i = j+1, j
+	}
+	if start < len(s) {
+		pieces = append(pieces, piece{s[start:], false})
+	}
+	return pieces
+}
+
+// stripped is the body of prod without its actions.
+func (prod production) stripped() production {
+	symbols := []string{}
+	for _, p := range prod.pieces() {
+		if !p.action {
+			symbols = append(symbols, strings.Fields(p.text)...)
+		}
+	}
+	return production(strings.Join(symbols, " "))
+}
+
+// actionlexer reads the tokens of an action: names, literals and punctuation.
+type actionlexer struct {
+	s   string
+	pos int
+}
+
+func (l *actionlexer) next() (string, error) {
+	for l.pos < len(l.s) && unicode.IsSpace(rune(l.s[l.pos])) {
+		l.pos++
+	}
+	if l.pos == len(l.s) {
+		return "", nil
+	}
+	start := l.pos
+	switch c := l.s[l.pos]; {
+	case c == '\'' || c == '"':
+		end := strings.IndexByte(l.s[l.pos+1:], c)
+		if end == -1 {
+			return "", fmt.Errorf("unterminated literal %s", l.s[start:])
+		}
+		l.pos += end + 2
+	case c == '_' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)):
+		for l.pos < len(l.s) && (l.s[l.pos] == '_' || unicode.IsLetter(rune(l.s[l.pos])) || unicode.IsDigit(rune(l.s[l.pos]))) {
+			l.pos++
+		}
+	default:
+		l.pos++
+	}
+	return l.s[start:l.pos], nil
+}
+
+// parseaction parses the text of an action, braces included.
+func parseaction(text string) (*action, error) {
+	if !strings.HasSuffix(text, "}") {
+		return nil, fmt.Errorf("unterminated action %s", text)
+	}
+	l := &actionlexer{s: text[1 : len(text)-1]}
+	var tokens []string
+	for {
+		tk, err := l.next()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", text, err)
+		}
+		if tk == "" {
+			break
+		}
+		tokens = append(tokens, tk)
+	}
+	p := &actionparser{tokens: tokens}
+	a := &action{text: text}
+	for p.pos < len(p.tokens) {
+		stmt, err := p.stmt()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", text, err)
+		}
+		a.stmts = append(a.stmts, stmt)
+		if p.peek() == ";" {
+			p.pos++
+		} else if p.pos < len(p.tokens) {
+			return nil, fmt.Errorf("%s: expected ';', found %q", text, p.peek())
+		}
+	}
+	return a, nil
+}
+
+type actionparser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *actionparser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *actionparser) expect(tk string) error {
+	if p.peek() != tk {
+		return fmt.Errorf("expected %q, found %q", tk, p.peek())
+	}
+	p.pos++
+	return nil
+}
+
+func (p *actionparser) stmt() (actionstmt, error) {
+	src, err := p.operand()
+	if err != nil {
+		return actionstmt{}, err
+	}
+	switch src := src.(type) {
+	case *call:
+		return actionstmt{src: src}, nil
+	case *attrref:
+		if err := p.expect("="); err != nil {
+			return actionstmt{}, err
+		}
+		val, err := p.operand()
+		if err != nil {
+			return actionstmt{}, err
+		}
+		return actionstmt{dst: src, src: val}, nil
+	}
+	return actionstmt{}, fmt.Errorf("literal %s is not a statement", src)
+}
+
+func (p *actionparser) operand() (operand, error) {
+	tk := p.peek()
+	switch {
+	case tk == "":
+		return nil, fmt.Errorf("unexpected end of action")
+	case tk[0] == '\'' || tk[0] == '"':
+		p.pos++
+		return tk[1 : len(tk)-1], nil
+	case tk[0] != '_' && !unicode.IsLetter(rune(tk[0])) && !unicode.IsDigit(rune(tk[0])):
+		return nil, fmt.Errorf("unexpected %q", tk)
+	}
+	p.pos++
+	switch p.peek() {
+	case ".":
+		p.pos++
+		attr := p.peek()
+		if attr == "" || !(attr[0] == '_' || unicode.IsLetter(rune(attr[0]))) {
+			return nil, fmt.Errorf("expected attribute of %s, found %q", tk, attr)
+		}
+		p.pos++
+		return &attrref{tk, attr}, nil
+	case "(":
+		p.pos++
+		c := &call{name: tk}
+		for p.peek() != ")" {
+			if len(c.args) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			arg, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			c.args = append(c.args, arg)
+		}
+		p.pos++
+		return c, nil
+	}
+	return nil, fmt.Errorf("expected '.' or '(' after %s, found %q", tk, p.peek())
+}
+
+// Func is a function that actions may call, registered in a Scheme by name.
+type Func func(args ...interface{}) (interface{}, error)
+
+// Attributes are the values of the attributes of a node of a parse tree.
+type Attributes map[string]interface{}
+
+// Scheme is a syntax-directed translation scheme: a Grammar whose productions
+// embed actions, and the functions those actions may call besides the builtin
+// print, which writes its arguments.
+type Scheme struct {
+	Grammar Grammar
+	Funcs   map[string]Func
+}
+
+// placed is an action of a production, to be executed before its child at.
+type placed struct {
+	at int
+	*action
+}
+
+// actions parses the actions of each production of the scheme.
+func (s Scheme) actions() (map[string]map[production][]placed, error) {
+	all := map[string]map[production][]placed{}
+	for _, nt := range s.Grammar {
+		all[nt.Head] = map[production][]placed{}
+		for _, prod := range nt.Productions {
+			at := 0
+			for _, p := range prod.pieces() {
+				if !p.action {
+					if body := strings.TrimSpace(p.text); body != "ε" {
+						at += len(production(body).symbolsconcat(true))
+					}
+					continue
+				}
+				a, err := parseaction(p.text)
+				if err == nil {
+					err = s.check(a)
+				}
+				if err != nil {
+					return nil, fmt.Errorf("%s → %s: %v", nt.Head, prod, err)
+				}
+				all[nt.Head][prod] = append(all[nt.Head][prod], placed{at, a})
+			}
+		}
+	}
+	return all, nil
+}
+
+// check ensures that every function that a calls is defined.
+func (s Scheme) check(a *action) error {
+	var visit func(o operand) error
+	visit = func(o operand) error {
+		c, ok := o.(*call)
+		if !ok {
+			return nil
+		}
+		if _, ok := s.Funcs[c.name]; !ok && c.name != "print" {
+			return fmt.Errorf("undefined function %s", c.name)
+		}
+		for _, arg := range c.args {
+			if err := visit(arg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, stmt := range a.stmts {
+		if err := visit(stmt.src); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// translator executes the actions of a parse tree.
+type translator struct {
+	Scheme
+	actions map[string]map[production][]placed
+	file    *source.File
+	w       io.Writer
+}
+
+// Translate parses f by the grammar of the scheme and executes the actions of
+// the parse tree in a depth-first traversal from left to right, which is the
+// order in which a top-down parser would meet them (Section 5.4.1). Each
+// action sees the attributes set by those before it, so that inherited
+// attributes are set before the child they belong to is visited and
+// synthesized ones after. Every node has the attribute lexeme, the text that
+// it derives. Translate returns the attributes of the root.
+func (s Scheme) Translate(w io.Writer, f *source.File) (Attributes, error) {
+	actions, err := s.actions()
+	if err != nil {
+		return nil, err
+	}
+	tree, err := s.Grammar.ParseFile(f)
+	if err != nil {
+		return nil, err
+	}
+	t := &translator{s, actions, f, w}
+	if err := t.visit(tree); err != nil {
+		return nil, err
+	}
+	return tree.attrs, nil
+}
+
+func (t *translator) visit(n *node) error {
+	lexeme := ""
+	if n.span.IsValid() {
+		lexeme = t.file.Text(n.span)
+	}
+	if n.attrs == nil {
+		n.attrs = Attributes{}
+	}
+	n.attrs["lexeme"] = lexeme
+	if n.head == "" {
+		return nil // a terminal
+	}
+	acts := t.actions[n.head][n.prod]
+	for i := 0; i <= len(n.children); i++ {
+		for _, a := range acts {
+			if a.at != i {
+				continue
+			}
+			if err := t.exec(n, a.action); err != nil {
+				if n.span.IsValid() {
+					return fmt.Errorf("%s: %s → %s: %v", t.file.Position(n.span.Start), n.head, n.prod, err)
+				}
+				return fmt.Errorf("%s → %s: %v", n.head, n.prod, err)
+			}
+		}
+		if i < len(n.children) {
+			if err := t.visit(&n.children[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// locate finds the symbol of the production head → prod to which a ref
+// names symbol refers: -1 for the head, or its index in the body.
+func (prod production) locate(head, symbol string) (int, error) {
+	if symbol == head {
+		return -1, nil
+	}
+	body := prod.stripped().symbolsconcat(true)
+	var found []int
+	for i, sym := range body {
+		if sym.string == symbol {
+			found = append(found, i)
+		}
+	}
+	if len(found) > 1 {
+		return 0, fmt.Errorf("%s occurs %d times in the body", symbol, len(found))
+	}
+	if len(found) == 1 {
+		return found[0], nil
+	}
+	// the kth occurrence of a recurring symbol
+	stem := strings.TrimRightFunc(symbol, unicode.IsDigit)
+	var k int
+	if _, err := fmt.Sscan(symbol[len(stem):], &k); err == nil && stem != "" {
+		for i, sym := range body {
+			if sym.string == stem {
+				if k--; k == 0 {
+					return i, nil
+				}
+			}
+		}
+	}
+	return 0, fmt.Errorf("no symbol %s in the production", symbol)
+}
+
+// resolve finds the node of the production at n to which ref refers, creating
+// the attributes of a child not yet visited so that they may be inherited.
+func (t *translator) resolve(n *node, ref *attrref) (*node, error) {
+	i, err := n.prod.locate(n.head, ref.symbol)
+	if err != nil {
+		return nil, err
+	}
+	if i == -1 {
+		return n, nil
+	}
+	if i >= len(n.children) {
+		return nil, fmt.Errorf("no node for %s", ref.symbol)
+	}
+	child := &n.children[i]
+	if child.attrs == nil {
+		child.attrs = Attributes{}
+	}
+	return child, nil
+}
+
+// evaluate computes o, finding the values of attributes by lookup.
+func evaluate(o operand, funcs map[string]Func, w io.Writer, lookup func(ref *attrref) (interface{}, error)) (interface{}, error) {
+	switch o := o.(type) {
+	case string:
+		return o, nil
+	case *attrref:
+		return lookup(o)
+	case *call:
+		args := make([]interface{}, len(o.args))
+		for i, arg := range o.args {
+			v, err := evaluate(arg, funcs, w, lookup)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = v
+		}
+		if o.name == "print" {
+			_, err := fmt.Fprint(w, args...)
+			return nil, err
+		}
+		return funcs[o.name](args...)
+	}
+	panic(fmt.Sprintf("unknown operand %T", o))
+}
+
+func (t *translator) eval(n *node, o operand) (interface{}, error) {
+	return evaluate(o, t.Funcs, t.w, func(ref *attrref) (interface{}, error) {
+		m, err := t.resolve(n, ref)
+		if err != nil {
+			return nil, err
+		}
+		v, ok := m.attrs[ref.attr]
+		if !ok {
+			return nil, fmt.Errorf("%s.%s is not yet defined", ref.symbol, ref.attr)
+		}
+		return v, nil
+	})
+}
+
+func (t *translator) exec(n *node, a *action) error {
+	for _, stmt := range a.stmts {
+		v, err := t.eval(n, stmt.src)
+		if err != nil {
+			return err
+		}
+		if stmt.dst != nil {
+			m, err := t.resolve(n, stmt.dst)
+			if err != nil {
+				return err
+			}
+			m.attrs[stmt.dst.attr] = v
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package grammar
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+// fig215 is the translation scheme of Fig. 2.15 with its left recursion
+// eliminated as in Section 2.4.5.
+func fig215() Grammar {
+	digits := []production{}
+	for i := 0; i < 10; i++ {
+		digits = append(digits, production(fmt.Sprintf("%d { print('%d') }", i, i)))
+	}
+	return Grammar{
+		Nonterminal{"expr", []production{"term rest"}},
+		Nonterminal{"rest", []production{
+			"+ term { print('+') } rest",
+			"- term { print('-') } rest",
+			"ε",
+		}},
+		Nonterminal{"term", digits},
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	var b strings.Builder
+	if _, err := (Scheme{Grammar: fig215()}).Translate(&b, source.NewFile("", "9-5+2")); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != "95-2+" {
+		t.Errorf("got %q, want %q", got, "95-2+")
+	}
+
+	G, err := Grammar{
+		Nonterminal{"expr", []production{
+			"expr + term { expr.val = add(expr1.val, term.val) }",
+			"term { expr.val = term.val }",
+		}},
+		Nonterminal{"term", []production{"/[0-9]/"}},
+	}.AntiLeftRecurse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := G[1].Productions[0]; got != "+ term { expr.val = add(expr1.val, term.val) } R" {
+		t.Errorf("left recursion eliminated as %q", got)
+	}
+}
+
+// TestAttributes evaluates sums by the L-attributed scheme of Example 5.19,
+// passing the value so far down the tree as rest.inh and the total back up as
+// rest.syn.
+func TestAttributes(t *testing.T) {
+	s := Scheme{
+		Grammar: Grammar{
+			Nonterminal{"expr", []production{"term { rest.inh = term.val } rest { expr.val = rest.syn }"}},
+			Nonterminal{"rest", []production{
+				"+ term { rest1.inh = add(rest.inh, term.val) } rest { rest.syn = rest1.syn }",
+				"ε { rest.syn = rest.inh }",
+			}},
+			Nonterminal{"term", []production{"digit { term.val = int(digit.lexeme) }"}},
+			Nonterminal{"digit", []production{"/[0-9]/"}},
+		},
+		Funcs: map[string]Func{
+			"int": func(args ...interface{}) (interface{}, error) {
+				return strconv.Atoi(args[0].(string))
+			},
+			"add": func(args ...interface{}) (interface{}, error) {
+				return args[0].(int) + args[1].(int), nil
+			},
+		},
+	}
+	attrs, err := s.Translate(&strings.Builder{}, source.NewFile("", "1 + 2 + 3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs["val"] != 6 || attrs["lexeme"] != "1 + 2 + 3" {
+		t.Errorf("got %v", attrs)
+	}
+
+	for _, tc := range []struct {
+		prod production
+		err  string
+	}{
+		{"digit { term.val = num(digit.lexeme) }", "undefined function num"},
+		{"digit { term.val = digit.lexeme", "unterminated action"},
+		{"digit { print(digit.lexeme) print('x') }", "expected ';'"},
+		{"digit { term.val = other.lexeme }", "no symbol other"},
+		{"digit { term.val = term.size }", "term.size is not yet defined"},
+	} {
+		s.Grammar[2].Productions[0] = tc.prod
+		_, err := s.Translate(&strings.Builder{}, source.NewFile("", "1"))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: got error %v, want %q", tc.prod, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,504 @@
+package grammar
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// GenerateOptions control the sentences that Generate derives.
+type GenerateOptions struct {
+	// Depth is the depth of the tree beyond which only the productions that
+	// end the derivation soonest are chosen; 10 if it is 0.
+	Depth int
+	// Weights are the relative weights of the productions of each head, in
+	// order, 1 for those missing.
+	Weights map[string][]float64
+	// Rand is the source of the choices, one seeded by 1 if it is nil.
+	Rand *rand.Rand
+}
+
+// Sentence is a string of terminals derived by a grammar, with the tree of
+// its derivation.
+type Sentence struct {
+	Text string
+	tree *node
+}
+
+func (s *Sentence) String() string { return s.Text }
+
+// Tree is the parse tree of the sentence, as the parsers build it.
+func (s *Sentence) Tree() *node { return s.tree }
+
+// sentencer derives sentences from a grammar.
+type sentencer struct {
+	G      Grammar
+	heads  map[string]Nonterminal
+	height map[string]int // of the shortest derivation from each Nonterminal
+	opts   GenerateOptions
+}
+
+// infinite is the height of a Nonterminal that derives no string of terminals.
+const infinite = int(^uint(0) >> 1)
+
+func (G Grammar) sentencer(opts GenerateOptions) (*sentencer, error) {
+	if err := G.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.Depth == 0 {
+		opts.Depth = 10
+	}
+	if opts.Rand == nil {
+		opts.Rand = rand.New(rand.NewSource(1))
+	}
+	s := &sentencer{G: G, heads: G.heads(), height: map[string]int{}, opts: opts}
+	for _, nt := range G {
+		s.height[nt.Head] = infinite
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, nt := range G {
+			for _, prod := range nt.Productions {
+				if h := s.prodheight(prod); h < s.height[nt.Head] {
+					s.height[nt.Head], changed = h, true
+				}
+			}
+		}
+	}
+	if s.height[G[0].Head] == infinite {
+		return nil, fmt.Errorf("%s derives no string of terminals", G[0].Head)
+	}
+	return s, nil
+}
+
+// prodheight is the height of the shortest derivation by prod.
+func (s *sentencer) prodheight(prod production) int {
+	h := 0
+	for _, sym := range prod.body() {
+		if _, ok := s.heads[sym]; !ok {
+			continue
+		}
+		if s.height[sym] == infinite {
+			return infinite
+		}
+		if s.height[sym] > h {
+			h = s.height[sym]
+		}
+	}
+	return h + 1
+}
+
+// shortest is the first production of head by which it derives a string of
+// terminals soonest.
+func (s *sentencer) shortest(head string) production {
+	for _, prod := range s.heads[head].Productions {
+		if s.prodheight(prod) == s.height[head] {
+			return prod
+		}
+	}
+	panic(fmt.Sprintf("%s derives no string of terminals", head))
+}
+
+// choose picks a production of head at depth d: one of those that end in
+// time, by weight, or else the shortest.
+func (s *sentencer) choose(head string, d int) production {
+	prods := s.heads[head].Productions
+	weights := s.opts.Weights[head]
+	var total float64
+	fits := make([]float64, len(prods))
+	for i, prod := range prods {
+		if h := s.prodheight(prod); h == infinite || d+h > s.opts.Depth {
+			continue
+		}
+		fits[i] = 1
+		if i < len(weights) {
+			fits[i] = weights[i]
+		}
+		total += fits[i]
+	}
+	if total > 0 {
+		r := s.opts.Rand.Float64() * total
+		for i, w := range fits {
+			if r < w {
+				return prods[i]
+			}
+			r -= w
+		}
+	}
+	return s.shortest(head)
+}
+
+// derive builds the tree of head by prod, expanding each Nonterminal of its
+// body by expand.
+func (s *sentencer) derive(head string, prod production, expand func(i int, sym string) (*node, error)) (*node, error) {
+	if len(prod.body()) == 0 {
+		return derived(head, prod, nil), nil
+	}
+	children := []node{}
+	for i, sym := range prod.body() {
+		if _, ok := s.heads[sym]; ok {
+			n, err := expand(i, sym)
+			if err != nil {
+				return nil, err
+			}
+			children = append(children, *n)
+			continue
+		}
+		text, err := s.terminal(sym)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, node{symbol: text})
+	}
+	return derived(head, prod, children), nil
+}
+
+// terminal is an instance of the terminal sym.
+func (s *sentencer) terminal(sym string) (string, error) {
+	if len(sym) < 3 || sym[0] != '/' || sym[len(sym)-1] != '/' {
+		return sym, nil
+	}
+	re, err := syntax.Parse(sym[1:len(sym)-1], syntax.Perl)
+	if err != nil {
+		return "", fmt.Errorf("terminal %s: %v", sym, err)
+	}
+	var b strings.Builder
+	if !s.instance(&b, re.Simplify()) {
+		return "", fmt.Errorf("terminal %s matches nothing", sym)
+	}
+	return b.String(), nil
+}
+
+// instance writes a random string that re matches, repeating at most three
+// times more than it must, reporting false if it matches none.
+func (s *sentencer) instance(b *strings.Builder, re *syntax.Regexp) bool {
+	r := s.opts.Rand
+	repeat := func(min, max int) bool {
+		if max < 0 {
+			max = min + 3
+		}
+		for n := min + r.Intn(max-min+1); n > 0; n-- {
+			if !s.instance(b, re.Sub[0]) {
+				return false
+			}
+		}
+		return true
+	}
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return false
+	case syntax.OpLiteral:
+		b.WriteString(string(re.Rune))
+	case syntax.OpCharClass:
+		n := 0
+		for i := 0; i < len(re.Rune); i += 2 {
+			n += int(re.Rune[i+1]-re.Rune[i]) + 1
+		}
+		if n == 0 {
+			return false
+		}
+		k := r.Intn(n)
+		for i := 0; i < len(re.Rune); i += 2 {
+			if m := int(re.Rune[i+1]-re.Rune[i]) + 1; k >= m {
+				k -= m
+				continue
+			}
+			c := re.Rune[i] + rune(k)
+			if !utf8.ValidRune(c) {
+				c = re.Rune[i]
+			}
+			b.WriteRune(c)
+			break
+		}
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		b.WriteRune(rune('a' + r.Intn(26)))
+	case syntax.OpCapture:
+		return s.instance(b, re.Sub[0])
+	case syntax.OpStar:
+		return repeat(0, 3)
+	case syntax.OpPlus:
+		return repeat(1, 4)
+	case syntax.OpQuest:
+		return repeat(0, 1)
+	case syntax.OpRepeat:
+		return repeat(re.Min, re.Max)
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if !s.instance(b, sub) {
+				return false
+			}
+		}
+	case syntax.OpAlternate:
+		return s.instance(b, re.Sub[r.Intn(len(re.Sub))])
+	}
+	return true
+}
+
+// glued reports, for each symbol of the body of prod, whether it is joined
+// to the one before it by ||, without space between them.
+func (prod production) glued() []bool {
+	glued := []bool{}
+	for _, sym := range prod.stripped().symbolsconcat(false) {
+		if sym.string == "ε" {
+			continue
+		}
+		for j := range strings.Split(sym.string, "||") {
+			glued = append(glued, j > 0)
+		}
+	}
+	return glued
+}
+
+// sentence is the sentence whose tree is n.
+func (s *sentencer) sentence(n *node) *Sentence {
+	var b strings.Builder
+	var leaves func(n *node, glued bool)
+	leaves = func(n *node, glued bool) {
+		if n.head == "" {
+			if b.Len() > 0 && !glued {
+				b.WriteByte(' ')
+			}
+			b.WriteString(n.symbol)
+			return
+		}
+		g := n.prod.glued()
+		for i := range n.children {
+			leaves(&n.children[i], (i == 0 && glued) || (i < len(g) && g[i]))
+		}
+	}
+	leaves(n, false)
+	return &Sentence{Text: b.String(), tree: n}
+}
+
+// Generate derives a random sentence from the start symbol of G, choosing
+// among the productions of each Nonterminal by their weights, and, beyond
+// the depth of opts, among those that end the derivation soonest. A terminal
+// that is a regular expression is replaced by a random string that it
+// matches. The terminals are separated by spaces, but for those joined by
+// ||.
+func (G Grammar) Generate(opts GenerateOptions) (*Sentence, error) {
+	s, err := G.sentencer(opts)
+	if err != nil {
+		return nil, err
+	}
+	var expand func(head string, d int) (*node, error)
+	expand = func(head string, d int) (*node, error) {
+		return s.derive(head, s.choose(head, d), func(_ int, sym string) (*node, error) {
+			return expand(sym, d+1)
+		})
+	}
+	n, err := expand(G[0].Head, 0)
+	if err != nil {
+		return nil, err
+	}
+	return s.sentence(n), nil
+}
+
+// Cover derives sentences from G until each of its productions has been used
+// at least once, as Purdom does: for each production not yet used, in order,
+// the sentence that reaches its head from the start symbol by the shortest
+// path, uses it, and then ends every derivation soonest. It reports the
+// productions that no sentence can use.
+func (G Grammar) Cover() ([]*Sentence, error) {
+	s, err := G.sentencer(GenerateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// the step by which each Nonterminal is first reached from the start,
+	// by the productions that end soonest first
+	type step struct {
+		head string
+		prod production
+		i    int
+	}
+	parent := map[string]step{}
+	reached := map[string]bool{G[0].Head: true}
+	for queue := []string{G[0].Head}; len(queue) > 0; queue = queue[1:] {
+		prods := append([]production{}, s.heads[queue[0]].Productions...)
+		sort.SliceStable(prods, func(i, j int) bool { return s.prodheight(prods[i]) < s.prodheight(prods[j]) })
+		for _, prod := range prods {
+			if s.prodheight(prod) == infinite {
+				continue
+			}
+			for i, sym := range prod.body() {
+				if _, ok := s.heads[sym]; ok && !reached[sym] {
+					reached[sym], parent[sym] = true, step{queue[0], prod, i}
+					queue = append(queue, sym)
+				}
+			}
+		}
+	}
+	used := map[string]map[production]bool{}
+	var mark func(n *node)
+	mark = func(n *node) {
+		if n.head == "" {
+			return
+		}
+		if used[n.head] == nil {
+			used[n.head] = map[production]bool{}
+		}
+		used[n.head][n.prod] = true
+		for i := range n.children {
+			mark(&n.children[i])
+		}
+	}
+	var shortest func(head string) (*node, error)
+	shortest = func(head string) (*node, error) {
+		return s.derive(head, s.shortest(head), func(_ int, sym string) (*node, error) {
+			return shortest(sym)
+		})
+	}
+	sentences := []*Sentence{}
+	for _, nt := range G {
+		for _, prod := range nt.Productions {
+			if used[nt.Head][prod] {
+				continue
+			}
+			if !reached[nt.Head] {
+				return nil, fmt.Errorf("%s → %s: %s is not reached from %s", nt.Head, prod, nt.Head, G[0].Head)
+			}
+			if s.prodheight(prod) == infinite {
+				return nil, fmt.Errorf("%s → %s derives no string of terminals", nt.Head, prod)
+			}
+			// the path from the start symbol to the head
+			path := []step{}
+			for head := nt.Head; head != G[0].Head; head = parent[head].head {
+				path = append([]step{parent[head]}, path...)
+			}
+			path = append(path, step{nt.Head, prod, -1})
+			var along func(k int) (*node, error)
+			along = func(k int) (*node, error) {
+				return s.derive(path[k].head, path[k].prod, func(i int, sym string) (*node, error) {
+					if i == path[k].i {
+						return along(k + 1)
+					}
+					return shortest(sym)
+				})
+			}
+			n, err := along(0)
+			if err != nil {
+				return nil, err
+			}
+			mark(n)
+			sentences = append(sentences, s.sentence(n))
+		}
+	}
+	return sentences, nil
+}
+
+// enumerator lists the sentences of a grammar by their number of tokens.
+type enumerator struct {
+	*sentencer
+	instances map[string]string            // of each terminal
+	lang      map[string][]map[string]bool // the sentences of each Nonterminal, by length
+}
+
+func (G Grammar) enumerator() (*enumerator, error) {
+	s, err := G.sentencer(GenerateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &enumerator{sentencer: s, instances: map[string]string{}, lang: map[string][]map[string]bool{}}, nil
+}
+
+// instance is the string that stands for the terminal sym, the same each
+// time.
+func (e *enumerator) instance(sym string) (string, error) {
+	if text, ok := e.instances[sym]; ok {
+		return text, nil
+	}
+	e.opts.Rand = rand.New(rand.NewSource(1))
+	text, err := e.terminal(sym)
+	if err != nil {
+		return "", err
+	}
+	e.instances[sym] = text
+	return text, nil
+}
+
+// layer adds the sentences of k tokens that each Nonterminal derives, those of
+// fewer having been added, and returns those of the start symbol, sorted.
+func (e *enumerator) layer(k int) ([]string, error) {
+	for _, nt := range e.G {
+		e.lang[nt.Head] = append(e.lang[nt.Head], map[string]bool{})
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, nt := range e.G {
+			for _, prod := range nt.Productions {
+				body, glued := prod.body(), prod.glued()
+				// concat derives the rest of the body from symbol i, in left
+				// tokens, after text
+				var concat func(i, left int, text string) error
+				concat = func(i, left int, text string) error {
+					if i == len(body) {
+						if left == 0 && !e.lang[nt.Head][k][text] {
+							e.lang[nt.Head][k][text], changed = true, true
+						}
+						return nil
+					}
+					join := func(s string) string {
+						switch {
+						case text == "":
+							return s
+						case s == "":
+							return text
+						case i < len(glued) && glued[i]:
+							return text + s
+						}
+						return text + " " + s
+					}
+					if _, ok := e.heads[body[i]]; !ok {
+						if left == 0 {
+							return nil
+						}
+						inst, err := e.instance(body[i])
+						if err != nil {
+							return err
+						}
+						return concat(i+1, left-1, join(inst))
+					}
+					for j := 0; j <= left; j++ {
+						for s := range e.lang[body[i]][j] {
+							if err := concat(i+1, left-j, join(s)); err != nil {
+								return err
+							}
+						}
+					}
+					return nil
+				}
+				if err := concat(0, k, ""); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	sentences := []string{}
+	for s := range e.lang[e.G[0].Head][k] {
+		sentences = append(sentences, s)
+	}
+	sort.Strings(sentences)
+	return sentences, nil
+}
+
+// Sentences are all the sentences of G of at most n tokens, the shorter
+// first. A terminal that is a regular expression stands for one string that
+// it matches, the same each time. There are exponentially many in n.
+func (G Grammar) Sentences(n int) ([]string, error) {
+	e, err := G.enumerator()
+	if err != nil {
+		return nil, err
+	}
+	all := []string{}
+	for k := 0; k <= n; k++ {
+		sentences, err := e.layer(k)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, sentences...)
+	}
+	return all, nil
+}
@@ -0,0 +1,303 @@
+package grammar
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+// fuzzcase is a grammar fuzzed, with whether it is ambiguous.
+type fuzzcase struct {
+	name      string
+	G         Grammar
+	ambiguous bool
+}
+
+// fuzzed are the grammars fuzzed.
+func fuzzed(t *testing.T) []fuzzcase {
+	return []fuzzcase{
+		{"prefix", mustgrammar(t, "S → + S S | - S S | a"), false},
+		{"nested", mustgrammar(t, nested), false},
+		{"fig54", fig54.Grammar, false},
+		{"fig215", fig215(), false},
+		{"dragon216", mustgrammar(t, `
+			stmt    → expr ; | if ( expr ) stmt | for ( optexpr ; optexpr ; optexpr ) stmt | other
+			optexpr → ε | expr
+			expr    → x
+		`), false},
+		{"leftrec", mustgrammar(t, "E → E - T | E + T | T\nT → T * F | F\nF → ( E ) | /[0-9]/"), false},
+		{"ambiguous", ambiguous, true},
+		{"balanced", mustgrammar(t, "S → ( S ) S | ε"), false},
+		{"concat", mustgrammar(t, "W → L||D | W , L||D\nL → /[a-z]/\nD → /[0-9]/"), false},
+		{"block", mustgrammar(t, "block → '{' S* '}'\nS → s | block"), false},
+	}
+}
+
+// recovered runs parse, reporting a panic as an error.
+func recovered(parse func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return parse()
+}
+
+// mutate deletes, swaps or appends a word of s, or cuts it short.
+func mutate(r *rand.Rand, s string) string {
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		return "@"
+	}
+	i, j := r.Intn(len(words)), r.Intn(len(words))
+	switch r.Intn(4) {
+	case 0:
+		words = append(words[:i], words[i+1:]...)
+	case 1:
+		words[i], words[j] = words[j], words[i]
+	case 2:
+		words = append(words, words[i])
+	default:
+		words = words[:i]
+	}
+	return strings.Join(words, " ")
+}
+
+// TestFuzz parses sentences generated from each grammar, and mutations of
+// them, by each parser: the general parsers must accept the sentences, those
+// that build trees must build those of their derivations, and none may panic.
+func TestFuzz(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, tc := range fuzzed(t) {
+		G, err := tc.G.Desugar()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sentences, err := G.Cover()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		for i := 0; i < 50; i++ {
+			s, err := G.Generate(GenerateOptions{Depth: 6, Rand: r})
+			if err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+			sentences = append(sentences, s)
+		}
+		cnf, err := G.ToCNF()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, llerr := G.ll1()
+
+		// the parsers of f, which is the sentence whose tree is want if it
+		// is not nil, and otherwise a mutation that may be rejected
+		parsers := func(f *source.File, want *node) map[string]func() error {
+			same := func(tree *node, err error) error {
+				if err != nil || want == nil || tc.ambiguous || tree.String() == want.String() {
+					return err
+				}
+				return fmt.Errorf("tree\n%s\nwant\n%s", tree, want)
+			}
+			return map[string]func() error{
+				"Earley": func() error {
+					F, err := G.Earley(f)
+					if err != nil {
+						return err
+					}
+					return same(F.Tree(Ordered))
+				},
+				"GLR": func() error {
+					F, err := G.GLR(f)
+					if err != nil {
+						return err
+					}
+					if count, err := F.Count(); err != nil || (!tc.ambiguous && count.Int64() != 1) {
+						return fmt.Errorf("%v parse trees (%v)", count, err)
+					}
+					return nil
+				},
+				"CYK": func() error {
+					table, err := cnf.CYK(f)
+					if err != nil {
+						return err
+					}
+					_ = table.String()
+					_, err = table.Tree()
+					return err
+				},
+				// these may reject a sentence, by their nature
+				"ParseFile": func() error {
+					if tree, err := G.ParseFile(f); err == nil {
+						return same(tree, nil)
+					}
+					return nil
+				},
+				"ParsePEGFile": func() error {
+					G.ParsePEGFile(f)
+					return nil
+				},
+				"llparse": func() error {
+					if llerr != nil {
+						return nil
+					}
+					return same(G.llparse(f, func(*node, int) error { return nil }))
+				},
+			}
+		}
+		for _, s := range sentences {
+			for name, parse := range parsers(source.NewFile("", s.Text), s.Tree()) {
+				if err := recovered(parse); err != nil {
+					t.Errorf("%s: %s on %q: %v", tc.name, name, s, err)
+				}
+			}
+			m := mutate(r, s.Text)
+			for name, parse := range parsers(source.NewFile("", m), nil) {
+				if err := recovered(parse); err != nil && strings.HasPrefix(err.Error(), "panic") {
+					t.Errorf("%s: %s on %q: %v", tc.name, name, m, err)
+				}
+			}
+		}
+	}
+}
+
+// height is the number of Nonterminals on the longest path down n.
+func height(n *node) int {
+	if n.head == "" {
+		return 0
+	}
+	h := 0
+	for i := range n.children {
+		if c := height(&n.children[i]); c > h {
+			h = c
+		}
+	}
+	return h + 1
+}
+
+func TestSentences(t *testing.T) {
+	prefix := mustgrammar(t, "S → + S S | - S S | a")
+	tests := []struct {
+		G       Grammar
+		opts    GenerateOptions
+		matches string
+	}{
+		{prefix, GenerateOptions{Depth: 3}, `^(a|[+-] (a|[+-] a a) (a|[+-] a a))$`},
+		{prefix, GenerateOptions{Weights: map[string][]float64{"S": {0, 0, 1}}}, `^a$`},
+		{prefix, GenerateOptions{Weights: map[string][]float64{"S": {1, 0}}}, `^[+ a]+$`},
+		{mustgrammar(t, "N → /-?[1-9][0-9]*/ | /0x[0-9a-f]{2,4}/"), GenerateOptions{}, `^(-?[1-9][0-9]*|0x[0-9a-f]{2,4})$`},
+		{mustgrammar(t, "W → L||D | W , L||D\nL → /[a-z]/\nD → /[0-9]/"), GenerateOptions{}, `^[a-z][0-9]( , [a-z][0-9])*$`},
+		{mustgrammar(t, "S → a S | ε"), GenerateOptions{Depth: 4}, `^(a ?){0,3}$`},
+	}
+	for _, tc := range tests {
+		tc.opts.Rand = rand.New(rand.NewSource(2))
+		re := regexp.MustCompile(tc.matches)
+		for i := 0; i < 20; i++ {
+			s, err := tc.G.Generate(tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !re.MatchString(s.Text) {
+				t.Errorf("%s: %q does not match %s", plain(tc.G), s, tc.matches)
+			}
+			if depth := tc.opts.Depth; depth > 0 && height(s.Tree()) > depth {
+				t.Errorf("%s: %q derived to depth %d", plain(tc.G), s, height(s.Tree()))
+			}
+		}
+	}
+}
+
+func TestCover(t *testing.T) {
+	tests := []struct {
+		G         Grammar
+		sentences []string
+	}{
+		{mustgrammar(t, "S → + S S | - S S | a"), []string{"+ a a", "- a a"}},
+		{
+			mustgrammar(t, "stmt → expr ; | if ( expr ) stmt | for ( optexpr ; optexpr ; optexpr ) stmt | other\n"+
+				"optexpr → ε | expr\nexpr → x"),
+			[]string{"x ;", "if ( x ) other", "for ( ; ; ) other", "for ( x ; ; ) other"},
+		},
+		// the shortest path to T → T * F is by E → T
+		{mustgrammar(t, "E → E + T | T\nT → T * F | F\nF → ( E ) | x"), []string{"x + x", "x * x", "( x )"}},
+	}
+	for _, tc := range tests {
+		sentences, err := tc.G.Cover()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		used := map[production]bool{}
+		var mark func(n *node)
+		mark = func(n *node) {
+			used[n.prod] = true
+			for i := range n.children {
+				mark(&n.children[i])
+			}
+		}
+		for _, s := range sentences {
+			got = append(got, s.Text)
+			mark(s.Tree())
+		}
+		if strings.Join(got, "\n") != strings.Join(tc.sentences, "\n") {
+			t.Errorf("%s: covered by %q, want %q", plain(tc.G), got, tc.sentences)
+		}
+		for _, nt := range tc.G {
+			for _, prod := range nt.Productions {
+				if !used[prod] {
+					t.Errorf("%s: %s → %s not used", plain(tc.G), nt.Head, prod)
+				}
+			}
+		}
+	}
+}
+
+func TestEnumerate(t *testing.T) {
+	tests := []struct {
+		G         Grammar
+		n         int
+		sentences []string
+	}{
+		{mustgrammar(t, "S → + S S | - S S | a"), 3, []string{"a", "+ a a", "- a a"}},
+		{mustgrammar(t, "S → ( S ) S | ε"), 4, []string{"", "( )", "( ( ) )", "( ) ( )"}},
+		{mustgrammar(t, "W → L||D | W , L||D\nL → /[a-z]/\nD → /[0-9]/"), 5, []string{"x1", "x1 , x1"}},
+		{mustgrammar(t, "S → a S b | T\nT → c T | ε"), 3, []string{"", "c", "a b", "c c", "a c b", "c c c"}},
+	}
+	for _, tc := range tests {
+		got, err := tc.G.Sentences(tc.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, "\n") != strings.Join(tc.sentences, "\n") {
+			t.Errorf("%s: sentences %q, want %q", plain(tc.G), got, tc.sentences)
+		}
+		for _, s := range got {
+			if err := tc.G.Recognize(source.NewFile("", s)); err != nil {
+				t.Errorf("%s: %q: %v", plain(tc.G), s, err)
+			}
+		}
+	}
+}
+
+func TestCoverErrors(t *testing.T) {
+	tests := []struct {
+		src, err string
+	}{
+		{"S → S a", "S derives no string of terminals"},
+		{"S → a | B\nB → B b", "S → B derives no string of terminals"},
+		{"S → a\nB → b", "B → b: B is not reached from S"},
+	}
+	for _, tc := range tests {
+		_, err := mustgrammar(t, tc.src).Cover()
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("%q: got error %v, want %s", tc.src, err, tc.err)
+		}
+	}
+	if _, err := mustgrammar(t, "S → /[/").Generate(GenerateOptions{}); err == nil {
+		t.Errorf("generated from an invalid regular expression")
+	}
+}
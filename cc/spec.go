@@ -0,0 +1,199 @@
+package grammar
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Spec is a grammar file for Generate: a Grammar in the notation of
+// ReadGrammar, whose productions may end with actions, preceded by
+// declarations, one per line:
+//
+//	%package name            the package of the parser
+//	%import path             a package that the types of attributes use
+//	%token name /regexp/     a terminal that matches a regular expression
+//	%ignore /regexp/         text to be skipped between tokens, besides space
+//	%left a b ...            terminals of one precedence, each declaration
+//	%right a b ...           binding tighter than those before it
+//	%nonassoc a b ...
+//	%attr head name type     a synthesized attribute of a Nonterminal
+//
+// The other terminals are the symbols of the bodies that are not heads: a
+// /regexp/, or else literal text. A production takes the precedence of the
+// last terminal of its body, unless it ends with %prec t for a terminal t.
+// Lines beginning with // are comments.
+type Spec struct {
+	Name    string // of the file
+	Package string
+	Grammar Grammar
+
+	imports  []string
+	tokens   map[string]string // the regular expressions of named terminals
+	ignore   []string
+	prec     map[string]precedence
+	prodprec map[production]string // the terminals named by %prec
+	attrs    map[string][]attr
+}
+
+type attr struct{ name, typ string }
+
+var (
+	directivere = regexp.MustCompile(`^%(\w+)\s*(.*)$`)
+	precre      = regexp.MustCompile(`\s%prec\s+(\S+)`)
+)
+
+// ReadSpec reads the grammar file src, named name.
+func ReadSpec(name, src string) (*Spec, error) {
+	spec := &Spec{
+		Name:     name,
+		Package:  "parser",
+		tokens:   map[string]string{},
+		prec:     map[string]precedence{},
+		prodprec: map[production]string{},
+		attrs:    map[string][]attr{},
+	}
+	lines := strings.Split(src, "\n")
+	level := 0
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "//") {
+			lines[i] = ""
+			continue
+		}
+		m := directivere.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		lines[i] = "" // keeping the numbers of the lines of the grammar
+		errorf := func(format string, args ...interface{}) error {
+			return fmt.Errorf("%s:%d: %s", name, i+1, fmt.Sprintf(format, args...))
+		}
+		args := strings.Fields(m[2])
+		pattern := func(s string) (string, error) {
+			if len(s) < 2 || s[0] != '/' || s[len(s)-1] != '/' {
+				return "", errorf("expected /regexp/, found %s", s)
+			}
+			if _, err := regexp.Compile(s[1 : len(s)-1]); err != nil {
+				return "", errorf("%v", err)
+			}
+			return s[1 : len(s)-1], nil
+		}
+		switch m[1] {
+		case "package":
+			if len(args) != 1 {
+				return nil, errorf("expected %%package name")
+			}
+			spec.Package = args[0]
+		case "import":
+			if len(args) != 1 {
+				return nil, errorf("expected %%import path")
+			}
+			spec.imports = append(spec.imports, strings.Trim(args[0], `"`))
+		case "token":
+			if len(args) < 2 {
+				return nil, errorf("expected %%token name /regexp/")
+			}
+			re, err := pattern(strings.TrimSpace(strings.TrimPrefix(m[2], args[0])))
+			if err != nil {
+				return nil, err
+			}
+			spec.tokens[args[0]] = re
+		case "ignore":
+			re, err := pattern(m[2])
+			if err != nil {
+				return nil, err
+			}
+			spec.ignore = append(spec.ignore, re)
+		case "left", "right", "nonassoc":
+			if len(args) == 0 {
+				return nil, errorf("expected terminals after %%%s", m[1])
+			}
+			level++
+			a := map[string]assoc{"left": leftassoc, "right": rightassoc, "nonassoc": nonassoc}[m[1]]
+			for _, t := range args {
+				if _, ok := spec.prec[t]; ok {
+					return nil, errorf("precedence of %s declared twice", t)
+				}
+				spec.prec[t] = precedence{level, a}
+			}
+		case "attr":
+			if len(args) < 3 {
+				return nil, errorf("expected %%attr head name type")
+			}
+			if args[1] == "lexeme" {
+				return nil, errorf("lexeme is the attribute of every symbol")
+			}
+			typ := strings.TrimSpace(m[2][strings.Index(m[2], args[1])+len(args[1]):])
+			spec.attrs[args[0]] = append(spec.attrs[args[0]], attr{args[1], typ})
+		default:
+			return nil, errorf("unknown directive %%%s", m[1])
+		}
+	}
+	G, err := ReadGrammar(strings.Join(lines, "\n"))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	for _, nt := range G {
+		for j, prod := range nt.Productions {
+			if m := precre.FindStringSubmatch(" " + string(prod)); m != nil {
+				if _, ok := spec.prec[m[1]]; !ok {
+					return nil, fmt.Errorf("%s: %s → %s: %s has no precedence", name, nt.Head, prod, m[1])
+				}
+				prod = production(strings.TrimSpace(precre.ReplaceAllString(" "+string(prod), "")))
+				nt.Productions[j] = prod
+				spec.prodprec[prod] = m[1]
+			}
+		}
+	}
+	heads := G.heads()
+	for head := range spec.attrs {
+		if _, ok := heads[head]; !ok {
+			return nil, fmt.Errorf("%s: attributes declared for unknown Nonterminal %s", name, head)
+		}
+	}
+	for t := range spec.tokens {
+		if _, ok := heads[t]; ok {
+			return nil, fmt.Errorf("%s: %%token %s is a Nonterminal", name, t)
+		}
+	}
+	spec.Grammar = G
+	return spec, nil
+}
+
+// sdd is the definition whose rules are the actions of the spec.
+func (spec *Spec) sdd() SDD {
+	d := SDD{Grammar: spec.Grammar, Synthesized: map[string][]string{}, Funcs: map[string]Func{}}
+	for head, attrs := range spec.attrs {
+		for _, a := range attrs {
+			d.Synthesized[head] = append(d.Synthesized[head], a.name)
+		}
+	}
+	// the functions are the Actions of the generated parser
+	for _, nt := range spec.Grammar {
+		for _, prod := range nt.Productions {
+			for _, p := range prod.pieces() {
+				if !p.action {
+					continue
+				}
+				a, err := parseaction(p.text)
+				if err != nil {
+					continue // reported by d.rules
+				}
+				var calls func(o operand)
+				calls = func(o operand) {
+					if c, ok := o.(*call); ok {
+						d.Funcs[c.name] = nil
+						for _, arg := range c.args {
+							calls(arg)
+						}
+					}
+				}
+				for _, stmt := range a.stmts {
+					calls(stmt.src)
+				}
+			}
+		}
+	}
+	return d
+}
@@ -1,3 +1,7 @@
-module github.com/akiarie/dragon-tests/compilers/ch2/trans
+module github.com/akiarie/dragon-tests/compilers/ch2/postfix
 
 go 1.16
+
+require github.com/akiarie/dragon-tests/source v0.0.0-00010101000000-000000000000
+
+replace github.com/akiarie/dragon-tests/source => ../../../source
@@ -0,0 +1,108 @@
+package postfix
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+type tokenclass string
+
+const (
+	tkNum   tokenclass = "num"
+	tkId               = "id"
+	tkOp               = "op"
+	tkParen            = "paren"
+	tkEOF              = "eof"
+)
+
+type token struct {
+	class tokenclass
+	value string
+	span  source.Span
+}
+
+func (tk token) String() string {
+	if tk.class == tkEOF {
+		return "end of input"
+	}
+	return fmt.Sprintf("%q", tk.value)
+}
+
+type lexer struct {
+	file   *source.File
+	input  string
+	start  int // of the token being read
+	pos    int
+	tokens []token
+	err    error
+}
+
+type stateFn func(*lexer) stateFn
+
+func (l *lexer) peek() rune {
+	if l.pos >= len(l.input) {
+		return -1
+	}
+	r, _ := utf8.DecodeRuneInString(l.input[l.pos:])
+	return r
+}
+
+func (l *lexer) emit(class tokenclass) {
+	l.tokens = append(l.tokens, token{class, l.input[l.start:l.pos], l.file.Span(l.start, l.pos)})
+	l.start = l.pos
+}
+
+// lexany reads the token beginning at pos, skipping space before it.
+func lexany(l *lexer) stateFn {
+	for unicode.IsSpace(l.peek()) {
+		l.pos++
+	}
+	l.start = l.pos
+	switch r := l.peek(); {
+	case r == -1:
+		l.emit(tkEOF)
+		return nil
+	case '0' <= r && r <= '9':
+		return lexnum
+	case r == '_' || unicode.IsLetter(r):
+		return lexid
+	case r == '+' || r == '-' || r == '*' || r == '/':
+		l.pos++
+		l.emit(tkOp)
+		return lexany
+	case r == '(' || r == ')':
+		l.pos++
+		l.emit(tkParen)
+		return lexany
+	default:
+		l.err = &Error{l.file.Position(l.file.Pos(l.pos)), fmt.Sprintf("unexpected character %q", r)}
+		return nil
+	}
+}
+
+func lexnum(l *lexer) stateFn {
+	for r := l.peek(); '0' <= r && r <= '9'; r = l.peek() {
+		l.pos++
+	}
+	l.emit(tkNum)
+	return lexany
+}
+
+func lexid(l *lexer) stateFn {
+	for r := l.peek(); r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r); r = l.peek() {
+		l.pos += utf8.RuneLen(r)
+	}
+	l.emit(tkId)
+	return lexany
+}
+
+// tokenize reads the tokens of f, ending with an end-of-input token.
+func tokenize(f *source.File) ([]token, error) {
+	l := &lexer{file: f, input: f.Source()}
+	for state := stateFn(lexany); state != nil; state = state(l) {
+	}
+	return l.tokens, l.err
+}
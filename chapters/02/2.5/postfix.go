@@ -0,0 +1,158 @@
+// Package postfix translates infix arithmetic expressions into postfix or
+// prefix notation, by the translation scheme of Section 2.5 extended with
+// multiplication, division, parentheses, identifiers and numbers of several
+// digits.
+package postfix
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+// Mode is the notation into which expressions are translated.
+type Mode int
+
+const (
+	Postfix Mode = iota // 9 5 - 2 + for 9-5+2
+	Prefix              // + - 9 5 2 for 9-5+2
+)
+
+// Error is a syntax error and the position at which it was found.
+type Error struct {
+	Pos source.Position
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// The translation scheme is that of Section 2.5, with term and factor in the
+// roles of expr and term:
+//
+//	expr   → term rest
+//	rest   → + term { print('+') } rest | - term { print('-') } rest | ε
+//	term   → factor more
+//	more   → * factor { print('*') } more | / factor { print('/') } more | ε
+//	factor → ( expr ) | num { print(num) } | id { print(id) }
+//
+// Each function returns the translation of what it has read, so that prefix
+// output can place an operator before operands already translated.
+type parser struct {
+	file  *source.File
+	input []token
+	pos   int
+	mode  Mode
+}
+
+func (p *parser) errorf(tk token, format string, args ...interface{}) error {
+	return &Error{p.file.Position(tk.span.Start), fmt.Sprintf(format, args...)}
+}
+
+// apply is the translation of op applied to the translations x and y.
+func (p *parser) apply(op string, x, y []string) []string {
+	if p.mode == Prefix {
+		return append(append([]string{op}, x...), y...)
+	}
+	return append(append(x, y...), op)
+}
+
+func (p *parser) expr() ([]string, error) {
+	out, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	return p.rest(out)
+}
+
+// rest is given the translation of the operands to its left, as the inherited
+// attribute that makes + and - associate to the left.
+func (p *parser) rest(left []string) ([]string, error) {
+	tk := p.input[p.pos]
+	if tk.value != "+" && tk.value != "-" {
+		return left, nil
+	}
+	p.pos++
+	right, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	return p.rest(p.apply(tk.value, left, right))
+}
+
+func (p *parser) term() ([]string, error) {
+	out, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	return p.more(out)
+}
+
+func (p *parser) more(left []string) ([]string, error) {
+	tk := p.input[p.pos]
+	if tk.value != "*" && tk.value != "/" {
+		return left, nil
+	}
+	p.pos++
+	right, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	return p.more(p.apply(tk.value, left, right))
+}
+
+func (p *parser) factor() ([]string, error) {
+	tk := p.input[p.pos]
+	switch {
+	case tk.class == tkNum, tk.class == tkId:
+		p.pos++
+		return []string{tk.value}, nil
+	case tk.value == "(":
+		p.pos++
+		out, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if close := p.input[p.pos]; close.value != ")" {
+			return nil, p.errorf(close, "expected ')' to match '(' at %s, found %s", p.file.Position(tk.span.Start), close)
+		}
+		p.pos++
+		return out, nil
+	}
+	if p.pos > 0 {
+		return nil, p.errorf(tk, "expected operand after %s, found %s", p.input[p.pos-1], tk)
+	}
+	return nil, p.errorf(tk, "expected operand, found %s", tk)
+}
+
+// Translate returns the expression in f in the notation m, with its operators
+// and operands separated by spaces.
+func Translate(f *source.File, m Mode) (string, error) {
+	tokens, err := tokenize(f)
+	if err != nil {
+		return "", err
+	}
+	p := &parser{file: f, input: tokens, mode: m}
+	out, err := p.expr()
+	if err != nil {
+		return "", err
+	}
+	if tk := p.input[p.pos]; tk.class != tkEOF {
+		return "", p.errorf(tk, "unexpected %s after expression", tk)
+	}
+	return strings.Join(out, " "), nil
+}
+
+// Tokens writes the tokens of f, one per line with its position and class.
+func Tokens(w io.Writer, f *source.File) error {
+	tokens, err := tokenize(f)
+	for _, tk := range tokens {
+		if tk.class != tkEOF {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", f.Position(tk.span.Start), tk.class, tk.value)
+		}
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+package postfix
+
+import (
+	"testing"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+func TestTranslate(t *testing.T) {
+	tests := []struct {
+		input           string
+		postfix, prefix string
+	}{
+		{"9-5+2", "9 5 - 2 +", "+ - 9 5 2"},
+		{"9-7+3+5-2-5+2", "9 7 - 3 + 5 + 2 - 5 - 2 +", "+ - - + + - 9 7 3 5 2 5 2"},
+		{"42", "42", "42"},
+		{"  12 +\n\t345 ", "12 345 +", "+ 12 345"},
+		{"a + b * c", "a b c * +", "+ a * b c"},
+		{"a * b + c", "a b * c +", "+ * a b c"},
+		{"8 / 4 / 2", "8 4 / 2 /", "/ / 8 4 2"},
+		{"(a + b) * c", "a b + c *", "* + a b c"},
+		{"x1 - (y_2 - (3))", "x1 y_2 3 - -", "- x1 - y_2 3"},
+		{"rate * 60 + initial", "rate 60 * initial +", "+ * rate 60 initial"},
+	}
+	for _, tc := range tests {
+		for _, m := range []struct {
+			mode Mode
+			want string
+		}{{Postfix, tc.postfix}, {Prefix, tc.prefix}} {
+			got, err := Translate(source.NewFile("expr", tc.input), m.mode)
+			if err != nil {
+				t.Errorf("%q: %v", tc.input, err)
+			} else if got != m.want {
+				t.Errorf("%q in mode %d: got %q, want %q", tc.input, m.mode, got, m.want)
+			}
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		input, want string
+	}{
+		{"", "expr:1:1: expected operand, found end of input"},
+		{"9-", `expr:1:3: expected operand after "-", found end of input`},
+		{"9 - * 2", `expr:1:5: expected operand after "-", found "*"`},
+		{"9 5", `expr:1:3: unexpected "5" after expression`},
+		{"(1 + 2", "expr:1:7: expected ')' to match '(' at expr:1:1, found end of input"},
+		{"1 + 2)", `expr:1:6: unexpected ")" after expression`},
+		{"1 +\n 2 % 3", "expr:2:4: unexpected character '%'"},
+	}
+	for _, tc := range tests {
+		_, err := Translate(source.NewFile("expr", tc.input), Postfix)
+		if err == nil {
+			t.Errorf("%q: expected error %s", tc.input, tc.want)
+			continue
+		}
+		if _, ok := err.(*Error); !ok {
+			t.Errorf("%q: error %T is not *Error", tc.input, err)
+		}
+		if err.Error() != tc.want {
+			t.Errorf("%q: got %s, want %s", tc.input, err, tc.want)
+		}
+	}
+}
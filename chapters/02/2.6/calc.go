@@ -0,0 +1,222 @@
+// Package calc translates arithmetic expressions over numbers and identifiers
+// into postfix notation, by the translation scheme of Section 2.6 with a
+// lexical analyser for numbers, identifiers, operators and comments, and
+// evaluates them as a calculator.
+package calc
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+// Error is a syntax or evaluation error and the position at which it was
+// found.
+type Error struct {
+	Pos source.Position
+	Msg string
+	eof bool // the input ended before the error, and may yet be completed
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// The statements are parsed into trees, which are then translated or
+// evaluated.
+type expr interface{}
+
+type (
+	leaf  struct{ tk token } // number or identifier
+	unary struct {
+		op token
+		x  expr
+	}
+	binary struct {
+		op   token
+		x, y expr
+	}
+	assign struct {
+		id token
+		x  expr
+	}
+)
+
+// parser reads the statements
+//
+//	stmts  → stmt ; stmts | stmt
+//	stmt   → id = expr | expr | ε
+//	expr   → arith rel arith | arith
+//	arith  → arith + term | arith - term | term
+//	term   → term * unary | term / unary | unary
+//	unary  → - unary | + unary | ! unary | factor
+//	factor → ( expr ) | num | id
+//
+// with the left recursion of arith and term eliminated as in Section 2.6. An
+// error is raised by panicking with an *Error.
+type parser struct {
+	pos   int
+	file  *source.File
+	input []token
+}
+
+func (p *parser) peek() token { return p.input[p.pos] }
+
+func (p *parser) errorf(format string, args ...interface{}) {
+	tk := p.peek()
+	panic(&Error{Pos: p.file.Position(tk.span.Start), Msg: fmt.Sprintf(format, args...), eof: tk.class == tkEOF})
+}
+
+func (p *parser) stmts() []expr {
+	var stmts []expr
+	for {
+		if tk := p.peek(); tk.class != tkSemi && tk.class != tkEOF {
+			stmts = append(stmts, p.stmt())
+		}
+		switch tk := p.peek(); tk.class {
+		case tkEOF:
+			return stmts
+		case tkSemi:
+			p.pos++
+		default:
+			p.errorf("expected operator or ';', found %s", describe(tk))
+		}
+	}
+}
+
+func (p *parser) stmt() expr {
+	if id := p.peek(); id.class == tkId && p.input[p.pos+1].class == tkAssign {
+		p.pos += 2
+		return assign{id, p.expr()}
+	}
+	return p.expr()
+}
+
+func (p *parser) expr() expr {
+	x := p.arith()
+	if op := p.peek(); op.class == tkRel {
+		p.pos++
+		return binary{op, x, p.arith()}
+	}
+	return x
+}
+
+func (p *parser) arith() expr {
+	x := p.term()
+	for op := p.peek(); op.value == "+" || op.value == "-"; op = p.peek() {
+		p.pos++
+		x = binary{op, x, p.term()}
+	}
+	return x
+}
+
+func (p *parser) term() expr {
+	x := p.unary()
+	for op := p.peek(); op.value == "*" || op.value == "/"; op = p.peek() {
+		p.pos++
+		x = binary{op, x, p.unary()}
+	}
+	return x
+}
+
+func (p *parser) unary() expr {
+	if op := p.peek(); op.value == "-" || op.value == "+" || op.value == "!" {
+		p.pos++
+		return unary{op, p.unary()}
+	}
+	return p.factor()
+}
+
+func (p *parser) factor() expr {
+	switch tk := p.peek(); {
+	case tk.class == tkNum, tk.class == tkId:
+		p.pos++
+		return leaf{tk}
+	case tk.value == "(":
+		p.pos++
+		x := p.expr()
+		if p.peek().value != ")" {
+			p.errorf("expected ')' to match '(' at %s, found %s", p.file.Position(tk.span.Start), describe(p.peek()))
+		}
+		p.pos++
+		return x
+	default:
+		p.errorf("%s is not a number or expression", describe(tk))
+		return nil
+	}
+}
+
+func describe(tk token) string {
+	if tk.class == tkEOF {
+		return "end of input"
+	}
+	return fmt.Sprintf("%q", tk.value)
+}
+
+// parse reads the statements of f.
+func parse(f *source.File) (stmts []expr, err error) {
+	tokens, err := tokenize(f)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			stmts, err = nil, e
+		}
+	}()
+	p := &parser{file: f, input: tokens}
+	return p.stmts(), nil
+}
+
+// postfix is the translation of x, with each operand in brackets. Unary minus
+// is written neg, and unary plus not at all.
+func postfix(x expr) string {
+	switch x := x.(type) {
+	case leaf:
+		return fmt.Sprintf("(%s)", x.tk.value)
+	case unary:
+		switch x.op.value {
+		case "+":
+			return postfix(x.x)
+		case "-":
+			return postfix(x.x) + "neg"
+		}
+		return postfix(x.x) + x.op.value
+	case binary:
+		return postfix(x.x) + postfix(x.y) + x.op.value
+	case assign:
+		return fmt.Sprintf("(%s)%s=", x.id.value, postfix(x.x))
+	}
+	panic(fmt.Sprintf("unknown expression %T", x))
+}
+
+// Tokens writes the tokens of f, one per line with its position and class.
+func Tokens(w io.Writer, f *source.File) error {
+	tokens, err := tokenize(f)
+	if err != nil {
+		return err
+	}
+	for _, tk := range tokens[:len(tokens)-1] {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", f.Position(tk.span.Start), tk.class, tk.value)
+	}
+	return nil
+}
+
+// Translate returns the postfix form of each statement in f, one per line.
+func Translate(f *source.File) (string, error) {
+	stmts, err := parse(f)
+	if err != nil {
+		return "", err
+	}
+	lines := make([]string, len(stmts))
+	for i, x := range stmts {
+		lines[i] = postfix(x)
+	}
+	return strings.Join(lines, "\n"), nil
+}
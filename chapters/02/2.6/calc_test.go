@@ -0,0 +1,138 @@
+package calc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+func TestTranslate(t *testing.T) {
+	tests := []struct {
+		input, want string
+	}{
+		{"9 - 5 + 2", "(9)(5)-(2)+"},
+		{"((1+2)*3)", "(1)(2)+(3)*"},
+		{"a * (b - (c / (d + 1)))", "(a)(b)(c)(d)(1)+/-*"},
+		{"-x + +y * !z", "(x)neg(y)(z)!*+"},
+		{"x = 1 + 2 <= 4; x", "(x)(1)(2)+(4)<==\n(x)"},
+		{"1 /* two */ + // three\n 4", "(1)(4)+"},
+		{"0.125 * 1.5E-3 + 0x1F", "(0.125)(1.5E-3)*(0x1F)+"},
+	}
+	for _, tc := range tests {
+		got, err := Translate(source.NewFile("calc", tc.input))
+		if err != nil {
+			t.Errorf("%q: %v", tc.input, err)
+		} else if got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		input, want string
+	}{
+		{"((1+2)*3)", "9\n"},
+		{"x = 3; x * 2", "6\n"},
+		{"x = 3; y = x * x; y - x; y / 2", "6\n4.5\n"},
+		{"2 + 3 * 4; (2 + 3) * 4; 10 - 4 - 3; 2 * 3 / 4", "14\n20\n3\n1.5\n"},
+		{"-2 * -3; - - 4; !0; !5; !(1 > 2)", "6\n4\n1\n0\n1\n"},
+		{"1 < 2; 2 <= 1; 3 > 3; 3 >= 3; 1 == 1.0; 1 != 1", "1\n0\n0\n1\n1\n0\n"},
+		{"1 + 2 == 3", "1\n"},
+		{";; 1 ;", "1\n"},
+	}
+	for _, tc := range tests {
+		var b bytes.Buffer
+		if err := New().Run(&b, source.NewFile("calc", tc.input)); err != nil {
+			t.Errorf("%q: %v", tc.input, err)
+		} else if b.String() != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.input, b.String(), tc.want)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		input, want string
+	}{
+		{"1 +", "calc:1:4: end of input is not a number or expression"},
+		{"(1 + (2)", "calc:1:9: expected ')' to match '(' at calc:1:1, found end of input"},
+		{"1 2", `calc:1:3: expected operator or ';', found "2"`},
+		{"1 < 2 < 3", `calc:1:7: expected operator or ';', found "<"`},
+		{"x = 1; y + 1", "calc:1:8: undefined variable y"},
+		{"1 / (2 - 2)", "calc:1:3: division by zero"},
+		{"1 # 2", `calc:1:3: Unknown characters "# 2"`},
+		{"1 /* 2", "calc:1:3: unterminated comment"},
+		{"1E+", `calc:1:1: malformed number "1E+"`},
+		{"0x", `calc:1:1: malformed number "0x"`},
+		{"1.2.3", `calc:1:1: Double-point in "1.2."`},
+	}
+	for _, tc := range tests {
+		err := New().Run(&bytes.Buffer{}, source.NewFile("calc", tc.input))
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("%q: got error %v, want %s", tc.input, err, tc.want)
+		}
+	}
+}
+
+func TestREPL(t *testing.T) {
+	input := `x = 3
+x * 2
+y
+x = x +
+  1; x * (2 +
+  (3))
+1 /* a comment
+over lines */ + x
+1 +`
+	var b bytes.Buffer
+	if err := New().REPL(strings.NewReader(input), &b, false); err != nil {
+		t.Fatal(err)
+	}
+	want := "6\nerror: 1:1: undefined variable y\n20\n5\nerror: 1:4: end of input is not a number or expression\n"
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestModes(t *testing.T) {
+	tests := []struct {
+		mode  Mode
+		input string
+		want  string
+	}{
+		{Float, "0.125; 1/3; 0x1F + 0o17 + 0b101; 1.5E3; 2.5e-2; 5.", "0.125\n0.3333333333333333\n51\n1500\n0.025\n5\n"},
+		{Float, "0.1 + 0.2 == 0.3", "0\n"},
+		{Rational, "0.1 + 0.2 == 0.3; 1/3 + 1/6; 0.125; -2/4; 1.5E-3", "1\n1/2\n1/8\n-1/2\n3/2000\n"},
+		{Rational, "x = 1/3; x * 3; x < 0.3334", "1\n1\n"},
+		{Integer, "18446744073709551615 + 1; 2 * 0xFFFFFFFFFFFFFFFF; 7 / 2; -7 / 2; 1E3", "18446744073709551616\n36893488147419103230\n3\n-3\n1000\n"},
+		{Decimal, "1/3; 2/3; 0.1 + 0.2; 1E40 + 1", "0.3333333333333333333333333333333333\n0.6666666666666666666666666666666667\n0.3\n1e+40\n"},
+	}
+	for _, tc := range tests {
+		var b bytes.Buffer
+		c := New()
+		c.Mode = tc.mode
+		if err := c.Run(&b, source.NewFile("calc", tc.input)); err != nil {
+			t.Errorf("%s %q: %v", tc.mode, tc.input, err)
+		} else if b.String() != tc.want {
+			t.Errorf("%s %q: got %q, want %q", tc.mode, tc.input, b.String(), tc.want)
+		}
+	}
+
+	c := New()
+	c.Mode, c.Precision = Decimal, 10
+	var b bytes.Buffer
+	if err := c.Run(&b, source.NewFile("calc", "1/7")); err != nil || b.String() != "0.1428571429\n" {
+		t.Errorf("precision 10: got %q, %v", b.String(), err)
+	}
+	c = New()
+	c.Mode = Integer
+	if err := c.Run(&b, source.NewFile("calc", "1 + 2.5")); err == nil || err.Error() != "calc:1:5: 5/2 is not an integer" {
+		t.Errorf("integer mode: got error %v", err)
+	}
+	if _, err := ParseMode("complex"); err == nil {
+		t.Error("parsed mode complex")
+	}
+}
@@ -0,0 +1,177 @@
+package calc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+// Calculator evaluates statements, keeping the values assigned to variables
+// from one to the next. Its Mode and Precision may be set before it is first
+// used.
+type Calculator struct {
+	Mode      Mode
+	Precision int // in significant decimal digits, for Decimal
+
+	vars  map[string]value
+	arith arithmetic
+	file  *source.File // being evaluated, for the positions of errors
+}
+
+// New returns a calculator in Float mode with no variables. Decimal mode
+// defaults to the 34 digits of IEEE 754 decimal128.
+func New() *Calculator {
+	return &Calculator{Precision: 34, vars: map[string]value{}}
+}
+
+func (c *Calculator) errorf(tk token, format string, args ...interface{}) error {
+	return &Error{Pos: c.file.Position(tk.span.Start), Msg: fmt.Sprintf(format, args...)}
+}
+
+func (c *Calculator) truth(b bool) value {
+	if b {
+		return c.arith.fromint(1)
+	}
+	return c.arith.fromint(0)
+}
+
+// eval computes x. Relations and ! are 1 when true and 0 when false.
+func (c *Calculator) eval(x expr) (value, error) {
+	switch x := x.(type) {
+	case leaf:
+		if x.tk.class == tkId {
+			v, ok := c.vars[x.tk.value]
+			if !ok {
+				return nil, c.errorf(x.tk, "undefined variable %s", x.tk.value)
+			}
+			return v, nil
+		}
+		r, err := literal(x.tk.value)
+		if err != nil {
+			return nil, c.errorf(x.tk, "%v", err)
+		}
+		v, err := c.arith.fromrat(r)
+		if err != nil {
+			return nil, c.errorf(x.tk, "%v", err)
+		}
+		return v, nil
+	case unary:
+		v, err := c.eval(x.x)
+		if err != nil {
+			return nil, err
+		}
+		switch x.op.value {
+		case "-":
+			return c.arith.neg(v), nil
+		case "!":
+			return c.truth(c.arith.sign(v) == 0), nil
+		}
+		return v, nil
+	case binary:
+		a, err := c.eval(x.x)
+		if err != nil {
+			return nil, err
+		}
+		b, err := c.eval(x.y)
+		if err != nil {
+			return nil, err
+		}
+		switch x.op.value {
+		case "+", "-", "*":
+			return c.arith.binary(x.op.value, a, b), nil
+		case "/":
+			if c.arith.sign(b) == 0 {
+				return nil, c.errorf(x.op, "division by zero")
+			}
+			return c.arith.binary(x.op.value, a, b), nil
+		}
+		switch cmp := c.arith.cmp(a, b); x.op.value {
+		case "<":
+			return c.truth(cmp < 0), nil
+		case "<=":
+			return c.truth(cmp <= 0), nil
+		case ">":
+			return c.truth(cmp > 0), nil
+		case ">=":
+			return c.truth(cmp >= 0), nil
+		case "==":
+			return c.truth(cmp == 0), nil
+		case "!=":
+			return c.truth(cmp != 0), nil
+		}
+	case assign:
+		v, err := c.eval(x.x)
+		if err != nil {
+			return nil, err
+		}
+		c.vars[x.id.value] = v
+		return v, nil
+	}
+	panic(fmt.Sprintf("unknown expression %T", x))
+}
+
+// Run evaluates the statements of f in turn, writing the value of each that
+// is not an assignment.
+func (c *Calculator) Run(w io.Writer, f *source.File) error {
+	stmts, err := parse(f)
+	if err != nil {
+		return err
+	}
+	if c.arith == nil {
+		switch c.Mode {
+		case Float:
+			c.arith = floats{}
+		case Rational:
+			c.arith = rationals{}
+		case Integer:
+			c.arith = integers{}
+		case Decimal:
+			c.arith = decimals{c.Precision}
+		}
+	}
+	c.file = f
+	for _, x := range stmts {
+		v, err := c.eval(x)
+		if err != nil {
+			return err
+		}
+		if _, ok := x.(assign); !ok {
+			fmt.Fprintln(w, c.arith.format(v))
+		}
+	}
+	return nil
+}
+
+// REPL reads statements from r a line at a time, evaluating each line as it
+// is completed and writing the values to w. A line that ends within an
+// expression or a comment is continued on the next. Errors are written and
+// the session goes on. The prompts are written only if interactive.
+func (c *Calculator) REPL(r io.Reader, w io.Writer, interactive bool) error {
+	prompt := func(s string) {
+		if interactive {
+			fmt.Fprint(w, s)
+		}
+	}
+	scanner := bufio.NewScanner(r)
+	var pending []string
+	for prompt("> "); scanner.Scan(); {
+		pending = append(pending, scanner.Text())
+		err := c.Run(w, source.NewFile("", strings.Join(pending, "\n")))
+		if e, ok := err.(*Error); ok && e.eof {
+			prompt("... ")
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(w, "error: %v\n", err)
+		}
+		pending = nil
+		prompt("> ")
+	}
+	if len(pending) > 0 {
+		fmt.Fprintf(w, "error: %v\n", c.Run(w, source.NewFile("", strings.Join(pending, "\n"))))
+	}
+	return scanner.Err()
+}
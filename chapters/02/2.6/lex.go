@@ -0,0 +1,209 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+type tokenclass string
+
+const (
+	tkNum    tokenclass = "num"
+	tkId                = "id"
+	tkOp                = "op"
+	tkRel               = "rel"
+	tkAssign            = "assign"
+	tkParen             = "paren"
+	tkSemi              = "semi"
+	tkSpace             = "[space]"
+	tkEOF               = "eof"
+)
+
+type token struct {
+	class  tokenclass
+	value  string
+	lexeme string // with any space and comments before it
+	span   source.Span
+}
+
+func (tk token) String() string {
+	return fmt.Sprintf("{%s %s %q}", tk.class, tk.value, tk.lexeme)
+}
+
+// errcomment is returned for a comment that the input ends within.
+var errcomment = errors.New("unterminated comment")
+
+// prefixes are the bases of integers written 0x..., 0o... and 0b....
+var prefixes = map[byte]int{'x': 16, 'X': 16, 'o': 8, 'O': 8, 'b': 2, 'B': 2}
+
+func isdigit(c byte, base int) bool {
+	switch base {
+	case 2:
+		return c == '0' || c == '1'
+	case 8:
+		return '0' <= c && c <= '7'
+	case 16:
+		return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+	}
+	return '0' <= c && c <= '9'
+}
+
+// scannumber returns the length of the number at the start of input, 0 if
+// there is none, or the negated length of a malformed one. Numbers are
+//
+//	digits → digit+
+//	number → digits ( . digits )? ( E [+-]? digits )?
+//
+// as in chapters/03/3.4/lex.c, except that either side of the point may be
+// empty and e may be lower case, or integers 0x..., 0o... or 0b... in bases
+// 16, 8 and 2.
+func scannumber(input string) int {
+	digits := func(i, base int) int {
+		for i < len(input) && isdigit(input[i], base) {
+			i++
+		}
+		return i
+	}
+	if len(input) > 1 && input[0] == '0' {
+		if base := prefixes[input[1]]; base != 0 {
+			if i := digits(2, base); i > 2 {
+				return i
+			}
+			return -2
+		}
+	}
+	i := digits(0, 10)
+	whole := i > 0
+	if i < len(input) && input[i] == '.' {
+		j := digits(i+1, 10)
+		if !whole && j == i+1 {
+			return 0 // a lone point
+		}
+		i = j
+	} else if !whole {
+		return 0
+	}
+	if i < len(input) && (input[i] == 'E' || input[i] == 'e') {
+		j := i + 1
+		if j < len(input) && (input[j] == '+' || input[j] == '-') {
+			j++
+		}
+		k := digits(j, 10)
+		if k == j {
+			return -k
+		}
+		i = k
+	}
+	return i
+}
+
+func parsetoken(input string, pos int) (*token, int, error) {
+	st := pos
+	for _, c := range input[pos:] {
+		if !unicode.IsSpace(c) {
+			break
+		}
+		st++
+	}
+	// ignore comments
+	if st+1 < len(input) && input[st] == '/' {
+		i := st + 1
+		if input[i] == '/' {
+			for i += 1; i < len(input); i++ {
+				if input[i] == '\n' {
+					break
+				}
+			}
+			if i == len(input) {
+				return &token{class: tkSpace, value: tkSpace, lexeme: input[pos:]}, len(input[pos:]), nil
+			}
+			i++ // skip newline
+			tk, shift, err := parsetoken(input, i)
+			if err != nil {
+				return nil, -1, err
+			}
+			return tk, (i - pos) + shift, nil
+		} else if input[i] == '*' {
+			for i += 1; i+1 < len(input); i++ {
+				if input[i:i+2] == "*/" {
+					i += 2 // input[i+1] == '/'
+					tk, shift, err := parsetoken(input, i)
+					if err != nil {
+						return nil, -1, err
+					}
+					return tk, (i - pos) + shift, nil
+				}
+			}
+			return nil, -1, errcomment
+		}
+	}
+
+	if st >= len(input) {
+		return &token{class: tkSpace, value: tkSpace, lexeme: input[pos:]}, len(input[pos:]), nil
+	}
+
+	if n := scannumber(input[st:]); n > 0 {
+		if st+n < len(input) && input[st+n] == '.' {
+			return nil, -1, fmt.Errorf("Double-point in %q", input[st:st+n+1])
+		}
+		return &token{class: tkNum, lexeme: input[pos : st+n], value: input[st : st+n]}, n + st - pos, nil
+	} else if n < 0 {
+		return nil, -1, fmt.Errorf("malformed number %q", input[st:st-n])
+	}
+
+	if unicode.IsLetter(rune(input[st])) {
+		i := st
+		for ; i < len(input); i++ {
+			if r := rune(input[i]); !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				break
+			}
+		}
+		return &token{class: tkId, lexeme: input[pos:i], value: input[st:i]}, len(input[pos:i]), nil
+	}
+
+	one := func(class tokenclass) (*token, int, error) {
+		return &token{class: class, lexeme: input[pos : st+1], value: input[st : st+1]}, 1 + st - pos, nil
+	}
+	switch c := input[st]; {
+	case strings.IndexByte("<>!=", c) != -1 && st+1 < len(input) && input[st+1] == '=':
+		return &token{class: tkRel, lexeme: input[pos : st+2], value: input[st : st+2]}, 2 + st - pos, nil
+	case c == '<' || c == '>':
+		return one(tkRel)
+	case c == '=':
+		return one(tkAssign)
+	case strings.IndexByte("+-*/!", c) != -1:
+		return one(tkOp)
+	case c == '(' || c == ')':
+		return one(tkParen)
+	case c == ';':
+		return one(tkSemi)
+	}
+
+	return nil, -1, fmt.Errorf("Unknown characters %q", input[st:])
+}
+
+// tokenize reads the tokens of f, ending with an end-of-input token.
+func tokenize(f *source.File) ([]token, error) {
+	input := f.Source()
+	tokens := []token{}
+	for pos := 0; pos < len(input); {
+		tk, shift, err := parsetoken(input, pos)
+		if err != nil {
+			at := len(input) - len(strings.TrimLeftFunc(input[pos:], unicode.IsSpace))
+			return nil, &Error{Pos: f.Position(f.Pos(at)), Msg: err.Error(), eof: err == errcomment}
+		}
+		pos += shift
+		if tk.class != tkSpace {
+			// the shift ends with the token
+			n := len(strings.TrimLeftFunc(tk.lexeme, unicode.IsSpace))
+			tk.span = f.Span(pos-n, pos)
+			tokens = append(tokens, *tk)
+		}
+	}
+	end := f.Pos(len(input))
+	return append(tokens, token{class: tkEOF, span: source.Span{Start: end, End: end}}), nil
+}
@@ -0,0 +1,205 @@
+package calc
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Mode is the arithmetic in which a Calculator computes.
+type Mode int
+
+const (
+	Float    Mode = iota // float64
+	Rational             // exact fractions, as math/big.Rat
+	Integer              // integers of any size, dividing with truncation
+	Decimal              // floating point with Calculator.Precision digits
+)
+
+var modes = []string{"float", "rat", "int", "decimal"}
+
+func (m Mode) String() string { return modes[m] }
+
+// ParseMode returns the Mode named s: float, rat, int or decimal.
+func ParseMode(s string) (Mode, error) {
+	for m, name := range modes {
+		if name == s {
+			return Mode(m), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown mode %q (want %s)", s, strings.Join(modes, ", "))
+}
+
+// literal is the exact value of a number token, which is decimal, possibly
+// with a fraction and an exponent, or an integer in hexadecimal, octal or
+// binary with the prefix 0x, 0o or 0b.
+func literal(s string) (*big.Rat, error) {
+	if len(s) > 2 && s[0] == '0' {
+		if base := prefixes[s[1]]; base != 0 {
+			n, ok := new(big.Int).SetString(s[2:], base)
+			if !ok {
+				return nil, fmt.Errorf("malformed number %q", s)
+			}
+			return new(big.Rat).SetInt(n), nil
+		}
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("malformed number %q", s)
+	}
+	return r, nil
+}
+
+// value is a number in the representation of an arithmetic: a float64, a
+// *big.Rat, a *big.Int or a *big.Float.
+type value interface{}
+
+// arithmetic is the computation of one Mode.
+type arithmetic interface {
+	fromrat(r *big.Rat) (value, error)
+	fromint(n int64) value
+	binary(op string, a, b value) value // for +, -, * and / by nonzero b
+	neg(a value) value
+	cmp(a, b value) int
+	sign(a value) int
+	format(a value) string
+}
+
+type floats struct{}
+
+func (floats) fromrat(r *big.Rat) (value, error) {
+	f, _ := r.Float64()
+	if math.IsInf(f, 0) {
+		return nil, fmt.Errorf("%s overflows float64", r.FloatString(0))
+	}
+	return f, nil
+}
+
+func (floats) fromint(n int64) value { return float64(n) }
+
+func (floats) binary(op string, a, b value) value {
+	x, y := a.(float64), b.(float64)
+	switch op {
+	case "+":
+		return x + y
+	case "-":
+		return x - y
+	case "*":
+		return x * y
+	}
+	return x / y
+}
+
+func (floats) neg(a value) value { return -a.(float64) }
+
+func (floats) cmp(a, b value) int {
+	switch x, y := a.(float64), b.(float64); {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func (t floats) sign(a value) int { return t.cmp(a, 0.0) }
+
+func (floats) format(a value) string { return strconv.FormatFloat(a.(float64), 'g', -1, 64) }
+
+type rationals struct{}
+
+func (rationals) fromrat(r *big.Rat) (value, error) { return r, nil }
+
+func (rationals) fromint(n int64) value { return big.NewRat(n, 1) }
+
+func (rationals) binary(op string, a, b value) value {
+	x, y, z := a.(*big.Rat), b.(*big.Rat), new(big.Rat)
+	switch op {
+	case "+":
+		return z.Add(x, y)
+	case "-":
+		return z.Sub(x, y)
+	case "*":
+		return z.Mul(x, y)
+	}
+	return z.Quo(x, y)
+}
+
+func (rationals) neg(a value) value { return new(big.Rat).Neg(a.(*big.Rat)) }
+
+func (rationals) cmp(a, b value) int { return a.(*big.Rat).Cmp(b.(*big.Rat)) }
+
+func (rationals) sign(a value) int { return a.(*big.Rat).Sign() }
+
+func (rationals) format(a value) string { return a.(*big.Rat).RatString() }
+
+type integers struct{}
+
+func (integers) fromrat(r *big.Rat) (value, error) {
+	if !r.IsInt() {
+		return nil, fmt.Errorf("%s is not an integer", r.RatString())
+	}
+	return new(big.Int).Set(r.Num()), nil
+}
+
+func (integers) fromint(n int64) value { return big.NewInt(n) }
+
+func (integers) binary(op string, a, b value) value {
+	x, y, z := a.(*big.Int), b.(*big.Int), new(big.Int)
+	switch op {
+	case "+":
+		return z.Add(x, y)
+	case "-":
+		return z.Sub(x, y)
+	case "*":
+		return z.Mul(x, y)
+	}
+	return z.Quo(x, y)
+}
+
+func (integers) neg(a value) value { return new(big.Int).Neg(a.(*big.Int)) }
+
+func (integers) cmp(a, b value) int { return a.(*big.Int).Cmp(b.(*big.Int)) }
+
+func (integers) sign(a value) int { return a.(*big.Int).Sign() }
+
+func (integers) format(a value) string { return a.(*big.Int).String() }
+
+// decimals compute with enough bits for digits significant decimal digits,
+// and print that many.
+type decimals struct{ digits int }
+
+func (d decimals) prec() uint {
+	return uint(math.Ceil(float64(d.digits)*math.Log2(10))) + 8
+}
+
+func (d decimals) fromrat(r *big.Rat) (value, error) {
+	return new(big.Float).SetPrec(d.prec()).SetRat(r), nil
+}
+
+func (d decimals) fromint(n int64) value {
+	return new(big.Float).SetPrec(d.prec()).SetInt64(n)
+}
+
+func (d decimals) binary(op string, a, b value) value {
+	x, y, z := a.(*big.Float), b.(*big.Float), new(big.Float).SetPrec(d.prec())
+	switch op {
+	case "+":
+		return z.Add(x, y)
+	case "-":
+		return z.Sub(x, y)
+	case "*":
+		return z.Mul(x, y)
+	}
+	return z.Quo(x, y)
+}
+
+func (decimals) neg(a value) value { return new(big.Float).Neg(a.(*big.Float)) }
+
+func (decimals) cmp(a, b value) int { return a.(*big.Float).Cmp(b.(*big.Float)) }
+
+func (decimals) sign(a value) int { return a.(*big.Float).Sign() }
+
+func (d decimals) format(a value) string { return a.(*big.Float).Text('g', d.digits) }
@@ -1,24 +1,36 @@
-package main
+// Package scopes translates blocks of declarations and uses of identifiers,
+// annotating each use with the type of its declaration, by the symbol tables
+// of Section 2.7 chained one per block.
+package scopes
 
 import (
 	"fmt"
-	"log"
+	"io"
 	"regexp"
 	"strings"
 	"unicode"
+
+	"github.com/akiarie/dragon-tests/source"
 )
 
 type stream []token
 
 type parser struct {
 	pos    int
-	raw    string
+	file   *source.File
 	input  stream
 	output string
 }
 
+// syntaxerror is raised by panicking with it, and recovered by Translate.
+type syntaxerror string
+
 func (p *parser) error(msg string) {
-	panic(fmt.Sprintf("Error: %s at %d %q in %q", msg, p.input[p.pos].pos, p.input[p.pos].value, p.raw))
+	if p.pos == len(p.input) {
+		panic(syntaxerror(fmt.Sprintf("%s: %s at end of input", p.file.Position(p.file.Pos(p.file.Size())), msg)))
+	}
+	tk := p.input[p.pos]
+	panic(syntaxerror(fmt.Sprintf("%s: %s at %q", p.file.Position(tk.span.Start), msg, tk.value)))
 }
 
 func (p *parser) Write(bytes []byte) (n int, err error) {
@@ -52,6 +64,9 @@ func (p *parser) block(prev *table) {
 }
 
 func (p *parser) punct(c byte) {
+	if p.pos == len(p.input) {
+		p.error(fmt.Sprintf("expected %q", c))
+	}
 	tk := p.input[p.pos]
 	if tk.class != tkPunctuation || tk.value[0] != c {
 		p.error(fmt.Sprintf("expected %q got %q", c, tk.value))
@@ -119,7 +134,7 @@ const (
 type token struct {
 	class tokenclass
 	value string
-	pos   int // position of lexeme in input stream
+	span  source.Span
 }
 
 func (tk token) String() string {
@@ -136,7 +151,7 @@ func parsetoken(input string, pos int) (*token, int, error) {
 	}
 	if st > pos {
 		if st >= len(input) {
-			return &token{class: tkSpace, value: tkSpace, pos: pos}, len(input[pos:]), nil
+			return &token{class: tkSpace, value: tkSpace}, len(input[pos:]), nil
 		}
 		// recurse & increment
 		tk, shift, err := parsetoken(input, st)
@@ -145,44 +160,73 @@ func parsetoken(input string, pos int) (*token, int, error) {
 
 	switch c := input[pos]; c {
 	case '{', '}', ';':
-		return &token{class: tkPunctuation, value: fmt.Sprintf("%c", c), pos: pos}, 1, nil
+		return &token{class: tkPunctuation, value: fmt.Sprintf("%c", c)}, 1, nil
 	}
 
 	for _, t := range []string{"int", "bool", "char"} {
 		if strings.Index(input[pos:], t) == 0 {
-			return &token{class: tkType, value: t, pos: pos}, len(t), nil
+			return &token{class: tkType, value: t}, len(t), nil
 		}
 	}
 
 	re := regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*`)
 	if match := re.FindString(input[pos:]); match != "" {
-		return &token{class: tkId, value: match, pos: pos}, len(match), nil
+		return &token{class: tkId, value: match}, len(match), nil
 	}
 	return nil, -1, fmt.Errorf("Unknown characters %q", input[pos:])
 }
 
-func tokenize(input string) ([]token, error) {
+func tokenize(f *source.File) ([]token, error) {
+	input := f.Source()
 	tokens := []token{}
 	for pos := 0; pos < len(input); {
 		tk, shift, err := parsetoken(input, pos)
 		if err != nil {
-			return nil, err
+			at := len(input) - len(strings.TrimLeftFunc(input[pos:], unicode.IsSpace))
+			return nil, fmt.Errorf("%s: %v", f.Position(f.Pos(at)), err)
 		}
+		pos += shift
 		if tk.class != tkSpace {
+			// the token ends the shift, which begins with any space
+			tk.span = f.Span(pos-len(tk.value), pos)
 			tokens = append(tokens, *tk)
 		}
-		pos += shift
 	}
 	return tokens, nil
 }
 
-func main() {
-	raw := "{ int x; char y; { bool y; x; y; } x; y; }"
-	tokens, err := tokenize(raw)
+// Tokens writes the tokens of f, one per line with its position and class.
+func Tokens(w io.Writer, f *source.File) error {
+	tokens, err := tokenize(f)
 	if err != nil {
-		log.Fatalln(err)
+		return err
+	}
+	for _, tk := range tokens {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", f.Position(tk.span.Start), tk.class, tk.value)
 	}
-	p := &parser{input: tokens, raw: raw}
+	return nil
+}
+
+// Translate returns the block in f with each use of an identifier annotated
+// with its type, as in { x:int; }.
+func Translate(f *source.File) (output string, err error) {
+	tokens, err := tokenize(f)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(syntaxerror)
+			if !ok {
+				panic(r)
+			}
+			output, err = "", fmt.Errorf("%s", e)
+		}
+	}()
+	p := &parser{input: tokens, file: f}
 	p.block(&table{m: make(map[string]string)})
-	fmt.Println(p.output)
+	if p.pos < len(p.input) {
+		p.error("unexpected token after block")
+	}
+	return p.output, nil
 }
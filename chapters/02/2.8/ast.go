@@ -0,0 +1,87 @@
+package trans
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+// dump writes the syntax tree below n, one node per line indented by depth,
+// with the position at which each begins. Expressions are written whole.
+func (p *parser) dump(w io.Writer, n node, depth int) {
+	line := func(n interface{ span() source.Span }, format string, a ...interface{}) {
+		fmt.Fprintf(w, "%s%s  %s\n", strings.Repeat("  ", depth), fmt.Sprintf(format, a...),
+			p.file.Position(n.span().Start))
+	}
+	switch s := n.(type) {
+	case block:
+		line(s, "block")
+		for _, stmt := range s.stmts {
+			p.dump(w, stmt, depth+1)
+		}
+	case *decl:
+		line(s, "%s", s)
+	case expr:
+		line(s, "expr %s", s)
+	case ifstmt:
+		line(s, "if %s", s.expr)
+		p.dump(w, s.stmt, depth+1)
+		if s.els != nil {
+			fmt.Fprintf(w, "%selse\n", strings.Repeat("  ", depth))
+			p.dump(w, s.els, depth+1)
+		}
+	case whilestmt:
+		line(s, "while %s", s.expr)
+		p.dump(w, s.stmt, depth+1)
+	case dostmt:
+		line(s, "do while %s", s.expr)
+		p.dump(w, s.stmt, depth+1)
+	case forstmt:
+		line(s, "for %s ; %s ; %s", s.init, s.cond, s.step)
+		p.dump(w, s.stmt, depth+1)
+	case switchstmt:
+		line(s, "switch %s", s.expr)
+		for _, c := range s.cases {
+			label := "default"
+			if !c.def {
+				label = fmt.Sprintf("case %d", c.value)
+			}
+			depth++
+			line(c, "%s", label)
+			for _, stmt := range c.body.stmts {
+				p.dump(w, stmt, depth+1)
+			}
+			depth--
+		}
+	case command:
+		if s.kind == comContinue {
+			line(s, "continue")
+		} else {
+			line(s, "break")
+		}
+	case retstmt:
+		if s.val == nil {
+			line(s, "return")
+		} else {
+			line(s, "return %s", s.val)
+		}
+	default:
+		line(s, "%T", s)
+	}
+}
+
+// dumpprogram writes the functions of prog and then its main block.
+func (p *parser) dumpprogram(w io.Writer, prog *program) {
+	for _, f := range prog.funcs {
+		params := make([]string, len(f.params))
+		for i, d := range f.params {
+			params[i] = fmt.Sprintf("%s %s", d._type, d.id)
+		}
+		fmt.Fprintf(w, "function %s %s ( %s )  %s\n", f._type, f.id, strings.Join(params, ", "),
+			p.file.Position(f.at.Start))
+		p.dump(w, f.body, 1)
+	}
+	p.dump(w, prog.main, 0)
+}
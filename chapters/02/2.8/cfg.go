@@ -0,0 +1,195 @@
+package trans
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// basicblock is the instructions code[start:end] of a function, which are
+// entered only at the first and left only after the last (Section 8.4.1).
+type basicblock struct {
+	start, end int
+	succ, pred []int // indices of blocks
+}
+
+// flowgraph is the code of a function, or of the main program, in basic
+// blocks. The first block is an empty entry (Section 8.4.3), so that no block
+// of code is entered both from outside and along an edge.
+type flowgraph struct {
+	prog   *tac
+	blocks []basicblock
+	of     []int // block of each instruction
+
+	idom     []int // immediate dominator of each block, -1 for the entry and unreachable blocks
+	order    []int // reachable blocks in reverse postorder
+	frontier [][]int
+}
+
+// newflowgraph partitions the code of prog into basic blocks, whose leaders
+// are the first instruction, every label, and every instruction following a
+// jump or return.
+func newflowgraph(prog *tac) *flowgraph {
+	g := &flowgraph{prog: prog, blocks: []basicblock{{}}, of: make([]int, len(prog.code))}
+	for i, code := range prog.code {
+		leader := i == 0 || code.op == opLabel
+		if i > 0 {
+			switch prog.code[i-1].op {
+			case opGoto, opIf, opIfFalse, opTable, opReturn:
+				leader = true
+			}
+		}
+		if leader {
+			if n := len(g.blocks); n > 1 {
+				g.blocks[n-1].end = i
+			}
+			g.blocks = append(g.blocks, basicblock{start: i})
+		}
+		g.of[i] = len(g.blocks) - 1
+	}
+	if n := len(g.blocks); n > 1 {
+		g.blocks[n-1].end = len(prog.code)
+		g.edge(0, 1)
+	}
+	for b := 1; b < len(g.blocks); b++ {
+		for _, i := range prog.succ(g.blocks[b].end - 1) {
+			g.edge(b, g.of[i])
+		}
+	}
+	g.dominators()
+	return g
+}
+
+func (g *flowgraph) edge(from, to int) {
+	if indexof(g.blocks[from].succ, to) == -1 {
+		g.blocks[from].succ = append(g.blocks[from].succ, to)
+		g.blocks[to].pred = append(g.blocks[to].pred, from)
+	}
+}
+
+// dominators finds the immediate dominators by the iterative method of
+// Cooper, Harvey and Kennedy, and from them the dominance frontiers.
+func (g *flowgraph) dominators() {
+	n := len(g.blocks)
+	g.idom = make([]int, n)
+	for b := range g.idom {
+		g.idom[b] = -1
+	}
+	g.frontier = make([][]int, n)
+	if n == 0 {
+		return
+	}
+	// reverse postorder of the blocks reachable from the entry
+	post, seen := []int{}, make([]bool, n)
+	var visit func(b int)
+	visit = func(b int) {
+		seen[b] = true
+		for _, s := range g.blocks[b].succ {
+			if !seen[s] {
+				visit(s)
+			}
+		}
+		post = append(post, b)
+	}
+	visit(0)
+	rpo := make([]int, n) // position of each block in reverse postorder
+	for i, b := range post {
+		g.order = append([]int{b}, g.order...)
+		rpo[b] = len(post) - 1 - i
+	}
+	intersect := func(a, b int) int {
+		for a != b {
+			for rpo[a] > rpo[b] {
+				a = g.idom[a]
+			}
+			for rpo[b] > rpo[a] {
+				b = g.idom[b]
+			}
+		}
+		return a
+	}
+	g.idom[0] = 0
+	for changed := true; changed; {
+		changed = false
+		for _, b := range g.order[1:] {
+			idom := -1
+			for _, p := range g.blocks[b].pred {
+				if g.idom[p] == -1 {
+					continue
+				}
+				if idom == -1 {
+					idom = p
+				} else {
+					idom = intersect(p, idom)
+				}
+			}
+			if g.idom[b] != idom {
+				g.idom[b], changed = idom, true
+			}
+		}
+	}
+	// a join point is in the frontier of each block from its predecessors up
+	// to, but not including, its immediate dominator
+	for _, b := range g.order {
+		if len(g.blocks[b].pred) < 2 {
+			continue
+		}
+		for _, p := range g.blocks[b].pred {
+			if !seen[p] {
+				continue
+			}
+			for r := p; r != g.idom[b]; r = g.idom[r] {
+				if indexof(g.frontier[r], b) == -1 {
+					g.frontier[r] = append(g.frontier[r], b)
+				}
+				if r == 0 {
+					break
+				}
+			}
+		}
+	}
+	g.idom[0] = -1
+}
+
+func blocknames(bs []int) string {
+	names := make([]string, len(bs))
+	for i, b := range bs {
+		names[i] = fmt.Sprintf("B%d", b)
+	}
+	return strings.Join(names, " ")
+}
+
+// header names the function of prog, for dumps of every function.
+func header(prog *tac) string {
+	if prog.name == "" {
+		return "main"
+	}
+	return "function " + prog.name
+}
+
+// print writes each block with its predecessors, successors and immediate
+// dominator, followed by its code.
+func (g *flowgraph) print(w io.Writer) {
+	fmt.Fprintln(w, header(g.prog))
+	for b, bb := range g.blocks {
+		fmt.Fprintf(w, "B%d:", b)
+		if b == 0 {
+			fmt.Fprint(w, " entry")
+		}
+		if len(bb.pred) > 0 {
+			fmt.Fprintf(w, " <- %s", blocknames(bb.pred))
+		}
+		if len(bb.succ) > 0 {
+			fmt.Fprintf(w, " -> %s", blocknames(bb.succ))
+		}
+		if d := g.idom[b]; d >= 0 {
+			fmt.Fprintf(w, " (idom B%d)", d)
+		} else if b > 0 {
+			fmt.Fprint(w, " (unreachable)")
+		}
+		fmt.Fprintln(w)
+		for _, code := range g.prog.code[bb.start:bb.end] {
+			fmt.Fprintf(w, "\t%s\n", code)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package trans
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+const loops = `int f ( int n ) { int s; while ( n > 0 ) { s = s + n; n = n - 1; } return s; }
+{
+	int i; int s;
+	i = 0;
+	while ( i < 10 ) {
+		if ( i % 2 == 0 ) s = s + i; else s = s - 1;
+		i = i + 1;
+	}
+	s = f ( s );
+}`
+
+func translated(t *testing.T, raw string) *TAC {
+	t.Helper()
+	prog, err := Parse(source.NewFile("input", raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := prog.Translate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// TestTranslateAgain checks that translating the syntax tree leaves it as it
+// was, for another translation or the code of the target machine.
+func TestTranslateAgain(t *testing.T) {
+	prog, err := Parse(source.NewFile("input", statements))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := prog.Translate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := prog.Translate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.String() != b.String() {
+		t.Errorf("translated\n%s\nthen\n%s", a, b)
+	}
+	if _, err := prog.Target(3); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFlowGraph(t *testing.T) {
+	c := translated(t, loops)
+	var b bytes.Buffer
+	newflowgraph(c.prog.funcs["f"]).print(&b)
+	want := `function f
+B0: entry -> B1
+B1: <- B0 B2 -> B2 B3 (idom B0)
+	L0:
+	t0 = n > 0
+	ifFalse t0 goto L1
+B2: <- B1 -> B1 (idom B1)
+	t1 = s + n
+	s = t1
+	t2 = n - 1
+	n = t2
+	goto L0
+B3: <- B1 (idom B1)
+	L1:
+	return s
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestSSA(t *testing.T) {
+	c := translated(t, loops)
+	var b bytes.Buffer
+	c.SSA(&b)
+	want := `main
+B0: entry -> B1
+B1: <- B0 -> B2
+	i.1 = 0
+B2: <- B1 B6 -> B3 B7
+	i.2 = phi(i.1, i.3)
+	s.1 = phi(s.0, s.5)
+	L2:
+	t3.1 = i.2 < 10
+	ifFalse t3.1 goto L3
+B3: <- B2 -> B4 B5
+	t4.1 = i.2 % 2
+	t5.1 = t4.1 == 0
+	ifFalse t5.1 goto L5
+B4: <- B3 -> B6
+	t6.1 = s.1 + i.2
+	s.4 = t6.1
+	goto L4
+B5: <- B3 -> B6
+	L5:
+	t7.1 = s.1 - 1
+	s.3 = t7.1
+B6: <- B4 B5 -> B2
+	s.5 = phi(s.4, s.3)
+	L4:
+	t8.1 = i.2 + 1
+	i.3 = t8.1
+	goto L2
+B7: <- B2
+	L3:
+	param s.1
+	t9.1 = call f, 1
+	s.2 = t9.1
+function f
+B0: entry -> B1
+B1: <- B0 B2 -> B2 B3
+	n.1 = phi(n.0, n.2)
+	s.1 = phi(s.0, s.2)
+	L0:
+	t0.1 = n.1 > 0
+	ifFalse t0.1 goto L1
+B2: <- B1 -> B1
+	t1.1 = s.1 + n.1
+	s.2 = t1.1
+	t2.1 = n.1 - 1
+	n.2 = t2.1
+	goto L0
+B3: <- B1
+	L1:
+	return s.1
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
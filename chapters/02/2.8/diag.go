@@ -0,0 +1,17 @@
+package trans
+
+import (
+	"fmt"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+// semanticerror is an error in generating code for the statement at pos.
+type semanticerror struct {
+	pos source.Position
+	err error
+}
+
+func (e *semanticerror) Error() string { return fmt.Sprintf("%s: %v", e.pos, e.err) }
+
+func (e *semanticerror) Unwrap() error { return e.err }
@@ -2,4 +2,12 @@ module github.com/akiarie/dragon-tests/compilers/ch2/trans
 
 go 1.16
 
-require github.com/fatih/color v1.13.0 // indirect
+require github.com/fatih/color v1.13.0
+
+require github.com/akiarie/dragon-tests/compilers/ch8/machine v0.0.0-00010101000000-000000000000
+
+replace github.com/akiarie/dragon-tests/compilers/ch8/machine => ../../08/8.2
+
+require github.com/akiarie/dragon-tests/source v0.0.0-00010101000000-000000000000
+
+replace github.com/akiarie/dragon-tests/source => ../../../source
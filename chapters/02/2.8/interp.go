@@ -0,0 +1,507 @@
+package trans
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// value is a cell of interpreter memory. Booleans are represented as the
+// integers 0 and 1.
+type value struct {
+	isfloat bool
+	i       int64
+	f       float64
+}
+
+func intval(i int64) value     { return value{i: i} }
+func floatval(f float64) value { return value{isfloat: true, f: f} }
+
+func (v value) float() float64 {
+	if v.isfloat {
+		return v.f
+	}
+	return float64(v.i)
+}
+
+func (v value) truth() bool {
+	if v.isfloat {
+		return v.f != 0
+	}
+	return v.i != 0
+}
+
+// as converts v to the representation of the given type.
+func (v value) as(_type string) value {
+	switch {
+	case _type == "float" && !v.isfloat:
+		return floatval(float64(v.i))
+	case _type == "int" && v.isfloat:
+		return intval(int64(v.f))
+	}
+	return v
+}
+
+func (v value) String() string {
+	if v.isfloat {
+		return strconv.FormatFloat(v.f, 'g', -1, 64)
+	}
+	return strconv.FormatInt(v.i, 10)
+}
+
+func parsevalue(s string) (value, error) {
+	switch s {
+	case "true":
+		return intval(1), nil
+	case "false":
+		return intval(0), nil
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	switch {
+	case err == nil:
+		return intval(i), nil
+	case errors.Is(err, strconv.ErrRange):
+		return value{}, fmt.Errorf("constant %s overflows int", s)
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return floatval(f), nil
+	}
+	return value{}, fmt.Errorf("invalid literal %q", s)
+}
+
+type variable struct {
+	decl  tacdecl
+	cells []value // a single cell for scalars
+}
+
+// interp executes three-address code. Declared variables are typed and
+// zero-initialised; temporaries take on the type of whatever is assigned to
+// them. Every call creates fresh variables for the callee, so that recursion
+// works, and the activation of the caller is suspended on a stack until the
+// callee returns; a function returns zero if control falls off its end.
+type interp struct {
+	prog     *tac // code being executed
+	vars     map[string]*variable
+	order    []string // declared variables, then temporaries as created
+	pc       int
+	steps    int
+	maxsteps int // no limit if <= 0
+
+	params []value      // values passed by param, awaiting a call
+	stack  []activation // suspended callers
+}
+
+// activation is the state of a caller while its callee runs.
+type activation struct {
+	prog  *tac
+	vars  map[string]*variable
+	order []string
+	pc    int    // of the call
+	dst   string // receives the result
+}
+
+// maxcells is the number of elements of the largest array allocated.
+const maxcells = 1 << 24
+
+// newframe creates the formals and declared variables of prog.
+func newframe(prog *tac) (map[string]*variable, []string, error) {
+	vars, order := map[string]*variable{}, []string{}
+	for _, d := range append(append([]tacdecl{}, prog.formals...), prog.decls...) {
+		if _, ok := vars[d.id]; ok {
+			return nil, nil, fmt.Errorf("variable %s redeclared", d.id)
+		}
+		n := d.size
+		switch {
+		case n < 0:
+			n = 1
+		case n > maxcells:
+			return nil, nil, fmt.Errorf("array %s of %d elements exceeds the limit of %d", d.id, n, maxcells)
+		}
+		v := &variable{decl: d, cells: make([]value, n)}
+		for i := range v.cells {
+			v.cells[i] = intval(0).as(d._type)
+		}
+		vars[d.id] = v
+		order = append(order, d.id)
+	}
+	return vars, order, nil
+}
+
+func newinterp(prog *tac) (*interp, error) {
+	vars, order, err := newframe(prog)
+	if err != nil {
+		return nil, err
+	}
+	return &interp{prog: prog, vars: vars, order: order}, nil
+}
+
+// initval is an initial value for a declared variable. The assignment may be
+// to a scalar (`v=5`), to a single array element (`a[3]=2.5`) or to
+// consecutive array elements starting at zero (`a=1,2,3`).
+type initval struct {
+	id    string
+	start int
+	vals  []value
+}
+
+func parseinit(assign string) (*initval, error) {
+	eq := strings.IndexByte(assign, '=')
+	if eq == -1 {
+		return nil, fmt.Errorf("initial value %q must have form name=value", assign)
+	}
+	iv := &initval{id: strings.TrimSpace(assign[:eq])}
+	if i := strings.IndexByte(iv.id, '['); i != -1 && strings.HasSuffix(iv.id, "]") {
+		n, err := strconv.Atoi(iv.id[i+1 : len(iv.id)-1])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid index in %q", iv.id)
+		}
+		iv.id, iv.start = iv.id[:i], n
+	}
+	for _, s := range strings.Split(assign[eq+1:], ",") {
+		val, err := parsevalue(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		iv.vals = append(iv.vals, val)
+	}
+	return iv, nil
+}
+
+// check ensures that the initial value fits within the declaration d.
+func (iv initval) check(d tacdecl) error {
+	n := d.size
+	if n < 0 {
+		n = 1
+	}
+	if end := iv.start + len(iv.vals); end > n {
+		return fmt.Errorf("index %d out of range for %s", end-1, d)
+	}
+	return nil
+}
+
+// set injects an initial value (see parseinit).
+func (in *interp) set(assign string) error {
+	iv, err := parseinit(assign)
+	if err != nil {
+		return err
+	}
+	v, ok := in.vars[iv.id]
+	if !ok || v.decl._type == "" {
+		return fmt.Errorf("cannot set undeclared variable %s", iv.id)
+	}
+	if err := iv.check(v.decl); err != nil {
+		return err
+	}
+	for i, val := range iv.vals {
+		v.cells[iv.start+i] = val.as(v.decl._type)
+	}
+	return nil
+}
+
+func (in *interp) errorf(format string, a ...interface{}) error {
+	code := in.prog.code[in.pc]
+	return fmt.Errorf("line %d: %s: %s", code.lineno, code, fmt.Sprintf(format, a...))
+}
+
+func (in *interp) operand(s string) (value, error) {
+	if v, ok := in.vars[s]; ok {
+		if v.decl.size >= 0 {
+			return value{}, in.errorf("array %s used as scalar", s)
+		}
+		return v.cells[0], nil
+	}
+	val, err := parsevalue(s)
+	switch {
+	case err == nil:
+		return val, nil
+	case strings.TrimLeft(s, "-0123456789") == "":
+		return value{}, in.errorf("%v", err)
+	}
+	return value{}, in.errorf("%s used before assignment", s)
+}
+
+func (in *interp) array(s string, index string) (*variable, int, error) {
+	v, ok := in.vars[s]
+	if !ok || v.decl.size < 0 {
+		return nil, -1, in.errorf("%s is not an array", s)
+	}
+	idx, err := in.operand(index)
+	if err != nil {
+		return nil, -1, err
+	}
+	if idx.isfloat {
+		return nil, -1, in.errorf("non-integer index %s", idx)
+	}
+	if idx.i < 0 || idx.i >= int64(len(v.cells)) {
+		return nil, -1, in.errorf("index %d out of range for %s", idx.i, v.decl)
+	}
+	return v, int(idx.i), nil
+}
+
+func (in *interp) assign(s string, val value) error {
+	v, ok := in.vars[s]
+	if !ok {
+		if _, err := parsevalue(s); err == nil {
+			return in.errorf("cannot assign to constant %s", s)
+		}
+		// temporary
+		v = &variable{decl: tacdecl{id: s, size: -1}, cells: make([]value, 1)}
+		in.vars[s] = v
+		in.order = append(in.order, s)
+	}
+	if v.decl.size >= 0 {
+		return in.errorf("cannot assign to array %s", s)
+	}
+	if v.decl._type != "" {
+		val = val.as(v.decl._type)
+	}
+	v.cells[0] = val
+	return nil
+}
+
+func boolval(b bool) value {
+	if b {
+		return intval(1)
+	}
+	return intval(0)
+}
+
+func (in *interp) binary(op string, a, b value) (value, error) {
+	if a.isfloat || b.isfloat {
+		x, y := a.float(), b.float()
+		switch op {
+		case "+":
+			return floatval(x + y), nil
+		case "-":
+			return floatval(x - y), nil
+		case "*":
+			return floatval(x * y), nil
+		case "/":
+			return floatval(x / y), nil
+		case "%":
+			return floatval(math.Mod(x, y)), nil
+		case "<":
+			return boolval(x < y), nil
+		case ">":
+			return boolval(x > y), nil
+		case "<=":
+			return boolval(x <= y), nil
+		case ">=":
+			return boolval(x >= y), nil
+		case "==":
+			return boolval(x == y), nil
+		case "!=":
+			return boolval(x != y), nil
+		}
+		return value{}, in.errorf("unknown operator %s", op)
+	}
+	x, y := a.i, b.i
+	switch op {
+	case "+":
+		return intval(x + y), nil
+	case "-":
+		return intval(x - y), nil
+	case "*":
+		return intval(x * y), nil
+	case "/", "%":
+		if y == 0 {
+			return value{}, in.errorf("integer division by zero")
+		}
+		if op == "/" {
+			return intval(x / y), nil
+		}
+		return intval(x % y), nil
+	case "<":
+		return boolval(x < y), nil
+	case ">":
+		return boolval(x > y), nil
+	case "<=":
+		return boolval(x <= y), nil
+	case ">=":
+		return boolval(x >= y), nil
+	case "==":
+		return boolval(x == y), nil
+	case "!=":
+		return boolval(x != y), nil
+	}
+	return value{}, in.errorf("unknown operator %s", op)
+}
+
+func (in *interp) eval(code instr) (value, error) {
+	a, err := in.operand(code.arg1)
+	if err != nil || code.binop == "" {
+		return a, err
+	}
+	b, err := in.operand(code.arg2)
+	if err != nil {
+		return value{}, err
+	}
+	return in.binary(code.binop, a, b)
+}
+
+// call suspends the current activation and begins one of the callee, whose
+// formals take the values of the last params.
+func (in *interp) call(code instr) error {
+	f, ok := in.prog.funcs[code.label]
+	if !ok {
+		return in.errorf("undefined function %s", code.label)
+	}
+	if code.nargs != len(f.formals) || code.nargs > len(in.params) {
+		return in.errorf("%s takes %d arguments, %d passed", code.label, len(f.formals), len(in.params))
+	}
+	vars, order, err := newframe(f)
+	if err != nil {
+		return in.errorf("%v", err)
+	}
+	args := in.params[len(in.params)-code.nargs:]
+	for i, d := range f.formals {
+		vars[d.id].cells[0] = args[i].as(d._type)
+	}
+	in.params = in.params[:len(in.params)-code.nargs]
+	in.stack = append(in.stack, activation{in.prog, in.vars, in.order, in.pc, code.dst})
+	in.prog, in.vars, in.order, in.pc = f, vars, order, 0
+	return nil
+}
+
+// ret ends the current activation, giving val to the caller.
+func (in *interp) ret(val value) error {
+	if in.prog.result != "void" {
+		val = val.as(in.prog.result)
+	}
+	a := in.stack[len(in.stack)-1]
+	in.stack = in.stack[:len(in.stack)-1]
+	in.prog, in.vars, in.order, in.pc = a.prog, a.vars, a.order, a.pc
+	if a.dst != "" {
+		if err := in.assign(a.dst, val); err != nil {
+			return err
+		}
+	}
+	in.pc++
+	return nil
+}
+
+// step executes a single instruction.
+func (in *interp) step() error {
+	code := in.prog.code[in.pc]
+	next := in.pc + 1
+	switch code.op {
+	case opLabel:
+	case opCopy, opBinary:
+		val, err := in.eval(code)
+		if err != nil {
+			return err
+		}
+		if err := in.assign(code.dst, val); err != nil {
+			return err
+		}
+	case opLoad:
+		v, i, err := in.array(code.arg1, code.index)
+		if err != nil {
+			return err
+		}
+		if err := in.assign(code.dst, v.cells[i]); err != nil {
+			return err
+		}
+	case opStore:
+		v, i, err := in.array(code.dst, code.index)
+		if err != nil {
+			return err
+		}
+		val, err := in.operand(code.arg1)
+		if err != nil {
+			return err
+		}
+		v.cells[i] = val.as(v.decl._type)
+	case opGoto:
+		next = in.prog.labels[code.label]
+	case opIf, opIfFalse:
+		val, err := in.eval(code)
+		if err != nil {
+			return err
+		}
+		if val.truth() == (code.op == opIf) {
+			next = in.prog.labels[code.label]
+		}
+	case opTable:
+		val, err := in.operand(code.arg1)
+		if err != nil {
+			return err
+		}
+		if val.isfloat {
+			return in.errorf("indexed goto on float %s", val)
+		}
+		next = in.prog.labels[code.label]
+		if val.i >= 0 && val.i < int64(len(code.table)) {
+			next = in.prog.labels[code.table[val.i]]
+		}
+	case opParam:
+		val, err := in.operand(code.arg1)
+		if err != nil {
+			return err
+		}
+		in.params = append(in.params, val)
+	case opCall:
+		return in.call(code)
+	case opReturn:
+		if len(in.stack) == 0 {
+			return in.errorf("return outside function")
+		}
+		var val value
+		if code.arg1 != "" {
+			v, err := in.operand(code.arg1)
+			if err != nil {
+				return err
+			}
+			val = v
+		}
+		return in.ret(val)
+	default:
+		return in.errorf("unknown opcode %d", code.op)
+	}
+	in.pc = next
+	return nil
+}
+
+// run executes the program until control falls off the end of the main code.
+func (in *interp) run() error {
+	for {
+		if in.pc >= len(in.prog.code) {
+			if len(in.stack) == 0 {
+				return nil
+			}
+			if err := in.ret(intval(0)); err != nil {
+				return err
+			}
+			continue
+		}
+		if in.maxsteps > 0 && in.steps >= in.maxsteps {
+			return fmt.Errorf("step limit %d exceeded at line %d", in.maxsteps, in.prog.code[in.pc].lineno)
+		}
+		if err := in.step(); err != nil {
+			return err
+		}
+		in.steps++
+	}
+}
+
+// dump writes the contents of memory, declared variables first.
+func (in *interp) dump(w io.Writer) {
+	for _, id := range in.order {
+		v := in.vars[id]
+		switch {
+		case v.decl._type == "":
+			fmt.Fprintf(w, "%s = %s\n", id, v.cells[0])
+		case v.decl.size < 0:
+			fmt.Fprintf(w, "%s %s = %s\n", id, v.decl._type, v.cells[0])
+		default:
+			cells := make([]string, len(v.cells))
+			for i, c := range v.cells {
+				cells[i] = c.String()
+			}
+			fmt.Fprintf(w, "%s %s[%d] = [%s]\n", id, v.decl._type, v.decl.size, strings.Join(cells, " "))
+		}
+	}
+}
@@ -0,0 +1,380 @@
+package trans
+
+import (
+	"strings"
+	"testing"
+)
+
+// partition is the quicksort inner loop from the README.
+const partition = `
+{
+    int i; int j; float[8] a; float v; float x;
+    while ( true ) {
+        do i = i+1; while ( a[i] < v );
+        do j = j-1; while ( a[j] > v );
+        if ( i >= j ) break;
+        x = a[i]; a[i] = a[j]; a[j] = x;
+    }
+}
+`
+
+// functions exercises recursion, nested calls, local arrays, procedures and
+// the conversion of arguments and results to float.
+const functions = `
+int fact ( int n ) {
+    if ( n <= 1 ) return 1;
+    return n * fact ( n - 1 );
+}
+int fib ( int n ) {
+    int a; int b; int t;
+    b = 1;
+    while ( n > 0 ) { t = a + b; a = b; b = t; n = n - 1; }
+    return a;
+}
+int squares ( int n ) {
+    int[10] s; int i; int total;
+    while ( i < n ) { s[i] = i * i; i = i + 1; }
+    s[0] = s[1] + s[2];
+    i = 0;
+    while ( i < n ) { total = total + s[i]; i = i + 1; }
+    return total;
+}
+int max ( int a, int b ) {
+    if ( a > b ) return a;
+    return b;
+}
+void touch ( int x ) {
+    x = x + 1;
+    return;
+}
+float half ( float x ) {
+    return x / 2;
+}
+float thrice ( int x ) {
+    return x * 3;
+}
+{
+    int n; int f; int g; int s; int m; float h; float q;
+    f = fact ( n );
+    g = fib ( fact ( 3 ) + 1 );
+    s = squares ( n );
+    touch ( n );
+    m = max ( fib ( n ), max ( n, 2 ) * 3 ) + fact ( 2 );
+    h = half ( 7 );
+    q = thrice ( n ) / 4;
+}
+`
+
+// statements exercises for, else, continue and both lowerings of switch.
+const statements = `
+{
+    int i; int s; int e; int o; int c; int d; int w; int n; int k; int f;
+    for ( i = 0; i < 10; i = i + 1 ) {
+        if ( i % 2 == 0 ) e = e + i; else o = o + i;
+        if ( i == 7 ) continue;
+        s = s + i;
+    }
+    i = 0;
+    do { i = i + 1; if ( i < 5 ) continue; c = c + i; } while ( i < 8 );
+    i = 0;
+    while ( i < 20 ) {
+        i = i + 1;
+        switch ( i % 6 ) {
+        case 0: d = d + 100; break;
+        case 1: d = d + 1;
+        case 2: d = d + 2; break;
+        case 4: continue;
+        case 5: d = d + 5;
+        default: d = d + 1000;
+        }
+        w = w + 1;
+    }
+    switch ( n ) { case 3: k = 3; break; case 300: k = 300; break; case -40: k = 0 - 40; break; default: k = 1; }
+    for ( ; ; ) { f = f + 1; if ( f >= 3 ) break; }
+    if ( f ) if ( 0 ) n = 1; else n = 2;
+}
+`
+
+func runsource(t *testing.T, raw string, maxsteps int, inits ...string) (*interp, error) {
+	t.Helper()
+	output, err := translate(raw, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := parsetac(output)
+	if err != nil {
+		t.Fatalf("cannot parse generated code: %v\n%s", err, output)
+	}
+	in, err := newinterp(prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in.maxsteps = maxsteps
+	for _, s := range inits {
+		if err := in.set(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return in, in.run()
+}
+
+func TestPartition(t *testing.T) {
+	// a[0] is the pivot and a[7] a sentinel that stops i
+	in, err := runsource(t, partition, 10000, "a=5,3,8,1,9,2,7,100", "v=5", "i=0", "j=7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	in.dump(&b)
+	j := int(in.vars["j"].cells[0].i)
+	a := in.vars["a"].cells
+	for k := 1; k <= j; k++ {
+		if a[k].f > 5 {
+			t.Errorf("a[%d] = %s above pivot left of j = %d\n%s", k, a[k], j, b.String())
+		}
+	}
+	for k := j + 1; k < 7; k++ {
+		if a[k].f < 5 {
+			t.Errorf("a[%d] = %s below pivot right of j = %d\n%s", k, a[k], j, b.String())
+		}
+	}
+	want := []float64{5, 3, 2, 1, 9, 8, 7, 100}
+	for k, w := range want {
+		if a[k].f != w {
+			t.Errorf("a[%d] = %s, want %g", k, a[k], w)
+		}
+	}
+}
+
+func TestCalls(t *testing.T) {
+	in, err := runsource(t, functions, 100000, "n=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{"n": 5, "f": 120, "g": 13, "s": 35, "m": 17}
+	for id, w := range want {
+		if got := in.vars[id].cells[0].i; got != w {
+			t.Errorf("%s = %d, want %d", id, got, w)
+		}
+	}
+	for id, w := range map[string]float64{"h": 3.5, "q": 3.75} {
+		if got := in.vars[id].cells[0].float(); got != w {
+			t.Errorf("%s = %g, want %g", id, got, w)
+		}
+	}
+	if len(in.stack) != 0 {
+		t.Errorf("%d activations remain", len(in.stack))
+	}
+
+	errs := []struct {
+		raw, err string
+	}{
+		{"{ int x; x = f ( 1 ); }", "undefined function f"},
+		{"int f ( int a ) { return a; } { int x; x = f ( 1, 2 ); }", "takes 1 arguments"},
+		{"void p ( ) { return; } { int x; x = p ( ); }", "used as value"},
+		{"{ int x; return x; }", "outside function"},
+		{"int f ( ) { return; } { f ( ); }", "must return a value"},
+		{"void p ( ) { return 1; } { p ( ); }", "cannot return a value"},
+		{"int f ( ) { return 1; } int f ( ) { return 2; } { f ( ); }", "redefined"},
+	}
+	for _, tc := range errs {
+		if _, err := translate(tc.raw, true); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: expected error containing %q, got %v", tc.raw, tc.err, err)
+		}
+	}
+
+	tacerrs := []struct {
+		code, err string
+	}{
+		{"return\n", "return outside function"},
+		{"function f int\n", "not ended"},
+		{"function f int\nreturn\nend\n", "does not match"},
+		{"function f void\nend\nt0 = call f, 0\n", "used as value"},
+		{"function f void\nformal x int\nend\ncall f, 0\n", "takes 1 arguments"},
+		{"declare x int\nformal y int\n", "formal must"},
+	}
+	for _, tc := range tacerrs {
+		if _, err := parsetac(tc.code); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: expected error containing %q, got %v", tc.code, tc.err, err)
+		}
+	}
+}
+
+// extremecases is a switch whose cases span more than int64 holds.
+const extremecases = `{
+    int x; int k;
+    switch ( x ) {
+        case 9223372036854775807: k = 1; break;
+        case -9223372036854775808: k = 2; break;
+        case 0: k = 3; break;
+        case 1: k = 4; break;
+    }
+}`
+
+func TestStatements(t *testing.T) {
+	in, err := runsource(t, statements, 100000, "n=-40")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{"e": 20, "o": 25, "s": 38, "c": 26, "d": 6335, "w": 17, "k": -40, "f": 3, "n": 2}
+	for id, w := range want {
+		if got := in.vars[id].cells[0].i; got != w {
+			t.Errorf("%s = %d, want %d", id, got, w)
+		}
+	}
+	output, err := translate(statements, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first switch is dense and the second sparse
+	for _, want := range []string{" [ ", " else ", "if n == 300 goto"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in\n%s", want, output)
+		}
+	}
+	// the span of these cases overflows int64
+	output, err = translate(extremecases, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(output, " [ ") || !strings.Contains(output, "if x == 9223372036854775807 goto") {
+		t.Errorf("expected a sequence of tests in\n%s", output)
+	}
+	in, err = runsource(t, extremecases, 1000, "x=-9223372036854775808")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k := in.vars["k"].cells[0].i; k != 2 {
+		t.Errorf("least case: k = %d, want 2", k)
+	}
+
+	errs := []struct {
+		raw, err string
+	}{
+		{"{ int x; continue; }", "outside loop"},
+		{"{ int x; switch ( x ) { case 1: continue; } }", "outside loop"},
+		{"{ int x; switch ( x ) { case 1: x = 1; case 1: x = 2; } }", "duplicate case 1"},
+		{"{ int x; switch ( x ) { default: x = 1; default: x = 2; } }", "more than one default"},
+		{"{ int x; x = 99999999999999999999; }", "constant 99999999999999999999 overflows int"},
+		{"{ int x; switch ( x ) { case -9223372036854775809: x = 1; } }", "constant -9223372036854775809 overflows int"},
+	}
+	for _, tc := range errs {
+		if _, err := translate(tc.raw, true); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: expected error containing %q, got %v", tc.raw, tc.err, err)
+		}
+	}
+}
+
+func TestInterp(t *testing.T) {
+	tests := []struct {
+		name  string
+		code  string
+		inits []string
+		dump  string
+		err   string
+	}{
+		{
+			name: "arithmetic",
+			code: "declare x int\ndeclare y float\nt0 = 7 / 2\nx = t0\nt1 = x * 1.5\ny = t1\n",
+			dump: "x int = 3\ny float = 4.5\nt0 = 3\nt1 = 4.5\n",
+		},
+		{
+			name: "truncation",
+			code: "declare x int\nx = 2.75\n",
+			dump: "x int = 2\n",
+		},
+		{
+			name: "relational goto",
+			code: "declare n int\nL0:\nif n >= 3 goto L1\nn = n + 1\ngoto L0\nL1:\n",
+			dump: "n int = 3\n",
+		},
+		{
+			name:  "injected array",
+			code:  "declare a int[3]\ndeclare s int\ns = a [ 2 ]\na [ 0 ] = s\n",
+			inits: []string{"a[1]=4,9"},
+			dump:  "a int[3] = [9 4 9]\ns int = 9\n",
+		},
+		{
+			name: "bounds",
+			code: "declare a int[3]\na [ 3 ] = 1\n",
+			err:  "out of range",
+		},
+		{
+			name: "undefined",
+			code: "declare x int\nx = t0\n",
+			err:  "before assignment",
+		},
+		{
+			name: "overflow",
+			code: "declare x int\nx = 99999999999999999999\n",
+			err:  "constant 99999999999999999999 overflows int",
+		},
+		{
+			name: "huge array",
+			code: "declare a int[99999999999999]\na [ 0 ] = 1\n",
+			err:  "array a of 99999999999999 elements exceeds the limit",
+		},
+		{
+			name: "step limit",
+			code: "L0:\ngoto L0\n",
+			err:  "step limit",
+		},
+		{
+			name: "call",
+			code: "function half float\nformal x float\nt0 = x / 2\nreturn t0\nend\n" +
+				"declare y int\nparam 5\nt1 = call half, 1\ny = t1\n",
+			dump: "y int = 2\nt1 = 2.5\n",
+		},
+		{
+			name: "indexed goto",
+			code: "declare x int\ngoto 1 [ L0 L1 ] else L2\nL0:\nx = 10\nL1:\nx = x + 1\nL2:\n",
+			dump: "x int = 1\n",
+		},
+		{
+			name: "indexed goto out of range",
+			code: "declare x int\ngoto -1 [ L0 ] else L1\nL0:\nx = 10\nL1:\n",
+			dump: "x int = 0\n",
+		},
+		{
+			name: "recursion limit",
+			code: "function f void\ncall f, 0\nend\ncall f, 0\n",
+			err:  "step limit",
+		},
+	}
+	for _, tc := range tests {
+		prog, err := parsetac(tc.code)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		in, err := newinterp(prog)
+		if err != nil {
+			if tc.err == "" || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: %v", tc.name, err)
+			}
+			continue
+		}
+		in.maxsteps = 100
+		for _, s := range tc.inits {
+			if err := in.set(s); err != nil {
+				t.Errorf("%s: %v", tc.name, err)
+			}
+		}
+		err = in.run()
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: expected error containing %q, got %v", tc.name, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		var b strings.Builder
+		in.dump(&b)
+		if b.String() != tc.dump {
+			t.Errorf("%s: expected dump\n%s\ngot\n%s", tc.name, tc.dump, b.String())
+		}
+	}
+}
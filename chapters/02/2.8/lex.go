@@ -1,10 +1,12 @@
-package main
+package trans
 
 import (
 	"fmt"
 	"regexp"
 	"strings"
 	"unicode"
+
+	"github.com/akiarie/dragon-tests/source"
 )
 
 type tokenclass string
@@ -20,12 +22,13 @@ const (
 	tkOp                     = "op"
 	tkRel                    = "rel"
 	tkAssign                 = "assign"
+	tkEOF                    = "eof"
 )
 
 type token struct {
 	class tokenclass
 	value string
-	pos   int // position of lexeme in input stream
+	span  source.Span
 }
 
 func (tk token) String() string {
@@ -33,26 +36,36 @@ func (tk token) String() string {
 }
 
 type lexer struct {
+	file  *source.File
 	input string
 	pos   int
-	lines []int
+}
+
+// isword reports whether input begins with the word w, rather than with an
+// identifier of which w is a prefix (as `format` begins with `for`).
+func isword(input, w string) bool {
+	if !strings.HasPrefix(input, w) {
+		return false
+	}
+	if len(input) == len(w) {
+		return true
+	}
+	c := input[len(w)]
+	return !(c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9')
 }
 
 func parsetoken(l *lexer) (*token, error) {
 	// space
 	st := l.pos
-	for i, c := range l.input[l.pos:] {
+	for _, c := range l.input[l.pos:] {
 		if !unicode.IsSpace(c) {
 			break
 		}
-		if c == '\n' {
-			l.lines = append(l.lines, i+l.pos)
-		}
 		st++
 	}
 	if st > l.pos {
 		if st >= len(l.input) {
-			tk := &token{class: tkSpace, value: tkSpace, pos: l.pos}
+			tk := &token{class: tkSpace, value: tkSpace}
 			l.pos += len(l.input[l.pos:])
 			return tk, nil
 		}
@@ -62,8 +75,8 @@ func parsetoken(l *lexer) (*token, error) {
 	}
 
 	// punct
-	if strings.IndexByte("{}()[];", l.input[l.pos]) != -1 {
-		tk := &token{class: tkPunctuation, value: fmt.Sprintf("%c", l.input[l.pos]), pos: l.pos}
+	if strings.IndexByte("{}()[];,:", l.input[l.pos]) != -1 {
+		tk := &token{class: tkPunctuation, value: fmt.Sprintf("%c", l.input[l.pos])}
 		l.pos++
 		return tk, nil
 	}
@@ -71,17 +84,17 @@ func parsetoken(l *lexer) (*token, error) {
 	// rel
 	if strings.IndexByte("=<>!", l.input[l.pos]) != -1 {
 		if l.pos+1 < len(l.input) && l.input[l.pos+1] == '=' {
-			tk := &token{class: tkRel, value: l.input[l.pos : l.pos+2], pos: l.pos}
+			tk := &token{class: tkRel, value: l.input[l.pos : l.pos+2]}
 			l.pos += 2
 			return tk, nil
 		}
 		switch c := l.input[l.pos]; c {
 		case '=':
-			tk := &token{class: tkAssign, value: "=", pos: l.pos}
+			tk := &token{class: tkAssign, value: "="}
 			l.pos++
 			return tk, nil
 		case '<', '>':
-			tk := &token{class: tkRel, value: fmt.Sprintf("%c", c), pos: l.pos}
+			tk := &token{class: tkRel, value: fmt.Sprintf("%c", c)}
 			l.pos++
 			return tk, nil
 		}
@@ -89,24 +102,25 @@ func parsetoken(l *lexer) (*token, error) {
 
 	// op
 	if strings.IndexByte("+-*/%", l.input[l.pos]) != -1 {
-		tk := &token{class: tkOp, value: fmt.Sprintf("%c", l.input[l.pos]), pos: l.pos}
+		tk := &token{class: tkOp, value: fmt.Sprintf("%c", l.input[l.pos])}
 		l.pos++
 		return tk, nil
 	}
 
 	// type
-	for _, t := range []string{"int", "float"} {
-		if strings.Index(l.input[l.pos:], t) == 0 {
-			tk := &token{class: tkType, value: t, pos: l.pos}
+	for _, t := range []string{"int", "float", "void"} {
+		if isword(l.input[l.pos:], t) {
+			tk := &token{class: tkType, value: t}
 			l.pos += len(t)
 			return tk, nil
 		}
 	}
 
 	// keyword
-	for _, t := range []string{"do", "while", "if", "break"} {
-		if strings.Index(l.input[l.pos:], t) == 0 {
-			tk := &token{class: tkKeyword, value: t, pos: l.pos}
+	for _, t := range []string{"do", "while", "if", "else", "for", "break", "continue", "return",
+		"switch", "case", "default"} {
+		if isword(l.input[l.pos:], t) {
+			tk := &token{class: tkKeyword, value: t}
 			l.pos += len(t)
 			return tk, nil
 		}
@@ -114,8 +128,8 @@ func parsetoken(l *lexer) (*token, error) {
 
 	// bool
 	for _, t := range []string{"false", "true"} {
-		if strings.Index(l.input[l.pos:], t) == 0 {
-			tk := &token{class: tkNum, value: t, pos: l.pos}
+		if isword(l.input[l.pos:], t) {
+			tk := &token{class: tkNum, value: t}
 			l.pos += len(t)
 			return tk, nil
 		}
@@ -124,33 +138,40 @@ func parsetoken(l *lexer) (*token, error) {
 	// id
 	re := regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*`)
 	if match := re.FindString(l.input[l.pos:]); match != "" {
-		tk := &token{class: tkId, value: match, pos: l.pos}
+		tk := &token{class: tkId, value: match}
 		l.pos += len(match)
 		return tk, nil
 	}
 
 	// num
-	re = regexp.MustCompile(`[0-9]+`)
+	re = regexp.MustCompile(`^[0-9]+`)
 	if match := re.FindString(l.input[l.pos:]); match != "" {
-		tk := &token{class: tkNum, value: match, pos: l.pos}
+		tk := &token{class: tkNum, value: match}
 		l.pos += len(match)
 		return tk, nil
 	}
 
-	return nil, fmt.Errorf("Unknown characters %q", l.input[l.pos:])
+	return nil, fmt.Errorf("unexpected character %q", l.input[l.pos])
 }
 
-func tokenize(input string) ([]token, []int, error) {
-	l := &lexer{input: input}
+// tokenize skips any character that begins no token, reporting it and
+// continuing with the next.
+func tokenize(f *source.File) ([]token, []source.Diagnostic) {
+	l := &lexer{file: f, input: f.Source()}
 	tokens := []token{}
-	for l.pos < len(input) {
+	var diags []source.Diagnostic
+	for l.pos < len(l.input) {
 		tk, err := parsetoken(l)
 		if err != nil {
-			return nil, nil, err
+			diags = append(diags, source.Diagnostic{Span: f.Span(l.pos, l.pos+1), Msg: err.Error()})
+			l.pos++
+			continue
 		}
 		if tk.class != tkSpace {
+			// every lexeme is the value of its token
+			tk.span = f.Span(l.pos-len(tk.value), l.pos)
 			tokens = append(tokens, *tk)
 		}
 	}
-	return tokens, l.lines, nil
+	return tokens, diags
 }
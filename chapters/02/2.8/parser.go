@@ -1,29 +1,34 @@
-package main
+package trans
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"sort"
+	"strconv"
 	"strings"
 
-	"github.com/fatih/color"
+	"github.com/akiarie/dragon-tests/source"
 )
 
 func newtable() *table {
-	return &table{[]string{}, []string{}, nil}
+	return &table{labels: []string{}, vars: []string{}}
 }
 
 type table struct {
 	labels []string
 	vars   []string
-	escape []string
+	escape []string  // targets of break, innermost last
+	cont   []*string // targets of continue, nil for a switch
+	funcs  map[string]*funcdef
+	fn     *funcdef // function being generated, nil in the main block
 }
 
-func (t *table) enterloop(esc string) {
-	if t.escape == nil {
-		t.escape = []string{esc}
-	} else {
-		t.escape = append(t.escape, esc)
-	}
+// enterloop pushes the targets of break and continue in a loop, or of break
+// alone in a switch if cont is nil. A continue target left empty is given a
+// label when first needed.
+func (t *table) enterloop(esc string, cont *string) {
+	t.escape = append(t.escape, esc)
+	t.cont = append(t.cont, cont)
 }
 
 func (t *table) breakloop(pop bool) (string, error) {
@@ -33,11 +38,24 @@ func (t *table) breakloop(pop bool) (string, error) {
 	n := len(t.escape) - 1
 	esc := t.escape[n]
 	if pop {
-		t.escape = t.escape[:n]
+		t.escape, t.cont = t.escape[:n], t.cont[:n]
 	}
 	return esc, nil
 }
 
+// continueloop returns the continue target of the innermost loop.
+func (t *table) continueloop() (string, error) {
+	for i := len(t.cont) - 1; i >= 0; i-- {
+		if c := t.cont[i]; c != nil {
+			if *c == "" {
+				*c = t.newlabel()
+			}
+			return *c, nil
+		}
+	}
+	return "", fmt.Errorf("cannot continue when outside loop")
+}
+
 func (t *table) newvar() string {
 	t.vars = append(t.vars, fmt.Sprintf("t%d", len(t.vars)))
 	return t.vars[len(t.vars)-1]
@@ -50,24 +68,185 @@ func (t *table) newlabel() string {
 
 type node interface {
 	gen(*parser, *table) error
+	span() source.Span
+}
+
+// spanned is embedded in each node of the syntax tree to record the source
+// it was parsed from.
+type spanned struct {
+	at source.Span
+}
+
+func (s spanned) span() source.Span { return s.at }
+
+// program is a sequence of function definitions followed by the main block.
+// Each function sees only its parameters and the variables it declares.
+type program struct {
+	funcs []*funcdef
+	main  block
+}
+
+func (prog *program) gen(p *parser, t *table) error {
+	// all functions are known before any is generated, so that they may call
+	// each other regardless of order
+	t.funcs = map[string]*funcdef{}
+	for _, f := range prog.funcs {
+		if _, ok := t.funcs[f.id.value]; ok {
+			return fmt.Errorf("function %s redefined", f.id)
+		}
+		t.funcs[f.id.value] = f
+	}
+	for _, f := range prog.funcs {
+		if err := f.gen(p, t); err != nil {
+			return err
+		}
+	}
+	return prog.main.gen(p, t)
+}
+
+type funcdef struct {
+	spanned
+	id,
+	_type token // void for procedures
+	params []*decl
+	body   block
+}
+
+func (f *funcdef) gen(p *parser, t *table) error {
+	fmt.Fprintf(p, "function %s %s\n", f.id, f._type)
+	for _, d := range f.params {
+		fmt.Fprintf(p, "formal %s %s\n", d.id, d._type)
+	}
+	t.fn = f
+	defer func() { t.fn = nil }()
+	if err := f.body.gen(p, t); err != nil {
+		return err
+	}
+	fmt.Fprintf(p, "end\n")
+	return nil
+}
+
+type retstmt struct {
+	spanned
+	val *rel // nil in procedures
+}
+
+func (ret retstmt) gen(p *parser, t *table) error {
+	if t.fn == nil {
+		return fmt.Errorf("return statement outside function")
+	}
+	void := t.fn._type.value == "void"
+	if ret.val == nil {
+		if !void {
+			return fmt.Errorf("function %s must return a value", t.fn.id)
+		}
+		fmt.Fprintf(p, "return\n")
+		return nil
+	}
+	if void {
+		return fmt.Errorf("procedure %s cannot return a value", t.fn.id)
+	}
+	val, err := p.rvalue(*ret.val, t)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(p, "return %s\n", val)
+	return nil
+}
+
+type call struct {
+	spanned
+	id   string
+	args []rel
+}
+
+func (c call) String() string {
+	args := make([]string, len(c.args))
+	for i, a := range c.args {
+		args[i] = a.String()
+	}
+	return fmt.Sprintf("%s ( %s )", c.id, strings.Join(args, ", "))
+}
+
+// rvalue emits the calling sequence, returning the temporary holding the
+// result if there is to be one.
+func (c call) rvalue(p *parser, t *table, result bool) (string, error) {
+	f, ok := t.funcs[c.id]
+	if !ok {
+		return "", fmt.Errorf("call to undefined function %s", c.id)
+	}
+	if len(c.args) != len(f.params) {
+		return "", fmt.Errorf("%s takes %d arguments, not %d", c.id, len(f.params), len(c.args))
+	}
+	if result && f._type.value == "void" {
+		return "", fmt.Errorf("procedure %s used as value", c.id)
+	}
+	// every argument is evaluated before any is passed, so that the params
+	// of calls among the arguments do not interleave with these
+	args := make([]string, len(c.args))
+	for i, a := range c.args {
+		val, err := p.rvalue(a, t)
+		if err != nil {
+			return "", err
+		}
+		args[i] = val
+	}
+	for _, a := range args {
+		fmt.Fprintf(p, "param %s\n", a)
+	}
+	if !result {
+		fmt.Fprintf(p, "call %s, %d\n", c.id, len(args))
+		return "", nil
+	}
+	tmp := t.newvar()
+	fmt.Fprintf(p, "%s = call %s, %d\n", tmp, c.id, len(args))
+	return tmp, nil
 }
 
-type block []node
+type block struct {
+	spanned
+	stmts []node
+}
 
 func (b block) gen(p *parser, t *table) error {
-	for _, stmt := range b {
+	for _, stmt := range b.stmts {
 		if err := stmt.gen(p, t); err != nil {
-			return err
+			return p.locate(stmt, err)
 		}
 	}
 	return nil
 }
 
-type command int
+// locate gives err the position of the statement n in which it arose, unless
+// it arose in a statement within n.
+func (p *parser) locate(n node, err error) error {
+	if _, ok := err.(*semanticerror); ok {
+		return err
+	}
+	return &semanticerror{p.file.Position(n.span().Start), err}
+}
+
+type commandkind int
 
-const comBreak command = iota
+const (
+	comBreak commandkind = iota
+	comContinue
+)
+
+type command struct {
+	spanned
+	kind commandkind
+}
 
 func (com command) gen(p *parser, t *table) error {
+	if com.kind == comContinue {
+		next, err := t.continueloop()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(p, "goto %s\n", next)
+		return nil
+	}
 	if t.escape == nil {
 		return fmt.Errorf("break statement out of loop")
 	}
@@ -80,6 +259,7 @@ func (com command) gen(p *parser, t *table) error {
 }
 
 type decl struct {
+	spanned
 	id,
 	_type token
 	num *token // ptr to indicate w/not this is array
@@ -104,8 +284,10 @@ func (d decl) gen(p *parser, t *table) error {
 }
 
 type ifstmt struct {
+	spanned
 	expr expr
 	stmt node
+	els  node // nil without else
 }
 
 func (_if ifstmt) gen(p *parser, t *table) error {
@@ -114,9 +296,22 @@ func (_if ifstmt) gen(p *parser, t *table) error {
 	if err != nil {
 		return err
 	}
-	fmt.Fprintf(p, "ifFalse %s goto %s\n", _bool, after)
-	err = _if.stmt.gen(p, t)
-	if err != nil {
+	if _if.els == nil {
+		fmt.Fprintf(p, "ifFalse %s goto %s\n", _bool, after)
+		if err := _if.stmt.gen(p, t); err != nil {
+			return err
+		}
+		fmt.Fprintf(p, "%s:\n", after)
+		return nil
+	}
+	els := t.newlabel()
+	fmt.Fprintf(p, "ifFalse %s goto %s\n", _bool, els)
+	if err := _if.stmt.gen(p, t); err != nil {
+		return err
+	}
+	fmt.Fprintf(p, "goto %s\n", after)
+	fmt.Fprintf(p, "%s:\n", els)
+	if err := _if.els.gen(p, t); err != nil {
 		return err
 	}
 	fmt.Fprintf(p, "%s:\n", after)
@@ -124,6 +319,7 @@ func (_if ifstmt) gen(p *parser, t *table) error {
 }
 
 type whilestmt struct {
+	spanned
 	expr expr
 	stmt node
 }
@@ -140,7 +336,7 @@ func (while whilestmt) gen(p *parser, t *table) error {
 		return err
 	}
 	fmt.Fprintf(p, "ifFalse %s goto %s\n", _bool, after)
-	t.enterloop(after)
+	t.enterloop(after, &before)
 	err = while.stmt.gen(p, t)
 	if err != nil {
 		return err
@@ -148,12 +344,13 @@ func (while whilestmt) gen(p *parser, t *table) error {
 	if _, err := t.breakloop(true); err != nil {
 		return err
 	}
-	fmt.Fprintf(p, "goto %s:\n", before)
+	fmt.Fprintf(p, "goto %s\n", before)
 	fmt.Fprintf(p, "%s:\n", after)
 	return nil
 }
 
 type dostmt struct {
+	spanned
 	expr expr
 	stmt node
 }
@@ -161,7 +358,8 @@ type dostmt struct {
 func (do dostmt) gen(p *parser, t *table) error {
 	before, after := t.newlabel(), t.newlabel()
 	fmt.Fprintf(p, "%s:\n", before)
-	t.enterloop(after)
+	var next string
+	t.enterloop(after, &next)
 	err := do.stmt.gen(p, t)
 	if err != nil {
 		return err
@@ -169,12 +367,176 @@ func (do dostmt) gen(p *parser, t *table) error {
 	if _, err := t.breakloop(true); err != nil {
 		return err
 	}
+	if next != "" {
+		fmt.Fprintf(p, "%s:\n", next)
+	}
 	_bool, err := p.rvalue(do.expr, t)
 	if err != nil {
 		return err
 	}
 	fmt.Fprintf(p, "ifFalse %s goto %s\n", _bool, after)
-	fmt.Fprintf(p, "goto %s:\n", before)
+	fmt.Fprintf(p, "goto %s\n", before)
+	fmt.Fprintf(p, "%s:\n", after)
+	return nil
+}
+
+// forstmt has empty exprs for those omitted; an omitted condition holds.
+type forstmt struct {
+	spanned
+	init, cond, step expr
+	stmt             node
+}
+
+func (stmt forstmt) String() string {
+	return fmt.Sprintf("for ( %v ; %v ; %v ) { %v }", stmt.init, stmt.cond, stmt.step, stmt.stmt)
+}
+
+func (_for forstmt) gen(p *parser, t *table) error {
+	if len(_for.init) > 0 {
+		if err := _for.init.gen(p, t); err != nil {
+			return err
+		}
+	}
+	before, after := t.newlabel(), t.newlabel()
+	fmt.Fprintf(p, "%s:\n", before)
+	if len(_for.cond) > 0 {
+		_bool, err := p.rvalue(_for.cond, t)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(p, "ifFalse %s goto %s\n", _bool, after)
+	}
+	var next string
+	t.enterloop(after, &next)
+	if err := _for.stmt.gen(p, t); err != nil {
+		return err
+	}
+	if _, err := t.breakloop(true); err != nil {
+		return err
+	}
+	if next != "" {
+		fmt.Fprintf(p, "%s:\n", next)
+	}
+	if len(_for.step) > 0 {
+		if err := _for.step.gen(p, t); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(p, "goto %s\n", before)
+	fmt.Fprintf(p, "%s:\n", after)
+	return nil
+}
+
+// switchcase is a case label and the statements following it, or the default
+// label if def is set.
+type switchcase struct {
+	spanned
+	value int64
+	def   bool
+	body  block
+}
+
+type switchstmt struct {
+	spanned
+	expr  expr
+	cases []*switchcase
+}
+
+// densecases is the least number of cases for which a switch is given a jump
+// table, provided that at least half of its entries are cases.
+const densecases = 4
+
+// check returns the case values in increasing order and the index of the
+// default case, or -1 if there is none.
+func (sw switchstmt) check() ([]int64, int, error) {
+	var values []int64
+	def, seen := -1, map[int64]bool{}
+	for i, c := range sw.cases {
+		switch {
+		case c.def && def != -1:
+			return nil, -1, fmt.Errorf("switch has more than one default")
+		case c.def:
+			def = i
+		case seen[c.value]:
+			return nil, -1, fmt.Errorf("duplicate case %d in switch", c.value)
+		default:
+			seen[c.value] = true
+			values = append(values, c.value)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return values, def, nil
+}
+
+// dense reports whether the sorted case values merit a jump table. The span
+// is taken unsigned, since that of extreme values overflows int64.
+func dense(values []int64) bool {
+	n := len(values)
+	return n >= densecases && uint64(values[n-1])-uint64(values[0]) < uint64(2*n)
+}
+
+// gen lowers the switch as in Section 6.8, to a jump table indexed by the
+// value less the least case if the cases are dense, and to a sequence of tests
+// otherwise. The bodies follow in order, each falling through to the next,
+// and break leaves the switch.
+func (sw switchstmt) gen(p *parser, t *table) error {
+	values, d, err := sw.check()
+	if err != nil {
+		return err
+	}
+	val, err := p.rvalue(sw.expr, t)
+	if err != nil {
+		return err
+	}
+	after := t.newlabel()
+	bodies, labels := make([]string, len(sw.cases)), map[int64]string{}
+	for i, c := range sw.cases {
+		bodies[i] = t.newlabel()
+		if !c.def {
+			labels[c.value] = bodies[i]
+		}
+	}
+	def := after
+	if d >= 0 {
+		def = bodies[d]
+	}
+	if dense(values) {
+		lo, hi, index := values[0], values[len(values)-1], val
+		if lo != 0 {
+			index = t.newvar()
+			if lo < 0 {
+				fmt.Fprintf(p, "%s = %s + %d\n", index, val, -lo)
+			} else {
+				fmt.Fprintf(p, "%s = %s - %d\n", index, val, lo)
+			}
+		}
+		targets := []string{}
+		for i := int64(0); i <= hi-lo; i++ {
+			if l, ok := labels[lo+i]; ok {
+				targets = append(targets, l)
+			} else {
+				targets = append(targets, def)
+			}
+		}
+		fmt.Fprintf(p, "goto %s [ %s ] else %s\n", index, strings.Join(targets, " "), def)
+	} else {
+		for i, c := range sw.cases {
+			if !c.def {
+				fmt.Fprintf(p, "if %s == %d goto %s\n", val, c.value, bodies[i])
+			}
+		}
+		fmt.Fprintf(p, "goto %s\n", def)
+	}
+	t.enterloop(after, nil)
+	for i, c := range sw.cases {
+		fmt.Fprintf(p, "%s:\n", bodies[i])
+		if err := c.body.gen(p, t); err != nil {
+			return err
+		}
+	}
+	if _, err := t.breakloop(true); err != nil {
+		return err
+	}
 	fmt.Fprintf(p, "%s:\n", after)
 	return nil
 }
@@ -198,10 +560,22 @@ func (expr expr) t() (composite, error) {
 
 func (expr expr) compose(a, b string) string { return fmt.Sprintf("%s = %s", a, b) }
 
+// span is that of the relations, which an omitted expr lacks.
+func (expr expr) span() source.Span {
+	if len(expr) == 0 {
+		return source.Span{}
+	}
+	return source.Join(expr[0].span(), expr[len(expr)-1].span())
+}
+
 func (exp expr) gen(p *parser, t *table) error {
 	if len(exp) == 0 {
 		return fmt.Errorf("cannot generate empty expr")
 	} else if len(exp) == 1 {
+		if c, ok := exp[0].call(); ok {
+			_, err := c.rvalue(p, t, false)
+			return err
+		}
 		fmt.Fprintf(p, "%s\n", exp[0])
 		return nil
 	}
@@ -227,6 +601,7 @@ func (ex expr) String() string {
 }
 
 type rel struct {
+	spanned
 	head arithm
 	tail *struct {
 		boolop token
@@ -246,6 +621,15 @@ func (rel rel) compose(a, b string) string {
 	return fmt.Sprintf("%s %s %s", a, rel.tail.boolop, b)
 }
 
+// call returns the call of which the relation consists, if it is just that.
+func (r rel) call() (call, bool) {
+	if r.tail != nil || r.head.tail != nil || r.head.head.tail != nil {
+		return call{}, false
+	}
+	c, ok := r.head.head.head.node.(call)
+	return c, ok
+}
+
 func (t rel) String() string {
 	if t.tail == nil {
 		return fmt.Sprintf("%s", t.head)
@@ -254,6 +638,7 @@ func (t rel) String() string {
 }
 
 type arithm struct {
+	spanned
 	head term
 	tail *struct {
 		sign token
@@ -280,6 +665,7 @@ func (t arithm) String() string {
 }
 
 type term struct {
+	spanned
 	head factor
 	tail *struct {
 		op token
@@ -312,9 +698,11 @@ const (
 	factypeBool
 	factypeConst
 	factypeAccess
+	factypeCall
 )
 
 type factor struct {
+	spanned
 	ftype factype
 	node  interface{}
 }
@@ -339,6 +727,9 @@ func (f factor) rvalue(p *parser, t *table) (string, error) {
 		tmp := t.newvar()
 		fmt.Fprintf(p, "%s = %s\n", tmp, lval)
 		return tmp, nil
+	case factypeCall:
+		c, _ := f.node.(call)
+		return c.rvalue(p, t, true)
 	default:
 		return "", fmt.Errorf("unknown factor %T as %v", f, f)
 	}
@@ -346,6 +737,7 @@ func (f factor) rvalue(p *parser, t *table) (string, error) {
 }
 
 type access struct {
+	spanned
 	id string
 	arithm
 }
@@ -359,10 +751,10 @@ type stream []token
 type parser struct {
 	showdecl bool
 	pos      int
-	lines    []int
-	raw      string
+	file     *source.File
 	input    stream
 	output   string
+	diags    []source.Diagnostic
 }
 
 func (p *parser) Write(b []byte) (int, error) {
@@ -370,43 +762,69 @@ func (p *parser) Write(b []byte) (int, error) {
 	return len(b), nil
 }
 
-func min(a, b int) int {
-	if a < b {
-		return a
+// bailout unwinds the parser from a syntax error to the statement being
+// parsed, which recovers by skipping to its end.
+type bailout struct{}
+
+// peek returns the next token, or an empty one at the end of the input.
+func (p *parser) peek() token {
+	if p.pos < len(p.input) {
+		return p.input[p.pos]
 	}
-	return b
+	return token{class: tkEOF, span: p.end()}
 }
 
-// error is a wretched syntax-error reporter but I don't have the time or
-// energy to clean it up right now :(
-func (p *parser) error(format string, a ...interface{}) error {
-	linelen := len(p.raw)
-	linepos := 0
-	linenum := 0
-	pos := p.input[p.pos].pos
-	for i, l := range p.lines {
-		if pos < l {
-			linelen = l - linepos
-			break
-		}
-		linenum = i
-		linepos = l
+// next consumes the next token.
+func (p *parser) next() token {
+	tk := p.peek()
+	if p.pos < len(p.input) {
+		p.pos++
 	}
-	fmt.Fprintf(os.Stderr, "error: %s at position %d on line %d:\n", fmt.Sprintf(format, a...), pos-linepos, linenum+1)
-	if linenum > 0 {
-		fmt.Fprintf(os.Stderr, "%d %s\n", linenum, p.raw[p.lines[linenum-1]+1:linepos])
+	return tk
+}
+
+// here is the span of the next token.
+func (p *parser) here() source.Span { return p.peek().span }
+
+// describe names the next token for a diagnostic.
+func (p *parser) describe() string { return describe(p.input[p.pos:], 0) }
+
+// at is the span of input[i], or the end of the source if there is none.
+func (p *parser) at(input stream, i int) source.Span {
+	if i < len(input) {
+		return input[i].span
+	}
+	return p.end()
+}
+
+// end is the empty span at the end of the source.
+func (p *parser) end() source.Span {
+	end := p.file.Pos(p.file.Size())
+	return source.Span{Start: end, End: end}
+}
+
+func describe(input stream, i int) string {
+	if i < len(input) {
+		return fmt.Sprintf("%q", input[i].value)
 	}
-	bftk := fmt.Sprintf("%d %s", linenum+1, p.raw[linepos+1:pos])
-	aftk := fmt.Sprintf("%s\n", p.raw[pos+len(p.input[p.pos].value):linepos+linelen])
-	red := color.New(color.FgRed, color.Bold)
-	red.Fprint(os.Stderr, bftk)
-	color.New(color.Bold).Fprint(os.Stderr, p.input[p.pos].value)
-	red.Fprint(os.Stderr, aftk)
-	if linenum+1 < len(p.lines) {
-		fmt.Fprintf(os.Stderr, "%d %s\n", linenum+2, p.raw[p.lines[linenum+2]+1:p.lines[min(linenum+3, len(p.lines))]])
+	return "end of input"
+}
+
+// errorat records a syntax error at sp.
+//
+// A second error at the same place is most likely a consequence of the
+// first, and is left out.
+func (p *parser) errorat(sp source.Span, format string, a ...interface{}) {
+	if n := len(p.diags); n > 0 && p.diags[n-1].Span.Start == sp.Start {
+		return
 	}
-	os.Exit(1)
-	return fmt.Errorf("HACK")
+	p.diags = append(p.diags, source.Diagnostic{Span: sp, Msg: fmt.Sprintf(format, a...)})
+}
+
+// fail records a syntax error at sp and abandons the statement being parsed.
+func (p *parser) fail(sp source.Span, format string, a ...interface{}) {
+	p.errorat(sp, format, a...)
+	panic(bailout{})
 }
 
 func (p *parser) lvalue(stmt interface{}, t *table) (string, error) {
@@ -493,115 +911,374 @@ func (p *parser) rvalue(cmp composite, t *table) (string, error) {
 	}
 }
 
-func (p *parser) block() block {
-	p.punct('{')
-	stmts := []node{}
-	for p.pos < len(p.input) {
-		// if next token is not end of block, must parse stmt
-		if tk := p.input[p.pos]; tk.value == "}" {
-			break
+// program parses the function definitions preceding the main block.
+func (p *parser) program() *program {
+	prog := &program{}
+	for p.peek().class == tkType {
+		if f := p.funcdef(); f != nil {
+			prog.funcs = append(prog.funcs, f)
 		}
-		stmts = append(stmts, p.stmt())
 	}
-	p.punct('}')
-	return stmts
+	prog.main = p.block()
+	if p.pos < len(p.input) {
+		p.errorat(p.here(), "unexpected %s after main block", p.describe())
+	}
+	return prog
 }
 
-func (p *parser) punct(c byte) {
-	tk := p.input[p.pos]
-	if tk.class != tkPunctuation || tk.value[0] != c {
-		p.error(fmt.Sprintf("expected %q got %q near", c, tk.value))
+// funcdef parses a function definition:
+//
+//	type id ( [ type id { , type id } ] ) block
+//
+// An error in the header abandons the whole definition.
+func (p *parser) funcdef() (f *funcdef) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(bailout); !ok {
+				panic(r)
+			}
+			p.skipblock()
+			f = nil
+		}
+	}()
+	f = &funcdef{_type: p.next()}
+	start := f._type.span
+	if f.id = p.peek(); f.id.class != tkId {
+		p.fail(p.here(), "function definition must have identifier, found %s", p.describe())
 	}
 	p.pos++
+	p.punct('(')
+	for p.pos < len(p.input) && p.peek().value != ")" {
+		if len(f.params) > 0 {
+			p.punct(',')
+		}
+		_type := p.peek()
+		if _type.class != tkType || _type.value == "void" {
+			p.fail(p.here(), "parameter must have type int or float, found %s", p.describe())
+		}
+		p.pos++
+		id := p.peek()
+		if id.class != tkId {
+			p.fail(p.here(), "parameter must have identifier, found %s", p.describe())
+		}
+		p.pos++
+		f.params = append(f.params, &decl{spanned{source.Join(_type.span, id.span)}, id, _type, nil})
+	}
+	p.punct(')')
+	f.body = p.block()
+	f.at = p.from(start)
+	return f
 }
 
-func (p *parser) stmt() node {
-	// expr ;
-	if expr, step, err := p.expr(p.input[p.pos:]); err == nil {
-		p.pos += step
-		p.punct(';')
-		return expr
+func (p *parser) block() block {
+	start := p.here()
+	p.punct('{')
+	b := block{}
+	for p.pos < len(p.input) && p.peek().value != "}" {
+		if stmt := p.recoverstmt(); stmt != nil {
+			b.stmts = append(b.stmts, stmt)
+		}
+	}
+	p.punct('}')
+	b.at = p.from(start)
+	return b
+}
+
+// from is the span from start to the end of the last token consumed.
+func (p *parser) from(start source.Span) source.Span {
+	if p.pos == 0 {
+		return start
 	}
+	return source.Join(start, p.input[p.pos-1].span)
+}
 
-	// decl ;
-	if _type := p.input[p.pos]; _type.class == tkType {
-		p.pos++
+// extent is the span of the first n tokens of input.
+func extent(input stream, n int) source.Span {
+	return source.Join(input[0].span, input[n-1].span)
+}
 
-		// arrays
-		var num *token
-		if tk := p.input[p.pos]; tk.class == tkPunctuation && tk.value == "[" {
-			p.punct('[')
-			if tk := p.input[p.pos]; tk.class != tkNum {
-				p.error("array declaration must have number as size")
-			} else {
-				num = &tk
+// recoverstmt parses a statement, recovering from a syntax error within it by
+// skipping to the end of the statement (panic mode). It returns nil for a
+// statement in error.
+func (p *parser) recoverstmt() (stmt node) {
+	start := p.pos
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(bailout); !ok {
+				panic(r)
 			}
-			p.pos++
-			p.punct(']')
+			p.sync(start)
+			stmt = nil
 		}
+	}()
+	return p.stmt()
+}
 
-		id := p.input[p.pos]
-		if id.class != tkId {
-			p.error("declaration must have identifier")
+// sync skips past the next ';' outside braces, or past the '}' closing a
+// block opened since the statement began, or up to the '}' closing the
+// enclosing block, consuming at least one token.
+func (p *parser) sync(start int) {
+	if p.pos == start && p.peek().value != "}" {
+		p.next()
+	}
+	depth := 0
+	for i := start; i < p.pos; i++ {
+		switch p.input[i].value {
+		case "{":
+			depth++
+		case "}":
+			depth--
+		}
+	}
+	for p.pos < len(p.input) {
+		switch p.peek().value {
+		case ";":
+			if depth <= 0 {
+				p.pos++
+				return
+			}
+		case "{":
+			depth++
+		case "}":
+			if depth <= 0 {
+				return
+			}
+			if depth--; depth == 0 {
+				p.pos++
+				return
+			}
 		}
 		p.pos++
-		p.punct(';')
-		return &decl{id, _type, num}
 	}
+}
 
-	// if ( expr ) stmt
-	if tk := p.input[p.pos]; tk.class == tkKeyword && tk.value == "if" {
+// skipblock skips up to and including the next block.
+func (p *parser) skipblock() {
+	for p.pos < len(p.input) && p.peek().value != "{" {
 		p.pos++
-		p.punct('(')
-		if expr, step, err := p.expr(p.input[p.pos:]); err == nil {
-			p.pos += step
-			p.punct(')')
-			return ifstmt{expr, p.stmt()}
-		} else {
-			p.error("if statement must include expression: %v", err)
+	}
+	depth := 0
+	for p.pos < len(p.input) {
+		switch p.next().value {
+		case "{":
+			depth++
+		case "}":
+			if depth--; depth == 0 {
+				return
+			}
 		}
 	}
+}
 
-	// while ( expr ) stmt
-	if tk := p.input[p.pos]; tk.class == tkKeyword && tk.value == "while" {
+// punct expects the punctuation c. If it is missing the error is reported and
+// parsing continues as though c had been inserted (phrase-level recovery).
+func (p *parser) punct(c byte) {
+	if tk := p.peek(); tk.class == tkPunctuation && tk.value[0] == c {
 		p.pos++
-		p.punct('(')
-		if expr, step, err := p.expr(p.input[p.pos:]); err == nil {
-			p.pos += step
-			p.punct(')')
-			return whilestmt{expr, p.stmt()}
-		} else {
-			p.error("while statement must include expression: %v", err)
+		return
+	}
+	// the token is missing after the last, rather than before the next
+	sp := p.here()
+	if p.pos > 0 {
+		end := p.input[p.pos-1].span.End
+		sp = source.Span{Start: end, End: end}
+	}
+	p.errorat(sp, "expected '%c', found %s", c, p.describe())
+}
+
+// cond parses the parenthesised expression following the keyword of stmt.
+func (p *parser) cond(stmt string) expr {
+	p.punct('(')
+	expr, step, err := p.expr(p.input[p.pos:])
+	if err != nil {
+		p.fail(p.here(), "%s statement must include expression, found %s", stmt, p.describe())
+	}
+	p.pos += step
+	p.punct(')')
+	return expr
+}
+
+func (p *parser) stmt() node {
+	switch tk := p.peek(); {
+	case tk.class == tkType:
+		return p.decl()
+	case tk.class == tkPunctuation && tk.value == "{":
+		return p.block()
+	case tk.class != tkKeyword:
+		// expr ;
+		expr, step, err := p.expr(p.input[p.pos:])
+		if err != nil {
+			p.fail(p.here(), "expected statement, found %s", p.describe())
 		}
+		p.pos += step
+		p.punct(';')
+		return expr
 	}
 
-	// do stmt while ( expr ) ;
-	if tk := p.input[p.pos]; tk.class == tkKeyword && tk.value == "do" {
-		p.pos++
+	tk := p.next()
+	start := tk.span
+	switch tk.value {
+	case "if":
+		// if ( expr ) stmt [ else stmt ]
+		// an else belongs to the nearest if, which is still being parsed
+		_if := ifstmt{expr: p.cond("if"), stmt: p.stmt()}
+		if tk := p.peek(); tk.class == tkKeyword && tk.value == "else" {
+			p.pos++
+			_if.els = p.stmt()
+		}
+		_if.at = p.from(start)
+		return _if
+	case "while":
+		// while ( expr ) stmt
+		expr := p.cond("while")
 		stmt := p.stmt()
-		if tk := p.input[p.pos]; tk.class != tkKeyword || tk.value != "while" {
-			p.error("do while statement must include 'while'")
+		return whilestmt{spanned{p.from(start)}, expr, stmt}
+	case "do":
+		// do stmt while ( expr ) ;
+		stmt := p.stmt()
+		if tk := p.peek(); tk.class != tkKeyword || tk.value != "while" {
+			p.fail(p.here(), "do while statement must include 'while', found %s", p.describe())
 		}
 		p.pos++
-		p.punct('(')
-		if expr, step, err := p.expr(p.input[p.pos:]); err == nil {
-			p.pos += step
-			p.punct(')')
-			p.punct(';')
-			return dostmt{expr, stmt}
-		} else {
-			p.error("do while statement must include expression: %v", err)
+		expr := p.cond("do while")
+		p.punct(';')
+		return dostmt{spanned{p.from(start)}, expr, stmt}
+	case "for":
+		return p.forstmt(start)
+	case "switch":
+		return p.switchstmt(start)
+	case "break":
+		p.punct(';')
+		return command{spanned{p.from(start)}, comBreak}
+	case "continue":
+		p.punct(';')
+		return command{spanned{p.from(start)}, comContinue}
+	case "return":
+		// return [ rel ] ;
+		if tk := p.peek(); tk.class == tkPunctuation && tk.value == ";" {
+			p.pos++
+			return retstmt{spanned{p.from(start)}, nil}
+		}
+		val, step, err := p.rel(p.input[p.pos:])
+		if err != nil {
+			p.fail(p.here(), "return statement must include expression, found %s", p.describe())
 		}
+		p.pos += step
+		p.punct(';')
+		return retstmt{spanned{p.from(start)}, val}
+	default:
+		p.pos--
+		p.fail(p.here(), "unexpected %s", p.describe())
+		return nil
 	}
+}
 
-	// break
-	if tk := p.input[p.pos]; tk.class == tkKeyword && tk.value == "break" {
+// decl parses
+//
+//	type [ '[' num ']' ] id ;
+func (p *parser) decl() node {
+	_type := p.next()
+	if _type.value == "void" {
+		p.fail(_type.span, "variables cannot be void")
+	}
+	// arrays
+	var num *token
+	if tk := p.peek(); tk.class == tkPunctuation && tk.value == "[" {
+		p.punct('[')
+		tk := p.peek()
+		if tk.class != tkNum {
+			p.fail(p.here(), "array declaration must have number as size, found %s", p.describe())
+		}
+		num = &tk
 		p.pos++
-		p.punct(';')
-		return comBreak
+		p.punct(']')
+	}
+	id := p.peek()
+	if id.class != tkId {
+		p.fail(p.here(), "declaration must have identifier, found %s", p.describe())
 	}
+	p.pos++
+	p.punct(';')
+	return &decl{spanned{p.from(_type.span)}, id, _type, num}
+}
 
-	return p.block()
+// forstmt parses
+//
+//	for ( optexpr ; optexpr ; optexpr ) stmt
+func (p *parser) forstmt(start source.Span) node {
+	p.punct('(')
+	var _for forstmt
+	for i, opt := range []*expr{&_for.init, &_for.cond, &_for.step} {
+		end := byte(';')
+		if i == 2 {
+			end = ')'
+		}
+		if tk := p.peek(); tk.class != tkPunctuation || tk.value[0] != end {
+			expr, step, err := p.expr(p.input[p.pos:])
+			if err != nil {
+				p.fail(p.here(), "for statement must have expressions or none, found %s", p.describe())
+			}
+			p.pos += step
+			*opt = expr
+		}
+		p.punct(end)
+	}
+	_for.stmt = p.stmt()
+	_for.at = p.from(start)
+	return _for
+}
+
+// switchstmt parses
+//
+//	switch ( expr ) { { case num : | default : | stmt } }
+//
+// where a case may have a negative number.
+func (p *parser) switchstmt(start source.Span) node {
+	sw := switchstmt{expr: p.cond("switch")}
+	p.punct('{')
+	for p.pos < len(p.input) && p.peek().value != "}" {
+		switch tk := p.peek(); {
+		case tk.class == tkKeyword && tk.value == "case":
+			p.pos++
+			label := tk.span
+			sign := ""
+			if tk := p.peek(); tk.class == tkOp && tk.value == "-" {
+				sign = "-"
+				p.pos++
+			}
+			// with its sign, as the least integer has no positive counterpart
+			v, err := strconv.ParseInt(sign+p.peek().value, 10, 64)
+			switch {
+			case p.peek().class == tkNum && errors.Is(err, strconv.ErrRange):
+				p.errorat(p.here(), "constant %s%s overflows int", sign, p.peek().value)
+			case p.peek().class != tkNum || err != nil:
+				p.errorat(p.here(), "case must have integer constant, found %s", p.describe())
+			}
+			if p.peek().value != ":" {
+				p.next()
+			}
+			p.punct(':')
+			sw.cases = append(sw.cases, &switchcase{spanned: spanned{p.from(label)}, value: v})
+		case tk.class == tkKeyword && tk.value == "default":
+			p.pos++
+			p.punct(':')
+			sw.cases = append(sw.cases, &switchcase{spanned: spanned{p.from(tk.span)}, def: true})
+		case len(sw.cases) == 0:
+			// the statement is checked and dropped
+			p.errorat(p.here(), "switch statement must begin with case or default, found %s", p.describe())
+			p.recoverstmt()
+		default:
+			c := sw.cases[len(sw.cases)-1]
+			if stmt := p.recoverstmt(); stmt != nil {
+				c.body.stmts = append(c.body.stmts, stmt)
+				c.body.at = source.Join(c.body.at, stmt.span())
+				c.at = source.Join(c.at, stmt.span())
+			}
+		}
+	}
+	p.punct('}')
+	sw.at = p.from(start)
+	return sw
 }
 
 func (p *parser) expr(input stream) (expr, int, error) {
@@ -616,7 +1293,7 @@ func (p *parser) expr(input stream) (expr, int, error) {
 			step++
 			prev, tot, err := p.expr(input[step:])
 			if err != nil {
-				return nil, -1, p.error(err.Error())
+				p.fail(p.at(input, step), "expected expression after '=', found %s", describe(input, step))
 			}
 			return append(expr{*rel}, prev...), step + tot, nil
 		}
@@ -635,16 +1312,16 @@ func (p *parser) rel(input stream) (*rel, int, error) {
 			step++
 			prev, tot, err := p.rel(input[step:])
 			if err != nil {
-				return nil, -1, p.error(err.Error())
+				p.fail(p.at(input, step), "expected expression after %q, found %s", tk.value, describe(input, step))
 			}
 			tail := struct {
 				boolop token
 				rel
 			}{tk, *prev}
-			return &rel{*arithm, &tail}, step + tot, nil
+			return &rel{spanned{extent(input, step+tot)}, *arithm, &tail}, step + tot, nil
 		}
 	}
-	return &rel{head: *arithm}, step, nil
+	return &rel{spanned: arithm.spanned, head: *arithm}, step, nil
 }
 
 func (p *parser) arithm(input stream) (*arithm, int, error) {
@@ -669,16 +1346,16 @@ func (p *parser) arithm(input stream) (*arithm, int, error) {
 			step++
 			prev, tot, err := p.arithm(input[step:])
 			if err != nil {
-				return nil, -1, p.error(err.Error())
+				p.fail(p.at(input, step), "expected expression after %q, found %s", tk.value, describe(input, step))
 			}
 			tail := struct {
 				sign token
 				arithm
 			}{tk, *prev}
-			return &arithm{*term, &tail}, step + tot, nil
+			return &arithm{spanned{extent(input, step+tot)}, *term, &tail}, step + tot, nil
 		}
 	}
-	return &arithm{head: *term}, step, nil
+	return &arithm{spanned: term.spanned, head: *term}, step, nil
 }
 
 func (p *parser) term(input stream) (*term, int, error) {
@@ -691,25 +1368,32 @@ func (p *parser) term(input stream) (*term, int, error) {
 			step++
 			prev, tot, err := p.term(input[step:])
 			if err != nil {
-				return nil, -1, p.error(err.Error())
+				p.fail(p.at(input, step), "expected expression after %q, found %s", tk.value, describe(input, step))
 			}
 			tail := struct {
 				op token
 				term
 			}{tk, *prev}
-			return &term{*factor, &tail}, step + tot, nil
+			return &term{spanned{extent(input, step+tot)}, *factor, &tail}, step + tot, nil
 		}
 	}
-	return &term{head: *factor}, step, nil
+	return &term{spanned: factor.spanned, head: *factor}, step, nil
 }
 
 func (p *parser) factor(input stream) (*factor, int, error) {
+	if len(input) == 0 {
+		return nil, -1, fmt.Errorf("unexpected end of input")
+	}
 	// num | id
+	one := spanned{input[0].span}
 	switch input[0].class {
 	case tkBool:
-		return &factor{factypeBool, input[0].value == "true"}, 1, nil
+		return &factor{one, factypeBool, input[0].value == "true"}, 1, nil
 	case tkNum:
-		return &factor{factypeConst, input[0].value}, 1, nil
+		if _, err := strconv.ParseInt(input[0].value, 10, 64); errors.Is(err, strconv.ErrRange) {
+			p.errorat(input[0].span, "constant %s overflows int", input[0].value)
+		}
+		return &factor{one, factypeConst, input[0].value}, 1, nil
 	case tkId:
 		if len(input) > 1 {
 			pos := 1
@@ -717,16 +1401,41 @@ func (p *parser) factor(input stream) (*factor, int, error) {
 				pos++
 				arithm, step, err := p.arithm(input[pos:])
 				if err != nil {
-					return nil, -1, p.error("array access must be arithmetic: %v", err)
+					p.fail(p.at(input, pos), "array access must be arithmetic, found %s", describe(input, pos))
 				}
 				pos += step
-				if tk := input[pos]; tk.class != tkPunctuation || tk.value != "]" {
-					return nil, -1, p.error("array access not closed: %v", err)
+				if pos == len(input) || input[pos].class != tkPunctuation || input[pos].value != "]" {
+					p.fail(p.at(input, pos), "expected ']', found %s", describe(input, pos))
+				}
+				acc := access{spanned{extent(input, pos+1)}, input[0].value, *arithm}
+				return &factor{acc.spanned, factypeAccess, acc}, pos + 1, nil
+			}
+			// id ( [ rel { , rel } ] )
+			if tk := input[pos]; tk.class == tkPunctuation && tk.value == "(" {
+				pos++
+				c := call{id: input[0].value}
+				for pos < len(input) && input[pos].value != ")" {
+					if len(c.args) > 0 {
+						if tk := input[pos]; tk.class != tkPunctuation || tk.value != "," {
+							p.fail(tk.span, "expected ',' between arguments, found %q", tk.value)
+						}
+						pos++
+					}
+					arg, step, err := p.rel(input[pos:])
+					if err != nil {
+						p.fail(p.at(input, pos), "argument must be an expression, found %s", describe(input, pos))
+					}
+					c.args = append(c.args, *arg)
+					pos += step
+				}
+				if pos == len(input) {
+					p.fail(p.at(input, pos), "call of %s not closed", c.id)
 				}
-				return &factor{factypeAccess, access{id: input[0].value, arithm: *arithm}}, pos + 1, nil
+				c.at = extent(input, pos+1)
+				return &factor{c.spanned, factypeCall, c}, pos + 1, nil
 			}
 		}
-		return &factor{factypeId, input[0].value}, 1, nil
+		return &factor{one, factypeId, input[0].value}, 1, nil
 	}
 
 	// FIXME: add expr factors
@@ -736,10 +1445,10 @@ func (p *parser) factor(input stream) (*factor, int, error) {
 	}
 	_, step, err := p.expr(input[1:])
 	if err != nil {
-		return nil, -1, p.error("bracketed expression parse error: %v", err)
+		p.fail(p.at(input, 1), "expected expression after '(', found %s", describe(input, 1))
 	}
-	if tk := input[1+step]; tk.class != tkPunctuation || tk.value != ")" {
-		return nil, -1, p.error("bracketed not closed: %v", err)
+	if 1+step == len(input) || input[1+step].class != tkPunctuation || input[1+step].value != ")" {
+		p.fail(p.at(input, 1+step), "expected ')', found %s", describe(input, 1+step))
 	}
 	return nil, -1, fmt.Errorf("factor expressions not implemented")
 }
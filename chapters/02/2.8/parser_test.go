@@ -0,0 +1,190 @@
+package trans
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+func TestSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want []string
+	}{
+		{
+			name: "several",
+			raw: `{
+	int x; int y
+	x = 3 + ;
+	while ( x > ) { x = x - 1; }
+	if ( x ) { y = 1 }
+	else y = 2;
+	x = f ( 1 2 );
+}`,
+			want: []string{
+				"prog:2:14: expected ';', found \"x\"",
+				"prog:3:10: expected expression after \"+\", found \";\"",
+				"prog:4:14: expected expression after \">\", found \")\"",
+				"prog:5:18: expected ';', found \"}\"",
+				"prog:7:12: expected ',' between arguments, found \"2\"",
+			},
+		},
+		{
+			name: "lexer",
+			raw:  "{ int x; x = 1 @ ; x = 2; }",
+			want: []string{"prog:1:16: unexpected character '@'"},
+		},
+		{
+			name: "function header",
+			raw:  "int f ( int a, b ) { return a; } int g ( ) { return 1 } { int x; x = g ( ); }",
+			want: []string{
+				"prog:1:16: parameter must have type int or float, found \"b\"",
+				"prog:1:54: expected ';', found \"}\"",
+			},
+		},
+		{
+			name: "nested block",
+			raw:  "{ int x; while ( x ) { x = ; if ( x ) { x = 1; } } x = 1 2; }",
+			want: []string{
+				"prog:1:28: expected expression after '=', found \";\"",
+				"prog:1:57: expected ';', found \"2\"",
+			},
+		},
+		{
+			name: "switch",
+			raw:  "{ int x; switch ( x ) { x = 1; case y : x = 2; default : break; } }",
+			want: []string{
+				"prog:1:25: switch statement must begin with case or default, found \"x\"",
+				"prog:1:37: case must have integer constant, found \"y\"",
+			},
+		},
+		{
+			name: "stray",
+			raw:  "{ else x = 1; } }",
+			want: []string{
+				"prog:1:3: unexpected \"else\"",
+				"prog:1:17: unexpected \"}\" after main block",
+			},
+		},
+		{name: "truncated", raw: "{ int x; x = ( 1", want: []string{"prog:1:17: expected ')', found end of input"}},
+		{name: "empty", raw: "", want: []string{"prog:1:1: expected '{', found end of input"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := parsefile(source.NewFile("prog", tc.raw))
+			if err == nil {
+				t.Fatal("expected syntax errors")
+			}
+			if _, ok := err.(*source.ErrorList); !ok {
+				t.Fatalf("error %T is not *source.ErrorList", err)
+			}
+			if got := strings.Split(err.Error(), "\n"); strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	// every proper prefix of a valid program is reported, without panicking
+	for _, raw := range []string{partition, functions, statements} {
+		for i := range raw {
+			if strings.TrimSpace(raw[i:]) == "" {
+				break
+			}
+			if _, _, err := parse(raw[:i]); err == nil {
+				t.Errorf("prefix %q parsed without error", raw[:i])
+			}
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	_, _, err := parsefile(source.NewFile("prog", "{\n\tint x;\n\tx = a[1 ;\n}"))
+	if err == nil {
+		t.Fatal("expected syntax errors")
+	}
+	var b bytes.Buffer
+	err.(*source.ErrorList).Print(&b, false)
+	want := "prog:3:10: expected ']', found \";\"\n" +
+		"   3 | \tx = a[1 ;\n" +
+		"     | \t        ^\n"
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestSpans(t *testing.T) {
+	raw := `{
+	int[4] a; int x;
+	x = a[1] + 2 * x;
+	if ( x > 1 ) x = f ( x, 2 ); else { break; }
+	while ( x < 3 ) x = x + 1;
+}`
+	p, prog, err := parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := func(n interface{ span() source.Span }) string { return p.file.Text(n.span()) }
+	want := []string{
+		"int[4] a;",
+		"int x;",
+		"x = a[1] + 2 * x",
+		"if ( x > 1 ) x = f ( x, 2 ); else { break; }",
+		"while ( x < 3 ) x = x + 1;",
+	}
+	if got := text(prog.main); got != raw {
+		t.Errorf("block: got %q", got)
+	}
+	for i, stmt := range prog.main.stmts {
+		if got := text(stmt); got != want[i] {
+			t.Errorf("statement %d: got %q, want %q", i, got, want[i])
+		}
+	}
+	ex := prog.main.stmts[2].(expr)
+	for _, tc := range []struct {
+		n    interface{ span() source.Span }
+		want string
+	}{
+		{ex[1], "a[1] + 2 * x"},
+		{ex[1].head.head, "a[1]"},
+		{ex[1].head.tail.arithm, "2 * x"},
+		{ex[1].head.tail.head.tail.term, "x"},
+		{prog.main.stmts[3].(ifstmt).expr, "x > 1"},
+		{prog.main.stmts[3].(ifstmt).els, "{ break; }"},
+	} {
+		if got := text(tc.n); got != tc.want {
+			t.Errorf("got %q, want %q", got, tc.want)
+		}
+	}
+	c, _ := prog.main.stmts[3].(ifstmt).stmt.(expr)[1].head.head.head.node.(call)
+	if got := text(c); got != "f ( x, 2 )" {
+		t.Errorf("call: got %q", got)
+	}
+}
+
+func TestSemanticPosition(t *testing.T) {
+	_, err := translate("{ int x;\n  while ( x ) { x = f ( 1 ); } }", false)
+	if err == nil || err.Error() != "input:2:17: call to undefined function f" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestDump(t *testing.T) {
+	prog, err := Parse(source.NewFile("input", "{ int x; if ( x ) x = 1; }"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	prog.AST(&b)
+	want := "block  input:1:1\n" +
+		"  decl{int x}  input:1:3\n" +
+		"  if x  input:1:10\n" +
+		"    expr x = 1  input:1:19\n"
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
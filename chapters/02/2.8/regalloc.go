@@ -0,0 +1,602 @@
+package trans
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+/*
+Register allocation by graph colouring (Section 8.8.4), in the style of
+Chaitin and Briggs:
+	1. compute liveness over the instructions of the three-address code;
+	2. build the interference graph, in which two variables are adjacent if one
+	   is defined while the other is live (the source of a copy excepted);
+	3. coalesce copy-related variables if Briggs' test says this is safe;
+	4. simplify the graph by removing variables of degree < k, choosing a spill
+	   candidate by cost / degree whenever none remains, and colour
+	   optimistically in the reverse order;
+	5. for each variable that cannot be coloured, rewrite the code so that it
+	   lives in memory and is only briefly held in registers, and start over.
+Only scalars are allocated; arrays always live in memory. Declared variables
+of the main code are live at exit, since their values are observable
+afterwards. Each function is allocated separately: its registers belong to its
+activation like its variables, so none need be saved across a call. The cost of
+spilling a variable is the number of its uses and definitions, each weighted
+by 10^d for a loop depth of d.
+*/
+
+type varset map[string]bool
+
+func (s varset) equal(t varset) bool {
+	if len(s) != len(t) {
+		return false
+	}
+	for v := range s {
+		if !t[v] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s varset) sorted() []string {
+	vars := []string{}
+	for v := range s {
+		vars = append(vars, v)
+	}
+	sort.Strings(vars)
+	return vars
+}
+
+// succ returns the indices of the instructions that may follow code[i].
+func (prog *tac) succ(i int) []int {
+	code := prog.code[i]
+	switch code.op {
+	case opGoto:
+		return []int{prog.labels[code.label]}
+	case opTable:
+		succ := []int{prog.labels[code.label]}
+		for _, l := range code.table {
+			succ = append(succ, prog.labels[l])
+		}
+		return succ
+	case opReturn:
+		return nil
+	case opIf, opIfFalse:
+		if i+1 < len(prog.code) {
+			return []int{i + 1, prog.labels[code.label]}
+		}
+		return []int{prog.labels[code.label]}
+	}
+	if i+1 < len(prog.code) {
+		return []int{i + 1}
+	}
+	return nil
+}
+
+// usedef returns the operands read and the operand written by code.
+func (code instr) usedef() (use []string, def string) {
+	switch code.op {
+	case opCopy:
+		return []string{code.arg1}, code.dst
+	case opBinary:
+		return []string{code.arg1, code.arg2}, code.dst
+	case opLoad:
+		return []string{code.index}, code.dst
+	case opStore:
+		return []string{code.index, code.arg1}, ""
+	case opIf, opIfFalse:
+		if code.binop != "" {
+			return []string{code.arg1, code.arg2}, ""
+		}
+		return []string{code.arg1}, ""
+	case opTable, opParam, opReturn:
+		return []string{code.arg1}, ""
+	case opCall:
+		return nil, code.dst
+	}
+	return nil, ""
+}
+
+// loopdepth returns the loop nesting depth of every instruction. Each jump
+// backwards is taken to close a loop that begins at its target, which holds
+// for the structured code the parser emits.
+func (prog *tac) loopdepth() []int {
+	depth := make([]int, len(prog.code))
+	for i, code := range prog.code {
+		switch code.op {
+		case opGoto, opIf, opIfFalse, opTable:
+			for _, l := range append([]string{code.label}, code.table...) {
+				if j := prog.labels[l]; j <= i {
+					for k := j; k <= i; k++ {
+						depth[k]++
+					}
+				}
+			}
+		}
+	}
+	return depth
+}
+
+type regalloc struct {
+	prog    *tac
+	k       int
+	arrays  varset
+	memory  varset // spilled variables, held in memory
+	nospill varset // variables introduced by spilling
+	exit    varset // live at exit
+	entry   varset // live on entry
+	liveout []varset
+
+	nodes []string // allocatable variables in order of appearance
+	adj   map[string]varset
+	moves map[string]varset
+	alias map[string]string // coalesced variable to its representative
+	cost  map[string]float64
+	color map[string]int
+
+	funcs map[string]*regalloc // allocation of each function, in the main code
+}
+
+func (ra *regalloc) allocatable(s string) bool {
+	if s == "" || ra.arrays[s] || ra.memory[s] {
+		return false
+	}
+	_, err := parsevalue(s)
+	return err != nil
+}
+
+func (ra *regalloc) liveness() {
+	n := len(ra.prog.code)
+	livein := make([]varset, n)
+	ra.liveout = make([]varset, n)
+	for i := range livein {
+		livein[i], ra.liveout[i] = varset{}, varset{}
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := n - 1; i >= 0; i-- {
+			out := varset{}
+			succ := ra.prog.succ(i)
+			if len(succ) == 0 {
+				for v := range ra.exit {
+					out[v] = true
+				}
+			}
+			for _, s := range succ {
+				for v := range livein[s] {
+					out[v] = true
+				}
+			}
+			use, def := ra.prog.code[i].usedef()
+			in := varset{}
+			for v := range out {
+				if v != def {
+					in[v] = true
+				}
+			}
+			for _, u := range use {
+				if ra.allocatable(u) {
+					in[u] = true
+				}
+			}
+			if !in.equal(livein[i]) || !out.equal(ra.liveout[i]) {
+				livein[i], ra.liveout[i] = in, out
+				changed = true
+			}
+		}
+	}
+	// everything live on entry is defined at once
+	ra.entry = varset{}
+	if n > 0 {
+		ra.entry = livein[0]
+		for a := range livein[0] {
+			for b := range livein[0] {
+				ra.addedge(a, b)
+			}
+		}
+	}
+}
+
+func (ra *regalloc) addnode(v string) {
+	if _, ok := ra.adj[v]; !ok && ra.allocatable(v) {
+		ra.nodes = append(ra.nodes, v)
+		ra.adj[v], ra.moves[v] = varset{}, varset{}
+	}
+}
+
+func (ra *regalloc) addedge(a, b string) {
+	if a == b || !ra.allocatable(a) || !ra.allocatable(b) {
+		return
+	}
+	ra.addnode(a)
+	ra.addnode(b)
+	ra.adj[a][b], ra.adj[b][a] = true, true
+}
+
+func (ra *regalloc) build() {
+	ra.nodes = nil
+	ra.adj, ra.moves = map[string]varset{}, map[string]varset{}
+	ra.alias, ra.cost = map[string]string{}, map[string]float64{}
+	ra.liveness()
+	depth := ra.prog.loopdepth()
+	for i, code := range ra.prog.code {
+		use, def := code.usedef()
+		weight := 1.0
+		for d := 0; d < depth[i]; d++ {
+			weight *= 10
+		}
+		for _, v := range append(use, def) {
+			if ra.allocatable(v) {
+				ra.addnode(v)
+				ra.cost[v] += weight
+			}
+		}
+		if !ra.allocatable(def) {
+			continue
+		}
+		if code.op == opCopy && ra.allocatable(code.arg1) && code.arg1 != def {
+			ra.moves[def][code.arg1], ra.moves[code.arg1][def] = true, true
+		}
+		for v := range ra.liveout[i] {
+			if code.op == opCopy && v == code.arg1 {
+				continue
+			}
+			ra.addedge(def, v)
+		}
+	}
+}
+
+func (ra *regalloc) find(v string) string {
+	for {
+		a, ok := ra.alias[v]
+		if !ok {
+			return v
+		}
+		v = a
+	}
+}
+
+// briggs reports whether merging a and b leaves fewer than k neighbours of
+// significant degree, in which case the merged node is still colourable.
+func (ra *regalloc) briggs(a, b string) bool {
+	neighbours := varset{}
+	for v := range ra.adj[a] {
+		neighbours[v] = true
+	}
+	for v := range ra.adj[b] {
+		neighbours[v] = true
+	}
+	significant := 0
+	for v := range neighbours {
+		deg := len(ra.adj[v])
+		if ra.adj[v][a] && ra.adj[v][b] {
+			deg-- // a and b become one
+		}
+		if deg >= ra.k {
+			significant++
+		}
+	}
+	return significant < ra.k
+}
+
+// coalesce merges copy-related variables until no more may be safely merged.
+func (ra *regalloc) coalesce() {
+	for merged := true; merged; {
+		merged = false
+		for _, a := range ra.nodes {
+			if _, ok := ra.alias[a]; ok {
+				continue
+			}
+			for _, b := range ra.moves[a].sorted() {
+				if a == b || ra.adj[a][b] || ra.nospill[a] != ra.nospill[b] || !ra.briggs(a, b) {
+					continue
+				}
+				ra.alias[b] = a
+				for v := range ra.adj[b] {
+					delete(ra.adj[v], b)
+					ra.adj[v][a], ra.adj[a][v] = true, true
+				}
+				for v := range ra.moves[b] {
+					delete(ra.moves[v], b)
+					if v != a {
+						ra.moves[v][a], ra.moves[a][v] = true, true
+					}
+				}
+				delete(ra.moves[a], b)
+				ra.adj[b], ra.moves[b] = varset{}, varset{}
+				ra.cost[a] += ra.cost[b]
+				merged = true
+			}
+		}
+	}
+}
+
+// simplify returns the order in which nodes are to be coloured.
+func (ra *regalloc) simplify() []string {
+	degree := map[string]int{}
+	remaining := []string{}
+	for _, v := range ra.nodes {
+		if _, ok := ra.alias[v]; !ok {
+			degree[v] = len(ra.adj[v])
+			remaining = append(remaining, v)
+		}
+	}
+	stack := []string{}
+	remove := func(i int) {
+		v := remaining[i]
+		remaining = append(remaining[:i], remaining[i+1:]...)
+		for u := range ra.adj[v] {
+			degree[u]--
+		}
+		stack = append(stack, v)
+	}
+	for len(remaining) > 0 {
+		low := -1
+		for i, v := range remaining {
+			if degree[v] < ra.k {
+				low = i
+				break
+			}
+		}
+		if low == -1 {
+			// potential spill: cheapest per interference removed
+			for i, v := range remaining {
+				if ra.nospill[v] {
+					continue
+				}
+				if low == -1 || ra.cost[v]/float64(degree[v]) < ra.cost[remaining[low]]/float64(degree[remaining[low]]) {
+					low = i
+				}
+			}
+			if low == -1 {
+				low = 0
+			}
+		}
+		remove(low)
+	}
+	return stack
+}
+
+// select colours the nodes in reverse order of simplification, returning those
+// which could not be coloured.
+func (ra *regalloc) selectcolors(stack []string) []string {
+	ra.color = map[string]int{}
+	spilled := []string{}
+	for i := len(stack) - 1; i >= 0; i-- {
+		v := stack[i]
+		used := map[int]bool{}
+		for u := range ra.adj[v] {
+			if c, ok := ra.color[u]; ok {
+				used[c] = true
+			}
+		}
+		c := 0
+		for ; c < ra.k && used[c]; c++ {
+		}
+		if c == ra.k {
+			spilled = append(spilled, v)
+			continue
+		}
+		ra.color[v] = c
+	}
+	for _, v := range ra.nodes {
+		if a := ra.find(v); a != v {
+			if c, ok := ra.color[a]; ok {
+				ra.color[v] = c
+			}
+		}
+	}
+	return spilled
+}
+
+// spill rewrites the program so that the variables in spilled (and those
+// coalesced with them) live in memory, being loaded into a fresh temporary
+// before each use and stored from one after each definition.
+func (ra *regalloc) spill(spilled []string) error {
+	targets := varset{}
+	for _, v := range ra.nodes {
+		for _, s := range spilled {
+			if ra.find(v) == s {
+				if ra.nospill[v] {
+					return fmt.Errorf("cannot allocate %d registers: spill temporary %s uncolourable", ra.k, v)
+				}
+				targets[v] = true
+			}
+		}
+	}
+	names := varset{}
+	for _, code := range ra.prog.code {
+		for _, s := range []string{code.dst, code.arg1, code.arg2, code.index} {
+			names[s] = true
+		}
+	}
+	n := 0
+	fresh := func(v string) string {
+		for {
+			n++
+			s := fmt.Sprintf("%s_s%d", v, n)
+			if !names[s] {
+				names[s] = true
+				ra.nospill[s] = true
+				return s
+			}
+		}
+	}
+	prog := ra.prog.derive()
+	for _, code := range ra.prog.code {
+		loaded := map[string]string{}
+		load := func(s *string) {
+			if !targets[*s] {
+				return
+			}
+			if t, ok := loaded[*s]; ok {
+				*s = t
+				return
+			}
+			t := fresh(*s)
+			prog.code = append(prog.code, instr{op: opCopy, dst: t, arg1: *s, lineno: code.lineno})
+			loaded[*s] = t
+			*s = t
+		}
+		load(&code.arg1)
+		load(&code.arg2)
+		load(&code.index)
+		var store *instr
+		if code.op != opStore && targets[code.dst] {
+			t := fresh(code.dst)
+			store = &instr{op: opCopy, dst: code.dst, arg1: t, lineno: code.lineno}
+			code.dst = t
+		}
+		if code.op == opLabel {
+			prog.labels[code.label] = len(prog.code)
+		}
+		prog.code = append(prog.code, code)
+		if store != nil {
+			prog.code = append(prog.code, *store)
+		}
+	}
+	for v := range targets {
+		ra.memory[v] = true
+	}
+	ra.prog = prog
+	return nil
+}
+
+// allocate colours the program with k registers, rewriting it as often as
+// spilling requires.
+func allocate(prog *tac, k int) (*regalloc, error) {
+	if k < 1 {
+		return nil, fmt.Errorf("cannot allocate %d registers", k)
+	}
+	ra := &regalloc{prog: prog, k: k, arrays: varset{}, memory: varset{}, nospill: varset{}, exit: varset{}}
+	for _, d := range prog.decls {
+		if d.size >= 0 {
+			ra.arrays[d.id] = true
+		} else if prog.name == "" {
+			ra.exit[d.id] = true
+		}
+	}
+	if prog.name == "" {
+		ra.funcs = map[string]*regalloc{}
+		for _, name := range prog.order {
+			fa, err := allocate(prog.funcs[name], k)
+			if err != nil {
+				return nil, fmt.Errorf("function %s: %v", name, err)
+			}
+			ra.funcs[name] = fa
+		}
+	}
+	for {
+		ra.build()
+		ra.coalesce()
+		spilled := ra.selectcolors(ra.simplify())
+		if len(spilled) == 0 {
+			return ra, nil
+		}
+		if err := ra.spill(spilled); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func regname(c int) string { return fmt.Sprintf("R%d", c) }
+
+// operand names the register holding s, if any.
+func (ra *regalloc) operand(s string) string {
+	if c, ok := ra.color[s]; ok && ra.allocatable(s) {
+		return regname(c)
+	}
+	return s
+}
+
+// rewrite returns the program with allocated variables replaced by registers
+// and the copies made redundant by coalescing removed. Formals and declared
+// variables that are held in registers are loaded on entry, if live, and the
+// declared variables of the main code are stored at exit.
+func (ra *regalloc) rewrite() *tac {
+	prog := ra.prog.derive()
+	var epilogue []instr
+	for _, d := range append(append([]tacdecl{}, ra.prog.formals...), ra.prog.decls...) {
+		if c, ok := ra.color[d.id]; ok && ra.allocatable(d.id) {
+			if ra.entry[d.id] {
+				prog.code = append(prog.code, instr{op: opCopy, dst: regname(c), arg1: d.id})
+			}
+			if ra.exit[d.id] {
+				epilogue = append(epilogue, instr{op: opCopy, dst: d.id, arg1: regname(c)})
+			}
+		}
+	}
+	for _, code := range ra.prog.code {
+		// arrays are not allocatable, so are never renamed
+		code.dst, code.arg1, code.arg2, code.index =
+			ra.operand(code.dst), ra.operand(code.arg1), ra.operand(code.arg2), ra.operand(code.index)
+		if code.op == opCopy && code.dst == code.arg1 {
+			continue
+		}
+		if code.op == opLabel {
+			prog.labels[code.label] = len(prog.code)
+		}
+		prog.code = append(prog.code, code)
+	}
+	prog.code = append(prog.code, epilogue...)
+	if ra.funcs != nil {
+		prog.funcs = map[string]*tac{}
+		for name, fa := range ra.funcs {
+			prog.funcs[name] = fa.rewrite()
+		}
+		for _, f := range prog.funcs {
+			f.funcs = prog.funcs
+		}
+	}
+	return prog
+}
+
+// print writes the interference graph, the assignment of registers and the
+// rewritten code.
+func (ra *regalloc) print(w io.Writer) {
+	fmt.Fprintf(w, "interference graph (%d registers):\n", ra.k)
+	for _, v := range ra.nodes {
+		if _, ok := ra.alias[v]; ok {
+			continue
+		}
+		fmt.Fprintf(w, "  %s: %s\n", v, strings.Join(ra.adj[v].sorted(), " "))
+	}
+	coalesced := []string{}
+	for _, v := range ra.nodes {
+		if a := ra.find(v); a != v {
+			coalesced = append(coalesced, fmt.Sprintf("%s→%s", v, a))
+		}
+	}
+	if len(coalesced) > 0 {
+		fmt.Fprintf(w, "coalesced: %s\n", strings.Join(coalesced, " "))
+	}
+	if len(ra.memory) > 0 {
+		fmt.Fprintf(w, "spilled: %s\n", strings.Join(ra.memory.sorted(), " "))
+	}
+	fmt.Fprintln(w, "assignment:")
+	for _, v := range ra.nodes {
+		if c, ok := ra.color[v]; ok {
+			fmt.Fprintf(w, "  %s %s\n", v, regname(c))
+		}
+	}
+	fmt.Fprintln(w, "code:")
+	prog := ra.rewrite()
+	for _, d := range prog.formals {
+		fmt.Fprintf(w, "  formal %s %s\n", d.id, d._type)
+	}
+	for _, d := range prog.decls {
+		fmt.Fprintf(w, "  %s\n", d)
+	}
+	for _, code := range prog.code {
+		fmt.Fprintf(w, "  %s\n", code)
+	}
+	for _, name := range ra.prog.order {
+		if fa, ok := ra.funcs[name]; ok {
+			fmt.Fprintf(w, "function %s %s, ", name, fa.prog.result)
+			fa.print(w)
+		}
+	}
+}
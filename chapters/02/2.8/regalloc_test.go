@@ -0,0 +1,165 @@
+package trans
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegalloc(t *testing.T) {
+	output, err := translate(partition, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inits := []string{"a=5,3,8,1,9,2,7,100", "v=5", "i=0", "j=7"}
+	run := func(prog *tac) string {
+		in, err := newinterp(prog)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in.maxsteps = 10000
+		for _, s := range inits {
+			if err := in.set(s); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := in.run(); err != nil {
+			t.Fatal(err)
+		}
+		var b strings.Builder
+		in.dump(&b)
+		// declared variables only
+		lines := strings.Split(b.String(), "\n")
+		return strings.Join(lines[:len(prog.decls)], "\n")
+	}
+	prog, err := parsetac(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := run(prog)
+
+	for k := 2; k <= 8; k++ {
+		ra, err := allocate(prog, k)
+		if err != nil {
+			t.Errorf("%d registers: %v", k, err)
+			continue
+		}
+		for _, v := range ra.nodes {
+			c, ok := ra.color[v]
+			if !ok {
+				t.Errorf("%d registers: %s not coloured", k, v)
+			} else if c < 0 || c >= k {
+				t.Errorf("%d registers: %s has colour %d", k, v, c)
+			}
+			for u := range ra.adj[ra.find(v)] {
+				if ra.color[u] == c {
+					t.Errorf("%d registers: %s and %s interfere but share %s", k, v, u, regname(c))
+				}
+			}
+		}
+		if k >= 5 && len(ra.memory) > 0 {
+			t.Errorf("%d registers: unexpected spills %v", k, ra.memory.sorted())
+		}
+		if got := run(ra.rewrite()); got != want {
+			var b strings.Builder
+			ra.print(&b)
+			t.Errorf("%d registers: expected\n%s\ngot\n%s\n%s", k, want, got, b.String())
+		}
+	}
+}
+
+func TestRegallocPrograms(t *testing.T) {
+	tests := []struct {
+		name, raw, init string
+	}{
+		{"functions", functions, "n=5"},
+		{"statements", statements, "n=-40"},
+	}
+	for _, tc := range tests {
+		output, err := translate(tc.raw, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prog, err := parsetac(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		run := func(prog *tac) string {
+			in, err := newinterp(prog)
+			if err != nil {
+				t.Fatal(err)
+			}
+			in.maxsteps = 100000
+			if err := in.set(tc.init); err != nil {
+				t.Fatal(err)
+			}
+			if err := in.run(); err != nil {
+				t.Fatal(err)
+			}
+			var b strings.Builder
+			in.dump(&b)
+			lines := strings.Split(b.String(), "\n")
+			return strings.Join(lines[:len(prog.decls)], "\n")
+		}
+		want := run(prog)
+		for k := 2; k <= 6; k++ {
+			ra, err := allocate(prog, k)
+			if err != nil {
+				t.Errorf("%s with %d registers: %v", tc.name, k, err)
+				continue
+			}
+			if len(ra.funcs) != len(prog.order) {
+				t.Errorf("%s with %d registers: %d functions allocated", tc.name, k, len(ra.funcs))
+			}
+			if got := run(ra.rewrite()); got != want {
+				var b strings.Builder
+				ra.print(&b)
+				t.Errorf("%s with %d registers: expected\n%s\ngot\n%s\n%s", tc.name, k, want, got, b.String())
+			}
+		}
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	prog, err := parsetac("declare x int\ndeclare y int\nt0 = x + 1\nx = t0\nt1 = x * 2\ny = t1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ra, err := allocate(prog, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ra.find("t0") != ra.find("x") || ra.find("t1") != ra.find("y") {
+		t.Errorf("copies not coalesced: %v", ra.alias)
+	}
+	for _, code := range ra.rewrite().code {
+		if code.op == opCopy && code.arg1 != "x" && code.dst != "x" && code.dst != "y" {
+			t.Errorf("redundant copy %s remains", code)
+		}
+	}
+}
+
+func TestSpillCost(t *testing.T) {
+	// with a single register to spare, the variable used only outside the
+	// loop is spilled rather than those used within it
+	prog, err := parsetac(`declare a int
+declare b int
+declare c int
+t0 = a + 1
+L0:
+t1 = b + c
+b = t1
+if b < 100 goto L0
+t2 = a * 2
+c = t2
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ra, err := allocate(prog, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ra.memory["a"] || ra.memory["b"] || ra.memory["c"] {
+		t.Errorf("expected only a spilled, got %v", ra.memory.sorted())
+	}
+}
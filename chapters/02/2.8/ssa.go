@@ -0,0 +1,212 @@
+package trans
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// phi is a φ-function at the head of a block, choosing among args by the
+// predecessor from which control arrived.
+type phi struct {
+	v    string // the variable before renaming
+	dst  string
+	args []string // in the order of the predecessors
+}
+
+func (f phi) String() string {
+	return fmt.Sprintf("%s = phi(%s)", f.dst, strings.Join(f.args, ", "))
+}
+
+// ssaform is a flow graph in static single-assignment form: each scalar
+// variable is renamed x.1, x.2, ... at each of its definitions, and x.0 is
+// its value on entry, the formal or the zero to which it is initialised.
+// Arrays are left as they are.
+type ssaform struct {
+	*flowgraph
+	phis [][]phi
+	code []instr
+}
+
+// rename returns code with its operands renamed by use and its destination
+// by def, following usedef.
+func (code instr) rename(use, def func(string) string) instr {
+	switch code.op {
+	case opCopy:
+		code.arg1 = use(code.arg1)
+		code.dst = def(code.dst)
+	case opBinary:
+		code.arg1, code.arg2 = use(code.arg1), use(code.arg2)
+		code.dst = def(code.dst)
+	case opLoad:
+		code.index = use(code.index)
+		code.dst = def(code.dst)
+	case opStore:
+		code.index, code.arg1 = use(code.index), use(code.arg1)
+	case opIf, opIfFalse:
+		code.arg1 = use(code.arg1)
+		if code.binop != "" {
+			code.arg2 = use(code.arg2)
+		}
+	case opTable, opParam, opReturn:
+		code.arg1 = use(code.arg1)
+	case opCall:
+		code.dst = def(code.dst)
+	}
+	return code
+}
+
+// toSSA places φ-functions at the iterated dominance frontiers of the blocks
+// that define each variable live on entry to some block (semi-pruned form,
+// as Briggs et al.), then renames the definitions and uses along the
+// dominator tree (Cytron et al.).
+func toSSA(g *flowgraph) *ssaform {
+	prog := g.prog
+	scalar := func(s string) bool {
+		if s == "" {
+			return false
+		}
+		if _, err := parsevalue(s); err == nil {
+			return false
+		}
+		d, ok := prog.local(s)
+		return !ok || d.size < 0
+	}
+
+	// the variables used in some block before any definition in it, and the
+	// blocks defining each
+	global, defs := map[string]bool{}, map[string][]int{}
+	for b, bb := range g.blocks {
+		killed := map[string]bool{}
+		for _, code := range prog.code[bb.start:bb.end] {
+			use, def := code.usedef()
+			for _, u := range use {
+				if scalar(u) && !killed[u] {
+					global[u] = true
+				}
+			}
+			if scalar(def) {
+				killed[def] = true
+				if n := len(defs[def]); n == 0 || defs[def][n-1] != b {
+					defs[def] = append(defs[def], b)
+				}
+			}
+		}
+	}
+
+	f := &ssaform{flowgraph: g, phis: make([][]phi, len(g.blocks)), code: make([]instr, len(prog.code))}
+	vars := make([]string, 0, len(global))
+	for v := range global {
+		vars = append(vars, v)
+	}
+	sort.Strings(vars)
+	for _, v := range vars {
+		// the entry defines every variable
+		work := append([]int{0}, defs[v]...)
+		placed := map[int]bool{}
+		for len(work) > 0 {
+			b := work[len(work)-1]
+			work = work[:len(work)-1]
+			for _, d := range g.frontier[b] {
+				if placed[d] {
+					continue
+				}
+				placed[d] = true
+				f.phis[d] = append(f.phis[d], phi{v: v, args: make([]string, len(g.blocks[d].pred))})
+				work = append(work, d)
+			}
+		}
+	}
+
+	children := make([][]int, len(g.blocks))
+	for _, b := range g.order[1:] {
+		children[g.idom[b]] = append(children[g.idom[b]], b)
+	}
+	counter, stacks := map[string]int{}, map[string][]string{}
+	top := func(v string) string {
+		if !scalar(v) {
+			return v
+		}
+		if s := stacks[v]; len(s) > 0 {
+			return s[len(s)-1]
+		}
+		return v + ".0"
+	}
+	var pushed []string
+	fresh := func(v string) string {
+		if !scalar(v) {
+			return v
+		}
+		counter[v]++
+		name := fmt.Sprintf("%s.%d", v, counter[v])
+		stacks[v] = append(stacks[v], name)
+		pushed = append(pushed, v)
+		return name
+	}
+	var walk func(b int)
+	walk = func(b int) {
+		mark := len(pushed)
+		for i := range f.phis[b] {
+			f.phis[b][i].dst = fresh(f.phis[b][i].v)
+		}
+		bb := g.blocks[b]
+		for i := bb.start; i < bb.end; i++ {
+			f.code[i] = prog.code[i].rename(top, fresh)
+		}
+		for _, s := range bb.succ {
+			j := indexof(g.blocks[s].pred, b)
+			for i := range f.phis[s] {
+				f.phis[s][i].args[j] = top(f.phis[s][i].v)
+			}
+		}
+		for _, c := range children[b] {
+			walk(c)
+		}
+		for _, v := range pushed[mark:] {
+			stacks[v] = stacks[v][:len(stacks[v])-1]
+		}
+		pushed = pushed[:mark]
+	}
+	if len(g.blocks) > 0 {
+		walk(0)
+	}
+	return f
+}
+
+func indexof(s []int, v int) int {
+	for i, x := range s {
+		if x == v {
+			return i
+		}
+	}
+	return -1
+}
+
+// print writes the blocks as flowgraph.print does, with the φ-functions at
+// their heads. A φ has no argument for an unreachable predecessor.
+func (f *ssaform) print(w io.Writer) {
+	fmt.Fprintln(w, header(f.prog))
+	for b, bb := range f.blocks {
+		fmt.Fprintf(w, "B%d:", b)
+		if b == 0 {
+			fmt.Fprint(w, " entry")
+		}
+		if len(bb.pred) > 0 {
+			fmt.Fprintf(w, " <- %s", blocknames(bb.pred))
+		}
+		if len(bb.succ) > 0 {
+			fmt.Fprintf(w, " -> %s", blocknames(bb.succ))
+		}
+		fmt.Fprintln(w)
+		if f.idom[b] < 0 && b > 0 {
+			continue
+		}
+		for _, p := range f.phis[b] {
+			fmt.Fprintf(w, "\t%s\n", p)
+		}
+		for _, code := range f.code[bb.start:bb.end] {
+			fmt.Fprintf(w, "\t%s\n", code)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package trans
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The three-address code emitted by the parser is plain text, one instruction
+// per line:
+//     declare x int          declare a float[100]
+//     L0:                    goto L0
+//     x = y                  x = y op z
+//     x = a [ i ]            a [ i ] = x
+//     ifFalse x goto L0      if x goto L0
+//     goto x [ L0 L1 L2 ] else L3
+//     param x                y = call p, n          call p, n
+//     return x               return
+// Conditional jumps may also carry a relation, as in `if t1 < v goto L0`. The
+// indexed goto jumps through a table of labels to the one numbered x, or to the
+// label after else if x is out of range; it implements a dense switch.
+//
+// Functions precede the main code, each enclosed by a header naming its
+// result type (void for procedures) and the line `end`:
+//     function fact int
+//     formal n int
+//     ...
+//     end
+// The formals are the parameters in order, and every other variable of the
+// function is local to it. A call passes the values of the n preceding params.
+
+type opcode int
+
+const (
+	opLabel opcode = iota
+	opCopy
+	opBinary
+	opLoad
+	opStore
+	opGoto
+	opIf
+	opIfFalse
+	opTable
+	opParam
+	opCall
+	opReturn
+)
+
+type instr struct {
+	op     opcode
+	dst    string // target of assignment or array of store
+	arg1   string
+	binop  string
+	arg2   string
+	index  string   // index of load or store
+	label  string   // target of jump, or function called
+	table  []string // targets of indexed jump, whose default is label
+	nargs  int      // number of arguments of call
+	lineno int      // line in the source text
+}
+
+func (in instr) String() string {
+	switch in.op {
+	case opLabel:
+		return fmt.Sprintf("%s:", in.label)
+	case opCopy:
+		return fmt.Sprintf("%s = %s", in.dst, in.arg1)
+	case opBinary:
+		return fmt.Sprintf("%s = %s %s %s", in.dst, in.arg1, in.binop, in.arg2)
+	case opLoad:
+		return fmt.Sprintf("%s = %s [ %s ]", in.dst, in.arg1, in.index)
+	case opStore:
+		return fmt.Sprintf("%s [ %s ] = %s", in.dst, in.index, in.arg1)
+	case opGoto:
+		return fmt.Sprintf("goto %s", in.label)
+	case opIf, opIfFalse:
+		kw := "if"
+		if in.op == opIfFalse {
+			kw = "ifFalse"
+		}
+		if in.binop == "" {
+			return fmt.Sprintf("%s %s goto %s", kw, in.arg1, in.label)
+		}
+		return fmt.Sprintf("%s %s %s %s goto %s", kw, in.arg1, in.binop, in.arg2, in.label)
+	case opTable:
+		return fmt.Sprintf("goto %s [ %s ] else %s", in.arg1, strings.Join(in.table, " "), in.label)
+	case opParam:
+		return fmt.Sprintf("param %s", in.arg1)
+	case opCall:
+		if in.dst == "" {
+			return fmt.Sprintf("call %s, %d", in.label, in.nargs)
+		}
+		return fmt.Sprintf("%s = call %s, %d", in.dst, in.label, in.nargs)
+	case opReturn:
+		if in.arg1 == "" {
+			return "return"
+		}
+		return fmt.Sprintf("return %s", in.arg1)
+	}
+	return fmt.Sprintf("unknown instruction %d", in.op)
+}
+
+type tacdecl struct {
+	id    string
+	_type string
+	size  int // -1 for scalars
+}
+
+func (d tacdecl) String() string {
+	if d.size < 0 {
+		return fmt.Sprintf("declare %s %s", d.id, d._type)
+	}
+	return fmt.Sprintf("declare %s %s[%d]", d.id, d._type, d.size)
+}
+
+type tac struct {
+	decls  []tacdecl
+	code   []instr
+	labels map[string]int // label to index in code
+
+	name    string // empty for the main code
+	result  string // result type of a function
+	formals []tacdecl
+	funcs   map[string]*tac // every function, shared by the whole program
+	order   []string        // functions in order of definition
+}
+
+// derive returns an empty copy of prog, to be given rewritten code.
+func (prog *tac) derive() *tac {
+	return &tac{decls: prog.decls, labels: map[string]int{}, name: prog.name,
+		result: prog.result, formals: prog.formals, funcs: prog.funcs, order: prog.order}
+}
+
+// local reports whether id is a formal or declared variable.
+func (prog *tac) local(id string) (tacdecl, bool) {
+	for _, d := range prog.formals {
+		if d.id == id {
+			return d, true
+		}
+	}
+	for _, d := range prog.decls {
+		if d.id == id {
+			return d, true
+		}
+	}
+	return tacdecl{}, false
+}
+
+var binops = map[string]bool{
+	"+": true, "-": true, "*": true, "/": true, "%": true,
+	"<": true, ">": true, "<=": true, ">=": true, "==": true, "!=": true,
+}
+
+func parsetac(text string) (*tac, error) {
+	prog := &tac{labels: map[string]int{}, funcs: map[string]*tac{}}
+	cur := prog // function being read, or the main code
+	for i, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		lineno := i + 1
+		switch fields[0] {
+		case "declare", "formal":
+			d, err := parsedecl(fields)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineno, err)
+			}
+			if fields[0] == "declare" {
+				cur.decls = append(cur.decls, *d)
+				continue
+			}
+			if cur == prog || d.size >= 0 || len(cur.decls) > 0 || len(cur.code) > 0 {
+				return nil, fmt.Errorf("line %d: formal must be scalar and follow function header", lineno)
+			}
+			cur.formals = append(cur.formals, *d)
+			continue
+		case "function":
+			if cur != prog {
+				return nil, fmt.Errorf("line %d: function %s not ended", lineno, cur.name)
+			}
+			if len(fields) != 3 || (fields[2] != "int" && fields[2] != "float" && fields[2] != "void") {
+				return nil, fmt.Errorf("line %d: malformed function header %q", lineno, line)
+			}
+			if _, ok := prog.funcs[fields[1]]; ok {
+				return nil, fmt.Errorf("line %d: function %s redefined", lineno, fields[1])
+			}
+			cur = &tac{labels: map[string]int{}, name: fields[1], result: fields[2], funcs: prog.funcs}
+			prog.funcs[cur.name] = cur
+			prog.order = append(prog.order, cur.name)
+			continue
+		case "end":
+			if cur == prog || len(fields) != 1 {
+				return nil, fmt.Errorf("line %d: end outside function", lineno)
+			}
+			cur = prog
+			continue
+		}
+		in, err := parseinstr(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineno, err)
+		}
+		in.lineno = lineno
+		if in.op == opLabel {
+			if _, ok := cur.labels[in.label]; ok {
+				return nil, fmt.Errorf("line %d: label %s redefined", lineno, in.label)
+			}
+			cur.labels[in.label] = len(cur.code)
+		}
+		if in.op == opReturn && cur == prog {
+			return nil, fmt.Errorf("line %d: return outside function", lineno)
+		}
+		cur.code = append(cur.code, *in)
+	}
+	if cur != prog {
+		return nil, fmt.Errorf("function %s not ended", cur.name)
+	}
+	if err := prog.check(); err != nil {
+		return nil, err
+	}
+	for _, name := range prog.order {
+		f := prog.funcs[name]
+		f.order = prog.order
+		if err := f.check(); err != nil {
+			return nil, err
+		}
+	}
+	return prog, nil
+}
+
+// check ensures that every jump has a target and every call a callee.
+func (prog *tac) check() error {
+	for _, in := range prog.code {
+		switch in.op {
+		case opGoto, opIf, opIfFalse, opTable:
+			for _, l := range append([]string{in.label}, in.table...) {
+				if _, ok := prog.labels[l]; !ok {
+					return fmt.Errorf("line %d: undefined label %s", in.lineno, l)
+				}
+			}
+		case opCall:
+			f, ok := prog.funcs[in.label]
+			switch {
+			case !ok:
+				return fmt.Errorf("line %d: call to undefined function %s", in.lineno, in.label)
+			case in.nargs != len(f.formals):
+				return fmt.Errorf("line %d: %s takes %d arguments", in.lineno, in.label, len(f.formals))
+			case in.dst != "" && f.result == "void":
+				return fmt.Errorf("line %d: procedure %s used as value", in.lineno, in.label)
+			}
+		case opReturn:
+			if (in.arg1 == "") != (prog.result == "void") {
+				return fmt.Errorf("line %d: return does not match result type %s", in.lineno, prog.result)
+			}
+		}
+	}
+	return nil
+}
+
+func parsedecl(fields []string) (*tacdecl, error) {
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("malformed declaration %q", strings.Join(fields, " "))
+	}
+	d := &tacdecl{id: fields[1], _type: fields[2], size: -1}
+	if i := strings.IndexByte(d._type, '['); i != -1 {
+		if !strings.HasSuffix(d._type, "]") {
+			return nil, fmt.Errorf("malformed array type %q", d._type)
+		}
+		n, err := strconv.Atoi(d._type[i+1 : len(d._type)-1])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid array size in %q", d._type)
+		}
+		d._type, d.size = d._type[:i], n
+	}
+	if d._type != "int" && d._type != "float" {
+		return nil, fmt.Errorf("unknown type %q", d._type)
+	}
+	return d, nil
+}
+
+// parsecall reads `call p, n` from the fields following any assignment.
+func parsecall(f []string) (*instr, error) {
+	join := strings.Join(f, " ")
+	args := strings.Split(strings.TrimSpace(strings.TrimPrefix(join, "call")), ",")
+	if f[0] != "call" || len(args) != 2 {
+		return nil, fmt.Errorf("malformed call %q", join)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(args[1]))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid argument count in %q", join)
+	}
+	return &instr{op: opCall, label: strings.TrimSpace(args[0]), nargs: n}, nil
+}
+
+func parseinstr(f []string) (*instr, error) {
+	join := strings.Join(f, " ")
+	switch {
+	case len(f) == 1 && strings.HasSuffix(f[0], ":"):
+		return &instr{op: opLabel, label: strings.TrimSuffix(f[0], ":")}, nil
+	case f[0] == "goto" && len(f) > 2:
+		n := len(f)
+		if n < 6 || f[2] != "[" || f[n-3] != "]" || f[n-2] != "else" {
+			return nil, fmt.Errorf("malformed indexed goto %q", join)
+		}
+		return &instr{op: opTable, arg1: f[1], table: f[3 : n-3], label: f[n-1]}, nil
+	case f[0] == "goto":
+		if len(f) != 2 {
+			return nil, fmt.Errorf("malformed goto %q", join)
+		}
+		return &instr{op: opGoto, label: f[1]}, nil
+	case f[0] == "if" || f[0] == "ifFalse":
+		op := opIf
+		if f[0] == "ifFalse" {
+			op = opIfFalse
+		}
+		switch {
+		case len(f) == 4 && f[2] == "goto":
+			return &instr{op: op, arg1: f[1], label: f[3]}, nil
+		case len(f) == 6 && f[4] == "goto" && binops[f[2]]:
+			return &instr{op: op, arg1: f[1], binop: f[2], arg2: f[3], label: f[5]}, nil
+		}
+		return nil, fmt.Errorf("malformed conditional %q", join)
+	case f[0] == "param":
+		if len(f) != 2 {
+			return nil, fmt.Errorf("malformed param %q", join)
+		}
+		return &instr{op: opParam, arg1: f[1]}, nil
+	case f[0] == "call":
+		return parsecall(f)
+	case len(f) >= 3 && f[1] == "=" && f[2] == "call":
+		in, err := parsecall(f[2:])
+		if err != nil {
+			return nil, err
+		}
+		in.dst = f[0]
+		return in, nil
+	case f[0] == "return":
+		switch len(f) {
+		case 1:
+			return &instr{op: opReturn}, nil
+		case 2:
+			return &instr{op: opReturn, arg1: f[1]}, nil
+		}
+		return nil, fmt.Errorf("malformed return %q", join)
+	case len(f) == 3 && f[1] == "=":
+		return &instr{op: opCopy, dst: f[0], arg1: f[2]}, nil
+	case len(f) == 5 && f[1] == "=" && binops[f[3]]:
+		return &instr{op: opBinary, dst: f[0], arg1: f[2], binop: f[3], arg2: f[4]}, nil
+	case len(f) == 6 && f[1] == "=" && f[3] == "[" && f[5] == "]":
+		return &instr{op: opLoad, dst: f[0], arg1: f[2], index: f[4]}, nil
+	case len(f) == 6 && f[1] == "[" && f[3] == "]" && f[4] == "=":
+		return &instr{op: opStore, dst: f[0], index: f[2], arg1: f[5]}, nil
+	}
+	return nil, fmt.Errorf("unknown instruction %q", join)
+}
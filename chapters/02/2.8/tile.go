@@ -0,0 +1,1390 @@
+package trans
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/akiarie/dragon-tests/compilers/ch8/machine"
+)
+
+/*
+Instruction selection for the target machine of Section 8.2, whose
+instructions are
+	LD dst, addr         ST addr, src         OP dst, src1, src2
+	BR L                 Bcond r, L           BR *addr       HALT
+with OP one of ADD, SUB, MUL, DIV and MOD, and Bcond one of BLTZ, BGTZ, BLEZ,
+BGEZ, BEQZ and BNEZ. Every statement of the source is turned into an
+expression tree, and each tree is covered with the cheapest set of tiles by
+dynamic programming (Section 8.11): the cost of an instruction is one, plus one
+for every memory address, label or constant it contains. Registers are then
+chosen by getReg (Section 8.6.2), which tracks the variables held in each
+register so that they need only be loaded once in a basic block, and stored at
+its end.
+
+The machine is dynamically typed: arithmetic is in floating point if either
+operand is a float. A value stored to a float variable is converted as the
+interpreter converts it, by adding #0.0 unless it is a constant, but the
+machine cannot truncate a float, so storing one to an int is an error. Arrays
+have elements of 8 bytes. Relations compare by subtraction:
+	x < y   becomes   SUB R, x, y ; BLTZ R, L
+The code runs on the simulator of chapters/08/8.2.
+
+Functions follow the main code, which ends with HALT, and are called with the
+stack calling sequence of Section 8.3.2. A frame holds the return address at
+0(SP), the formals and the locals in turn, and then the temporaries spilled
+within the function; the caller stores the arguments in the frame above its
+own and the callee leaves its result in R0:
+	ST F+8(SP), R ; ... ; ADD SP, SP, #F ; ST *SP, #L ; BR f ; L: SUB SP, SP, #F
+*/
+
+// tnode is a node of an expression tree.
+type tnode struct {
+	op    string // operator, or one of "id", "const", "index" and "call"
+	val   string // identifier, constant, array or function
+	kids  []*tnode
+	local bool // array in the frame of a function, or call from one
+	cost  int  // least cost of computing the node into a register
+	tile  *tile
+}
+
+func (n *tnode) String() string {
+	switch n.op {
+	case "id", "const":
+		return n.val
+	case "index":
+		return fmt.Sprintf("%s [ %s ]", n.val, n.kids[0])
+	case "call":
+		args := make([]string, len(n.kids))
+		for i, k := range n.kids {
+			args[i] = k.String()
+		}
+		return fmt.Sprintf("%s ( %s )", n.val, strings.Join(args, ", "))
+	}
+	return fmt.Sprintf("(%s %s %s)", n.kids[0], n.op, n.kids[1])
+}
+
+func (n *tnode) constant() bool { return n.op == "const" }
+
+func isrelop(op string) bool {
+	switch op {
+	case "<", ">", "<=", ">=", "==", "!=":
+		return true
+	}
+	return false
+}
+
+func reltree(r rel) (*tnode, error) {
+	head, err := arithmtree(r.head)
+	if err != nil || r.tail == nil {
+		return head, err
+	}
+	tail, err := reltree(r.tail.rel)
+	if err != nil {
+		return nil, err
+	}
+	return &tnode{op: r.tail.boolop.value, kids: []*tnode{head, tail}}, nil
+}
+
+func arithmtree(a arithm) (*tnode, error) {
+	head, err := termtree(a.head)
+	if err != nil || a.tail == nil {
+		return head, err
+	}
+	tail, err := arithmtree(a.tail.arithm)
+	if err != nil {
+		return nil, err
+	}
+	return &tnode{op: a.tail.sign.value, kids: []*tnode{head, tail}}, nil
+}
+
+func termtree(t term) (*tnode, error) {
+	head, err := factortree(t.head)
+	if err != nil || t.tail == nil {
+		return head, err
+	}
+	tail, err := termtree(t.tail.term)
+	if err != nil {
+		return nil, err
+	}
+	return &tnode{op: t.tail.op.value, kids: []*tnode{head, tail}}, nil
+}
+
+func factortree(f factor) (*tnode, error) {
+	switch f.ftype {
+	case factypeId:
+		return &tnode{op: "id", val: fmt.Sprintf("%s", f.node)}, nil
+	case factypeBool, factypeConst:
+		val := fmt.Sprintf("%v", f.node)
+		switch val {
+		case "true":
+			val = "1"
+		case "false":
+			val = "0"
+		}
+		return &tnode{op: "const", val: val}, nil
+	case factypeAccess:
+		acc, _ := f.node.(access)
+		index, err := arithmtree(acc.arithm)
+		if err != nil {
+			return nil, err
+		}
+		return &tnode{op: "index", val: acc.id, kids: []*tnode{index}}, nil
+	case factypeCall:
+		c, _ := f.node.(call)
+		n := &tnode{op: "call", val: c.id}
+		for _, a := range c.args {
+			arg, err := reltree(a)
+			if err != nil {
+				return nil, err
+			}
+			n.kids = append(n.kids, arg)
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("unknown factor %v", f)
+}
+
+// tile is a pattern covering the root of an expression tree and perhaps some
+// of its descendants.
+type tile struct {
+	name string
+	// cost is the cost of the tile and of the subtrees it leaves uncovered,
+	// or -1 if the tile does not match
+	cost func(n *tnode) int
+	emit func(g *tiler, n *tnode) (*operand, error)
+}
+
+var machineops = map[string]string{"+": "ADD", "-": "SUB", "*": "MUL", "/": "DIV", "%": "MOD"}
+
+var tiles = []*tile{
+	{
+		name: "LD R, #c",
+		cost: func(n *tnode) int {
+			if !n.constant() {
+				return -1
+			}
+			return 2
+		},
+		emit: func(g *tiler, n *tnode) (*operand, error) {
+			r, err := g.getreg(nil)
+			if err != nil {
+				return nil, err
+			}
+			g.emit("LD %s, #%s", regname(r), n.val)
+			return g.result(r), nil
+		},
+	},
+	{
+		name: "LD R, x",
+		cost: func(n *tnode) int {
+			if n.op != "id" {
+				return -1
+			}
+			return 2
+		},
+		emit: func(g *tiler, n *tnode) (*operand, error) {
+			return &operand{v: n.val, reg: -1}, nil
+		},
+	},
+	{
+		name: "LD R, #8c ; LD R, a(R)",
+		cost: func(n *tnode) int {
+			if n.op != "index" || n.local || !n.kids[0].constant() {
+				return -1
+			}
+			return 4
+		},
+		emit: func(g *tiler, n *tnode) (*operand, error) {
+			c, err := strconv.Atoi(n.kids[0].val)
+			if err != nil {
+				return nil, fmt.Errorf("non-integer index %s", n.kids[0])
+			}
+			r, err := g.getreg(nil)
+			if err != nil {
+				return nil, err
+			}
+			g.emit("LD %s, #%d", regname(r), 8*c)
+			g.emit("LD %s, %s(%s)", regname(r), n.val, regname(r))
+			return g.result(r), nil
+		},
+	},
+	{
+		name: "LD R, c(SP)",
+		cost: func(n *tnode) int {
+			if n.op != "index" || !n.local || !n.kids[0].constant() {
+				return -1
+			}
+			return 2
+		},
+		emit: func(g *tiler, n *tnode) (*operand, error) {
+			c, err := strconv.Atoi(n.kids[0].val)
+			if err != nil {
+				return nil, fmt.Errorf("non-integer index %s", n.kids[0])
+			}
+			r, err := g.getreg(nil)
+			if err != nil {
+				return nil, err
+			}
+			g.emit("LD %s, %d(SP)", regname(r), g.frame[n.val]+8*c)
+			return g.result(r), nil
+		},
+	},
+	{
+		// a local array also needs ADD R, R, SP
+		name: "MUL R, R, #8 ; LD R, a(R)",
+		cost: func(n *tnode) int {
+			if n.op != "index" {
+				return -1
+			}
+			if n.local {
+				return n.kids[0].cost + 5
+			}
+			return n.kids[0].cost + 4
+		},
+		emit: func(g *tiler, n *tnode) (*operand, error) {
+			index, err := g.eval(n.kids[0])
+			if err != nil {
+				return nil, err
+			}
+			r, err := g.offset(index)
+			if err != nil {
+				return nil, err
+			}
+			g.emit("LD %s, %s", regname(r), g.element(n.val, r))
+			return g.result(r), nil
+		},
+	},
+	{
+		name: "OP R, R, R",
+		cost: func(n *tnode) int {
+			if machineops[n.op] == "" {
+				return -1
+			}
+			return n.kids[0].cost + n.kids[1].cost + 1
+		},
+		emit: func(g *tiler, n *tnode) (*operand, error) {
+			return g.binary(machineops[n.op], n.kids[0], n.kids[1], false)
+		},
+	},
+	{
+		name: "OP R, R, #c",
+		cost: func(n *tnode) int {
+			if machineops[n.op] == "" || !n.kids[1].constant() {
+				return -1
+			}
+			return n.kids[0].cost + 2
+		},
+		emit: func(g *tiler, n *tnode) (*operand, error) {
+			return g.binary(machineops[n.op], n.kids[0], n.kids[1], true)
+		},
+	},
+	{
+		name: "OP R, R, #c (commuted)",
+		cost: func(n *tnode) int {
+			if (n.op != "+" && n.op != "*") || !n.kids[0].constant() {
+				return -1
+			}
+			return n.kids[1].cost + 2
+		},
+		emit: func(g *tiler, n *tnode) (*operand, error) {
+			return g.binary(machineops[n.op], n.kids[1], n.kids[0], true)
+		},
+	},
+	{
+		// SUB R, R, R ; Bcond R, L1 ; LD R, #0 ; BR L2 ; L1: LD R, #1 ; L2:
+		name: "relation",
+		cost: func(n *tnode) int {
+			if !isrelop(n.op) {
+				return -1
+			}
+			return n.kids[0].cost + n.kids[1].cost + 1 + 8
+		},
+		emit: func(g *tiler, n *tnode) (*operand, error) {
+			return g.relation(n, false)
+		},
+	},
+	{
+		name: "relation with #c",
+		cost: func(n *tnode) int {
+			if !isrelop(n.op) || !n.kids[1].constant() {
+				return -1
+			}
+			return n.kids[0].cost + 2 + 8
+		},
+		emit: func(g *tiler, n *tnode) (*operand, error) {
+			return g.relation(n, true)
+		},
+	},
+	{
+		// ST c(SP), R for each argument ; ST *SP, #L ; BR f, within ADD SP
+		// and SUB SP in a function
+		name: "call",
+		cost: func(n *tnode) int {
+			if n.op != "call" {
+				return -1
+			}
+			c := 2 + 2
+			if n.local {
+				c += 2 + 2
+			}
+			for _, k := range n.kids {
+				c += k.cost + 2
+			}
+			return c
+		},
+		emit: func(g *tiler, n *tnode) (*operand, error) {
+			return g.call(n, true)
+		},
+	},
+}
+
+// labeltree chooses the cheapest tile for every node of the tree, bottom-up.
+func labeltree(n *tnode) error {
+	for _, k := range n.kids {
+		if err := labeltree(k); err != nil {
+			return err
+		}
+	}
+	n.tile = nil
+	for _, t := range tiles {
+		if c := t.cost(n); c >= 0 && (n.tile == nil || c < n.cost) {
+			n.tile, n.cost = t, c
+		}
+	}
+	if n.tile == nil {
+		return fmt.Errorf("no tile covers %s", n)
+	}
+	return nil
+}
+
+// operand is the value of a subtree once evaluated: either the current value
+// of a variable, found through the register descriptors, or an intermediate
+// result held in a register or, if it has been spilled, in memory.
+type operand struct {
+	v   string
+	reg int
+	mem string
+}
+
+type regdesc struct {
+	vars varset   // variables whose current value the register holds
+	tmp  *operand // intermediate result held
+}
+
+type tiler struct {
+	k      int
+	regs   []regdesc
+	addr   map[string]int // variable to the register holding it
+	dirty  varset         // variables whose value in memory is stale
+	data   []string       // directives reserving memory
+	temps  int
+	labels int
+	escape []string  // targets of break
+	cont   []*string // targets of continue, nil for a switch
+	out    []string
+
+	funcs map[string]*funcdef
+	types map[string]string // declared type of each variable in scope
+	fn    *funcdef          // function being generated, or nil for the main code
+	frame map[string]int    // offset of each formal and local in the frame
+	base  int               // offset of the first temporary in the frame
+	size  int               // size of the frame
+}
+
+func (g *tiler) emit(format string, a ...interface{}) {
+	g.out = append(g.out, fmt.Sprintf("\t"+format, a...))
+}
+
+func (g *tiler) newlabel() string {
+	g.labels++
+	return fmt.Sprintf("L%d", g.labels-1)
+}
+
+// mem is the location of the variable v: its place in the frame within a
+// function, or its reserved name in the main code.
+func (g *tiler) mem(v string) string {
+	if off, ok := g.frame[v]; ok {
+		return fmt.Sprintf("%d(SP)", off)
+	}
+	return v
+}
+
+// temp reserves a fresh temporary, in the frame within a function.
+func (g *tiler) temp() string {
+	g.temps++
+	if g.fn != nil {
+		return fmt.Sprintf("%d(SP)", g.base+8*(g.temps-1))
+	}
+	t := fmt.Sprintf("T%d", g.temps-1)
+	g.data = append(g.data, fmt.Sprintf(".data %s 8", t))
+	return t
+}
+
+func (g *tiler) result(r int) *operand {
+	o := &operand{reg: r}
+	g.regs[r].tmp = o
+	return o
+}
+
+// evict empties register r, storing the variables for which it holds the only
+// current value and spilling any intermediate result to a fresh temporary.
+func (g *tiler) evict(r int) {
+	d := &g.regs[r]
+	for _, v := range d.vars.sorted() {
+		if g.dirty[v] {
+			g.emit("ST %s, %s", g.mem(v), regname(r))
+			delete(g.dirty, v)
+		}
+		delete(g.addr, v)
+	}
+	d.vars = varset{}
+	if d.tmp != nil {
+		t := g.temp()
+		g.emit("ST %s, %s", t, regname(r))
+		d.tmp.reg, d.tmp.mem = -1, t
+		d.tmp = nil
+	}
+}
+
+// getreg chooses a register outside pinned, preferring one that is empty or
+// whose variables are also in memory, and spilling an intermediate result only
+// as a last resort.
+func (g *tiler) getreg(pinned map[int]bool) (int, error) {
+	best, bestcost := -1, 0
+	for r, d := range g.regs {
+		if pinned[r] {
+			continue
+		}
+		cost := 0
+		if d.tmp != nil {
+			cost += 1000
+		}
+		for v := range d.vars {
+			cost += 2
+			if g.dirty[v] {
+				cost += 10
+			}
+		}
+		if best == -1 || cost < bestcost {
+			best, bestcost = r, cost
+		}
+	}
+	if best == -1 {
+		return -1, fmt.Errorf("too few registers")
+	}
+	g.evict(best)
+	return best, nil
+}
+
+// inreg puts the operand in a register outside pinned.
+func (g *tiler) inreg(o *operand, pinned map[int]bool) (int, error) {
+	if o.v != "" {
+		if r, ok := g.addr[o.v]; ok {
+			return r, nil
+		}
+		r, err := g.getreg(pinned)
+		if err != nil {
+			return -1, err
+		}
+		g.emit("LD %s, %s", regname(r), g.mem(o.v))
+		g.regs[r].vars[o.v] = true
+		g.addr[o.v] = r
+		return r, nil
+	}
+	if o.reg >= 0 {
+		return o.reg, nil
+	}
+	r, err := g.getreg(pinned)
+	if err != nil {
+		return -1, err
+	}
+	g.emit("LD %s, %s", regname(r), o.mem)
+	o.reg, o.mem = r, ""
+	g.regs[r].tmp = o
+	return r, nil
+}
+
+// release frees the register of an intermediate result once it has been used.
+func (g *tiler) release(o *operand) {
+	if o.v == "" && o.reg >= 0 && g.regs[o.reg].tmp == o {
+		g.regs[o.reg].tmp = nil
+	}
+}
+
+// eval emits the code for a labelled tree.
+func (g *tiler) eval(n *tnode) (*operand, error) {
+	return n.tile.emit(g, n)
+}
+
+// operands evaluates a pair of subtrees and puts them in registers, returning
+// the second as an immediate constant if imm is set.
+func (g *tiler) operands(l, r *tnode, imm bool) (int, string, error) {
+	lo, err := g.eval(l)
+	if err != nil {
+		return -1, "", err
+	}
+	var ro *operand
+	if !imm {
+		if ro, err = g.eval(r); err != nil {
+			return -1, "", err
+		}
+	}
+	rl, err := g.inreg(lo, nil)
+	if err != nil {
+		return -1, "", err
+	}
+	g.release(lo)
+	if imm {
+		return rl, "#" + r.val, nil
+	}
+	rr, err := g.inreg(ro, map[int]bool{rl: true})
+	if err != nil {
+		return -1, "", err
+	}
+	g.release(ro)
+	return rl, regname(rr), nil
+}
+
+func (g *tiler) binary(op string, l, r *tnode, imm bool) (*operand, error) {
+	rl, src2, err := g.operands(l, r, imm)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := g.getreg(nil)
+	if err != nil {
+		return nil, err
+	}
+	g.emit("%s %s, %s, %s", op, regname(dst), regname(rl), src2)
+	return g.result(dst), nil
+}
+
+// offset turns an evaluated index into a byte offset in a free register.
+func (g *tiler) offset(index *operand) (int, error) {
+	ri, err := g.inreg(index, nil)
+	if err != nil {
+		return -1, err
+	}
+	g.release(index)
+	r, err := g.getreg(nil)
+	if err != nil {
+		return -1, err
+	}
+	g.emit("MUL %s, %s, #8", regname(r), regname(ri))
+	return r, nil
+}
+
+// element turns the byte offset in register r into the location of an element
+// of array.
+func (g *tiler) element(array string, r int) string {
+	off, ok := g.frame[array]
+	if !ok {
+		return fmt.Sprintf("%s(%s)", array, regname(r))
+	}
+	g.emit("ADD %s, %s, SP", regname(r), regname(r))
+	return fmt.Sprintf("%d(%s)", off, regname(r))
+}
+
+// branches maps each relation to the branch taken when it holds.
+var branches = map[string]string{
+	"<": "BLTZ", ">": "BGTZ", "<=": "BLEZ", ">=": "BGEZ", "==": "BEQZ", "!=": "BNEZ",
+}
+
+var negations = map[string]string{
+	"<": ">=", ">": "<=", "<=": ">", ">=": "<", "==": "!=", "!=": "==",
+}
+
+// difference leaves the difference of the operands of a relation in a free
+// register.
+func (g *tiler) difference(n *tnode, imm bool) (int, error) {
+	rl, src2, err := g.operands(n.kids[0], n.kids[1], imm)
+	if err != nil {
+		return -1, err
+	}
+	r, err := g.getreg(nil)
+	if err != nil {
+		return -1, err
+	}
+	g.emit("SUB %s, %s, %s", regname(r), regname(rl), src2)
+	return r, nil
+}
+
+func (g *tiler) relation(n *tnode, imm bool) (*operand, error) {
+	r, err := g.difference(n, imm)
+	if err != nil {
+		return nil, err
+	}
+	// only r changes between the branch and the join, so the descriptors
+	// remain valid throughout
+	yes, join := g.newlabel(), g.newlabel()
+	g.emit("%s %s, %s", branches[n.op], regname(r), yes)
+	g.emit("LD %s, #0", regname(r))
+	g.emit("BR %s", join)
+	g.out = append(g.out, yes+":")
+	g.emit("LD %s, #1", regname(r))
+	g.out = append(g.out, join+":")
+	return g.result(r), nil
+}
+
+// flush stores every variable whose value in memory is stale, as at the end of
+// a basic block.
+func (g *tiler) flush() {
+	for r := range g.regs {
+		for _, v := range g.regs[r].vars.sorted() {
+			if g.dirty[v] {
+				g.emit("ST %s, %s", g.mem(v), regname(r))
+				delete(g.dirty, v)
+			}
+		}
+	}
+}
+
+// forget empties the descriptors, as at the start of a basic block.
+func (g *tiler) forget() {
+	for r := range g.regs {
+		g.regs[r] = regdesc{vars: varset{}}
+	}
+	g.addr = map[string]int{}
+}
+
+// enterloop pushes the targets of break and continue, as table.enterloop does.
+func (g *tiler) enterloop(esc string, cont *string) {
+	g.escape, g.cont = append(g.escape, esc), append(g.cont, cont)
+}
+
+func (g *tiler) exitloop() {
+	n := len(g.escape) - 1
+	g.escape, g.cont = g.escape[:n], g.cont[:n]
+}
+
+func (g *tiler) label(l string) {
+	g.flush()
+	g.forget()
+	g.out = append(g.out, l+":")
+}
+
+func (g *tiler) jump(l string) {
+	g.flush()
+	g.emit("BR %s", l)
+	g.forget()
+}
+
+// call emits the calling sequence for the call n, whose arguments are all
+// evaluated and converted to the types of the formals before any is stored. The callee may use every register, so they
+// are all emptied first; the result is left in R0.
+func (g *tiler) call(n *tnode, result bool) (*operand, error) {
+	f, ok := g.funcs[n.val]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("call to undefined function %s", n.val)
+	case len(n.kids) != len(f.params):
+		return nil, fmt.Errorf("%s takes %d arguments, not %d", n.val, len(f.params), len(n.kids))
+	case result && f._type.value == "void":
+		return nil, fmt.Errorf("procedure %s used as value", n.val)
+	}
+	args := make([]*operand, len(n.kids))
+	for i, k := range n.kids {
+		floatconst(k, f.params[i]._type.value)
+		o, err := g.eval(k)
+		if err != nil {
+			return nil, err
+		}
+		if args[i], err = g.convert(k, o, f.params[i]._type.value); err != nil {
+			return nil, err
+		}
+	}
+	for i, o := range args {
+		r, err := g.inreg(o, nil)
+		if err != nil {
+			return nil, err
+		}
+		g.release(o)
+		g.emit("ST %d(SP), %s", g.size+8+8*i, regname(r))
+	}
+	for r := range g.regs {
+		g.evict(r)
+	}
+	ret := g.newlabel()
+	if g.fn != nil {
+		g.emit("ADD SP, SP, #%d", g.size)
+	}
+	g.emit("ST *SP, #%s", ret)
+	g.emit("BR %s", n.val)
+	g.out = append(g.out, ret+":")
+	if g.fn != nil {
+		g.emit("SUB SP, SP, #%d", g.size)
+	}
+	return g.result(0), nil
+}
+
+// labeltree marks the local arrays and the calls of the tree, checking that
+// every variable of a function is its own, and then labels it.
+func (g *tiler) labeltree(n *tnode) error {
+	if err := g.scope(n); err != nil {
+		return err
+	}
+	return labeltree(n)
+}
+
+func (g *tiler) scope(n *tnode) error {
+	for _, k := range n.kids {
+		if err := g.scope(k); err != nil {
+			return err
+		}
+	}
+	switch n.op {
+	case "id", "index":
+		if _, ok := g.frame[n.val]; g.fn != nil && !ok {
+			return fmt.Errorf("%s is not a variable of function %s", n.val, g.fn.id)
+		}
+		n.local = g.fn != nil
+	case "call":
+		n.local = g.fn != nil
+	}
+	return nil
+}
+
+// branch jumps to l if the condition evaluates to when.
+func (g *tiler) branch(cond *tnode, when bool, l string) error {
+	if err := g.labeltree(cond); err != nil {
+		return err
+	}
+	if cond.constant() {
+		if v, err := parsevalue(cond.val); err == nil && v.truth() == when {
+			g.jump(l)
+		}
+		return nil
+	}
+	if isrelop(cond.op) {
+		op := cond.op
+		if !when {
+			op = negations[op]
+		}
+		r, err := g.difference(cond, cond.kids[1].constant())
+		if err != nil {
+			return err
+		}
+		g.flush()
+		g.emit("%s %s, %s", branches[op], regname(r), l)
+		return nil
+	}
+	o, err := g.eval(cond)
+	if err != nil {
+		return err
+	}
+	r, err := g.inreg(o, nil)
+	if err != nil {
+		return err
+	}
+	g.release(o)
+	g.flush()
+	if when {
+		g.emit("BNEZ %s, %s", regname(r), l)
+	} else {
+		g.emit("BEQZ %s, %s", regname(r), l)
+	}
+	return nil
+}
+
+// typeof is the type of the value of the tree n: a float if any of its
+// operands is, as in the machine's arithmetic.
+func (g *tiler) typeof(n *tnode) string {
+	switch {
+	case n.constant():
+		if v, err := parsevalue(n.val); err == nil && v.isfloat {
+			return "float"
+		}
+		return "int"
+	case n.op == "id", n.op == "index":
+		return g.types[n.val]
+	case n.op == "call":
+		if f, ok := g.funcs[n.val]; ok {
+			return f._type.value
+		}
+		return ""
+	case isrelop(n.op):
+		return "int"
+	}
+	if g.typeof(n.kids[0]) == "float" || g.typeof(n.kids[1]) == "float" {
+		return "float"
+	}
+	return "int"
+}
+
+// floatconst makes n a float literal if it is an integer constant stored to a
+// location of type to, so that it need not be converted.
+func floatconst(n *tnode, to string) {
+	if v, err := parsevalue(n.val); n.constant() && to == "float" && err == nil && !v.isfloat {
+		n.val = strconv.FormatFloat(float64(v.i), 'f', 1, 64)
+	}
+}
+
+// convert converts the value o of the tree n for a location of type to.
+func (g *tiler) convert(n *tnode, o *operand, to string) (*operand, error) {
+	from := g.typeof(n)
+	switch {
+	case from == "float" && to == "int":
+		return nil, fmt.Errorf("cannot truncate %s to int on the target machine", n)
+	case from == "float" || to != "float":
+		return o, nil
+	}
+	r, err := g.inreg(o, nil)
+	if err != nil {
+		return nil, err
+	}
+	g.release(o)
+	dst := r
+	if o.v != "" {
+		// the register holds the variable, which keeps its type
+		if dst, err = g.getreg(map[int]bool{r: true}); err != nil {
+			return nil, err
+		}
+	}
+	g.emit("ADD %s, %s, #0.0", regname(dst), regname(r))
+	return g.result(dst), nil
+}
+
+// assign makes the value of o that of the variable x.
+func (g *tiler) assign(x string, o *operand) error {
+	r, err := g.inreg(o, nil)
+	if err != nil {
+		return err
+	}
+	g.release(o)
+	if old, ok := g.addr[x]; ok {
+		delete(g.regs[old].vars, x)
+	}
+	g.regs[r].vars[x] = true
+	g.addr[x] = r
+	g.dirty[x] = true
+	return nil
+}
+
+// store makes the value of o that of the element of array at index.
+func (g *tiler) store(array string, index *tnode, o *operand) error {
+	var r int
+	var err error
+	if off, ok := g.frame[array]; ok && index.constant() {
+		c, err := strconv.Atoi(index.val)
+		if err != nil {
+			return fmt.Errorf("non-integer index %s", index)
+		}
+		rv, err := g.inreg(o, nil)
+		if err != nil {
+			return err
+		}
+		g.release(o)
+		g.emit("ST %d(SP), %s", off+8*c, regname(rv))
+		return nil
+	}
+	if index.constant() {
+		c, err := strconv.Atoi(index.val)
+		if err != nil {
+			return fmt.Errorf("non-integer index %s", index)
+		}
+		if r, err = g.getreg(nil); err != nil {
+			return err
+		}
+		g.emit("LD %s, #%d", regname(r), 8*c)
+	} else {
+		io, err := g.eval(index)
+		if err != nil {
+			return err
+		}
+		if r, err = g.offset(io); err != nil {
+			return err
+		}
+	}
+	rv, err := g.inreg(o, map[int]bool{r: true})
+	if err != nil {
+		return err
+	}
+	g.release(o)
+	g.emit("ST %s, %s", g.element(array, r), regname(rv))
+	return nil
+}
+
+func (g *tiler) expr(ex expr) error {
+	if len(ex) == 1 {
+		if _, ok := ex[0].call(); ok {
+			return g.callstmt(ex[0])
+		}
+	}
+	trees := make([]*tnode, len(ex))
+	for i, r := range ex {
+		t, err := reltree(r)
+		if err != nil {
+			return err
+		}
+		trees[i] = t
+	}
+	rhs := trees[len(trees)-1]
+	if err := g.labeltree(rhs); err != nil {
+		return err
+	}
+	if len(trees) > 1 {
+		floatconst(rhs, g.typeof(trees[len(trees)-2]))
+	}
+	g.out = append(g.out, fmt.Sprintf("\t// %s (cost %d)", ex, rhs.cost))
+	o, err := g.eval(rhs)
+	if err != nil {
+		return err
+	}
+	// assignments associate to the right
+	for i := len(trees) - 2; i >= 0; i-- {
+		lhs := trees[i]
+		if o, err = g.convert(trees[i+1], o, g.typeof(lhs)); err != nil {
+			return err
+		}
+		switch lhs.op {
+		case "id":
+			if err := g.assign(lhs.val, o); err != nil {
+				return err
+			}
+			o = &operand{v: lhs.val, reg: -1}
+		case "index":
+			if err := g.labeltree(lhs.kids[0]); err != nil {
+				return err
+			}
+			if err := g.store(lhs.val, lhs.kids[0], o); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("cannot assign to %s", lhs)
+		}
+	}
+	g.release(o)
+	return nil
+}
+
+// callstmt calls a procedure, or a function whose result is discarded.
+func (g *tiler) callstmt(r rel) error {
+	t, err := reltree(r)
+	if err != nil {
+		return err
+	}
+	if err := g.labeltree(t); err != nil {
+		return err
+	}
+	g.out = append(g.out, fmt.Sprintf("\t// %s (cost %d)", t, t.cost))
+	o, err := g.call(t, false)
+	if err != nil {
+		return err
+	}
+	g.release(o)
+	return nil
+}
+
+// ret leaves the value of the function in R0 and returns to the caller.
+func (g *tiler) ret(s retstmt) error {
+	if g.fn == nil {
+		return fmt.Errorf("return statement outside function")
+	}
+	if void := g.fn._type.value == "void"; void != (s.val == nil) {
+		if void {
+			return fmt.Errorf("procedure %s cannot return a value", g.fn.id)
+		}
+		return fmt.Errorf("function %s must return a value", g.fn.id)
+	}
+	if s.val != nil {
+		t, err := reltree(*s.val)
+		if err != nil {
+			return err
+		}
+		if err := g.labeltree(t); err != nil {
+			return err
+		}
+		floatconst(t, g.fn._type.value)
+		g.out = append(g.out, fmt.Sprintf("\t// return %s (cost %d)", t, t.cost))
+		o, err := g.eval(t)
+		if err != nil {
+			return err
+		}
+		if o, err = g.convert(t, o, g.fn._type.value); err != nil {
+			return err
+		}
+		r, err := g.inreg(o, nil)
+		if err != nil {
+			return err
+		}
+		g.release(o)
+		if r != 0 {
+			g.emit("LD R0, %s", regname(r))
+		}
+	}
+	// the locals die with the frame
+	g.emit("BR *0(SP)")
+	g.forget()
+	g.dirty = varset{}
+	return nil
+}
+
+func (g *tiler) stmt(n node) error {
+	switch s := n.(type) {
+	case block:
+		for _, stmt := range s.stmts {
+			if err := g.stmt(stmt); err != nil {
+				return err
+			}
+		}
+	case expr:
+		return g.expr(s)
+	case *decl:
+	case ifstmt:
+		cond, err := exprtree(s.expr)
+		if err != nil {
+			return err
+		}
+		after := g.newlabel()
+		els := after
+		if s.els != nil {
+			els = g.newlabel()
+		}
+		if err := g.branch(cond, false, els); err != nil {
+			return err
+		}
+		if err := g.stmt(s.stmt); err != nil {
+			return err
+		}
+		if s.els != nil {
+			g.jump(after)
+			g.label(els)
+			if err := g.stmt(s.els); err != nil {
+				return err
+			}
+		}
+		g.label(after)
+	case whilestmt:
+		cond, err := exprtree(s.expr)
+		if err != nil {
+			return err
+		}
+		before, after := g.newlabel(), g.newlabel()
+		g.label(before)
+		if err := g.branch(cond, false, after); err != nil {
+			return err
+		}
+		g.enterloop(after, &before)
+		if err := g.stmt(s.stmt); err != nil {
+			return err
+		}
+		g.exitloop()
+		g.jump(before)
+		g.label(after)
+	case dostmt:
+		cond, err := exprtree(s.expr)
+		if err != nil {
+			return err
+		}
+		before, after := g.newlabel(), g.newlabel()
+		g.label(before)
+		var next string
+		g.enterloop(after, &next)
+		if err := g.stmt(s.stmt); err != nil {
+			return err
+		}
+		g.exitloop()
+		if next != "" {
+			g.label(next)
+		}
+		if err := g.branch(cond, true, before); err != nil {
+			return err
+		}
+		g.label(after)
+	case forstmt:
+		if len(s.init) > 0 {
+			if err := g.expr(s.init); err != nil {
+				return err
+			}
+		}
+		before, after := g.newlabel(), g.newlabel()
+		g.label(before)
+		if len(s.cond) > 0 {
+			cond, err := exprtree(s.cond)
+			if err != nil {
+				return err
+			}
+			if err := g.branch(cond, false, after); err != nil {
+				return err
+			}
+		}
+		var next string
+		g.enterloop(after, &next)
+		if err := g.stmt(s.stmt); err != nil {
+			return err
+		}
+		g.exitloop()
+		if next != "" {
+			g.label(next)
+		}
+		if len(s.step) > 0 {
+			if err := g.expr(s.step); err != nil {
+				return err
+			}
+		}
+		g.jump(before)
+		g.label(after)
+	case switchstmt:
+		return g.switchstmt(s)
+	case command:
+		if s.kind == comContinue {
+			return g.next()
+		}
+		if len(g.escape) == 0 {
+			return fmt.Errorf("break statement out of loop")
+		}
+		g.jump(g.escape[len(g.escape)-1])
+	case retstmt:
+		return g.ret(s)
+	default:
+		return fmt.Errorf("unknown statement %T", n)
+	}
+	return nil
+}
+
+// next jumps to the continue target of the innermost loop, giving it a label if
+// it has none.
+func (g *tiler) next() error {
+	for i := len(g.cont) - 1; i >= 0; i-- {
+		if c := g.cont[i]; c != nil {
+			if *c == "" {
+				*c = g.newlabel()
+			}
+			g.jump(*c)
+			return nil
+		}
+	}
+	return fmt.Errorf("continue statement out of loop")
+}
+
+// switchstmt tests the cases in turn if they are sparse, and otherwise jumps
+// through a table of branches, indexed by the value less the least case lo:
+//
+//	SUB R, V, #lo ; BLTZ R, Ld ; SUB R', R, #n ; BGEZ R', Ld ; ADD R, R, #Lt ; BR *R
+//	Lt: BR L0 ; BR L1 ; ...
+func (g *tiler) switchstmt(sw switchstmt) error {
+	values, d, err := sw.check()
+	if err != nil {
+		return err
+	}
+	t, err := exprtree(sw.expr)
+	if err != nil {
+		return err
+	}
+	if err := g.labeltree(t); err != nil {
+		return err
+	}
+	g.out = append(g.out, fmt.Sprintf("\t// switch ( %s ) (cost %d)", t, t.cost))
+	o, err := g.eval(t)
+	if err != nil {
+		return err
+	}
+	after := g.newlabel()
+	bodies, labels := make([]string, len(sw.cases)), map[int64]string{}
+	for i, c := range sw.cases {
+		bodies[i] = g.newlabel()
+		if !c.def {
+			labels[c.value] = bodies[i]
+		}
+	}
+	def := after
+	if d >= 0 {
+		def = bodies[d]
+	}
+	rv, err := g.inreg(o, nil)
+	if err != nil {
+		return err
+	}
+	// the value may be that of a variable, so is left intact in rv
+	r, err := g.getreg(map[int]bool{rv: true})
+	if err != nil {
+		return err
+	}
+	g.release(o)
+	g.flush()
+	if dense(values) {
+		lo, hi := values[0], values[len(values)-1]
+		g.emit("SUB %s, %s, #%d", regname(r), regname(rv), lo)
+		g.emit("BLTZ %s, %s", regname(r), def)
+		above, err := g.getreg(map[int]bool{r: true})
+		if err != nil {
+			return err
+		}
+		g.emit("SUB %s, %s, #%d", regname(above), regname(r), hi-lo+1)
+		g.emit("BGEZ %s, %s", regname(above), def)
+		table := g.newlabel()
+		g.emit("ADD %s, %s, #%s", regname(r), regname(r), table)
+		g.emit("BR *%s", regname(r))
+		g.out = append(g.out, table+":")
+		for i := int64(0); i <= hi-lo; i++ {
+			if l, ok := labels[lo+i]; ok {
+				g.emit("BR %s", l)
+			} else {
+				g.emit("BR %s", def)
+			}
+		}
+	} else {
+		for i, c := range sw.cases {
+			if !c.def {
+				g.emit("SUB %s, %s, #%d", regname(r), regname(rv), c.value)
+				g.emit("BEQZ %s, %s", regname(r), bodies[i])
+			}
+		}
+		g.emit("BR %s", def)
+	}
+	g.forget()
+	g.enterloop(after, nil)
+	for i, c := range sw.cases {
+		g.label(bodies[i])
+		if err := g.stmt(c.body); err != nil {
+			return err
+		}
+	}
+	g.exitloop()
+	g.label(after)
+	return nil
+}
+
+// exprtree converts a condition, which may not be an assignment.
+func exprtree(ex expr) (*tnode, error) {
+	if len(ex) != 1 {
+		return nil, fmt.Errorf("assignment %s used as condition", ex)
+	}
+	return reltree(ex[0])
+}
+
+// declarations calls visit with every variable declared in the statement.
+func declarations(n node, visit func(d *decl)) {
+	switch s := n.(type) {
+	case block:
+		for _, stmt := range s.stmts {
+			declarations(stmt, visit)
+		}
+	case *decl:
+		visit(s)
+	case ifstmt:
+		declarations(s.stmt, visit)
+		if s.els != nil {
+			declarations(s.els, visit)
+		}
+	case whilestmt:
+		declarations(s.stmt, visit)
+	case dostmt:
+		declarations(s.stmt, visit)
+	case forstmt:
+		declarations(s.stmt, visit)
+	case switchstmt:
+		for _, c := range s.cases {
+			declarations(c.body, visit)
+		}
+	}
+}
+
+// size is the number of bytes taken by the variable.
+func (d *decl) size() int {
+	if d.num == nil {
+		return 8
+	}
+	n, _ := strconv.Atoi(d.num.value)
+	return 8 * n
+}
+
+// function generates the code of f. Calls need the size of the frame before
+// every temporary is known, so the code is generated again if the first guess
+// proves wrong; the second pass spills exactly as the first.
+func (g *tiler) function(f *funcdef) error {
+	g.fn, g.frame, g.types = f, map[string]int{}, map[string]string{}
+	off := 8
+	for _, d := range f.params {
+		g.frame[d.id.value] = off
+		g.types[d.id.value] = d._type.value
+		off += 8
+	}
+	declarations(f.body, func(d *decl) {
+		g.frame[d.id.value] = off
+		g.types[d.id.value] = d._type.value
+		off += d.size()
+	})
+	g.base, g.size = off, off
+	out, labels := len(g.out), g.labels
+	for {
+		g.out, g.labels, g.temps = g.out[:out], labels, 0
+		g.forget()
+		g.dirty = varset{}
+		g.out = append(g.out, f.id.value+":")
+		// locals start at zero, as in the interpreter
+		for a := 8 + 8*len(f.params); a < g.base; a += 8 {
+			g.emit("ST %d(SP), #0", a)
+		}
+		if err := g.stmt(f.body); err != nil {
+			return fmt.Errorf("function %s: %v", f.id, err)
+		}
+		// falling off the end returns zero, as in the interpreter
+		if g.out[len(g.out)-1] != "\tBR *0(SP)" {
+			if f._type.value != "void" {
+				g.emit("LD R0, #0")
+			}
+			g.emit("BR *0(SP)")
+		}
+		if size := g.base + 8*g.temps; size != g.size {
+			g.size = size
+			continue
+		}
+		return nil
+	}
+}
+
+// gentarget generates code for the target machine using k registers.
+func gentarget(prog *program, k int) (string, error) {
+	if k < 2 {
+		return "", fmt.Errorf("cannot generate code with %d registers", k)
+	}
+	g := &tiler{k: k, regs: make([]regdesc, k), dirty: varset{}, funcs: map[string]*funcdef{}, types: map[string]string{}}
+	for _, f := range prog.funcs {
+		if _, ok := g.funcs[f.id.value]; ok {
+			return "", fmt.Errorf("function %s redefined", f.id)
+		}
+		g.funcs[f.id.value] = f
+	}
+	g.forget()
+	declarations(prog.main, func(d *decl) {
+		g.data = append(g.data, fmt.Sprintf(".data %s %d", d.id, d.size()))
+		g.types[d.id.value] = d._type.value
+	})
+	if err := g.stmt(prog.main); err != nil {
+		return "", err
+	}
+	g.flush()
+	if len(prog.funcs) > 0 {
+		g.emit("HALT")
+	}
+	for _, f := range prog.funcs {
+		if err := g.function(f); err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(append(g.data, g.out...), "\n") + "\n", nil
+}
+
+// loadtarget loads the code produced by gentarget into the simulator, setting
+// the initial values inits (see parseinit).
+func loadtarget(code string, inits []string) (*machine.Machine, error) {
+	m, err := machine.New(code)
+	if err != nil {
+		return nil, err
+	}
+	for _, assign := range inits {
+		iv, err := parseinit(assign)
+		if err != nil {
+			return nil, err
+		}
+		vals := make([]machine.Value, len(iv.vals))
+		for i, v := range iv.vals {
+			vals[i] = machine.Value{IsFloat: v.isfloat, I: v.i, F: v.f}
+		}
+		if err := m.Set(iv.id, iv.start, vals...); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,164 @@
+package trans
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTarget(t *testing.T) {
+	tests := []struct {
+		name  string
+		raw   string
+		inits []string
+	}{
+		{
+			name:  "partition",
+			raw:   partition,
+			inits: []string{"a=5,3,8,1,9,2,7,100", "v=5", "i=0", "j=7"},
+		},
+		{
+			name: "arithmetic",
+			raw: `{
+				int n; int q; int r; int x; int y; int lt; int ge; int eq; int ne;
+				q = n / 4; r = n % 4;
+				x = n * 3 - q * 2 + r; y = 7 * x % 5 - 2;
+				lt = x < y; ge = x >= 1; eq = x == x; ne = 2 * x != y;
+			}`,
+			inits: []string{"n=-23"},
+		},
+		{
+			name: "loops",
+			raw: `{
+				int i; int j; int s; int[10] a; int[10] b;
+				while ( i < 10 ) { a[i] = i * i; b[9 - i] = a[i] + 1; i = i + 1; }
+				i = 0;
+				do {
+					j = 0;
+					while ( true ) {
+						if ( j >= i ) break;
+						s = s + a[j] * b[i] - a[2] + b[a[1] + j];
+						j = j + 1;
+					}
+					i = i + 1;
+				} while ( i != 9 );
+			}`,
+		},
+		{
+			name:  "statements",
+			raw:   statements,
+			inits: []string{"n=-40"},
+		},
+		{
+			name:  "extreme cases",
+			raw:   extremecases,
+			inits: []string{"x=-9223372036854775808"},
+		},
+		{
+			name: "floats",
+			raw: `{
+				float f; int i; float g; float h; float[2] a;
+				f = 7; f = f / 2;
+				g = i; g = g / 2;
+				a[0] = 7; a[1] = a[0] / 2;
+				h = i * 3; h = h / 2 + f;
+			}`,
+			inits: []string{"i=7"},
+		},
+		{
+			name:  "functions",
+			raw:   functions,
+			inits: []string{"n=5"},
+		},
+	}
+	for _, tc := range tests {
+		_, src, err := parse(tc.raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, err := translate(tc.raw, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prog, err := parsetac(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in, err := newinterp(prog)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in.maxsteps = 100000
+		for _, s := range tc.inits {
+			if err := in.set(s); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := in.run(); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		for k := 2; k <= 5; k++ {
+			code, err := gentarget(src, k)
+			if err != nil {
+				t.Errorf("%s with %d registers: %v", tc.name, k, err)
+				continue
+			}
+			m, err := loadtarget(code, tc.inits)
+			if err != nil {
+				t.Fatalf("%s with %d registers: %v\n%s", tc.name, k, err, code)
+			}
+			m.MaxSteps, m.NumRegs = 100000, k
+			if err := m.Run(); err != nil {
+				t.Errorf("%s with %d registers: %v\n%s", tc.name, k, err, code)
+				continue
+			}
+			for _, d := range prog.decls {
+				for i, cell := range in.vars[d.id].cells {
+					if got, _ := m.Load(d.id, i); got.Float64() != cell.float() {
+						t.Errorf("%s with %d registers: %s cell %d is %s, expected %s\n%s",
+							tc.name, k, d.id, i, got, cell, code)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestTiling(t *testing.T) {
+	tests := []struct {
+		stmt string
+		cost int
+		code []string // instructions expected in order
+	}{
+		{"x = a[2];", 4, []string{"LD R0, #16", "LD R0, a(R0)"}},
+		{"x = a[y];", 6, []string{"LD R0, y", "MUL R1, R0, #8", "LD R1, a(R1)"}},
+		{"x = 2 * y;", 4, []string{"LD R0, y", "MUL R1, R0, #2"}},
+		{"x = y - 2;", 4, []string{"LD R0, y", "SUB R1, R0, #2"}},
+		{"x = 2 - y;", 5, []string{"LD R0, #2", "LD R1, y", "SUB R0, R0, R1"}},
+		{"x = y * z + a[y];", 12, []string{"LD R0, y", "LD R1, z", "MUL R2, R0, R1", "MUL R0, R0, #8", "ADD R0, R2, R0"}},
+	}
+	for _, tc := range tests {
+		_, src, err := parse("{ int x; int y; int z; int[4] a; " + tc.stmt + " }")
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, err := gentarget(src, 3)
+		if err != nil {
+			t.Errorf("%s: %v", tc.stmt, err)
+			continue
+		}
+		if want := fmt.Sprintf("(cost %d)", tc.cost); !strings.Contains(code, want) {
+			t.Errorf("%s: expected %s in\n%s", tc.stmt, want, code)
+		}
+		rest := code
+		for _, in := range tc.code {
+			i := strings.Index(rest, "\t"+in+"\n")
+			if i == -1 {
+				t.Errorf("%s: expected %q in order in\n%s", tc.stmt, in, code)
+				break
+			}
+			rest = rest[i:]
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Package trans translates the language of Section 2.8 into three-address
+// code, which it can interpret, compile to x86-64 assembly or allocate
+// registers for, and generates code for the target machine of Section 8.2
+// directly from the syntax tree.
+package trans
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+// parse reads a program of source.
+func parse(raw string) (*parser, *program, error) {
+	return parsefile(source.NewFile("input", raw))
+}
+
+// parsefile reads the program in f. Every syntax error is returned together
+// as a *source.ErrorList.
+func parsefile(f *source.File) (*parser, *program, error) {
+	tokens, diags := tokenize(f)
+	p := &parser{input: tokens, file: f, diags: diags}
+	prog := p.program()
+	if len(p.diags) > 0 {
+		sort.SliceStable(p.diags, func(i, j int) bool { return p.diags[i].Span.Start < p.diags[j].Span.Start })
+		return nil, nil, &source.ErrorList{File: f, Diags: p.diags}
+	}
+	return p, prog, nil
+}
+
+// translate compiles a block of source into three-address code.
+func translate(raw string, showdecl bool) (string, error) {
+	p, prog, err := parse(raw)
+	if err != nil {
+		return "", err
+	}
+	return p.translate(prog, showdecl)
+}
+
+func (p *parser) translate(prog *program, showdecl bool) (string, error) {
+	p.showdecl = showdecl
+	if err := prog.gen(p, newtable()); err != nil {
+		return "", err
+	}
+	return p.output, nil
+}
+
+// Tokens writes the tokens of f, one per line with its position and class.
+func Tokens(w io.Writer, f *source.File) error {
+	tokens, diags := tokenize(f)
+	for _, tk := range tokens {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", f.Position(tk.span.Start), tk.class, tk.value)
+	}
+	if len(diags) > 0 {
+		return &source.ErrorList{File: f, Diags: diags}
+	}
+	return nil
+}
+
+// Program is a parsed source program.
+type Program struct {
+	p    *parser
+	prog *program
+}
+
+// Parse parses the program in f, returning all of its syntax errors at once.
+func Parse(f *source.File) (*Program, error) {
+	p, prog, err := parsefile(f)
+	if err != nil {
+		return nil, err
+	}
+	return &Program{p, prog}, nil
+}
+
+// AST writes the syntax tree of the program.
+func (prog *Program) AST(w io.Writer) {
+	prog.p.dumpprogram(w, prog.prog)
+}
+
+// Translate generates the three-address code of the program, with its
+// declarations.
+func (prog *Program) Translate() (*TAC, error) {
+	// the parser accumulates output, so that each translation needs its own
+	p := &parser{file: prog.p.file}
+	text, err := p.translate(prog.prog, true)
+	if err != nil {
+		return nil, err
+	}
+	code, err := parsetac(text)
+	if err != nil {
+		return nil, err
+	}
+	return &TAC{text, code}, nil
+}
+
+// Target generates code for the target machine with k registers.
+func (prog *Program) Target(k int) (string, error) {
+	return gentarget(prog.prog, k)
+}
+
+// RunTarget runs the target code for k registers on the simulator of Section
+// 8.2 for at most steps instructions, with the initial values inits, and
+// writes the registers, memory and cost.
+func RunTarget(w io.Writer, code string, k int, inits []string, steps int) error {
+	m, err := loadtarget(code, inits)
+	if err != nil {
+		return err
+	}
+	m.MaxSteps, m.NumRegs = steps, k
+	err = m.Run()
+	m.Dump(w)
+	fmt.Fprintf(w, "cost %d\n", m.Cost)
+	return err
+}
+
+// TAC is the three-address code of a program.
+type TAC struct {
+	text string
+	prog *tac
+}
+
+func (c *TAC) String() string { return c.text }
+
+// functions returns the main code followed by each function.
+func (c *TAC) functions() []*tac {
+	progs := []*tac{c.prog}
+	for _, name := range c.prog.order {
+		progs = append(progs, c.prog.funcs[name])
+	}
+	return progs
+}
+
+// CFG writes the flow graph of basic blocks of the main code and each function.
+func (c *TAC) CFG(w io.Writer) {
+	for _, prog := range c.functions() {
+		newflowgraph(prog).print(w)
+	}
+}
+
+// SSA writes the code in static single-assignment form.
+func (c *TAC) SSA(w io.Writer) {
+	for _, prog := range c.functions() {
+		toSSA(newflowgraph(prog)).print(w)
+	}
+}
+
+// X86 compiles the code to x86-64 assembly, whose data is initialised with
+// inits.
+func (c *TAC) X86(inits []string) (string, error) {
+	return genx86(c.prog, inits)
+}
+
+// Regalloc allocates k registers and writes the interference graph, the
+// assignment and the rewritten code.
+func (c *TAC) Regalloc(w io.Writer, k int) error {
+	ra, err := allocate(c.prog, k)
+	if err != nil {
+		return err
+	}
+	ra.print(w)
+	return nil
+}
+
+// Run interprets the code for at most steps instructions (none for no
+// limit), with the initial values inits, and dumps memory when it ends.
+func (c *TAC) Run(w io.Writer, inits []string, steps int) error {
+	in, err := newinterp(c.prog)
+	if err != nil {
+		return err
+	}
+	in.maxsteps = steps
+	for _, s := range inits {
+		if err := in.set(s); err != nil {
+			return err
+		}
+	}
+	err = in.run()
+	in.dump(w)
+	return err
+}
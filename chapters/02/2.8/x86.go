@@ -0,0 +1,855 @@
+package trans
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+/*
+The x86-64 backend translates three-address code into GNU as (AT&T syntax)
+assembly for Linux, which brings its own runtime rather than the C library's,
+so that it needs no more than assembling and linking:
+	as -o prog.o prog.s && ld -o prog prog.o
+The runtime (x86runtime) enters at _start, calls main and exits with its
+result, and writes with the write system call.
+Every variable, declared or temporary, lives in an 8-byte slot of the stack
+frame of main; ints are held as quadwords and floats as doubles, with
+arithmetic on the latter done in the SSE registers. The frame of main is
+	 -8(%rbp)    saved %rbx (the loop counter in the memory dump)
+	-16(%rbp)    saved %r12 (unused, but keeps %rsp 16-byte aligned)
+	-24(%rbp)    first slot
+	...
+with arrays occupying consecutive slots from their lowest element upwards.
+Declared variables are zeroed on entry, and memory is written to standard
+output at exit in the format of (*interp).dump, except that floats are written
+exactly in hexadecimal, as by %a in C (0x1.8p+1 for 3); strconv.ParseFloat
+reads either.
+
+Every function of the program has a frame of the same shape, preceded by the
+arguments of its call:
+	16+8(n-1-i)(%rbp)    argument i of n, pushed by the caller as the type of
+	                     the formal
+	  8(%rbp)            return address
+with the formals the first of the slots, to which the arguments are copied.
+The caller pads the arguments to keep %rsp 16-byte aligned and pops them after
+the call, and the result is returned in %rax or %xmm0.
+*/
+
+// types infers the type of every variable in prog. Temporaries take the type
+// of the value first assigned to them, so the code is scanned until no new
+// types are discovered.
+func (prog *tac) types() (map[string]string, error) {
+	types := map[string]string{}
+	for _, d := range append(append([]tacdecl{}, prog.formals...), prog.decls...) {
+		types[d.id] = d._type
+	}
+	typeof := func(s string) string {
+		if v, err := parsevalue(s); err == nil {
+			if v.isfloat {
+				return "float"
+			}
+			return "int"
+		}
+		return types[s]
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, code := range prog.code {
+			var t string
+			switch code.op {
+			case opCopy:
+				t = typeof(code.arg1)
+			case opBinary:
+				a, b := typeof(code.arg1), typeof(code.arg2)
+				switch {
+				case code.binop == "<", code.binop == ">", code.binop == "<=",
+					code.binop == ">=", code.binop == "==", code.binop == "!=":
+					t = "int"
+				case a == "" || b == "":
+				case a == "float" || b == "float":
+					t = "float"
+				default:
+					t = "int"
+				}
+			case opLoad:
+				t = typeof(code.arg1)
+			case opCall:
+				if code.dst == "" {
+					continue
+				}
+				t = prog.funcs[code.label].result
+			default:
+				continue
+			}
+			if t == "" {
+				continue
+			}
+			if prev, ok := types[code.dst]; !ok {
+				types[code.dst] = t
+				changed = true
+			} else if prev != t && !prog.declared(code.dst) {
+				return nil, fmt.Errorf("line %d: %s assigned both %s and %s", code.lineno, code.dst, prev, t)
+			}
+		}
+	}
+	for _, code := range prog.code {
+		for _, s := range []string{code.dst, code.arg1, code.arg2, code.index} {
+			if s == "" {
+				continue
+			}
+			if _, err := parsevalue(s); err != nil && types[s] == "" {
+				return nil, fmt.Errorf("line %d: cannot infer type of %s", code.lineno, s)
+			}
+		}
+	}
+	return types, nil
+}
+
+func (prog *tac) declared(id string) bool {
+	_, ok := prog.local(id)
+	return ok
+}
+
+type x86gen struct {
+	prog   *tac
+	types  map[string]string
+	decls  map[string]tacdecl
+	slots  map[string]int // offset from %rbp
+	frame  int
+	consts []float64
+	out    strings.Builder
+
+	pc     int // index of the instruction being translated
+	pushed int // arguments pushed for the coming call
+	pad    bool
+}
+
+func (g *x86gen) emit(format string, a ...interface{}) {
+	fmt.Fprintf(&g.out, "\t"+format+"\n", a...)
+}
+
+func (g *x86gen) label(s string) {
+	fmt.Fprintf(&g.out, "%s:\n", s)
+}
+
+func (g *x86gen) errorf(code instr, format string, a ...interface{}) error {
+	return fmt.Errorf("line %d: %s: %s", code.lineno, code, fmt.Sprintf(format, a...))
+}
+
+// taclabel qualifies the labels of functions, which are local to each.
+func (g *x86gen) taclabel(l string) string {
+	if g.prog.name != "" {
+		return ".Ltac_" + g.prog.name + "_" + l
+	}
+	return ".Ltac_" + l
+}
+
+func x86func(name string) string { return "tac_" + name }
+
+// layout assigns a stack slot to every variable, formals and declared
+// variables first.
+func (g *x86gen) layout() {
+	g.slots = map[string]int{}
+	off := 16
+	alloc := func(id string, n int) {
+		off += 8 * n
+		g.slots[id] = -off
+	}
+	for _, d := range g.prog.formals {
+		alloc(d.id, 1)
+	}
+	for _, d := range g.prog.decls {
+		n := d.size
+		if n < 0 {
+			n = 1
+		}
+		alloc(d.id, n)
+	}
+	for _, code := range g.prog.code {
+		if _, ok := g.slots[code.dst]; code.dst != "" && !ok {
+			alloc(code.dst, 1)
+		}
+	}
+	g.frame = (off - 16 + 15) &^ 15
+}
+
+func (g *x86gen) slot(id string) string {
+	return fmt.Sprintf("%d(%%rbp)", g.slots[id])
+}
+
+func (g *x86gen) floatconst(f float64) string {
+	for i, c := range g.consts {
+		if math.Float64bits(c) == math.Float64bits(f) {
+			return fmt.Sprintf(".LC%d(%%rip)", i)
+		}
+	}
+	g.consts = append(g.consts, f)
+	return fmt.Sprintf(".LC%d(%%rip)", len(g.consts)-1)
+}
+
+// loadint puts the operand s into the integer register reg, truncating floats.
+func (g *x86gen) loadint(s, reg string) {
+	if v, err := parsevalue(s); err == nil {
+		if v.isfloat {
+			v = v.as("int")
+		}
+		g.emit("movabsq $%d, %s", v.i, reg)
+		return
+	}
+	if g.types[s] == "float" {
+		g.emit("cvttsd2siq %s, %s", g.slot(s), reg)
+		return
+	}
+	g.emit("movq %s, %s", g.slot(s), reg)
+}
+
+// loadfloat puts the operand s into the SSE register xreg.
+func (g *x86gen) loadfloat(s, xreg string) {
+	if v, err := parsevalue(s); err == nil {
+		g.emit("movsd %s, %s", g.floatconst(v.float()), xreg)
+		return
+	}
+	if g.types[s] == "int" {
+		g.emit("cvtsi2sdq %s, %s", g.slot(s), xreg)
+		return
+	}
+	g.emit("movsd %s, %s", g.slot(s), xreg)
+}
+
+// storeint stores the integer in %rax to the scalar dst.
+func (g *x86gen) storeint(dst string) {
+	if g.types[dst] == "float" {
+		g.emit("cvtsi2sdq %%rax, %%xmm0")
+		g.emit("movsd %%xmm0, %s", g.slot(dst))
+		return
+	}
+	g.emit("movq %%rax, %s", g.slot(dst))
+}
+
+// storefloat stores the double in %xmm0 to the scalar dst.
+func (g *x86gen) storefloat(dst string) {
+	if g.types[dst] == "int" {
+		g.emit("cvttsd2siq %%xmm0, %%rax")
+		g.emit("movq %%rax, %s", g.slot(dst))
+		return
+	}
+	g.emit("movsd %%xmm0, %s", g.slot(dst))
+}
+
+func (g *x86gen) isfloat(s string) bool {
+	if v, err := parsevalue(s); err == nil {
+		return v.isfloat
+	}
+	return g.types[s] == "float"
+}
+
+var x86intops = map[string]string{"+": "addq", "-": "subq", "*": "imulq"}
+var x86floatops = map[string]string{"+": "addsd", "-": "subsd", "*": "mulsd", "/": "divsd"}
+var x86setcc = map[string]string{"<": "setl", ">": "setg", "<=": "setle", ">=": "setge", "==": "sete", "!=": "setne"}
+
+// binary leaves the result of arg1 binop arg2 in %rax if it is an integer (as
+// is the case for all relations) and in %xmm0 otherwise, returning which.
+func (g *x86gen) binary(code instr) (isfloat bool) {
+	if !g.isfloat(code.arg1) && !g.isfloat(code.arg2) {
+		g.loadint(code.arg1, "%rax")
+		g.loadint(code.arg2, "%rcx")
+		switch code.binop {
+		case "+", "-", "*":
+			g.emit("%s %%rcx, %%rax", x86intops[code.binop])
+		case "/", "%":
+			g.emit("testq %%rcx, %%rcx")
+			g.emit("je .Ldivzero")
+			g.emit("cqto")
+			g.emit("idivq %%rcx")
+			if code.binop == "%" {
+				g.emit("movq %%rdx, %%rax")
+			}
+		default:
+			g.emit("cmpq %%rcx, %%rax")
+			g.emit("%s %%al", x86setcc[code.binop])
+			g.emit("movzbq %%al, %%rax")
+		}
+		return false
+	}
+	g.loadfloat(code.arg1, "%xmm0")
+	g.loadfloat(code.arg2, "%xmm1")
+	switch code.binop {
+	case "+", "-", "*", "/":
+		g.emit("%s %%xmm1, %%xmm0", x86floatops[code.binop])
+		return true
+	case "%":
+		g.emit("call .Lfmod")
+		return true
+	case "<":
+		g.emit("ucomisd %%xmm0, %%xmm1")
+		g.emit("seta %%al")
+	case "<=":
+		g.emit("ucomisd %%xmm0, %%xmm1")
+		g.emit("setae %%al")
+	case ">":
+		g.emit("ucomisd %%xmm1, %%xmm0")
+		g.emit("seta %%al")
+	case ">=":
+		g.emit("ucomisd %%xmm1, %%xmm0")
+		g.emit("setae %%al")
+	case "==":
+		g.emit("ucomisd %%xmm1, %%xmm0")
+		g.emit("sete %%al")
+		g.emit("setnp %%cl")
+		g.emit("andb %%cl, %%al")
+	case "!=":
+		g.emit("ucomisd %%xmm1, %%xmm0")
+		g.emit("setne %%al")
+		g.emit("setp %%cl")
+		g.emit("orb %%cl, %%al")
+	}
+	g.emit("movzbq %%al, %%rax")
+	return false
+}
+
+// index puts the bounds-checked index of an array access into %rcx.
+func (g *x86gen) index(code instr, array string) error {
+	d, ok := g.decls[array]
+	if !ok || d.size < 0 {
+		return g.errorf(code, "%s is not an array", array)
+	}
+	if g.isfloat(code.index) {
+		return g.errorf(code, "non-integer index %s", code.index)
+	}
+	g.loadint(code.index, "%rcx")
+	g.emit("cmpq $%d, %%rcx", d.size)
+	g.emit("jae .Lbounds")
+	return nil
+}
+
+func (g *x86gen) instr(code instr) error {
+	switch code.op {
+	case opLabel:
+		g.label(g.taclabel(code.label))
+	case opCopy:
+		if g.isfloat(code.arg1) {
+			g.loadfloat(code.arg1, "%xmm0")
+			g.storefloat(code.dst)
+		} else {
+			g.loadint(code.arg1, "%rax")
+			g.storeint(code.dst)
+		}
+	case opBinary:
+		if g.binary(code) {
+			g.storefloat(code.dst)
+		} else {
+			g.storeint(code.dst)
+		}
+	case opLoad:
+		if err := g.index(code, code.arg1); err != nil {
+			return err
+		}
+		addr := fmt.Sprintf("%d(%%rbp,%%rcx,8)", g.slots[code.arg1])
+		if g.decls[code.arg1]._type == "float" {
+			g.emit("movsd %s, %%xmm0", addr)
+			g.storefloat(code.dst)
+		} else {
+			g.emit("movq %s, %%rax", addr)
+			g.storeint(code.dst)
+		}
+	case opStore:
+		if err := g.index(code, code.dst); err != nil {
+			return err
+		}
+		addr := fmt.Sprintf("%d(%%rbp,%%rcx,8)", g.slots[code.dst])
+		if g.decls[code.dst]._type == "float" {
+			g.loadfloat(code.arg1, "%xmm0")
+			g.emit("movsd %%xmm0, %s", addr)
+		} else {
+			g.loadint(code.arg1, "%rax")
+			g.emit("movq %%rax, %s", addr)
+		}
+	case opGoto:
+		g.emit("jmp %s", g.taclabel(code.label))
+	case opIf, opIfFalse:
+		jump := "jne"
+		if code.op == opIfFalse {
+			jump = "je"
+		}
+		if code.binop != "" {
+			if g.binary(code) {
+				return g.errorf(code, "condition must be a relation")
+			}
+		} else if g.isfloat(code.arg1) {
+			g.loadfloat(code.arg1, "%xmm0")
+			g.emit("xorpd %%xmm1, %%xmm1")
+			g.emit("ucomisd %%xmm1, %%xmm0")
+			g.emit("setne %%al")
+			g.emit("setp %%cl")
+			g.emit("orb %%cl, %%al")
+			g.emit("movzbq %%al, %%rax")
+		} else {
+			g.loadint(code.arg1, "%rax")
+		}
+		g.emit("testq %%rax, %%rax")
+		g.emit("%s %s", jump, g.taclabel(code.label))
+	case opTable:
+		// the table holds the offsets of the targets from the table itself,
+		// as position-independent code requires
+		if g.isfloat(code.arg1) {
+			return g.errorf(code, "indexed goto on float %s", code.arg1)
+		}
+		table := fmt.Sprintf(".Ltable%d", g.pc)
+		if g.prog.name != "" {
+			table = fmt.Sprintf(".Ltable_%s_%d", g.prog.name, g.pc)
+		}
+		g.loadint(code.arg1, "%rax")
+		g.emit("cmpq $%d, %%rax", len(code.table))
+		g.emit("jae %s", g.taclabel(code.label))
+		g.emit("leaq %s(%%rip), %%rdx", table)
+		g.emit("movslq (%%rdx,%%rax,4), %%rax")
+		g.emit("addq %%rdx, %%rax")
+		g.emit("jmp *%%rax")
+		g.emit(".section .rodata")
+		g.emit(".p2align 2")
+		g.label(table)
+		for _, l := range code.table {
+			g.emit(".long %s-%s", g.taclabel(l), table)
+		}
+		g.emit(".text")
+	case opParam:
+		return g.param(code)
+	case opCall:
+		f := g.prog.funcs[code.label]
+		if g.pushed != code.nargs {
+			return g.errorf(code, "%d params for %d arguments", g.pushed, code.nargs)
+		}
+		g.emit("call %s", x86func(code.label))
+		if n := 8 * code.nargs; n > 0 || g.pad {
+			if g.pad {
+				n += 8
+			}
+			g.emit("addq $%d, %%rsp", n)
+		}
+		g.pushed, g.pad = 0, false
+		if code.dst == "" {
+			break
+		}
+		if f.result == "float" {
+			g.storefloat(code.dst)
+		} else {
+			g.storeint(code.dst)
+		}
+	case opReturn:
+		if g.prog.result == "float" {
+			g.loadfloat(code.arg1, "%xmm0")
+		} else if code.arg1 != "" {
+			g.loadint(code.arg1, "%rax")
+		}
+		g.emit("jmp %s", g.taclabel("return"))
+	default:
+		return g.errorf(code, "unknown opcode %d", code.op)
+	}
+	return nil
+}
+
+// param pushes an argument as the type of the formal it is passed to, which is
+// found from the call that follows.
+func (g *x86gen) param(code instr) error {
+	var call *instr
+	for i := g.pc; i < len(g.prog.code); i++ {
+		if g.prog.code[i].op == opCall {
+			call = &g.prog.code[i]
+			break
+		}
+	}
+	if call == nil || g.pushed >= call.nargs {
+		return g.errorf(code, "param without call")
+	}
+	if g.pushed == 0 && call.nargs%2 == 1 {
+		g.emit("subq $8, %%rsp")
+		g.pad = true
+	}
+	if g.prog.funcs[call.label].formals[g.pushed]._type == "float" {
+		g.loadfloat(code.arg1, "%xmm0")
+		g.emit("movq %%xmm0, %%rax")
+	} else {
+		g.loadint(code.arg1, "%rax")
+	}
+	g.emit("pushq %%rax")
+	g.pushed++
+	return nil
+}
+
+// begin sets up the translation of prog, emitting the prologue that saves
+// the registers and zeroes the declared variables.
+func (g *x86gen) begin(prog *tac, name string) error {
+	types, err := prog.types()
+	if err != nil {
+		return err
+	}
+	g.prog, g.types, g.decls = prog, types, map[string]tacdecl{}
+	for _, d := range prog.decls {
+		g.decls[d.id] = d
+	}
+	g.layout()
+
+	g.emit(".globl %s", name)
+	g.emit(".type %s, @function", name)
+	g.label(name)
+	g.emit("pushq %%rbp")
+	g.emit("movq %%rsp, %%rbp")
+	g.emit("pushq %%rbx")
+	g.emit("pushq %%r12")
+	g.emit("subq $%d, %%rsp", g.frame)
+	if n := len(prog.decls); n > 0 {
+		first, last := prog.decls[0], prog.decls[n-1]
+		cells := (g.slots[first.id] - g.slots[last.id]) / 8
+		if first.size < 0 {
+			cells++
+		} else {
+			cells += first.size
+		}
+		g.emit("leaq %d(%%rbp), %%rdi", g.slots[last.id])
+		g.emit("movq $%d, %%rcx", cells)
+		g.emit("xorl %%eax, %%eax")
+		g.emit("rep stosq")
+	}
+	return nil
+}
+
+// body translates the code of prog.
+func (g *x86gen) body() error {
+	for i, code := range g.prog.code {
+		fmt.Fprintf(&g.out, "\t# %s\n", code)
+		g.pc = i
+		if err := g.instr(code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// function translates a function of the program.
+func (g *x86gen) function(f *tac) error {
+	if err := g.begin(f, x86func(f.name)); err != nil {
+		return err
+	}
+	n := len(f.formals)
+	for i, d := range f.formals {
+		g.emit("movq %d(%%rbp), %%rax", 16+8*(n-1-i))
+		g.emit("movq %%rax, %s", g.slot(d.id))
+	}
+	if err := g.body(); err != nil {
+		return err
+	}
+	// falling off the end returns zero
+	g.emit("xorl %%eax, %%eax")
+	g.emit("xorpd %%xmm0, %%xmm0")
+	g.label(g.taclabel("return"))
+	g.emit("leaq -16(%%rbp), %%rsp")
+	g.emit("popq %%r12")
+	g.emit("popq %%rbx")
+	g.emit("popq %%rbp")
+	g.emit("ret")
+	g.emit(".size %s, .-%s", x86func(f.name), x86func(f.name))
+	return nil
+}
+
+// dump emits the code printing every declared variable.
+func (g *x86gen) dump() []string {
+	var strs []string
+	index := map[string]int{}
+	puts := func(s string) {
+		i, ok := index[s]
+		if !ok {
+			i, index[s] = len(strs), len(strs)
+			strs = append(strs, s)
+		}
+		g.emit("leaq .LS%d(%%rip), %%rdi", i)
+		g.emit("call .Lputs")
+	}
+	put := func(typ, addr string) {
+		if typ == "float" {
+			g.emit("movsd %s, %%xmm0", addr)
+			g.emit("call .Lputfloat")
+		} else {
+			g.emit("movq %s, %%rdi", addr)
+			g.emit("call .Lputint")
+		}
+	}
+	for i, d := range g.prog.decls {
+		if d.size < 0 {
+			puts(fmt.Sprintf("%s %s = ", d.id, d._type))
+			put(d._type, g.slot(d.id))
+			puts("\n")
+			continue
+		}
+		puts(fmt.Sprintf("%s %s[%d] = [", d.id, d._type, d.size))
+		loop, done := fmt.Sprintf(".Ldump%d", i), fmt.Sprintf(".Ldump%d_end", i)
+		g.emit("xorl %%ebx, %%ebx")
+		g.label(loop)
+		g.emit("cmpq $%d, %%rbx", d.size)
+		g.emit("jae %s", done)
+		g.emit("testq %%rbx, %%rbx")
+		g.emit("je %s_cell", loop)
+		puts(" ")
+		g.label(loop + "_cell")
+		put(d._type, fmt.Sprintf("%d(%%rbp,%%rbx,8)", g.slots[d.id]))
+		g.emit("incq %%rbx")
+		g.emit("jmp %s", loop)
+		g.label(done)
+		puts("]\n")
+	}
+	return strs
+}
+
+// x86runtime is the entry point of the program and the routines called by
+// its code, none of which touch %rbx or %r12:
+//
+//	.Lwrite       writes %rdx bytes at %rsi to the file %edi
+//	.Lputs        writes the string at %rdi to standard output
+//	.Lputint      writes %rdi in decimal
+//	.Lputfloat    writes %xmm0 in hexadecimal, or inf or nan
+//	.Lfmod        the remainder of %xmm0 by %xmm1 with the sign of %xmm0, as
+//	              fmod in C (and math.Mod), found exactly by fprem
+const x86runtime = `	.globl _start
+	.type _start, @function
+_start:
+	xorl %ebp, %ebp
+	andq $-16, %rsp
+	call main
+	movl %eax, %edi
+	movl $60, %eax # exit
+	syscall
+	.size _start, .-_start
+.Lwrite:
+	testq %rdx, %rdx
+	jle .Lwrite_done
+	movl $1, %eax # write
+	syscall
+	testq %rax, %rax
+	jle .Lwrite_done
+	addq %rax, %rsi
+	subq %rax, %rdx
+	jmp .Lwrite
+.Lwrite_done:
+	ret
+.Lputs:
+	movq %rdi, %rsi
+	xorl %edx, %edx
+.Lputs_len:
+	cmpb $0, (%rsi,%rdx)
+	je .Lputs_write
+	incq %rdx
+	jmp .Lputs_len
+.Lputs_write:
+	movl $1, %edi
+	jmp .Lwrite
+.Lputint:
+	subq $40, %rsp
+	leaq 32(%rsp), %rsi
+	movq %rdi, %rax
+	movl $10, %ecx
+.Lputint_digit:
+	# the remainder takes the sign of the dividend, which keeps the least
+	# integer in range
+	cqto
+	idivq %rcx
+	movq %rdx, %r8
+	sarq $63, %r8
+	xorq %r8, %rdx
+	subq %r8, %rdx
+	addb $'0', %dl
+	decq %rsi
+	movb %dl, (%rsi)
+	testq %rax, %rax
+	jne .Lputint_digit
+	testq %rdi, %rdi
+	jns .Lputint_write
+	decq %rsi
+	movb $'-', (%rsi)
+.Lputint_write:
+	leaq 32(%rsp), %rdx
+	subq %rsi, %rdx
+	movl $1, %edi
+	call .Lwrite
+	addq $40, %rsp
+	ret
+.Lputfloat:
+	subq $40, %rsp
+	movq %rsp, %rsi
+	movq %xmm0, %rax
+	btrq $63, %rax
+	jnc .Lputfloat_abs
+	movb $'-', (%rsi)
+	incq %rsi
+.Lputfloat_abs:
+	movq %rax, %rdi
+	shrq $52, %rdi
+	movabsq $0xfffffffffffff, %rcx
+	andq %rcx, %rax
+	cmpq $0x7ff, %rdi
+	je .Lputfloat_special
+	movl $0x00007830, (%rsi) # "0x"
+	addq $2, %rsi
+	# the leading digit is 0 for zero and subnormals, whose exponent is that
+	# of the least normal
+	movb $'0', (%rsi)
+	testq %rdi, %rdi
+	je .Lputfloat_subnormal
+	movb $'1', (%rsi)
+	subq $1023, %rdi
+	jmp .Lputfloat_mantissa
+.Lputfloat_subnormal:
+	testq %rax, %rax
+	je .Lputfloat_mantissa
+	movq $-1022, %rdi
+.Lputfloat_mantissa:
+	incq %rsi
+	testq %rax, %rax
+	je .Lputfloat_exponent
+	movb $'.', (%rsi)
+	incq %rsi
+	shlq $12, %rax
+	leaq .Lhex(%rip), %r8
+.Lputfloat_digit:
+	movq %rax, %rcx
+	shrq $60, %rcx
+	movb (%r8,%rcx), %cl
+	movb %cl, (%rsi)
+	incq %rsi
+	shlq $4, %rax
+	jne .Lputfloat_digit
+.Lputfloat_exponent:
+	movb $'p', (%rsi)
+	movb $'+', 1(%rsi)
+	testq %rdi, %rdi
+	jns .Lputfloat_write
+	movb $'-', 1(%rsi)
+	negq %rdi
+.Lputfloat_write:
+	addq $2, %rsi
+	movq %rdi, 32(%rsp)
+	movq %rsi, %rdx
+	subq %rsp, %rdx
+	movq %rsp, %rsi
+	movl $1, %edi
+	call .Lwrite
+	movq 32(%rsp), %rdi
+	addq $40, %rsp
+	jmp .Lputint
+.Lputfloat_special:
+	# nan is written without its sign, which strconv.ParseFloat rejects
+	movl $0x00666e69, (%rsi) # "inf"
+	testq %rax, %rax
+	je .Lputfloat_word
+	movq %rsp, %rsi
+	movl $0x006e616e, (%rsi) # "nan"
+.Lputfloat_word:
+	leaq 3(%rsi), %rdx
+	subq %rsp, %rdx
+	movq %rsp, %rsi
+	movl $1, %edi
+	call .Lwrite
+	addq $40, %rsp
+	ret
+.Lfmod:
+	subq $16, %rsp
+	movsd %xmm1, (%rsp)
+	fldl (%rsp)
+	movsd %xmm0, 8(%rsp)
+	fldl 8(%rsp)
+.Lfmod_reduce:
+	fprem
+	fnstsw %ax
+	testw $0x400, %ax
+	jne .Lfmod_reduce
+	fstpl (%rsp)
+	fstp %st(0)
+	movsd (%rsp), %xmm0
+	addq $16, %rsp
+	ret
+`
+
+// x86errors are the runtime errors, reported on standard error before the
+// program exits with status 1.
+var x86errors = []struct{ label, msg string }{
+	{".Lbounds", "error: array index out of range\n"},
+	{".Ldivzero", "error: integer division by zero\n"},
+}
+
+// genx86 translates prog into a complete assembly file, storing the initial
+// values inits (see parseinit) once the declared variables are zeroed.
+func genx86(prog *tac, inits []string) (string, error) {
+	g := &x86gen{}
+	g.emit(".text")
+	for _, name := range prog.order {
+		if err := g.function(prog.funcs[name]); err != nil {
+			return "", err
+		}
+	}
+	if err := g.begin(prog, "main"); err != nil {
+		return "", err
+	}
+	for _, s := range inits {
+		iv, err := parseinit(s)
+		if err != nil {
+			return "", err
+		}
+		d, ok := g.decls[iv.id]
+		if !ok {
+			return "", fmt.Errorf("cannot set undeclared variable %s", iv.id)
+		}
+		if err := iv.check(d); err != nil {
+			return "", err
+		}
+		for i, v := range iv.vals {
+			v = v.as(d._type)
+			off := g.slots[d.id] + 8*(iv.start+i)
+			if v.isfloat {
+				g.emit("movabsq $%d, %%rax", int64(math.Float64bits(v.f)))
+			} else {
+				g.emit("movabsq $%d, %%rax", v.i)
+			}
+			g.emit("movq %%rax, %d(%%rbp)", off)
+		}
+	}
+	if err := g.body(); err != nil {
+		return "", err
+	}
+	strs := g.dump()
+	g.emit("xorl %%eax, %%eax")
+	g.emit("leaq -16(%%rbp), %%rsp")
+	g.emit("popq %%r12")
+	g.emit("popq %%rbx")
+	g.emit("popq %%rbp")
+	g.emit("ret")
+	for _, e := range x86errors {
+		g.label(e.label)
+		g.emit("movl $2, %%edi")
+		g.emit("leaq %s_msg(%%rip), %%rsi", e.label)
+		g.emit("movl $%d, %%edx", len(e.msg))
+		g.emit("call .Lwrite")
+		g.emit("movl $1, %%edi")
+		g.emit("movl $60, %%eax # exit")
+		g.emit("syscall")
+	}
+	g.emit(".size main, .-main")
+	g.out.WriteString(x86runtime)
+
+	g.emit(".section .rodata")
+	g.emit(".align 8")
+	for i, c := range g.consts {
+		g.label(fmt.Sprintf(".LC%d", i))
+		g.emit(".quad %d # %g", int64(math.Float64bits(c)), c)
+	}
+	for i, s := range strs {
+		g.label(fmt.Sprintf(".LS%d", i))
+		g.emit(".string %q", s)
+	}
+	for _, e := range x86errors {
+		g.label(e.label + "_msg")
+		g.emit(".ascii %q", e.msg)
+	}
+	g.label(".Lhex")
+	g.emit(".ascii \"0123456789abcdef\"")
+	g.emit(".section .note.GNU-stack,\"\",@progbits")
+	return g.out.String(), nil
+}
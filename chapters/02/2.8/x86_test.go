@@ -0,0 +1,187 @@
+package trans
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// parsedump reads the declared variables from a memory dump.
+func parsedump(t *testing.T, dump string) map[string][]float64 {
+	t.Helper()
+	mem := map[string][]float64{}
+	for _, line := range strings.Split(strings.TrimSpace(dump), "\n") {
+		eq := strings.Index(line, " = ")
+		lhs := strings.Fields(line[:eq])
+		if len(lhs) != 2 {
+			continue // temporary
+		}
+		cells := strings.Fields(strings.Trim(line[eq+3:], "[]"))
+		for _, c := range cells {
+			f, err := strconv.ParseFloat(c, 64)
+			if err != nil {
+				t.Fatalf("bad cell %q in %q", c, line)
+			}
+			mem[lhs[0]] = append(mem[lhs[0]], f)
+		}
+	}
+	return mem
+}
+
+// assemble builds the native program for the three-address code and returns
+// the path to the binary.
+func assemble(t *testing.T, prog *tac, inits []string) string {
+	t.Helper()
+	s, err := genx86(prog, inits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	src, obj, bin := filepath.Join(dir, "prog.s"), filepath.Join(dir, "prog.o"), filepath.Join(dir, "prog")
+	if err := os.WriteFile(src, []byte(s), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("as", "-o", obj, src).CombinedOutput(); err != nil {
+		t.Fatalf("cannot assemble: %v\n%s\n%s", err, out, s)
+	}
+	if out, err := exec.Command("ld", "-o", bin, obj).CombinedOutput(); err != nil {
+		t.Fatalf("cannot link: %v\n%s", err, out)
+	}
+	return bin
+}
+
+func TestX86(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("x86-64 Linux only")
+	}
+	for _, tool := range []string{"as", "ld"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found", tool)
+		}
+	}
+	tests := []struct {
+		name  string
+		raw   string
+		inits []string
+		fails bool
+	}{
+		{
+			name:  "partition",
+			raw:   partition,
+			inits: []string{"a=5,3,8,1,9,2,7,100", "v=5", "i=0", "j=7"},
+		},
+		{
+			name: "arithmetic",
+			raw: `{
+				int n; int q; int r; float h; float x; float y;
+				int lt; int ge; int eq; int ne;
+				h = 3; h = h / 2;
+				q = n / 4; r = n % 4;
+				x = n * h - q; y = x % 2;
+				lt = x < y; ge = x >= y; eq = x == x; ne = x != y;
+			}`,
+			inits: []string{"n=-23"},
+		},
+		{
+			name: "loop",
+			raw: `{
+				int i; int s; float[10] a; float m;
+				while ( i < 10 ) { a[i] = i; a[i] = a[i] / 2; i = i + 1; }
+				i = 0;
+				do { s = s + i * i; m = m + a[i]; i = i + 1; } while ( i != 10 );
+			}`,
+		},
+		{
+			name:  "statements",
+			raw:   statements,
+			inits: []string{"n=-40"},
+		},
+		{
+			name:  "functions",
+			raw:   functions,
+			inits: []string{"n=5"},
+		},
+		{
+			name: "extremes",
+			raw: `{
+				int i; int j; float a; float b; float r; float z; float s; float h; float[2] e;
+				j = i - 1; j = j + 1;
+				r = a % b; z = b - b; h = a * a;
+				e[0] = s / 2; e[1] = z - s;
+			}`,
+			inits: []string{"i=-9223372036854775808", "a=-1e300", "b=3.7", "s=5e-324"},
+		},
+		{
+			name:  "bounds",
+			raw:   `{ int i; int[4] a; while ( true ) { a[i] = i; i = i + 1; } }`,
+			fails: true,
+		},
+		{
+			name:  "division by zero",
+			raw:   `{ int i; int j; j = 1 / i; }`,
+			fails: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := translate(tc.raw, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prog, err := parsetac(output)
+			if err != nil {
+				t.Fatalf("%v\n%s", err, output)
+			}
+			in, err := newinterp(prog)
+			if err != nil {
+				t.Fatal(err)
+			}
+			in.maxsteps = 100000
+			for _, s := range tc.inits {
+				if err := in.set(s); err != nil {
+					t.Fatal(err)
+				}
+			}
+			interr := in.run()
+
+			cmd := exec.Command(assemble(t, prog, tc.inits))
+			var stdout, stderr strings.Builder
+			cmd.Stdout, cmd.Stderr = &stdout, &stderr
+			nativeerr := cmd.Run()
+
+			if tc.fails {
+				if interr == nil || nativeerr == nil {
+					t.Fatalf("expected failure, got interpreter %v and native %v", interr, nativeerr)
+				}
+				if !strings.HasPrefix(stderr.String(), "error: ") {
+					t.Errorf("expected runtime error, got %q", stderr.String())
+				}
+				return
+			}
+			if interr != nil {
+				t.Fatal(interr)
+			}
+			if nativeerr != nil {
+				t.Fatalf("%v: %s", nativeerr, stderr.String())
+			}
+			var b strings.Builder
+			in.dump(&b)
+			want, got := parsedump(t, b.String()), parsedump(t, stdout.String())
+			for id, cells := range want {
+				if len(got[id]) != len(cells) {
+					t.Errorf("%s: expected %d cells, got %d", id, len(cells), len(got[id]))
+					continue
+				}
+				for i := range cells {
+					if got[id][i] != cells[i] {
+						t.Errorf("%s[%d]: interpreter has %g, native %g", id, i, cells[i], got[id][i])
+					}
+				}
+			}
+		})
+	}
+}
@@ -5,11 +5,12 @@ import (
 	"log"
 
 	"github.com/akiarie/dragon-tests/grammar"
+	"github.com/akiarie/dragon-tests/source"
 )
 
 // Exercise 2.4.1
 func ex241() {
-	gA := grammar.Grammar{grammar.Nonterminal{"S", []string{"+ S S", "- S S", "a"}}}
+	gA := mustread("S → + S S | - S S | a")
 	/*
 		The production
 			S → S ( S ) S | ε
@@ -19,8 +20,8 @@ func ex241() {
 		which leads to a further simplification, namely
 			S → ( S ) S S | ε.
 	*/
-	gB := grammar.Grammar{grammar.Nonterminal{"S", []string{"( S ) S S", "ε"}}}
-	gC := grammar.Grammar{grammar.Nonterminal{"S", []string{"0 S 1", "0 1"}}}
+	gB := mustread("S → ( S ) S S | ε")
+	gC := mustread("S → 0 S 1 | 0 1")
 	for _, G := range []grammar.Grammar{gA, gB, gC} {
 		if err := G.Validate(); err != nil {
 			log.Fatal(err)
@@ -32,6 +33,11 @@ func ex241() {
 		log.Fatal(err)
 	}
 	fmt.Println(treeA)
+	// the sentential forms by which gA derives the string, and the handles
+	// by which a bottom-up parser reduces it
+	fmt.Println(treeA.Leftmost())
+	fmt.Println(treeA.Rightmost())
+	fmt.Print(treeA.Reductions())
 	treeB, err := gB.ParseAST([]byte(`()`))
 	if err != nil {
 		log.Fatal(err)
@@ -43,3 +49,28 @@ func ex241() {
 	}
 	fmt.Println(treeC)
 }
+
+// The grammars of Exercise 2.4.1 (a) and (c) in Chomsky normal form, with the
+// tables of the CYK algorithm for the strings parsed above.
+func cyk241() {
+	for _, ex := range []struct{ src, input string }{
+		{"S → + S S | - S S | a", `+ - + a a + a a a`},
+		{"S → 0 S 1 | 0 1", `00 001 111`},
+	} {
+		G, err := mustread(ex.src).ToCNF()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(G)
+		table, err := G.CYK(source.NewFile("", ex.input))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(table)
+		tree, err := table.Tree()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(tree)
+	}
+}
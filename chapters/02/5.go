@@ -2,8 +2,11 @@ package main
 
 import (
 	"fmt"
+	"log"
+	"os"
 
 	"github.com/akiarie/dragon-tests/grammar"
+	"github.com/akiarie/dragon-tests/source"
 )
 
 // Exercise 2.1.5
@@ -35,20 +38,24 @@ func fig215() {
 					...
 				 | 9 { print('9') }
 	*/
-	digits := []string{}
-	for i := 0; i < 10; i++ {
-		digits = append(digits, fmt.Sprintf("%d { print('%d') }", i, i))
+	src := `
+		expr → term rest
+
+		rest → + term { print('+') } rest
+		     | - term { print('-') } rest
+		     | ε
+	`
+	src += "term → 0 { print('0') }\n"
+	for i := 1; i < 10; i++ {
+		src += fmt.Sprintf("     | %d { print('%d') }\n", i, i)
 	}
-	G := grammar.Grammar{
-		grammar.Nonterminal{"expr", []string{"term rest"}},
-		grammar.Nonterminal{"rest",
-			[]string{
-				"+ term { print('+') } rest",
-				"- term { print('-') } rest",
-				"ε",
-			},
-		},
-		grammar.Nonterminal{"term", digits},
+	G, err := grammar.ReadGrammar(src)
+	if err != nil {
+		log.Fatal(err)
 	}
 	fmt.Println(G)
+	if _, err := (grammar.Scheme{Grammar: G}).Translate(os.Stdout, source.NewFile("", "9-5+2")); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println()
 }
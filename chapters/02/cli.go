@@ -11,26 +11,25 @@ func main() {
 	fig215()
 }
 
+// mustread reads the grammar src, exiting if it is malformed.
+func mustread(src string) grammar.Grammar {
+	G, err := grammar.ReadGrammar(src)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return G
+}
+
 // Figure 2.16
 func fig216() {
-	G := grammar.Grammar{
-		grammar.Nonterminal{
-			"stmt",
-			[]string{
-				"expr ;",
-				"if ( expr ) stmt",
-				"for ( optexpr ; optexpr ; optexpr ) stmt",
-				"other",
-			},
-		},
-		grammar.Nonterminal{
-			"optexpr",
-			[]string{
-				"ε",
-				"expr",
-			},
-		},
-	}
+	G := mustread(`
+		stmt    → expr ;
+		        | if ( expr ) stmt
+		        | for ( optexpr ; optexpr ; optexpr ) stmt
+		        | other
+		optexpr → ε
+		        | expr
+	`)
 	if err := G.Validate(); err != nil {
 		log.Fatal(err)
 	}
@@ -2,6 +2,12 @@ module github.com/akiarie/dragon-tests/compilers/ch2
 
 go 1.16
 
-replace github.com/akiarie/dragon-tests/grammar => ../../grammar
+replace (
+	github.com/akiarie/dragon-tests/grammar => ../../cc
+	github.com/akiarie/dragon-tests/source => ../../source
+)
 
-require github.com/akiarie/dragon-tests/grammar v0.0.0-00010101000000-000000000000
+require (
+	github.com/akiarie/dragon-tests/grammar v0.0.0-00010101000000-000000000000
+	github.com/akiarie/dragon-tests/source v0.0.0-00010101000000-000000000000
+)
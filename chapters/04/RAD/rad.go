@@ -0,0 +1,197 @@
+// Package rad parses arithmetic expressions by recursive descent with ascent
+// through right recursion, and prints them back in a normal spacing.
+package rad
+
+import (
+	"fmt"
+	"io"
+	"unicode"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+/*
+We will parse the grammar
+	E   → E + T | T
+	T   → T * F | F
+	F   → ( E ) | num
+	num → [0-9]+
+using recursive-descent-ascent.
+*/
+
+type parser struct {
+	pos    int
+	file   *source.File
+	input  []token
+	output string
+}
+
+func (p *parser) expr() error {
+	if err := p.term(); err != nil {
+		return err
+	}
+	if p.pos >= len(p.input) {
+		return nil
+	}
+	tk := p.input[p.pos]
+	if tk.lexeme != "+" {
+		return nil
+	}
+	p.output += " + "
+	p.pos++
+	return p.expr()
+}
+
+func (p *parser) term() error {
+	if err := p.factor(); err != nil {
+		return err
+	}
+	if p.pos >= len(p.input) {
+		return nil
+	}
+	tk := p.input[p.pos]
+	if tk.lexeme != "*" {
+		return nil
+	}
+	p.output += " * "
+	p.pos++
+	return p.term()
+}
+
+func (p *parser) factor() error {
+	if p.pos >= len(p.input) {
+		return fmt.Errorf("%s: expected factor at end of input", p.file.Position(p.file.Pos(p.file.Size())))
+	}
+	switch tk := p.input[p.pos]; tk.class {
+	case tkBracket:
+		if tk.lexeme != "(" {
+			return fmt.Errorf("%s: %q cannot start factor", p.file.Position(tk.span.Start), tk.lexeme)
+		}
+		p.output += "("
+		p.pos++
+		if err := p.expr(); err != nil {
+			return err
+		}
+		if p.pos >= len(p.input) {
+			return fmt.Errorf("%s: expected ')' at end of input", p.file.Position(p.file.Pos(p.file.Size())))
+		}
+		tk := p.input[p.pos]
+		if tk.lexeme != ")" {
+			return fmt.Errorf("%s: %q cannot end factor", p.file.Position(tk.span.Start), tk.lexeme)
+		}
+		p.output += ")"
+		p.pos++
+		return nil
+	case tkNum:
+		p.output += tk.lexeme
+		p.pos++
+		return nil
+	default:
+		return fmt.Errorf("%s: unknown class %s in factor", p.file.Position(tk.span.Start), tk.class)
+	}
+}
+
+type tkclass string
+
+const (
+	tkNum     tkclass = "num"
+	tkBracket         = "bracket"
+	tkOp              = "op"
+	tkSpace           = "[space]"
+)
+
+type token struct {
+	class  tkclass
+	lexeme string
+	length int // in runes, with any space before the lexeme
+	span   source.Span
+}
+
+func (tk token) String() string {
+	return fmt.Sprintf("{%s}", tk.lexeme)
+}
+
+func all(input []rune, class tkclass) (*token, error) {
+	lexeme := string(input[0])
+	if len(input) > 1 {
+		n, err := next(input[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n.class == class {
+			lexeme += n.lexeme
+		}
+	}
+	return &token{class: class, lexeme: lexeme, length: len(lexeme)}, nil
+}
+
+func next(input []rune) (*token, error) {
+	switch r := input[0]; {
+	case r == '+', r == '*':
+		return &token{class: tkOp, lexeme: string(r), length: 1}, nil
+	case r == '(', r == ')':
+		return &token{class: tkBracket, lexeme: string(r), length: 1}, nil
+	case unicode.IsSpace(r):
+		if len(input) == 1 {
+			return &token{class: tkSpace, length: 1}, nil
+		}
+		n, err := next(input[1:])
+		if err != nil {
+			return nil, err
+		}
+		return &token{class: n.class, lexeme: n.lexeme, length: n.length + 1}, nil
+	case unicode.IsNumber(r):
+		return all(input, tkNum)
+	default:
+		return nil, fmt.Errorf("unknown token %q", r)
+	}
+}
+
+func tokenise(f *source.File) ([]token, error) {
+	input := []rune(f.Source())
+	var tokens []token
+	for i, offset := 0, 0; i < len(input); {
+		tk, err := next(input[i:])
+		if err != nil {
+			return nil, fmt.Errorf("%s: error after parsing %v: %s",
+				f.Position(f.Pos(offset)), tokens, err)
+		}
+		offset += len(string(input[i : i+tk.length]))
+		i += tk.length
+		if tk.class == tkSpace {
+			continue
+		}
+		tk.span = f.Span(offset-len(tk.lexeme), offset)
+		tokens = append(tokens, *tk)
+	}
+	return tokens, nil
+}
+
+// Tokens writes the tokens of f, one per line with its position and class.
+func Tokens(w io.Writer, f *source.File) error {
+	tokens, err := tokenise(f)
+	if err != nil {
+		return err
+	}
+	for _, tk := range tokens {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", f.Position(tk.span.Start), tk.class, tk.lexeme)
+	}
+	return nil
+}
+
+// Translate returns the expression in f with one space about each operator.
+func Translate(f *source.File) (string, error) {
+	tokens, err := tokenise(f)
+	if err != nil {
+		return "", fmt.Errorf("cannot tokenise: %v", err)
+	}
+	p := &parser{file: f, input: tokens}
+	if err := p.expr(); err != nil {
+		return "", fmt.Errorf("cannot parse: %v", err)
+	}
+	if p.pos < len(p.input) {
+		tk := p.input[p.pos]
+		return "", fmt.Errorf("cannot parse: %s: unexpected %q", f.Position(tk.span.Start), tk.lexeme)
+	}
+	return p.output, nil
+}
@@ -0,0 +1,92 @@
+// Command machine runs a program for the target machine of Section 8.2 and
+// prints the final registers, memory and total cost.
+//
+//	machine [-steps n] [-regs k] [-set name=v[,v...]] [file]
+//
+// The program is read from standard input if no file is given.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/akiarie/dragon-tests/compilers/ch8/machine"
+)
+
+type assignments []string
+
+func (a *assignments) String() string { return strings.Join(*a, " ") }
+
+func (a *assignments) Set(s string) error {
+	*a = append(*a, s)
+	return nil
+}
+
+// set stores an initial value of the form name=v[,v...] or name[i]=v[,v...].
+func set(m *machine.Machine, assign string) error {
+	eq := strings.IndexByte(assign, '=')
+	if eq == -1 {
+		return fmt.Errorf("initial value %q must have form name=value", assign)
+	}
+	id, index := strings.TrimSpace(assign[:eq]), 0
+	if i := strings.IndexByte(id, '['); i != -1 && strings.HasSuffix(id, "]") {
+		n, err := strconv.Atoi(id[i+1 : len(id)-1])
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid index in %q", id)
+		}
+		id, index = id[:i], n
+	}
+	var vals []machine.Value
+	for _, s := range strings.Split(assign[eq+1:], ",") {
+		v, err := machine.ParseValue(strings.TrimSpace(s))
+		if err != nil {
+			return err
+		}
+		vals = append(vals, v)
+	}
+	return m.Set(id, index, vals...)
+}
+
+func main() {
+	var inits assignments
+	steps := flag.Int("steps", 1000000, "maximum number of instructions to execute (0 for no limit)")
+	regs := flag.Int("regs", 0, "number of registers `k` available (0 for no limit)")
+	flag.Var(&inits, "set", "initial value `name=v[,v...]` (repeatable)")
+	flag.Parse()
+
+	var text []byte
+	var err error
+	switch flag.NArg() {
+	case 0:
+		text, err = io.ReadAll(os.Stdin)
+	case 1:
+		text, err = os.ReadFile(flag.Arg(0))
+	default:
+		log.Fatalln("usage: machine [flags] [file]")
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	m, err := machine.New(string(text))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	m.MaxSteps, m.NumRegs = *steps, *regs
+	for _, a := range inits {
+		if err := set(m, a); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	err = m.Run()
+	m.DumpRegs(os.Stdout)
+	m.Dump(os.Stdout)
+	fmt.Printf("cost %d in %d steps\n", m.Cost, m.Steps)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
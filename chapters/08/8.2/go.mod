@@ -0,0 +1,3 @@
+module github.com/akiarie/dragon-tests/compilers/ch8/machine
+
+go 1.16
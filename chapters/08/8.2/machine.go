@@ -0,0 +1,610 @@
+// Package machine simulates the target machine of Section 8.2: a byte
+// addressable memory of 8-byte words, general-purpose registers R0, R1, ...
+// and the instructions
+//
+//	LD r, x          r = x
+//	ST x, r          x = r
+//	OP r, x, y       r = x OP y, for OP one of ADD SUB MUL DIV MOD
+//	BR L             goto L
+//	BR *x            goto the instruction whose index is in location x
+//	Bcond r, L       if r cond 0 goto L, for cond one of LT GT LE GE EQ NE
+//	HALT             stop
+//
+// where the operands x and y may be given in any of the addressing modes
+//
+//	r                the register r
+//	#c               the constant c, or the index of the instruction at
+//	                 label c, or the address reserved for the name c
+//	x                the location reserved for the name x, or the absolute
+//	                 address x if it is a number
+//	a(r)             the location a + contents(r), with a a name or constant
+//	*r               the location contents(r)
+//	*a(r)            the location contents(a + contents(r))
+//
+// A program reserves memory with directives
+//
+//	.data x 8
+//
+// that give the size in bytes. Above reserved memory lies a stack of Stack
+// bytes, whose bottom is the initial contents of the register SP. Labels stand
+// alone or precede an instruction on the same line, and // starts a comment.
+//
+// The cost of an instruction is one plus one for each memory address, constant
+// or label that occupies a word following it (Section 8.2.2).
+package machine
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Mode is the addressing mode of an operand.
+type Mode int
+
+const (
+	Register Mode = iota
+	Immediate
+	Direct
+	Indexed
+	Indirect
+	IndirectIndexed
+)
+
+// SP is the number of the stack pointer register.
+const SP = -1
+
+// Operand is an instruction operand. Base is the name used by the Direct and
+// Indexed modes, or the label or name of an Immediate, which New resolves into
+// Const; if it is empty Offset is used instead.
+type Operand struct {
+	Mode   Mode
+	Reg    int
+	Base   string
+	Offset int64
+	Const  Value
+}
+
+func (o Operand) String() string {
+	base := o.Base
+	if base == "" {
+		base = strconv.FormatInt(o.Offset, 10)
+	}
+	switch o.Mode {
+	case Register:
+		return regname(o.Reg)
+	case Immediate:
+		if o.Base != "" {
+			return "#" + o.Base
+		}
+		return "#" + o.Const.String()
+	case Direct:
+		return base
+	case Indexed:
+		return fmt.Sprintf("%s(%s)", base, regname(o.Reg))
+	case Indirect:
+		return "*" + regname(o.Reg)
+	}
+	return fmt.Sprintf("*%s(%s)", base, regname(o.Reg))
+}
+
+func regname(r int) string {
+	if r == SP {
+		return "SP"
+	}
+	return fmt.Sprintf("R%d", r)
+}
+
+// words reports whether the operand occupies a word after the instruction.
+func (o Operand) words() bool {
+	return o.Mode != Register && o.Mode != Indirect
+}
+
+// Instr is a single instruction. Branches keep their target in Label, except
+// for an indirect BR, whose operand is in Args.
+type Instr struct {
+	Op    string
+	Args  []Operand
+	Label string
+	Line  int
+}
+
+func (in Instr) String() string {
+	args := make([]string, len(in.Args))
+	for i, a := range in.Args {
+		args[i] = a.String()
+	}
+	if in.Label != "" {
+		args = append(args, in.Label)
+	}
+	if len(args) == 0 {
+		return in.Op
+	}
+	return in.Op + " " + strings.Join(args, ", ")
+}
+
+// Cost is the cost of the instruction.
+func (in Instr) Cost() int {
+	c := 1
+	for _, a := range in.Args {
+		if a.words() {
+			c++
+		}
+	}
+	if in.Label != "" {
+		c++
+	}
+	return c
+}
+
+var operations = map[string]bool{"ADD": true, "SUB": true, "MUL": true, "DIV": true, "MOD": true}
+
+var branches = map[string]func(float64) bool{
+	"BLTZ": func(f float64) bool { return f < 0 },
+	"BGTZ": func(f float64) bool { return f > 0 },
+	"BLEZ": func(f float64) bool { return f <= 0 },
+	"BGEZ": func(f float64) bool { return f >= 0 },
+	"BEQZ": func(f float64) bool { return f == 0 },
+	"BNEZ": func(f float64) bool { return f != 0 },
+}
+
+// Machine is a loaded program together with the machine state.
+type Machine struct {
+	Code    []Instr
+	Labels  map[string]int
+	Symbols map[string]int // address of each reserved name
+	Sizes   map[string]int
+	Order   []string // names in order of reservation
+	Mem     map[int]Value
+	Regs    map[int]Value
+	PC      int
+	Steps   int
+	Cost    int
+
+	MaxSteps int // no limit if <= 0
+	NumRegs  int // no limit if <= 0, not counting SP
+	Stack    int // size of the stack in bytes
+	top      int // end of reserved memory
+}
+
+// DefaultStack is the size of the stack given by New.
+const DefaultStack = 1 << 16
+
+// New parses an assembly program.
+func New(text string) (*Machine, error) {
+	m := &Machine{Labels: map[string]int{}, Symbols: map[string]int{}, Sizes: map[string]int{},
+		Mem: map[int]Value{}, Regs: map[int]Value{}, Stack: DefaultStack}
+	for i, line := range strings.Split(text, "\n") {
+		if c := strings.Index(line, "//"); c != -1 {
+			line = line[:c]
+		}
+		line = strings.TrimSpace(line)
+		if c := strings.IndexByte(line, ':'); c != -1 {
+			l := strings.TrimSpace(line[:c])
+			if !isname(l) {
+				return nil, fmt.Errorf("line %d: invalid label %q", i+1, l)
+			}
+			if _, ok := m.Labels[l]; ok {
+				return nil, fmt.Errorf("line %d: label %s redefined", i+1, l)
+			}
+			m.Labels[l] = len(m.Code)
+			line = strings.TrimSpace(line[c+1:])
+		}
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if fields[0] == ".data" {
+			if err := m.reserve(fields[1:]); err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+1, err)
+			}
+			continue
+		}
+		in, err := parseinstr(fields[0], strings.TrimSpace(line[len(fields[0]):]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		in.Line = i + 1
+		m.Code = append(m.Code, in)
+	}
+	for _, in := range m.Code {
+		if _, ok := m.Labels[in.Label]; in.Label != "" && !ok {
+			return nil, fmt.Errorf("line %d: undefined label %s", in.Line, in.Label)
+		}
+		for i, a := range in.Args {
+			l, label := m.Labels[a.Base]
+			addr, ok := m.Symbols[a.Base]
+			switch {
+			case a.Base == "":
+			case a.Mode == Immediate && label:
+				in.Args[i].Const = Value{I: int64(l)}
+			case !ok:
+				return nil, fmt.Errorf("line %d: unknown name %s", in.Line, a.Base)
+			case a.Mode == Immediate:
+				in.Args[i].Const = Value{I: int64(addr)}
+			}
+		}
+	}
+	m.Regs[SP] = Value{I: int64(m.top)}
+	return m, nil
+}
+
+func (m *Machine) reserve(args []string) error {
+	if len(args) != 2 || !isname(args[0]) {
+		return fmt.Errorf("malformed directive")
+	}
+	if _, ok := m.Symbols[args[0]]; ok {
+		return fmt.Errorf("%s reserved twice", args[0])
+	}
+	size, err := strconv.Atoi(args[1])
+	if err != nil || size <= 0 || size%8 != 0 {
+		return fmt.Errorf("invalid size %s", args[1])
+	}
+	m.Symbols[args[0]], m.Sizes[args[0]] = m.top, size
+	m.Order = append(m.Order, args[0])
+	m.top += size
+	return nil
+}
+
+func isname(s string) bool {
+	for i, c := range s {
+		if !(c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func parseinstr(op, rest string) (Instr, error) {
+	in := Instr{Op: op}
+	var args []string
+	if rest != "" {
+		for _, a := range strings.Split(rest, ",") {
+			args = append(args, strings.TrimSpace(a))
+		}
+	}
+	var n int
+	switch {
+	case op == "LD" || op == "ST":
+		n = 2
+	case op == "BR":
+		n = 1
+	case op == "HALT":
+		n = 0
+	case operations[op]:
+		n = 3
+	case branches[op] != nil:
+		n = 2
+	default:
+		return in, fmt.Errorf("unknown instruction %s", op)
+	}
+	if len(args) != n {
+		return in, fmt.Errorf("%s takes %d operands", op, n)
+	}
+	if op == "BR" && strings.HasPrefix(args[0], "*") {
+		o, err := parseoperand(args[0])
+		if err != nil {
+			return in, err
+		}
+		in.Args = []Operand{o}
+		return in, nil
+	}
+	if op == "BR" || branches[op] != nil {
+		in.Label, args = args[n-1], args[:n-1]
+		if !isname(in.Label) {
+			return in, fmt.Errorf("invalid label %q", in.Label)
+		}
+	}
+	for _, a := range args {
+		o, err := parseoperand(a)
+		if err != nil {
+			return in, err
+		}
+		in.Args = append(in.Args, o)
+	}
+	switch {
+	case op == "ST":
+		if in.Args[0].Mode == Register || in.Args[0].Mode == Immediate {
+			return in, fmt.Errorf("ST needs a memory destination")
+		}
+		if in.Args[1].Mode != Register && in.Args[1].Mode != Immediate {
+			return in, fmt.Errorf("ST needs a register or constant source")
+		}
+	case op != "BR" && op != "HALT" && in.Args[0].Mode != Register:
+		return in, fmt.Errorf("%s needs a register in place of %s", op, in.Args[0])
+	}
+	return in, nil
+}
+
+func parsereg(s string) (int, bool) {
+	if s == "SP" {
+		return SP, true
+	}
+	if len(s) < 2 || s[0] != 'R' {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s[1:])
+	return n, err == nil && n >= 0
+}
+
+func parseoperand(s string) (Operand, error) {
+	if r, ok := parsereg(s); ok {
+		return Operand{Mode: Register, Reg: r}, nil
+	}
+	if strings.HasPrefix(s, "#") {
+		if isname(s[1:]) {
+			return Operand{Mode: Immediate, Base: s[1:]}, nil
+		}
+		v, err := ParseValue(s[1:])
+		return Operand{Mode: Immediate, Const: v}, err
+	}
+	indirect := strings.HasPrefix(s, "*")
+	if indirect {
+		s = s[1:]
+		if r, ok := parsereg(s); ok {
+			return Operand{Mode: Indirect, Reg: r}, nil
+		}
+	}
+	o := Operand{Mode: Direct}
+	if i := strings.IndexByte(s, '('); i != -1 && strings.HasSuffix(s, ")") {
+		r, ok := parsereg(s[i+1 : len(s)-1])
+		if !ok {
+			return o, fmt.Errorf("invalid register in %s", s)
+		}
+		o.Mode, o.Reg, s = Indexed, r, s[:i]
+	}
+	if c, err := strconv.ParseInt(s, 10, 64); err == nil {
+		o.Offset = c
+	} else if isname(s) {
+		o.Base = s
+	} else {
+		return o, fmt.Errorf("invalid operand %s", s)
+	}
+	if indirect {
+		if o.Mode != Indexed {
+			return o, fmt.Errorf("invalid operand *%s", s)
+		}
+		o.Mode = IndirectIndexed
+	}
+	return o, nil
+}
+
+func (m *Machine) errorf(format string, a ...interface{}) error {
+	in := m.Code[m.PC]
+	return fmt.Errorf("line %d: %s: %s", in.Line, in, fmt.Sprintf(format, a...))
+}
+
+func (m *Machine) reg(r int) (Value, error) {
+	if m.NumRegs > 0 && r >= m.NumRegs {
+		return Value{}, m.errorf("no register %s", regname(r))
+	}
+	return m.Regs[r], nil
+}
+
+// pointer reads an address held in a register or in memory.
+func (m *Machine) pointer(v Value) (int, error) {
+	if v.IsFloat {
+		return -1, m.errorf("%s is not an address", v)
+	}
+	return int(v.I), nil
+}
+
+func (m *Machine) check(addr int) (int, error) {
+	if addr < 0 || addr >= m.top+m.Stack || addr%8 != 0 {
+		return -1, m.errorf("invalid address %d", addr)
+	}
+	return addr, nil
+}
+
+// address resolves a memory operand.
+func (m *Machine) address(o Operand) (int, error) {
+	base := int(o.Offset)
+	if o.Base != "" {
+		base = m.Symbols[o.Base]
+	}
+	if o.Mode == Direct {
+		return m.check(base)
+	}
+	r, err := m.reg(o.Reg)
+	if err != nil {
+		return -1, err
+	}
+	off, err := m.pointer(r)
+	if err != nil {
+		return -1, err
+	}
+	switch o.Mode {
+	case Indexed:
+		if o.Base != "" && (off < 0 || off >= m.Sizes[o.Base]) {
+			return -1, m.errorf("offset %d out of range for %s", off, o.Base)
+		}
+		return m.check(base + off)
+	case Indirect:
+		return m.check(off)
+	}
+	addr, err := m.check(base + off)
+	if err != nil {
+		return -1, err
+	}
+	p, err := m.pointer(m.Mem[addr])
+	if err != nil {
+		return -1, err
+	}
+	return m.check(p)
+}
+
+// target resolves the operand of an indirect branch to an instruction index.
+func (m *Machine) target(o Operand) (int, error) {
+	v, err := m.reg(o.Reg)
+	if o.Mode == IndirectIndexed {
+		v, err = m.operand(Operand{Mode: Indexed, Reg: o.Reg, Base: o.Base, Offset: o.Offset})
+	}
+	if err != nil {
+		return -1, err
+	}
+	t, err := m.pointer(v)
+	if err != nil {
+		return -1, err
+	}
+	if t < 0 || t > len(m.Code) {
+		return -1, m.errorf("invalid branch target %d", t)
+	}
+	return t, nil
+}
+
+func (m *Machine) operand(o Operand) (Value, error) {
+	switch o.Mode {
+	case Register:
+		return m.reg(o.Reg)
+	case Immediate:
+		return o.Const, nil
+	}
+	addr, err := m.address(o)
+	if err != nil {
+		return Value{}, err
+	}
+	return m.Mem[addr], nil
+}
+
+func (m *Machine) setreg(r int, v Value) error {
+	if m.NumRegs > 0 && r >= m.NumRegs {
+		return m.errorf("no register %s", regname(r))
+	}
+	m.Regs[r] = v
+	return nil
+}
+
+// Step executes the instruction at PC.
+func (m *Machine) Step() error {
+	in := m.Code[m.PC]
+	next := m.PC + 1
+	switch {
+	case in.Op == "LD":
+		v, err := m.operand(in.Args[1])
+		if err != nil {
+			return err
+		}
+		if err := m.setreg(in.Args[0].Reg, v); err != nil {
+			return err
+		}
+	case in.Op == "ST":
+		v, err := m.operand(in.Args[1])
+		if err != nil {
+			return err
+		}
+		addr, err := m.address(in.Args[0])
+		if err != nil {
+			return err
+		}
+		m.Mem[addr] = v
+	case in.Op == "BR" && in.Label == "":
+		t, err := m.target(in.Args[0])
+		if err != nil {
+			return err
+		}
+		next = t
+	case in.Op == "BR":
+		next = m.Labels[in.Label]
+	case in.Op == "HALT":
+		next = len(m.Code)
+	case operations[in.Op]:
+		a, err := m.operand(in.Args[1])
+		if err != nil {
+			return err
+		}
+		b, err := m.operand(in.Args[2])
+		if err != nil {
+			return err
+		}
+		v, err := arith(in.Op, a, b)
+		if err != nil {
+			return m.errorf("%v", err)
+		}
+		if err := m.setreg(in.Args[0].Reg, v); err != nil {
+			return err
+		}
+	default:
+		v, err := m.reg(in.Args[0].Reg)
+		if err != nil {
+			return err
+		}
+		if branches[in.Op](v.Float64()) {
+			next = m.Labels[in.Label]
+		}
+	}
+	m.Cost += in.Cost()
+	m.Steps++
+	m.PC = next
+	return nil
+}
+
+// Run executes the program until it halts or control falls off its end.
+func (m *Machine) Run() error {
+	for m.PC < len(m.Code) {
+		if m.MaxSteps > 0 && m.Steps >= m.MaxSteps {
+			return fmt.Errorf("step limit %d exceeded at line %d", m.MaxSteps, m.Code[m.PC].Line)
+		}
+		if err := m.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set stores vals in consecutive words of the name id, starting at word
+// index.
+func (m *Machine) Set(id string, index int, vals ...Value) error {
+	addr, ok := m.Symbols[id]
+	if !ok {
+		return fmt.Errorf("cannot set unreserved name %s", id)
+	}
+	if index < 0 || 8*(index+len(vals)) > m.Sizes[id] {
+		return fmt.Errorf("index %d out of range for %s", index+len(vals)-1, id)
+	}
+	for i, v := range vals {
+		m.Mem[addr+8*(index+i)] = v
+	}
+	return nil
+}
+
+// Load returns word index of the name id.
+func (m *Machine) Load(id string, index int) (Value, error) {
+	addr, ok := m.Symbols[id]
+	if !ok {
+		return Value{}, fmt.Errorf("unknown name %s", id)
+	}
+	if index < 0 || 8*index >= m.Sizes[id] {
+		return Value{}, fmt.Errorf("index %d out of range for %s", index, id)
+	}
+	return m.Mem[addr+8*index], nil
+}
+
+// Dump writes the contents of reserved memory.
+func (m *Machine) Dump(w io.Writer) {
+	for _, id := range m.Order {
+		addr, n := m.Symbols[id], m.Sizes[id]/8
+		if n == 1 {
+			fmt.Fprintf(w, "%s = %s\n", id, m.Mem[addr])
+			continue
+		}
+		cells := make([]string, n)
+		for i := range cells {
+			cells[i] = m.Mem[addr+8*i].String()
+		}
+		fmt.Fprintf(w, "%s[%d] = [%s]\n", id, n, strings.Join(cells, " "))
+	}
+}
+
+// DumpRegs writes the contents of the registers that have been assigned.
+func (m *Machine) DumpRegs(w io.Writer) {
+	var regs []int
+	for r := range m.Regs {
+		regs = append(regs, r)
+	}
+	sort.Ints(regs)
+	for _, r := range regs {
+		fmt.Fprintf(w, "%s = %s\n", regname(r), m.Regs[r])
+	}
+}
@@ -0,0 +1,186 @@
+package machine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCost(t *testing.T) {
+	// the examples of Section 8.2.2
+	tests := []struct {
+		instr string
+		cost  int
+	}{
+		{"LD R0, R1", 1},
+		{"LD R0, M", 2},
+		{"LD R1, *100(R2)", 2},
+		{"LD R1, *R2", 1},
+		{"LD R1, a(R2)", 2},
+		{"ADD R0, R1, #8", 2},
+		{"SUB R0, R1, R2", 1},
+		{"ST M, R0", 2},
+		{"BR L", 2},
+		{"BLTZ R0, L", 2},
+	}
+	for _, tc := range tests {
+		m, err := New(".data M 8\n.data a 16\nL: " + tc.instr)
+		if err != nil {
+			t.Errorf("%s: %v", tc.instr, err)
+			continue
+		}
+		if got := m.Code[0].Cost(); got != tc.cost {
+			t.Errorf("%s: cost %d, expected %d", tc.instr, got, tc.cost)
+		}
+		if got := m.Code[0].String(); got != tc.instr {
+			t.Errorf("%s: printed as %s", tc.instr, got)
+		}
+	}
+}
+
+func TestAddressing(t *testing.T) {
+	m, err := New(`
+	.data x 8
+	.data a 32
+	.data p 8
+	.data y 8
+	LD R0, #16
+	LD R1, a(R0)     // a[2]
+	LD R2, 8(R0)     // address 24 is a[2]
+	ST y, R2
+	LD R3, p         // p holds the address of a[3]
+	LD R4, *R3
+	LD R5, #-24
+	LD R6, *y(R5)    // a[2] = 8 is the address of a[0]
+	ADD R7, R1, R4
+	ST *R3, R7
+	ST x, R6
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set("a", 0, Int(24), Int(7), Int(8), Int(5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set("p", 0, Int(32)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	m.Dump(&b)
+	want := "x = 24\na[4] = [24 7 8 13]\np = 32\ny = 8\n"
+	if b.String() != want {
+		t.Errorf("expected\n%sgot\n%s", want, b.String())
+	}
+	if m.Cost != 2+2+2+2+2+1+2+2+1+1+2 {
+		t.Errorf("cost %d", m.Cost)
+	}
+}
+
+func TestBranches(t *testing.T) {
+	// sum of 1..n, with a float accumulator
+	m, err := New(`
+	.data n 8
+	.data s 8
+		LD R0, n
+		LD R1, #0.5
+	L0:	BLEZ R0, L1
+		ADD R1, R1, R0
+		SUB R0, R0, #1
+		BR L0
+	L1:	ST s, R1
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Set("n", 0, Int(10))
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := m.Load("s", 0); s != Float(55.5) {
+		t.Errorf("s is %s", s)
+	}
+	if m.Steps != 2+4*10+1+1 {
+		t.Errorf("%d steps", m.Steps)
+	}
+	if m.Cost != 2+2+10*(2+1+2+2)+2+2 {
+		t.Errorf("cost %d", m.Cost)
+	}
+}
+
+func TestCalls(t *testing.T) {
+	// recursive factorial with the stack calling sequence of Section 8.3.2;
+	// a frame holds the return address and then n
+	m, err := New(`
+	.data n 8
+	.data r 8
+		LD R0, n
+		ST 8(SP), R0
+		ST *SP, #L0
+		BR fact
+	L0:	ST r, R0
+		HALT
+	fact:	LD R1, 8(SP)
+		BGTZ R1, L1
+		LD R0, #1
+		BR *0(SP)
+	L1:	SUB R1, R1, #1
+		ST 24(SP), R1
+		ADD SP, SP, #16
+		ST *SP, #L2
+		BR fact
+	L2:	SUB SP, SP, #16
+		LD R1, 8(SP)
+		MUL R0, R0, R1
+		BR *0(SP)
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Set("n", 0, Int(5))
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := m.Load("r", 0); r != Int(120) {
+		t.Errorf("r is %s", r)
+	}
+	if sp := m.Regs[SP]; sp != Int(16) {
+		t.Errorf("SP is %s", sp)
+	}
+	if in := m.Code[2]; in.String() != "ST *SP, #L0" || in.Cost() != 2 {
+		t.Errorf("%s costs %d", in, in.Cost())
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		prog, err string
+	}{
+		{"LD x, R0", "needs a register"},
+		{"ST R0, R1", "memory destination"},
+		{"BR L", "undefined label"},
+		{"LD R0, y", "unknown name"},
+		{"JMP L", "unknown instruction"},
+		{".data x 7", "invalid size"},
+		{".data a 16\nLD R0, #16\nLD R1, a(R0)", "out of range"},
+		{"LD R0, #1\nLD R1, *R0", "invalid address"},
+		{"LD R0, #1\nDIV R0, R0, #0", "division by zero"},
+		{"L: BR L", "step limit"},
+		{"LD R4, #1", "no register R4"},
+		{"LD R0, #x", "unknown name"},
+		{"HALT R0", "takes 0 operands"},
+		{"LD R0, #-1\nBR *R0", "invalid branch target"},
+		{"ST 0(SP), #1\nLD SP, #1\nST 0(SP), #1", "invalid address"},
+	}
+	for _, tc := range tests {
+		m, err := New(tc.prog)
+		if err == nil {
+			m.MaxSteps, m.NumRegs = 100, 4
+			err = m.Run()
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: expected error %q, got %v", tc.prog, tc.err, err)
+		}
+	}
+}
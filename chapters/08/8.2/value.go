@@ -0,0 +1,85 @@
+package machine
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Value is the content of a register or memory word. The machine is
+// dynamically typed: arithmetic is done on floats if either operand is a float,
+// and on integers otherwise.
+type Value struct {
+	IsFloat bool
+	I       int64
+	F       float64
+}
+
+// Int returns the integer i as a Value.
+func Int(i int64) Value { return Value{I: i} }
+
+// Float returns the float f as a Value.
+func Float(f float64) Value { return Value{IsFloat: true, F: f} }
+
+// Float64 returns v as a float.
+func (v Value) Float64() float64 {
+	if v.IsFloat {
+		return v.F
+	}
+	return float64(v.I)
+}
+
+func (v Value) String() string {
+	if v.IsFloat {
+		return strconv.FormatFloat(v.F, 'g', -1, 64)
+	}
+	return strconv.FormatInt(v.I, 10)
+}
+
+// ParseValue reads an integer or float literal.
+func ParseValue(s string) (Value, error) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return Int(i), nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return Float(f), nil
+	}
+	return Value{}, fmt.Errorf("invalid literal %q", s)
+}
+
+func arith(op string, a, b Value) (Value, error) {
+	if a.IsFloat || b.IsFloat {
+		x, y := a.Float64(), b.Float64()
+		switch op {
+		case "ADD":
+			return Float(x + y), nil
+		case "SUB":
+			return Float(x - y), nil
+		case "MUL":
+			return Float(x * y), nil
+		case "DIV":
+			return Float(x / y), nil
+		case "MOD":
+			return Float(math.Mod(x, y)), nil
+		}
+		return Value{}, fmt.Errorf("unknown operation %s", op)
+	}
+	x, y := a.I, b.I
+	switch op {
+	case "ADD":
+		return Int(x + y), nil
+	case "SUB":
+		return Int(x - y), nil
+	case "MUL":
+		return Int(x * y), nil
+	case "DIV", "MOD":
+		if y == 0 {
+			return Value{}, fmt.Errorf("integer division by zero")
+		}
+		if op == "DIV" {
+			return Int(x / y), nil
+		}
+		return Int(x % y), nil
+	}
+	return Value{}, fmt.Errorf("unknown operation %s", op)
+}
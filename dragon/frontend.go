@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/akiarie/dragon-tests/compilers/ch2/calc"
+	"github.com/akiarie/dragon-tests/compilers/ch2/postfix"
+	"github.com/akiarie/dragon-tests/compilers/ch2/scopes"
+	"github.com/akiarie/dragon-tests/compilers/ch2/trans"
+	"github.com/akiarie/dragon-tests/compilers/ch4/rad"
+	"github.com/akiarie/dragon-tests/grammar"
+	"github.com/akiarie/dragon-tests/source"
+)
+
+// stage writes one form of the translation of f.
+type stage func(w io.Writer, f *source.File, opts *options) error
+
+// frontend is a translator with the stages it can dump.
+type frontend struct {
+	stages map[string]stage
+	run    stage // executes the program, if the language can be run
+
+	// repl evaluates the lines of r as they are read, if the language has a
+	// read-eval-print loop
+	repl func(r io.Reader, w io.Writer, opts *options, interactive bool) error
+}
+
+func (fe frontend) names() []string {
+	var names []string
+	for name := range fe.stages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// translation is the ir stage of a translator returning its output whole.
+func translation(translate func(f *source.File) (string, error)) stage {
+	return func(w io.Writer, f *source.File, opts *options) error {
+		out, err := translate(f)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, out)
+		return nil
+	}
+}
+
+func tokens(print func(w io.Writer, f *source.File) error) stage {
+	return func(w io.Writer, f *source.File, opts *options) error {
+		return print(w, f)
+	}
+}
+
+// static is the constructor of a front end that the options do not change.
+func static(fe frontend) func(opts *options) (frontend, error) {
+	return func(opts *options) (frontend, error) { return fe, nil }
+}
+
+// frontends construct the front end of each language from the options.
+var frontends = map[string]func(opts *options) (frontend, error){
+	"postfix": static(frontend{stages: map[string]stage{
+		"tokens": tokens(postfix.Tokens),
+		"ir": func(w io.Writer, f *source.File, opts *options) error {
+			mode := postfix.Postfix
+			if opts.prefix {
+				mode = postfix.Prefix
+			}
+			out, err := postfix.Translate(f, mode)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(w, out)
+			return nil
+		},
+	}}),
+	"calc": static(frontend{
+		stages: map[string]stage{
+			"tokens": tokens(calc.Tokens),
+			"ir":     translation(calc.Translate),
+		},
+		run: func(w io.Writer, f *source.File, opts *options) error {
+			c, err := calculator(opts)
+			if err != nil {
+				return err
+			}
+			return c.Run(w, f)
+		},
+		repl: func(r io.Reader, w io.Writer, opts *options, interactive bool) error {
+			c, err := calculator(opts)
+			if err != nil {
+				return err
+			}
+			return c.REPL(r, w, interactive)
+		},
+	}),
+	"scopes": static(frontend{stages: map[string]stage{
+		"tokens": tokens(scopes.Tokens),
+		"ir":     translation(scopes.Translate),
+	}}),
+	"rad": static(frontend{stages: map[string]stage{
+		"tokens": tokens(rad.Tokens),
+		"ir":     translation(rad.Translate),
+	}}),
+	"tac":     static(tac),
+	"grammar": grammars,
+}
+
+// calculator is a calculator in the arithmetic of -mode and -precision.
+func calculator(opts *options) (*calc.Calculator, error) {
+	mode, err := calc.ParseMode(opts.mode)
+	if err != nil {
+		return nil, err
+	}
+	c := calc.New()
+	c.Mode = mode
+	if opts.precision > 0 {
+		c.Precision = opts.precision
+	}
+	return c, nil
+}
+
+// grammars constructs the front end of the grammar read from -grammar.
+func grammars(opts *options) (frontend, error) {
+	if opts.grammar == "" {
+		return frontend{}, fmt.Errorf("-lang grammar requires -grammar")
+	}
+	src, err := os.ReadFile(opts.grammar)
+	if err != nil {
+		return frontend{}, err
+	}
+	G, err := grammar.ReadGrammar(string(src))
+	if err != nil {
+		return frontend{}, fmt.Errorf("%s: %v", opts.grammar, err)
+	}
+	if G, err = G.Desugar(); err != nil {
+		return frontend{}, fmt.Errorf("%s: %v", opts.grammar, err)
+	}
+	if err := G.Validate(); err != nil {
+		return frontend{}, err
+	}
+	return frontend{stages: map[string]stage{
+		"tokens": func(w io.Writer, f *source.File, opts *options) error {
+			tokens, err := G.Tokens(f)
+			if err != nil {
+				return err
+			}
+			for _, tk := range tokens {
+				fmt.Fprintf(w, "%s\t%s\n", f.Position(tk.Span().Start), tk)
+			}
+			return nil
+		},
+		"ast": func(w io.Writer, f *source.File, opts *options) error {
+			parse := G.ParseFile
+			if opts.peg {
+				parse = G.ParsePEGFile
+			}
+			tree, err := parse(f)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(w, tree)
+			return nil
+		},
+		"leftmost": func(w io.Writer, f *source.File, opts *options) error {
+			parse := G.ParseFile
+			if opts.peg {
+				parse = G.ParsePEGFile
+			}
+			tree, err := parse(f)
+			if err != nil {
+				return err
+			}
+			writelatex(w, tree.Leftmost(), opts)
+			return nil
+		},
+		"rightmost": func(w io.Writer, f *source.File, opts *options) error {
+			parse := G.ParseFile
+			if opts.peg {
+				parse = G.ParsePEGFile
+			}
+			tree, err := parse(f)
+			if err != nil {
+				return err
+			}
+			writelatex(w, tree.Rightmost(), opts)
+			return nil
+		},
+		"handles": func(w io.Writer, f *source.File, opts *options) error {
+			parse := G.ParseFile
+			if opts.peg {
+				parse = G.ParsePEGFile
+			}
+			tree, err := parse(f)
+			if err != nil {
+				return err
+			}
+			writelatex(w, tree.Reductions(), opts)
+			return nil
+		},
+		"forest": func(w io.Writer, f *source.File, opts *options) error {
+			parse := G.Earley
+			if opts.glr {
+				parse = G.GLR
+			}
+			F, err := parse(f)
+			if err != nil {
+				return err
+			}
+			fmt.Fprint(w, F)
+			count, err := F.Count()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%s parse trees\n", count)
+			return nil
+		},
+		"ir": func(w io.Writer, f *source.File, opts *options) error {
+			if _, err := (grammar.Scheme{Grammar: G}).Translate(w, f); err != nil {
+				return err
+			}
+			fmt.Fprintln(w)
+			return nil
+		},
+	}}, nil
+}
+
+// latexer is a derivation, or the handles of a parse, which -latex writes in
+// LaTeX.
+type latexer interface {
+	fmt.Stringer
+	LaTeX() string
+}
+
+func writelatex(w io.Writer, x latexer, opts *options) {
+	if opts.latex {
+		fmt.Fprint(w, x.LaTeX())
+		return
+	}
+	fmt.Fprintln(w, strings.TrimSuffix(x.String(), "\n"))
+}
+
+// translated is the three-address code of f.
+func translated(f *source.File) (*trans.TAC, error) {
+	prog, err := trans.Parse(f)
+	if err != nil {
+		return nil, err
+	}
+	return prog.Translate()
+}
+
+// the stages of three-address code
+func code(dump func(w io.Writer, c *trans.TAC, opts *options) error) stage {
+	return func(w io.Writer, f *source.File, opts *options) error {
+		c, err := translated(f)
+		if err != nil {
+			return err
+		}
+		return dump(w, c, opts)
+	}
+}
+
+var tac = frontend{
+	stages: map[string]stage{
+		"tokens": tokens(trans.Tokens),
+		"ast": func(w io.Writer, f *source.File, opts *options) error {
+			prog, err := trans.Parse(f)
+			if err != nil {
+				return err
+			}
+			prog.AST(w)
+			return nil
+		},
+		"ir": code(func(w io.Writer, c *trans.TAC, opts *options) error {
+			fmt.Fprint(w, c)
+			return nil
+		}),
+		"cfg": code(func(w io.Writer, c *trans.TAC, opts *options) error {
+			c.CFG(w)
+			return nil
+		}),
+		"ssa": code(func(w io.Writer, c *trans.TAC, opts *options) error {
+			c.SSA(w)
+			return nil
+		}),
+		"asm": func(w io.Writer, f *source.File, opts *options) error {
+			if opts.tile > 0 {
+				prog, err := trans.Parse(f)
+				if err != nil {
+					return err
+				}
+				asm, err := prog.Target(opts.tile)
+				if err != nil {
+					return err
+				}
+				fmt.Fprint(w, asm)
+				return nil
+			}
+			c, err := translated(f)
+			if err != nil {
+				return err
+			}
+			asm, err := c.X86(opts.inits)
+			if err != nil {
+				return err
+			}
+			fmt.Fprint(w, asm)
+			return nil
+		},
+		"regalloc": code(func(w io.Writer, c *trans.TAC, opts *options) error {
+			return c.Regalloc(w, opts.regalloc)
+		}),
+	},
+	run: func(w io.Writer, f *source.File, opts *options) error {
+		if opts.tile > 0 {
+			prog, err := trans.Parse(f)
+			if err != nil {
+				return err
+			}
+			asm, err := prog.Target(opts.tile)
+			if err != nil {
+				return err
+			}
+			return trans.RunTarget(w, asm, opts.tile, opts.inits, opts.steps)
+		}
+		c, err := translated(f)
+		if err != nil {
+			return err
+		}
+		return c.Run(w, opts.inits, opts.steps)
+	},
+}
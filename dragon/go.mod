@@ -0,0 +1,24 @@
+module github.com/akiarie/dragon-tests/dragon
+
+go 1.16
+
+require (
+	github.com/akiarie/dragon-tests/compilers/ch2/calc v0.0.0-00010101000000-000000000000
+	github.com/akiarie/dragon-tests/compilers/ch2/postfix v0.0.0-00010101000000-000000000000
+	github.com/akiarie/dragon-tests/compilers/ch2/scopes v0.0.0-00010101000000-000000000000
+	github.com/akiarie/dragon-tests/compilers/ch2/trans v0.0.0-00010101000000-000000000000
+	github.com/akiarie/dragon-tests/compilers/ch4/rad v0.0.0-00010101000000-000000000000
+	github.com/akiarie/dragon-tests/grammar v0.0.0-00010101000000-000000000000
+	github.com/akiarie/dragon-tests/source v0.0.0-00010101000000-000000000000
+)
+
+replace (
+	github.com/akiarie/dragon-tests/compilers/ch2/calc => ../chapters/02/2.6
+	github.com/akiarie/dragon-tests/compilers/ch2/postfix => ../chapters/02/2.5
+	github.com/akiarie/dragon-tests/compilers/ch2/scopes => ../chapters/02/2.7
+	github.com/akiarie/dragon-tests/compilers/ch2/trans => ../chapters/02/2.8
+	github.com/akiarie/dragon-tests/compilers/ch4/rad => ../chapters/04/RAD
+	github.com/akiarie/dragon-tests/compilers/ch8/machine => ../chapters/08/8.2
+	github.com/akiarie/dragon-tests/grammar => ../cc
+	github.com/akiarie/dragon-tests/source => ../source
+)
@@ -0,0 +1,209 @@
+// Command dragon runs one of the translators of the chapters on source files,
+// and writes the stages of its translation.
+//
+//	dragon [-lang l] [-grammar file.grm] [-dump stage[,stage...]] [-o dir] [file...]
+//
+// The source is read from standard input if no file is given. The languages
+// and the stages each can dump are
+//
+//	postfix  tokens ir                    Section 2.5, expressions to postfix
+//	calc     tokens ir                    Section 2.6, expressions to postfix
+//	scopes   tokens ir                    Section 2.7, uses annotated with types
+//	tac      tokens ast ir cfg ssa asm    Section 2.8, three-address code
+//	rad      tokens ir                    Chapter 4, recursive descent-ascent
+//	grammar  tokens ast leftmost rightmost handles forest ir
+//	                                      the scheme given by -grammar
+//
+// Each stage is written to standard output, or with -o to dir/name.stage for
+// the file name.ext (stdin.stage for standard input).
+//
+// For postfix, -prefix translates to prefix notation instead. For calc, -run
+// evaluates the statements, and -repl evaluates them a line at a time from
+// standard input, in the arithmetic given by -mode and -precision. For
+// grammar, which may be written in extended BNF, -peg parses the ast stage by
+// a packrat parser, reading the grammar as a parsing expression grammar, and
+// the forest stage is the parse forest of an Earley parser, or with -glr of a
+// GLR parser, which accept any grammar. The leftmost and rightmost stages are
+// the sentential forms of the derivations of the ast, and the handles stage
+// the handles by which a bottom-up parse reduces it, written in LaTeX with
+// -latex.
+// For tac, the asm stage is x86-64 assembly, or code for the target machine
+// of Section 8.2 with -tile; -regalloc adds the stage regalloc, and -run
+// executes the program after its stages are written.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/akiarie/dragon-tests/source"
+)
+
+type assignments []string
+
+func (a *assignments) String() string { return strings.Join(*a, " ") }
+
+func (a *assignments) Set(s string) error {
+	*a = append(*a, s)
+	return nil
+}
+
+// options are the flags that frontends consult.
+type options struct {
+	grammar   string
+	glr       bool
+	peg       bool
+	latex     bool
+	prefix    bool
+	mode      string
+	precision int
+	inits     assignments
+	steps     int
+	regalloc  int
+	tile      int
+	run       bool
+}
+
+// run runs dragon with the flags and files of args, writing the stages to
+// stdout and any usage to stderr.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	var opts options
+	fs := flag.NewFlagSet("dragon", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	lang := fs.String("lang", "tac", "source language `l`: "+strings.Join(languages(), ", "))
+	fs.StringVar(&opts.grammar, "grammar", "", "grammar `file` for -lang grammar")
+	fs.BoolVar(&opts.glr, "glr", false, "construct the forest stage with a GLR parser rather than an Earley one (grammar)")
+	fs.BoolVar(&opts.peg, "peg", false, "construct the ast stage with a packrat parser, reading the grammar as a PEG (grammar)")
+	fs.BoolVar(&opts.latex, "latex", false, "write the leftmost, rightmost and handles stages in LaTeX (grammar)")
+	fs.BoolVar(&opts.prefix, "prefix", false, "translate to prefix rather than postfix notation (postfix)")
+	dump := fs.String("dump", "ir", "comma-separated `stages` to write: tokens, ast, leftmost, rightmost, handles, forest, ir, cfg, ssa, asm")
+	dir := fs.String("o", "", "write each stage to a file in `dir` instead of standard output")
+	fs.BoolVar(&opts.run, "run", false, "execute the program (calc, tac)")
+	fs.StringVar(&opts.mode, "mode", "float", "arithmetic `m` of the calculator: float, rat, int or decimal (calc)")
+	fs.IntVar(&opts.precision, "precision", 0, "significant `digits` in -mode decimal (calc, default 34)")
+	repl := fs.Bool("repl", false, "evaluate standard input a line at a time (calc)")
+	fs.Var(&opts.inits, "set", "initial value `name=v[,v...]` when executing (tac, repeatable)")
+	fs.IntVar(&opts.steps, "steps", 1000000, "maximum number of instructions to execute (0 for no limit)")
+	fs.IntVar(&opts.regalloc, "regalloc", 0, "allocate `k` registers and dump the interference graph and assignment (tac)")
+	fs.IntVar(&opts.tile, "tile", 0, "generate code for the target machine of Section 8.2 with `k` registers (tac)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: dragon [flags] [file...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	newfrontend, ok := frontends[*lang]
+	if !ok {
+		return fmt.Errorf("unknown language %q", *lang)
+	}
+	fe, err := newfrontend(&opts)
+	if err != nil {
+		return err
+	}
+	var stages []string
+	for _, s := range strings.Split(*dump, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if _, ok := fe.stages[s]; !ok {
+			return fmt.Errorf("%s cannot dump stage %q (has %s)", *lang, s, strings.Join(fe.names(), ", "))
+		}
+		stages = append(stages, s)
+	}
+	if opts.regalloc > 0 {
+		stages = append(stages, "regalloc")
+	}
+	if opts.run && fe.run == nil {
+		return fmt.Errorf("%s cannot be run", *lang)
+	}
+
+	if *repl {
+		if fe.repl == nil {
+			return fmt.Errorf("%s has no REPL", *lang)
+		}
+		if fs.NArg() > 0 {
+			return fmt.Errorf("-repl reads standard input, not files")
+		}
+		return fe.repl(stdin, stdout, &opts, isterminal(stdin))
+	}
+
+	var files []*source.File
+	if fs.NArg() == 0 {
+		b, err := io.ReadAll(stdin)
+		if err != nil {
+			return err
+		}
+		files = append(files, source.NewFile("stdin", string(b)))
+	}
+	for _, name := range fs.Args() {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		files = append(files, source.NewFile(name, string(b)))
+	}
+
+	headers := *dir == "" && len(files)*len(stages) > 1
+	for _, f := range files {
+		for _, stage := range stages {
+			var b bytes.Buffer
+			if err := fe.stages[stage](&b, f, &opts); err != nil {
+				return err
+			}
+			if *dir != "" {
+				base := strings.TrimSuffix(filepath.Base(f.Name()), filepath.Ext(f.Name()))
+				if err := os.WriteFile(filepath.Join(*dir, base+"."+stage), b.Bytes(), 0o644); err != nil {
+					return err
+				}
+				continue
+			}
+			if headers {
+				fmt.Fprintf(stdout, "== %s: %s\n", f.Name(), stage)
+			}
+			stdout.Write(b.Bytes())
+		}
+		if opts.run {
+			if err := fe.run(stdout, f, &opts); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isterminal reports whether v is a terminal, to which a REPL should prompt
+// and errors may be coloured.
+func isterminal(v interface{}) bool {
+	f, ok := v.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func languages() []string {
+	var names []string
+	for name := range frontends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		if err != flag.ErrHelp {
+			source.PrintError(os.Stderr, err, isterminal(os.Stderr) && os.Getenv("NO_COLOR") == "")
+		}
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLanguages(t *testing.T) {
+	tests := []struct {
+		args  []string
+		input string
+		want  string
+	}{
+		{[]string{"-lang", "postfix"}, "9-5+2\n", "9 5 - 2 +\n"},
+		{[]string{"-lang", "postfix", "-prefix"}, "12 * (x - 3)", "* 12 - x 3\n"},
+		{[]string{"-lang", "calc"}, "(1 + x) * 2", "(1)(x)+(2)*\n"},
+		{[]string{"-lang", "calc", "-dump", "tokens"}, "1 +\n x", "stdin:1:1\tnum\t1\nstdin:1:3\top\t+\nstdin:2:2\tid\tx\n"},
+		{[]string{"-lang", "calc", "-run", "-dump", ""}, "x = 3; x * 2", "6\n"},
+		{[]string{"-lang", "calc", "-repl"}, "x = 3\nx *\n 2\ny\n", "6\nerror: 1:1: undefined variable y\n"},
+		{[]string{"-lang", "calc", "-run", "-dump", "", "-mode", "rat"}, "1/3 + 0.125", "11/24\n"},
+		{[]string{"-lang", "calc", "-run", "-dump", "", "-mode", "decimal", "-precision", "5"}, "2/3", "0.66667\n"},
+		{[]string{"-lang", "scopes"}, "{ int x; { char x; x; } x; }", "{ { x:char; } x:int; }\n"},
+		{[]string{"-lang", "rad"}, "1+2 *3", "1 + 2 * 3\n"},
+		{[]string{"-lang", "grammar", "-grammar", "../cc/bnf/dragon-216.grm", "-dump", "tokens"}, "other", "stdin:1:1\tother\n"},
+		{[]string{"-lang", "grammar", "-grammar", "../cc/bnf/dragon-215.grm"}, "9-5+2", "95-2+\n"},
+		{[]string{"-lang", "grammar", "-grammar", "../cc/bnf/dragon-216.grm", "-dump", "ast", "-peg"}, "other", "stmt → other\n└── other\n    \n\n"},
+		{[]string{"-lang", "grammar", "-grammar", "../cc/bnf/dragon-216.grm", "-dump", "forest"}, "other", "stmt[0:1] → other[0:1]\n1 parse trees\n"},
+		{[]string{"-lang", "grammar", "-grammar", "../cc/bnf/dragon-216.grm", "-dump", "forest", "-glr"}, "other", "stmt[0:1] → other[0:1]\n1 parse trees\n"},
+		{[]string{"-lang", "grammar", "-grammar", "../cc/bnf/block.grm", "-dump", "forest"}, "{ other }", "block[0:3] → {[0:1] stmt_star[1:2] }[2:3]\nstmt_star[1:2] → stmt[1:2] stmt_star[2:2]\nstmt[1:2] → other[1:2]\nstmt_star[2:2] → ε\n1 parse trees\n"},
+		{[]string{"-lang", "grammar", "-grammar", "../cc/bnf/prefix.grm", "-dump", "leftmost"}, "+ - a a a", "S ⇒ + S S\n  ⇒ + - S S S\n  ⇒ + - a S S\n  ⇒ + - a a S\n  ⇒ + - a a a\n"},
+		{[]string{"-lang", "grammar", "-grammar", "../cc/bnf/prefix.grm", "-dump", "rightmost", "-latex"}, "- a a", "\\begin{align*}\nS &\\underset{rm}{\\Rightarrow} -\\;S\\;S \\\\\n &\\underset{rm}{\\Rightarrow} -\\;S\\;\\mathbf{a} \\\\\n &\\underset{rm}{\\Rightarrow} -\\;\\mathbf{a}\\;\\mathbf{a}\n\\end{align*}\n"},
+		{[]string{"-lang", "grammar", "-grammar", "../cc/bnf/prefix.grm", "-dump", "handles", "-peg"}, "- a a", "right sentential form  handle  reducing production\n- a a                  a       S → a\n- S a                  a       S → a\n- S S                  - S S   S → - S S\n"},
+		{[]string{}, "{ int x; x = 1 + 2; }", "declare x int\nt0 = 1 + 2\nx = t0\n"},
+		{[]string{"-dump", "ssa"}, "{ int x; x = 1; x = x + 1; }", "main\nB0: entry -> B1\nB1: <- B0\n\tx.1 = 1\n\tt0.1 = x.1 + 1\n\tx.2 = t0.1\n"},
+		{[]string{"-run", "-dump", ""}, "{ int x; x = 6 * 7; }", "x int = 42\nt0 = 42\n"},
+	}
+	for _, tc := range tests {
+		var out bytes.Buffer
+		if err := run(tc.args, strings.NewReader(tc.input), &out, &out); err != nil {
+			t.Errorf("%v: %v", tc.args, err)
+			continue
+		}
+		if out.String() != tc.want {
+			t.Errorf("%v: got\n%s\nwant\n%s", tc.args, out.String(), tc.want)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		args  []string
+		input string
+		want  string
+	}{
+		{[]string{"-lang", "pascal"}, "", `unknown language "pascal"`},
+		{[]string{"-lang", "calc", "-dump", "cfg"}, "", `calc cannot dump stage "cfg" (has ir, tokens)`},
+		{[]string{"-lang", "grammar"}, "", "-lang grammar requires -grammar"},
+		{[]string{"-lang", "grammar", "-grammar", "../cc/bnf/dragon-216.grm", "-dump", "ast", "-peg"}, "for ( ; expr ; ) other", `stdin:1:9: expected one of {;}, found "expr"`},
+		{[]string{"-lang", "rad", "-run"}, "", "rad cannot be run"},
+		{[]string{"-lang", "tac", "-repl"}, "", "tac has no REPL"},
+		{[]string{"-lang", "calc", "-run", "-mode", "complex"}, "1", `unknown mode "complex" (want float, rat, int, decimal)`},
+		{[]string{"-lang", "scopes"}, "{ int x; y; }", `stdin:1:11: no type stored for y at ";"`},
+		{[]string{}, "{ int x; x = ; }", `stdin:1:14: expected expression after '=', found ";"`},
+	}
+	for _, tc := range tests {
+		var out bytes.Buffer
+		err := run(tc.args, strings.NewReader(tc.input), &out, &out)
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("%v: got error %v, want %s", tc.args, err, tc.want)
+		}
+	}
+}
+
+// TestUsage checks that flag errors and usage go to standard error, so that
+// they do not mix with the stages.
+func TestUsage(t *testing.T) {
+	var out, errout bytes.Buffer
+	if err := run([]string{"-nosuch"}, strings.NewReader(""), &out, &errout); err == nil {
+		t.Error("-nosuch accepted")
+	}
+	if out.Len() > 0 || !strings.Contains(errout.String(), "usage: dragon") {
+		t.Errorf("wrote %q to stdout and %q to stderr", out.String(), errout.String())
+	}
+}
+
+func TestOutputDir(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "prog.txt")
+	if err := os.WriteFile(src, []byte("{ int x; while ( x < 3 ) x = x + 1; }"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := run([]string{"-dump", "tokens,ast,ir,cfg,ssa,asm", "-o", dir, src}, nil, &out, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() > 0 {
+		t.Errorf("unexpected output %q", out.String())
+	}
+	for _, stage := range []string{"tokens", "ast", "ir", "cfg", "ssa", "asm"} {
+		b, err := os.ReadFile(filepath.Join(dir, "prog."+stage))
+		if err != nil {
+			t.Error(err)
+		} else if len(b) == 0 {
+			t.Errorf("stage %s is empty", stage)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package source
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Diagnostic is an error concerning a span of a file.
+type Diagnostic struct {
+	Span Span
+	Msg  string
+}
+
+// ErrorList is every diagnostic of a file, in the order found, as a single
+// error, so that a caller may report all of them at once.
+type ErrorList struct {
+	File  *File
+	Diags []Diagnostic
+}
+
+func (e *ErrorList) Error() string {
+	lines := make([]string, len(e.Diags))
+	for i, d := range e.Diags {
+		lines[i] = fmt.Sprintf("%s: %s", e.File.Position(d.Span.Start), d.Msg)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Print writes each diagnostic followed by the line of source it begins on,
+// with the span underlined by carets up to the end of that line:
+//
+//	prog:3:11: expected ';', found '}'
+//	   3 |     x = a[i] }
+//	     |              ^
+//
+// The position and the carets are in bold red if colour is set, for a
+// terminal.
+func (e *ErrorList) Print(w io.Writer, colour bool) {
+	red := func(s string) string { return s }
+	if colour {
+		red = func(s string) string { return "\x1b[1;31m" + s + "\x1b[0m" }
+	}
+	for _, d := range e.Diags {
+		pos := e.File.Position(d.Span.Start)
+		fmt.Fprintf(w, "%s %s\n", red(fmt.Sprintf("%s:", pos)), d.Msg)
+
+		text, col := e.File.Line(pos.Line), pos.Column
+		n := d.Span.Len()
+		if n < 1 {
+			n = 1
+		}
+		if rest := len(text) - (col - 1); n > rest && rest > 0 {
+			n = rest
+		}
+		if col-1 > len(text) {
+			col = len(text) + 1
+		}
+		// tabs are kept in the margin so that the carets line up
+		margin := strings.Map(func(r rune) rune {
+			if r == '\t' {
+				return r
+			}
+			return ' '
+		}, text[:col-1])
+		fmt.Fprintf(w, "%4d | %s\n", pos.Line, text)
+		fmt.Fprintf(w, "     | %s%s\n", margin, red(strings.Repeat("^", n)))
+	}
+}
+
+// PrintError writes err to w, as Print does if it is an *ErrorList.
+func PrintError(w io.Writer, err error, colour bool) {
+	var e *ErrorList
+	if errors.As(err, &e) {
+		e.Print(w, colour)
+		return
+	}
+	fmt.Fprintln(w, err)
+}
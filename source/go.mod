@@ -0,0 +1,3 @@
+module github.com/akiarie/dragon-tests/source
+
+go 1.16
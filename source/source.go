@@ -0,0 +1,189 @@
+// Package source resolves positions in source text, so that a lexer need
+// record only an offset for each token and a diagnostic or tree dump can still
+// name the file, line and column of the range it concerns.
+//
+// The files of a FileSet share a single space of positions: each file is given
+// a base, and the offset o in it is the Pos base+o. A Pos thus identifies its
+// file as well as the place in it, and the zero Pos is no position at all.
+package source
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Pos is a position in the files of a FileSet.
+type Pos int
+
+// NoPos is the zero Pos, which lies in no file.
+const NoPos Pos = 0
+
+// IsValid reports whether p is a position.
+func (p Pos) IsValid() bool { return p != NoPos }
+
+// Span is the range [Start, End) of positions in a file.
+type Span struct {
+	Start, End Pos
+}
+
+// IsValid reports whether s has a start.
+func (s Span) IsValid() bool { return s.Start.IsValid() }
+
+// Len is the number of bytes in s.
+func (s Span) Len() int { return int(s.End - s.Start) }
+
+// Join is the least span covering both a and b, either of which may be
+// invalid.
+func Join(a, b Span) Span {
+	switch {
+	case !a.IsValid():
+		return b
+	case !b.IsValid():
+		return a
+	}
+	if b.Start < a.Start {
+		a.Start = b.Start
+	}
+	if b.End > a.End {
+		a.End = b.End
+	}
+	return a
+}
+
+// Position is a resolved Pos. Line and Column count from one, and the column is
+// in bytes.
+type Position struct {
+	File   string
+	Offset int
+	Line   int
+	Column int
+}
+
+// IsValid reports whether p is a position.
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+// String returns "file:line:col", omitting the file if it has no name, or "-"
+// for no position.
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// File is a source file of a FileSet.
+type File struct {
+	name  string
+	base  int
+	src   string
+	lines []int // offsets of the first byte of each line
+}
+
+// NewFile returns src as the only file of a new FileSet.
+func NewFile(name, src string) *File {
+	return NewFileSet().AddFile(name, src)
+}
+
+// Name is the name of the file.
+func (f *File) Name() string { return f.name }
+
+// Base is the Pos of the first byte of the file.
+func (f *File) Base() Pos { return Pos(f.base) }
+
+// Size is the length of the file in bytes.
+func (f *File) Size() int { return len(f.src) }
+
+// Source is the text of the file.
+func (f *File) Source() string { return f.src }
+
+// Pos returns the position of offset, which may be the size of the file (the
+// position at its end).
+func (f *File) Pos(offset int) Pos {
+	if offset < 0 || offset > len(f.src) {
+		panic(fmt.Sprintf("offset %d out of range [0, %d] in %s", offset, len(f.src), f.name))
+	}
+	return Pos(f.base + offset)
+}
+
+// Span returns the span of the offsets [start, end).
+func (f *File) Span(start, end int) Span {
+	return Span{f.Pos(start), f.Pos(end)}
+}
+
+// Offset returns the offset of p, which must lie in the file.
+func (f *File) Offset(p Pos) int {
+	if int(p) < f.base || int(p) > f.base+len(f.src) {
+		panic(fmt.Sprintf("position %d outside %s", p, f.name))
+	}
+	return int(p) - f.base
+}
+
+// Text is the source covered by s.
+func (f *File) Text(s Span) string {
+	return f.src[f.Offset(s.Start):f.Offset(s.End)]
+}
+
+// Position resolves p, which must lie in the file.
+func (f *File) Position(p Pos) Position {
+	offset := f.Offset(p)
+	i := sort.Search(len(f.lines), func(i int) bool { return f.lines[i] > offset }) - 1
+	return Position{File: f.name, Offset: offset, Line: i + 1, Column: offset - f.lines[i] + 1}
+}
+
+// Line returns the text of line n, counting from one, without its newline.
+func (f *File) Line(n int) string {
+	if n < 1 || n > len(f.lines) {
+		return ""
+	}
+	text := f.src[f.lines[n-1]:]
+	if nl := strings.IndexByte(text, '\n'); nl != -1 {
+		text = text[:nl]
+	}
+	return text
+}
+
+// FileSet is a set of files sharing one space of positions.
+type FileSet struct {
+	base  int
+	files []*File
+}
+
+// NewFileSet returns an empty set.
+func NewFileSet() *FileSet {
+	return &FileSet{base: 1}
+}
+
+// AddFile adds the file name with text src to the set.
+func (s *FileSet) AddFile(name, src string) *File {
+	f := &File{name: name, base: s.base, src: src, lines: []int{0}}
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\n' {
+			f.lines = append(f.lines, i+1)
+		}
+	}
+	// the position at the end of a file is distinct from the start of the next
+	s.base += len(src) + 1
+	s.files = append(s.files, f)
+	return f
+}
+
+// File returns the file containing p, or nil if there is none.
+func (s *FileSet) File(p Pos) *File {
+	i := sort.Search(len(s.files), func(i int) bool { return s.files[i].base > int(p) }) - 1
+	if i < 0 || int(p) > s.files[i].base+len(s.files[i].src) {
+		return nil
+	}
+	return s.files[i]
+}
+
+// Position resolves p, or returns the zero Position if it lies in no file.
+func (s *FileSet) Position(p Pos) Position {
+	if f := s.File(p); f != nil {
+		return f.Position(p)
+	}
+	return Position{}
+}
@@ -0,0 +1,91 @@
+package source
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestPosition(t *testing.T) {
+	fset := NewFileSet()
+	a := fset.AddFile("a", "{\n\tint x;\n}\n")
+	b := fset.AddFile("b", "x\n\ny")
+	tests := []struct {
+		file   *File
+		offset int
+		want   string
+	}{
+		{a, 0, "a:1:1"},
+		{a, 1, "a:1:2"},
+		{a, 2, "a:2:1"},
+		{a, 7, "a:2:6"},
+		{a, 11, "a:3:2"},
+		{a, 12, "a:4:1"}, // end of file
+		{b, 0, "b:1:1"},
+		{b, 2, "b:2:1"},
+		{b, 3, "b:3:1"},
+		{b, 4, "b:3:2"},
+	}
+	for _, tc := range tests {
+		p := tc.file.Pos(tc.offset)
+		if got := fset.Position(p).String(); got != tc.want {
+			t.Errorf("%s offset %d: got %s, want %s", tc.file.Name(), tc.offset, got, tc.want)
+		}
+		if f := fset.File(p); f != tc.file {
+			t.Errorf("%s offset %d: in file %v", tc.file.Name(), tc.offset, f)
+		}
+		if got := tc.file.Offset(p); got != tc.offset {
+			t.Errorf("%s offset %d: got offset %d", tc.file.Name(), tc.offset, got)
+		}
+	}
+	if got := fset.Position(NoPos).String(); got != "-" {
+		t.Errorf("NoPos: got %s", got)
+	}
+	if f := fset.File(b.Base() + Pos(b.Size()) + 1); f != nil {
+		t.Errorf("position beyond the last file in %s", f.Name())
+	}
+}
+
+func TestSpan(t *testing.T) {
+	f := NewFile("", "int x; float y;")
+	x, y := f.Span(4, 5), f.Span(13, 14)
+	if got := f.Text(Join(y, x)); got != "x; float y" {
+		t.Errorf("join: got %q", got)
+	}
+	if got := Join(Span{}, x); got != x {
+		t.Errorf("join with invalid span: got %v", got)
+	}
+	if n := Join(x, y).Len(); n != 10 {
+		t.Errorf("len: got %d", n)
+	}
+	if got := f.Position(y.Start).String(); got != "1:14" {
+		t.Errorf("unnamed file: got %s", got)
+	}
+	if got := f.Line(1); got != "int x; float y;" {
+		t.Errorf("line: got %q", got)
+	}
+}
+
+func TestPrintError(t *testing.T) {
+	f := NewFile("prog", "{\n\tx = 1 }\n")
+	err := fmt.Errorf("parsing: %w", &ErrorList{File: f, Diags: []Diagnostic{
+		{f.Span(9, 10), "expected ';', found '}'"},
+		{f.Span(9, 11), "clipped at the end of the line"},
+	}})
+	var b bytes.Buffer
+	PrintError(&b, err, false)
+	want := "prog:2:8: expected ';', found '}'\n" +
+		"   2 | \tx = 1 }\n" +
+		"     | \t      ^\n" +
+		"prog:2:8: clipped at the end of the line\n" +
+		"   2 | \tx = 1 }\n" +
+		"     | \t      ^\n"
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+	b.Reset()
+	PrintError(&b, fmt.Errorf("plain"), true)
+	if b.String() != "plain\n" {
+		t.Errorf("got %q", b.String())
+	}
+}